@@ -0,0 +1,120 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// loadAppleRootCerts returns the trusted roots for Apple signed data (JWS with x5c chain).
+// APPLE_ROOT_CERT_PATH: PEM or DER file (AppleRootCA-G3.cer from apple.com/certificateauthority,
+// or a locally generated root for testing). APPLE_ROOT_CERT_PEM: PEM string.
+func loadAppleRootCerts() (*x509.CertPool, error) {
+	var data []byte
+	if path := os.Getenv("APPLE_ROOT_CERT_PATH"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		data = b
+	} else if s := os.Getenv("APPLE_ROOT_CERT_PEM"); s != "" {
+		data = []byte(s)
+	} else {
+		return nil, errors.New("APPLE_ROOT_CERT_PATH or APPLE_ROOT_CERT_PEM not set")
+	}
+
+	pool := x509.NewCertPool()
+	if strings.Contains(string(data), "-----BEGIN") {
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificate found in apple root PEM")
+		}
+		return pool, nil
+	}
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse apple root certificate: %w", err)
+	}
+	pool.AddCert(cert)
+	return pool, nil
+}
+
+type appleJWSHeader struct {
+	Alg string   `json:"alg"`
+	X5c []string `json:"x5c"`
+}
+
+// verifyAppleJWS checks an Apple signed payload (ES256, x5c certificate chain up to one of
+// roots) and decodes its claims into v.
+func verifyAppleJWS(token string, roots *x509.CertPool, v any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("jws: expected 3 parts")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("jws header: %w", err)
+	}
+	var header appleJWSHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return fmt.Errorf("jws header: %w", err)
+	}
+	if header.Alg != "ES256" {
+		return fmt.Errorf("jws: unsupported alg %q", header.Alg)
+	}
+	if len(header.X5c) == 0 {
+		return errors.New("jws: missing x5c chain")
+	}
+
+	certs := make([]*x509.Certificate, 0, len(header.X5c))
+	for _, c := range header.X5c {
+		der, err := base64.StdEncoding.DecodeString(c)
+		if err != nil {
+			return fmt.Errorf("jws x5c: %w", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("jws x5c: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	leaf := certs[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("jws certificate chain: %w", err)
+	}
+
+	pub, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("jws: leaf certificate is not ECDSA")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return errors.New("jws: invalid signature encoding")
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(pub, hash[:], r, s) {
+		return errors.New("jws: signature mismatch")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("jws payload: %w", err)
+	}
+	return json.Unmarshal(payload, v)
+}
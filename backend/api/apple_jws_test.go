@@ -0,0 +1,208 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAppleCA is a locally generated root → intermediate → leaf chain standing in for
+// Apple's signing certificates
+type testAppleCA struct {
+	rootPEM []byte
+	rootDER []byte
+	roots   *x509.CertPool
+	chain   []string // x5c: leaf, intermediate (base64 DER)
+	key     *ecdsa.PrivateKey
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newTestCert(t *testing.T, name string, ca bool, notAfter time.Time, key *ecdsa.PrivateKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, []byte) {
+	t.Helper()
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+	}
+	if ca {
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, der
+}
+
+func newTestAppleCA(t *testing.T) *testAppleCA {
+	return newTestAppleCAWithLeafExpiry(t, time.Now().Add(24*time.Hour))
+}
+
+func newTestAppleCAWithLeafExpiry(t *testing.T, leafNotAfter time.Time) *testAppleCA {
+	t.Helper()
+	year := time.Now().AddDate(1, 0, 0)
+	rootKey, interKey, leafKey := newTestKey(t), newTestKey(t), newTestKey(t)
+	root, rootDER := newTestCert(t, "Test Apple Root CA", true, year, rootKey, nil, nil)
+	inter, interDER := newTestCert(t, "Test Apple WWDR CA", true, year, interKey, root, rootKey)
+	_, leafDER := newTestCert(t, "Test Apple Store Signing", false, leafNotAfter, leafKey, inter, interKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	return &testAppleCA{
+		rootPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER}),
+		rootDER: rootDER,
+		roots:   roots,
+		chain:   []string{base64.StdEncoding.EncodeToString(leafDER), base64.StdEncoding.EncodeToString(interDER)},
+		key:     leafKey,
+	}
+}
+
+// sign returns an ES256 JWS of claims with the CA's x5c chain
+func (ca *testAppleCA) sign(t *testing.T, claims any) string {
+	t.Helper()
+	return signTestJWS(t, map[string]any{"alg": "ES256", "x5c": ca.chain}, claims, ca.key)
+}
+
+func signTestJWS(t *testing.T, header, claims any, key *ecdsa.PrivateKey) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signing := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	hash := sha256.Sum256([]byte(signing))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyAppleJWS(t *testing.T) {
+	ca := newTestAppleCA(t)
+	other := newTestAppleCA(t)
+	expired := newTestAppleCAWithLeafExpiry(t, time.Now().Add(-time.Minute))
+	claims := map[string]any{"transactionId": "1000", "productId": "premium"}
+	valid := ca.sign(t, claims)
+	parts := strings.Split(valid, ".")
+	tamperedClaims, _ := json.Marshal(map[string]any{"transactionId": "2000", "productId": "premium"})
+
+	tests := []struct {
+		name  string
+		token string
+		roots *x509.CertPool
+		err   string
+	}{
+		{"valid chain", valid, ca.roots, ""},
+		{"other root", valid, other.roots, "certificate chain"},
+		{"tampered payload", parts[0] + "." + base64.RawURLEncoding.EncodeToString(tamperedClaims) + "." + parts[2], ca.roots, "signature mismatch"},
+		{"signed by another key", signTestJWS(t, map[string]any{"alg": "ES256", "x5c": ca.chain}, claims, other.key), ca.roots, "signature mismatch"},
+		{"chain of another CA", signTestJWS(t, map[string]any{"alg": "ES256", "x5c": other.chain}, claims, other.key), ca.roots, "certificate chain"},
+		{"missing intermediate", signTestJWS(t, map[string]any{"alg": "ES256", "x5c": ca.chain[:1]}, claims, ca.key), ca.roots, "certificate chain"},
+		{"expired leaf", expired.sign(t, claims), expired.roots, "certificate chain"},
+		{"alg none", signTestJWS(t, map[string]any{"alg": "none", "x5c": ca.chain}, claims, ca.key), ca.roots, "unsupported alg"},
+		{"no x5c", signTestJWS(t, map[string]any{"alg": "ES256"}, claims, ca.key), ca.roots, "missing x5c"},
+		{"bad x5c", signTestJWS(t, map[string]any{"alg": "ES256", "x5c": []string{"not-base64!"}}, claims, ca.key), ca.roots, "jws x5c"},
+		{"two parts", parts[0] + "." + parts[1], ca.roots, "expected 3 parts"},
+		{"short signature", parts[0] + "." + parts[1] + ".AAAA", ca.roots, "invalid signature encoding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got appleTransactionInfo
+			err := verifyAppleJWS(tt.token, tt.roots, &got)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got.TransactionID != "1000" || got.ProductID != "premium" {
+					t.Fatalf("claims = %+v", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadAppleRootCerts(t *testing.T) {
+	ca := newTestAppleCA(t)
+	dir := t.TempDir()
+	pemPath := filepath.Join(dir, "root.pem")
+	derPath := filepath.Join(dir, "root.cer")
+	junkPath := filepath.Join(dir, "junk.cer")
+	os.WriteFile(pemPath, ca.rootPEM, 0o600)
+	os.WriteFile(derPath, ca.rootDER, 0o600)
+	os.WriteFile(junkPath, []byte("junk"), 0o600)
+
+	tests := []struct {
+		name    string
+		path    string
+		pem     string
+		wantErr bool
+	}{
+		{"pem file", pemPath, "", false},
+		{"der file", derPath, "", false},
+		{"pem env", "", string(ca.rootPEM), false},
+		{"path wins over pem env", derPath, "junk", false},
+		{"not set", "", "", true},
+		{"missing file", filepath.Join(dir, "missing.cer"), "", true},
+		{"not a certificate", junkPath, "", true},
+		{"pem without certificate", "", "-----BEGIN CERTIFICATE-----\n-----END CERTIFICATE-----", true},
+	}
+	token := ca.sign(t, map[string]any{"transactionId": "1000"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("APPLE_ROOT_CERT_PATH", tt.path)
+			t.Setenv("APPLE_ROOT_CERT_PEM", tt.pem)
+			roots, err := loadAppleRootCerts()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var tx appleTransactionInfo
+			if err := verifyAppleJWS(token, roots, &tx); err != nil {
+				t.Fatalf("token not verified with the loaded root: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"korean-kids-stories/entitlements"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// appleNotificationRequest is the body of App Store Server Notifications v2
+type appleNotificationRequest struct {
+	SignedPayload string `json:"signedPayload"`
+}
+
+// appleNotificationPayload is the decoded signedPayload
+type appleNotificationPayload struct {
+	NotificationType string `json:"notificationType"`
+	Subtype          string `json:"subtype"`
+	NotificationUUID string `json:"notificationUUID"`
+	SignedDate       int64  `json:"signedDate"`
+	Data             struct {
+		BundleID              string `json:"bundleId"`
+		Environment           string `json:"environment"`
+		SignedTransactionInfo string `json:"signedTransactionInfo"`
+		SignedRenewalInfo     string `json:"signedRenewalInfo"`
+		Status                int    `json:"status"`
+	} `json:"data"`
+}
+
+// appleTransactionInfo is a decoded JWSTransaction (dates are unix ms)
+type appleTransactionInfo struct {
+	TransactionID         string `json:"transactionId"`
+	OriginalTransactionID string `json:"originalTransactionId"`
+	ProductID             string `json:"productId"`
+	BundleID              string `json:"bundleId"`
+	PurchaseDate          int64  `json:"purchaseDate"`
+	ExpiresDate           int64  `json:"expiresDate"`
+	RevocationDate        int64  `json:"revocationDate"`
+	RevocationReason      *int   `json:"revocationReason,omitempty"`
+	Type                  string `json:"type"`
+	Environment           string `json:"environment"`
+}
+
+// appleRenewalInfo is a decoded JWSRenewalInfo
+type appleRenewalInfo struct {
+	OriginalTransactionID  string `json:"originalTransactionId"`
+	AutoRenewProductID     string `json:"autoRenewProductId"`
+	AutoRenewStatus        int    `json:"autoRenewStatus"`
+	ExpirationIntent       int    `json:"expirationIntent"`
+	IsInBillingRetryPeriod bool   `json:"isInBillingRetryPeriod"`
+	GracePeriodExpiresDate int64  `json:"gracePeriodExpiresDate"`
+}
+
+// appleNotificationsHandler: POST /api/iap/apple/notifications (App Store Server Notifications v2).
+// Renewals, refunds, billing retry and expirations update iap_verifications.expires_at;
+// every verified notification is recorded in iap_notifications. Payloads that fail the
+// signature check are only logged (anyone can post here). Non-2xx makes Apple retry.
+func appleNotificationsHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		var req appleNotificationRequest
+		if err := json.NewDecoder(e.Request.Body).Decode(&req); err != nil || req.SignedPayload == "" {
+			return e.JSON(400, map[string]string{"error": "signedPayload required"})
+		}
+		roots, err := loadAppleRootCerts()
+		if err != nil {
+			log.Printf("apple notifications: %v", err)
+			return e.JSON(500, map[string]string{"error": "server misconfigured: apple root certificate"})
+		}
+
+		var payload appleNotificationPayload
+		if err := verifyAppleJWS(req.SignedPayload, roots, &payload); err != nil {
+			log.Printf("apple notifications: rejected signedPayload: %v", err)
+			return e.JSON(400, map[string]string{"error": "invalid signed payload"})
+		}
+
+		existing := findIAPNotification(app, "ios", payload.NotificationUUID)
+		if existing != nil && existing.GetString("result") != "failed" {
+			return e.JSON(200, map[string]string{"status": "duplicate"})
+		}
+
+		n := iapNotification{
+			Platform:       "ios",
+			NotificationID: payload.NotificationUUID,
+			Type:           payload.NotificationType,
+			Subtype:        payload.Subtype,
+			Environment:    payload.Data.Environment,
+		}
+		payloadLog := map[string]any{"notification": payload}
+		n.Payload = payloadLog
+
+		switch {
+		case payload.Data.BundleID != "" && payload.Data.BundleID != getAppleBundleID():
+			n.Result, n.Error = "ignored", "bundle id "+payload.Data.BundleID
+		case payload.Data.SignedTransactionInfo == "":
+			n.Result = "ignored" // TEST, CONSUMPTION_REQUEST... carry no transaction
+		default:
+			var tx appleTransactionInfo
+			var renewal appleRenewalInfo
+			if err := verifyAppleJWS(payload.Data.SignedTransactionInfo, roots, &tx); err != nil {
+				log.Printf("apple notifications: %s rejected signedTransactionInfo: %v", payload.NotificationUUID, err)
+				return e.JSON(400, map[string]string{"error": "invalid signedTransactionInfo"})
+			}
+			payloadLog["transaction"] = tx
+			if payload.Data.SignedRenewalInfo != "" {
+				if err := verifyAppleJWS(payload.Data.SignedRenewalInfo, roots, &renewal); err != nil {
+					log.Printf("apple notifications: %s rejected signedRenewalInfo: %v", payload.NotificationUUID, err)
+					return e.JSON(400, map[string]string{"error": "invalid signedRenewalInfo"})
+				}
+				payloadLog["renewal"] = renewal
+			}
+			n.TransactionID = appleOriginalTransactionID(&tx)
+			n.ProductID = tx.ProductID
+
+			matched, updated, err := applyAppleTransaction(app, payload.NotificationType, payload.SignedDate, &tx, &renewal)
+			switch {
+			case err != nil:
+				n.Result, n.Error = "failed", err.Error()
+			case matched == 0:
+				n.Result = "unmatched" // not verified from the app yet
+			case updated == 0:
+				n.Result, n.Error = "ignored", "out of order: older than the stored state"
+			default:
+				n.Result = "processed"
+			}
+		}
+
+		if err := saveIAPNotification(app, existing, n); err != nil {
+			log.Printf("apple notifications: audit %s: %v", n.NotificationID, err)
+		}
+		if n.Result == "failed" {
+			return e.JSON(500, map[string]string{"error": n.Error})
+		}
+		return e.JSON(200, map[string]string{"status": n.Result})
+	}
+}
+
+// applyAppleTransaction updates expires_at of every iap_verifications row of the subscription
+// (one per device). Apple does not deliver notifications in order, so a row is skipped when
+// it already holds a newer notification (store_signed_at after signedDate) or, unless the
+// purchase was revoked, a later expiry. Returns the number of rows matched and updated.
+func applyAppleTransaction(app core.App, notificationType string, signedDate int64, tx *appleTransactionInfo, renewal *appleRenewalInfo) (int, int, error) {
+	col, err := app.FindCollectionByNameOrId("iap_verifications")
+	if err != nil {
+		return 0, 0, err
+	}
+	records, err := app.FindRecordsByFilter(col.Id,
+		"platform = 'ios' && (transaction_id = {:original} || transaction_id = {:tx})", "", 0, 0,
+		dbx.Params{"original": appleOriginalTransactionID(tx), "tx": tx.TransactionID})
+	if err != nil || len(records) == 0 {
+		return 0, 0, err
+	}
+	expiresAt := appleExpiresAt(notificationType, tx, renewal)
+	if expiresAt == "" {
+		return len(records), len(records), nil // non-subscription purchase without revocation: nothing to update
+	}
+	signedAt := ""
+	if signedDate > 0 {
+		signedAt = formatUnixMs(signedDate)
+	}
+	revoked := appleRevoked(notificationType, tx)
+	updated := 0
+	err = app.RunInTransaction(func(txApp core.App) error {
+		for _, r := range records {
+			if stored := r.GetString("store_signed_at"); signedAt != "" && stored > signedAt {
+				continue
+			}
+			if current := r.GetString("expires_at"); !revoked && current > expiresAt {
+				continue
+			}
+			r.Set("expires_at", expiresAt)
+			if signedAt != "" {
+				r.Set("store_signed_at", signedAt)
+			}
+			if err := txApp.Save(r); err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	return len(records), updated, err
+}
+
+// appleRevoked: refunds and revocations end access and may move expires_at back
+func appleRevoked(notificationType string, tx *appleTransactionInfo) bool {
+	return tx.RevocationDate > 0 || notificationType == "REFUND" || notificationType == "REVOKE"
+}
+
+// appleExpiresAt: revocation (refund/revoke) ends access immediately; otherwise the
+// transaction expiry, extended by the billing grace period when Apple grants one
+func appleExpiresAt(notificationType string, tx *appleTransactionInfo, renewal *appleRenewalInfo) string {
+	if tx.RevocationDate > 0 {
+		return formatUnixMs(tx.RevocationDate)
+	}
+	if notificationType == "REFUND" || notificationType == "REVOKE" {
+		return time.Now().UTC().Format(entitlements.DateLayout)
+	}
+	expires := tx.ExpiresDate
+	if renewal != nil && renewal.GracePeriodExpiresDate > expires {
+		expires = renewal.GracePeriodExpiresDate
+	}
+	if expires <= 0 {
+		return ""
+	}
+	return formatUnixMs(expires)
+}
+
+// appleOriginalTransactionID is the id saved by /api/iap/verify for the subscription
+func appleOriginalTransactionID(tx *appleTransactionInfo) string {
+	if tx.OriginalTransactionID != "" {
+		return tx.OriginalTransactionID
+	}
+	return tx.TransactionID
+}
+
+func formatUnixMs(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(entitlements.DateLayout)
+}
+
+func getAppleBundleID() string {
+	if s := os.Getenv("APPLE_BUNDLE_ID"); s != "" {
+		return s
+	}
+	return "com.hbstore.koreankids"
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestAppleNotifications(t *testing.T) {
+	app := newTestApp(t)
+	ca := newTestAppleCA(t)
+	t.Setenv("APPLE_ROOT_CERT_PATH", "")
+	t.Setenv("APPLE_ROOT_CERT_PEM", string(ca.rootPEM))
+	t.Setenv("APPLE_BUNDLE_ID", "com.test.kids")
+
+	col, err := app.FindCollectionByNameOrId("iap_verifications")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := core.NewRecord(col)
+	row.Set("device_id", "device-1")
+	row.Set("transaction_id", "orig-1")
+	row.Set("product_id", "premium")
+	row.Set("platform", "ios")
+	if err := app.Save(row); err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Now().Add(-time.Hour).UnixMilli()
+	day := int64(24 * time.Hour / time.Millisecond)
+	transaction := func(expires, revoked int64) map[string]any {
+		return map[string]any{"transactionId": "tx-2", "originalTransactionId": "orig-1", "productId": "premium",
+			"bundleId": "com.test.kids", "expiresDate": expires, "revocationDate": revoked}
+	}
+	notification := func(uuid, kind string, signedDate int64, signedTransaction string) string {
+		payload := ca.sign(t, map[string]any{
+			"notificationType": kind,
+			"notificationUUID": uuid,
+			"signedDate":       signedDate,
+			"data": map[string]any{
+				"bundleId":              "com.test.kids",
+				"environment":           "Sandbox",
+				"signedTransactionInfo": signedTransaction,
+			},
+		})
+		body, _ := json.Marshal(map[string]string{"signedPayload": payload})
+		return string(body)
+	}
+	forged := newTestAppleCA(t)
+
+	// Applied in order: each step sees the state left by the previous ones
+	tests := []struct {
+		name      string
+		body      string
+		status    int
+		result    string // audit row result, "" = no audit row
+		expiresAt int64  // expected iap_verifications.expires_at (unix ms)
+		uuid      string
+	}{
+		{
+			name:   "missing signedPayload",
+			body:   `{}`,
+			status: 400,
+		},
+		{
+			name:   "payload signed by another root",
+			body:   `{"signedPayload":"` + forged.sign(t, map[string]any{"notificationUUID": "n-forged"}) + `"}`,
+			status: 400,
+		},
+		{
+			name:   "forged transaction inside a valid payload",
+			body:   notification("n-0", "DID_RENEW", base, forged.sign(t, transaction(base+30*day, 0))),
+			status: 400,
+		},
+		{
+			name:      "renewal",
+			body:      notification("n-2", "DID_RENEW", base+2000, ca.sign(t, transaction(base+60*day, 0))),
+			status:    200,
+			result:    "processed",
+			uuid:      "n-2",
+			expiresAt: base + 60*day,
+		},
+		{
+			name:      "older notification delivered late",
+			body:      notification("n-1", "DID_RENEW", base+1000, ca.sign(t, transaction(base+30*day, 0))),
+			status:    200,
+			result:    "ignored",
+			uuid:      "n-1",
+			expiresAt: base + 60*day,
+		},
+		{
+			name:      "newer notification with an earlier expiry",
+			body:      notification("n-3", "DID_CHANGE_RENEWAL_STATUS", base+3000, ca.sign(t, transaction(base+30*day, 0))),
+			status:    200,
+			result:    "ignored",
+			uuid:      "n-3",
+			expiresAt: base + 60*day,
+		},
+		{
+			name:      "refund moves the expiry back",
+			body:      notification("n-4", "REFUND", base+4000, ca.sign(t, transaction(base+60*day, base+4000))),
+			status:    200,
+			result:    "processed",
+			uuid:      "n-4",
+			expiresAt: base + 4000,
+		},
+		{
+			name:      "duplicate",
+			body:      notification("n-4", "REFUND", base+4000, ca.sign(t, transaction(base+60*day, base+4000))),
+			status:    200,
+			result:    "processed",
+			uuid:      "n-4",
+			expiresAt: base + 4000,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveTest(app, appleNotificationsHandler(app), "POST", "/api/iap/apple/notifications", strings.NewReader(tt.body))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.uuid != "" {
+				audit := findIAPNotification(app, "ios", tt.uuid)
+				if audit == nil || audit.GetString("result") != tt.result {
+					t.Fatalf("audit row = %v, want result %q", audit, tt.result)
+				}
+			}
+			if tt.expiresAt != 0 {
+				stored, err := app.FindRecordById("iap_verifications", row.Id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := stored.GetString("expires_at"), formatUnixMs(tt.expiresAt); got != want {
+					t.Fatalf("expires_at = %s, want %s", got, want)
+				}
+			}
+		})
+	}
+
+	// Rejected payloads are logged only
+	total, err := app.CountRecords("iap_notifications")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4 {
+		t.Fatalf("iap_notifications rows = %d, want 4 (verified notifications only)", total)
+	}
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	appStoreServerAPIProd = "https://api.storekit.itunes.apple.com"
+	appStoreServerAPISand = "https://api.storekit-sandbox.itunes.apple.com"
+	// maxHistoryPages bounds the transaction history walk (20 transactions per page)
+	maxHistoryPages = 10
+)
+
+var errAppleTransactionNotFound = errors.New("transaction not found")
+
+// AppStoreServerAPIVerifier verifies StoreKit transaction ids (req.TransactionID) with the
+// App Store Server API: transaction history by originalTransactionId, signed transactions
+// checked against the Apple root certificate.
+type AppStoreServerAPIVerifier struct {
+	KeyID         string
+	IssuerID      string
+	BundleID      string
+	PrivateKey    *ecdsa.PrivateKey // In-App Purchase key (.p8) from App Store Connect
+	Roots         *x509.CertPool
+	ProductionURL string
+	SandboxURL    string
+	Client        *http.Client
+}
+
+// appleHistoryResponse is GET /inApps/v2/history/{transactionId}
+type appleHistoryResponse struct {
+	SignedTransactions []string `json:"signedTransactions"`
+	HasMore            bool     `json:"hasMore"`
+	Revision           string   `json:"revision"`
+	Environment        string   `json:"environment"`
+}
+
+// newAppStoreServerAPIVerifierFromEnv: APPLE_IAP_KEY_ID, APPLE_IAP_ISSUER_ID,
+// APPLE_IAP_PRIVATE_KEY_PATH (or APPLE_IAP_PRIVATE_KEY), root from APPLE_ROOT_CERT_PATH
+func newAppStoreServerAPIVerifierFromEnv() (AppleVerifier, error) {
+	keyID := os.Getenv("APPLE_IAP_KEY_ID")
+	issuerID := os.Getenv("APPLE_IAP_ISSUER_ID")
+	if keyID == "" || issuerID == "" {
+		return nil, errors.New("APPLE_IAP_KEY_ID and APPLE_IAP_ISSUER_ID required")
+	}
+	keyPEM := []byte(os.Getenv("APPLE_IAP_PRIVATE_KEY"))
+	if path := os.Getenv("APPLE_IAP_PRIVATE_KEY_PATH"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM = b
+	}
+	key, err := parseApplePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := loadAppleRootCerts()
+	if err != nil {
+		return nil, err
+	}
+	return &AppStoreServerAPIVerifier{
+		KeyID:         keyID,
+		IssuerID:      issuerID,
+		BundleID:      getAppleBundleID(),
+		PrivateKey:    key,
+		Roots:         roots,
+		ProductionURL: appStoreServerAPIProd,
+		SandboxURL:    appStoreServerAPISand,
+	}, nil
+}
+
+func parseApplePrivateKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("APPLE_IAP_PRIVATE_KEY_PATH or APPLE_IAP_PRIVATE_KEY: no PEM key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("apple private key: %w", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("apple private key is not ECDSA")
+	}
+	return key, nil
+}
+
+func (v *AppStoreServerAPIVerifier) Verify(ctx context.Context, req *VerifyRequest) (*AppleVerification, error) {
+	results, raw, err := v.verifyProducts(ctx, req, []string{req.ProductID})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, &verifyError{Status: 400, Message: "product not found in transaction history", Raw: raw}
+	}
+	if results[0].Revoked {
+		return nil, &verifyError{Status: 400, Message: "transaction revoked", Raw: results[0].Raw}
+	}
+	return results[0], nil
+}
+
+// VerifyProducts looks up the history of req.TransactionID and returns the newest transaction
+// of each product found
+func (v *AppStoreServerAPIVerifier) VerifyProducts(ctx context.Context, req *VerifyRequest, productIDs []string) ([]*AppleVerification, error) {
+	results, _, err := v.verifyProducts(ctx, req, productIDs)
+	return results, err
+}
+
+func (v *AppStoreServerAPIVerifier) verifyProducts(ctx context.Context, req *VerifyRequest, productIDs []string) ([]*AppleVerification, json.RawMessage, error) {
+	if req.TransactionID == "" {
+		return nil, nil, &verifyError{Status: 400, Message: "transaction_id required for ios"}
+	}
+
+	// Production first; sandbox transactions are not found there (TestFlight / Xcode builds)
+	var found map[string]*appleTransactionInfo
+	var pages []json.RawMessage
+	var err error
+	for _, baseURL := range []string{v.ProductionURL, v.SandboxURL} {
+		found, pages, err = v.latestTransactions(ctx, baseURL, req.TransactionID, productIDs)
+		if !errors.Is(err, errAppleTransactionNotFound) {
+			break
+		}
+	}
+	if errors.Is(err, errAppleTransactionNotFound) {
+		return nil, nil, &verifyError{Status: 400, Message: "apple transaction not found"}
+	}
+	if err != nil {
+		return nil, nil, &verifyError{Status: 502, Message: "apple request failed: " + err.Error()}
+	}
+	raw, _ := json.Marshal(map[string]any{"history": pages})
+
+	var results []*AppleVerification
+	for _, productID := range productIDs {
+		tx := found[productID]
+		if tx == nil {
+			continue
+		}
+		if v.BundleID != "" && tx.BundleID != v.BundleID {
+			return nil, raw, &verifyError{Status: 400, Message: "bundle id mismatch", Raw: raw}
+		}
+		result := &AppleVerification{
+			OriginalTransactionID: appleOriginalTransactionID(tx),
+			TransactionID:         tx.TransactionID,
+			ProductID:             tx.ProductID,
+			Environment:           tx.Environment,
+			Raw:                   raw,
+		}
+		if tx.ExpiresDate > 0 {
+			result.ExpiresAt = formatUnixMs(tx.ExpiresDate)
+		}
+		if tx.RevocationDate > 0 {
+			result.Revoked = true
+			result.ExpiresAt = formatUnixMs(tx.RevocationDate)
+		}
+		results = append(results, result)
+	}
+	return results, raw, nil
+}
+
+// latestTransactions walks the history (newest first) until the newest transaction of every
+// product is found; returns them by product id with the raw history pages read
+func (v *AppStoreServerAPIVerifier) latestTransactions(ctx context.Context, baseURL, transactionID string, productIDs []string) (map[string]*appleTransactionInfo, []json.RawMessage, error) {
+	found := map[string]*appleTransactionInfo{}
+	wanted := map[string]bool{}
+	for _, p := range productIDs {
+		wanted[p] = true
+	}
+	var pages []json.RawMessage
+	revision := ""
+	for page := 0; page < maxHistoryPages && len(found) < len(wanted); page++ {
+		query := url.Values{"sort": {"DESCENDING"}}
+		if revision != "" {
+			query.Set("revision", revision)
+		}
+		var history appleHistoryResponse
+		raw, err := v.get(ctx, baseURL+"/inApps/v2/history/"+url.PathEscape(transactionID)+"?"+query.Encode(), &history)
+		if err != nil {
+			return nil, pages, err
+		}
+		pages = append(pages, raw)
+		for _, signed := range history.SignedTransactions {
+			var tx appleTransactionInfo
+			if err := verifyAppleJWS(signed, v.Roots, &tx); err != nil {
+				return nil, pages, fmt.Errorf("signed transaction: %w", err)
+			}
+			if wanted[tx.ProductID] && found[tx.ProductID] == nil {
+				found[tx.ProductID] = &tx
+			}
+		}
+		if !history.HasMore {
+			break
+		}
+		revision = history.Revision
+	}
+	return found, pages, nil
+}
+
+func (v *AppStoreServerAPIVerifier) get(ctx context.Context, rawURL string, out any) (json.RawMessage, error) {
+	token, err := v.token()
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, errAppleTransactionNotFound
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("status %d: %s", res.StatusCode, string(data))
+	}
+	return data, json.Unmarshal(data, out)
+}
+
+// token signs the App Store Server API bearer JWT (ES256, valid 5 minutes)
+func (v *AppStoreServerAPIVerifier) token() (string, error) {
+	now := time.Now()
+	t := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": v.IssuerID,
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+		"aud": "appstoreconnect-v1",
+		"bid": v.BundleID,
+	})
+	t.Header["kid"] = v.KeyID
+	return t.SignedString(v.PrivateKey)
+}
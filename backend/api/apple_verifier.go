@@ -0,0 +1,226 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"korean-kids-stories/entitlements"
+)
+
+// AppleVerifier verifies an iOS purchase from POST /api/iap/verify.
+// APPLE_IAP_VERIFIER selects the implementation: "server_api" (App Store Server API) or
+// "legacy" (verifyReceipt + shared secret). Default: server_api when APPLE_IAP_KEY_ID is set.
+type AppleVerifier interface {
+	Verify(ctx context.Context, req *VerifyRequest) (*AppleVerification, error)
+	// VerifyProducts checks several products with one store lookup (restore purchases).
+	// Products without a transaction are omitted; revoked ones are returned with Revoked set.
+	VerifyProducts(ctx context.Context, req *VerifyRequest, productIDs []string) ([]*AppleVerification, error)
+}
+
+// AppleVerification is the verified transaction for req.ProductID
+type AppleVerification struct {
+	OriginalTransactionID string // saved as iap_verifications.transaction_id
+	TransactionID         string
+	ProductID             string
+	ExpiresAt             string // empty for non-subscriptions
+	Environment           string
+	Revoked               bool            // refunded / revoked: ExpiresAt is the revocation date
+	Raw                   json.RawMessage // store response, kept in purchase_events
+}
+
+// verifyError is a verification failure with the HTTP status returned to the app
+type verifyError struct {
+	Status  int
+	Message string
+	Raw     json.RawMessage // store response when the store rejected the purchase
+}
+
+func (e *verifyError) Error() string {
+	return e.Message
+}
+
+// newAppleVerifier builds the configured verifier. Replaced in tests.
+var newAppleVerifier = func() (AppleVerifier, error) {
+	mode := os.Getenv("APPLE_IAP_VERIFIER")
+	if mode == "" {
+		mode = "legacy"
+		if os.Getenv("APPLE_IAP_KEY_ID") != "" {
+			mode = "server_api"
+		}
+	}
+	switch mode {
+	case "server_api":
+		return newAppStoreServerAPIVerifierFromEnv()
+	case "legacy":
+		secret := getAppleSharedSecret()
+		if secret == "" {
+			return nil, fmt.Errorf("IAP_SHARED_SECRET not set")
+		}
+		return &LegacyReceiptVerifier{SharedSecret: secret, ProductionURL: appleVerifyProd, SandboxURL: appleVerifySand}, nil
+	default:
+		return nil, fmt.Errorf("unknown APPLE_IAP_VERIFIER %q (server_api, legacy)", mode)
+	}
+}
+
+// LegacyReceiptVerifier posts the base64 receipt to the deprecated verifyReceipt endpoint
+type LegacyReceiptVerifier struct {
+	SharedSecret  string
+	ProductionURL string
+	SandboxURL    string
+	Client        *http.Client
+}
+
+// appleVerifyReq is sent to Apple
+type appleVerifyReq struct {
+	ReceiptData   string `json:"receipt-data"`
+	Password      string `json:"password"` // shared secret
+	ExcludeOldTxs bool   `json:"exclude-old-transactions"`
+}
+
+// appleVerifyResp is Apple's response
+type appleVerifyResp struct {
+	Status  int `json:"status"`
+	Receipt struct {
+		InApp []struct {
+			ProductID             string `json:"product_id"`
+			TransactionID         string `json:"transaction_id"`
+			OriginalTransactionID string `json:"original_transaction_id"`
+		} `json:"in_app"`
+	} `json:"receipt"`
+	LatestReceiptInfo []struct {
+		ProductID             string `json:"product_id"`
+		TransactionID         string `json:"transaction_id"`
+		OriginalTransactionID string `json:"original_transaction_id"`
+		ExpiresDateMs         string `json:"expires_date_ms"`
+		CancellationDateMs    string `json:"cancellation_date_ms"`
+	} `json:"latest_receipt_info"`
+	Environment string `json:"environment"`
+}
+
+func (v *LegacyReceiptVerifier) Verify(ctx context.Context, req *VerifyRequest) (*AppleVerification, error) {
+	results, err := v.VerifyProducts(ctx, req, []string{req.ProductID})
+	if err != nil {
+		return nil, err
+	}
+	// Refunded / revoked: rejected like the App Store Server API verifier
+	if results[0].Revoked {
+		return nil, &verifyError{Status: 400, Message: "transaction revoked", Raw: results[0].Raw}
+	}
+	// A valid receipt without the product is still reported as verified (no transaction id)
+	return results[0], nil
+}
+
+// VerifyProducts always returns one entry per product (empty transaction ids when absent)
+// for Verify; restore skips entries without a transaction id.
+func (v *LegacyReceiptVerifier) VerifyProducts(ctx context.Context, req *VerifyRequest, productIDs []string) ([]*AppleVerification, error) {
+	if req.ReceiptData == "" {
+		return nil, &verifyError{Status: 400, Message: "receipt_data required for ios"}
+	}
+	body := appleVerifyReq{
+		ReceiptData:   req.ReceiptData,
+		Password:      v.SharedSecret,
+		ExcludeOldTxs: true,
+	}
+	bodyBytes, _ := json.Marshal(body)
+
+	// Try production first
+	resp, raw, err := v.post(ctx, v.ProductionURL, bodyBytes)
+	if err != nil {
+		return nil, &verifyError{Status: 502, Message: "apple request failed: " + err.Error()}
+	}
+
+	// 21007 = sandbox receipt sent to prod → retry sandbox
+	if resp.Status == 21007 {
+		resp, raw, err = v.post(ctx, v.SandboxURL, bodyBytes)
+		if err != nil {
+			return nil, &verifyError{Status: 502, Message: "apple sandbox request failed: " + err.Error()}
+		}
+	}
+
+	if resp.Status != 0 {
+		return nil, &verifyError{Status: 400, Message: fmt.Sprintf("apple status %d", resp.Status), Raw: raw}
+	}
+
+	results := make([]*AppleVerification, 0, len(productIDs))
+	for _, productID := range productIDs {
+		// Find our product in in_app or latest_receipt_info (subscription: use latest_receipt_info for expires_date_ms)
+		result := &AppleVerification{ProductID: productID, Environment: resp.Environment, Raw: raw}
+		// Prefer latest_receipt_info for subscriptions (has expires_date_ms); latest expiry wins
+		var latest int64 = -1
+		for _, item := range resp.LatestReceiptInfo {
+			if item.ProductID != productID {
+				continue
+			}
+			ms, _ := strconv.ParseInt(item.ExpiresDateMs, 10, 64)
+			if ms <= latest {
+				continue
+			}
+			latest = ms
+			result.TransactionID = item.TransactionID
+			result.OriginalTransactionID = item.OriginalTransactionID
+			result.ExpiresAt = ""
+			if ms > 0 {
+				result.ExpiresAt = time.UnixMilli(ms).UTC().Format(entitlements.DateLayout)
+			}
+			result.Revoked = false
+			if cancel, _ := strconv.ParseInt(item.CancellationDateMs, 10, 64); cancel > 0 {
+				result.Revoked = true
+				result.ExpiresAt = time.UnixMilli(cancel).UTC().Format(entitlements.DateLayout)
+			}
+		}
+		if result.TransactionID == "" {
+			for _, item := range resp.Receipt.InApp {
+				if item.ProductID == productID {
+					result.TransactionID = item.TransactionID
+					result.OriginalTransactionID = item.OriginalTransactionID
+					break
+				}
+			}
+		}
+		if result.OriginalTransactionID == "" {
+			result.OriginalTransactionID = result.TransactionID
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// post returns the decoded response and the raw body without latest_receipt (the
+// re-encoded receipt is large and not needed for auditing)
+func (v *LegacyReceiptVerifier) post(ctx context.Context, url string, body []byte) (*appleVerifyResp, json.RawMessage, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(httpReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	var resp appleVerifyResp
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, nil, err
+	}
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(data, &raw) == nil {
+		delete(raw, "latest_receipt")
+		data, _ = json.Marshal(raw)
+	}
+	return &resp, data, nil
+}
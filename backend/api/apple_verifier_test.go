@@ -0,0 +1,276 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestLegacyReceiptVerifier(t *testing.T) {
+	expires := time.Now().Add(30 * 24 * time.Hour).UnixMilli()
+	older := time.Now().Add(-30 * 24 * time.Hour).UnixMilli()
+	cancelled := time.Now().Add(-time.Hour).UnixMilli()
+	ms := func(v int64) string { return strconv.FormatInt(v, 10) }
+
+	// Receipts by receipt-data; "sandbox-*" receipts answer 21007 in production
+	receipts := map[string]map[string]any{
+		"subscription": {
+			"status":         0,
+			"environment":    "Production",
+			"latest_receipt": "large base64 blob",
+			"latest_receipt_info": []map[string]string{
+				{"product_id": "premium", "transaction_id": "t1", "original_transaction_id": "o1", "expires_date_ms": ms(older)},
+				{"product_id": "premium", "transaction_id": "t2", "original_transaction_id": "o1", "expires_date_ms": ms(expires)},
+				{"product_id": "other", "transaction_id": "t9", "original_transaction_id": "o9", "expires_date_ms": ms(expires)},
+			},
+		},
+		"refunded": {
+			"status": 0,
+			"latest_receipt_info": []map[string]string{
+				{"product_id": "premium", "transaction_id": "t3", "original_transaction_id": "o3", "expires_date_ms": ms(expires), "cancellation_date_ms": ms(cancelled)},
+			},
+		},
+		"one-time": {
+			"status":  0,
+			"receipt": map[string]any{"in_app": []map[string]string{{"product_id": "premium", "transaction_id": "t4"}}},
+		},
+		"sandbox-subscription": {
+			"status":      0,
+			"environment": "Sandbox",
+			"latest_receipt_info": []map[string]string{
+				{"product_id": "premium", "transaction_id": "t5", "original_transaction_id": "o5", "expires_date_ms": ms(expires)},
+			},
+		},
+		"malformed": {"status": 21003},
+	}
+	handler := func(env string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var req appleVerifyReq
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Password != "shared" || !req.ExcludeOldTxs {
+				http.Error(w, "bad request", 400)
+				return
+			}
+			if env == "production" && strings.HasPrefix(req.ReceiptData, "sandbox-") {
+				json.NewEncoder(w).Encode(map[string]int{"status": 21007})
+				return
+			}
+			if env == "sandbox" && !strings.HasPrefix(req.ReceiptData, "sandbox-") {
+				json.NewEncoder(w).Encode(map[string]int{"status": 21008})
+				return
+			}
+			json.NewEncoder(w).Encode(receipts[req.ReceiptData])
+		}
+	}
+	prod := httptest.NewServer(handler("production"))
+	defer prod.Close()
+	sandbox := httptest.NewServer(handler("sandbox"))
+	defer sandbox.Close()
+	verifier := &LegacyReceiptVerifier{SharedSecret: "shared", ProductionURL: prod.URL, SandboxURL: sandbox.URL}
+
+	tests := []struct {
+		name     string
+		receipt  string
+		product  string
+		original string
+		expires  int64
+		revoked  bool
+		env      string
+		status   int // verifyError status, 0 = success
+		message  string
+	}{
+		{"latest expiry wins", "subscription", "premium", "o1", expires, false, "Production", 0, ""},
+		{"refunded purchase rejected", "refunded", "premium", "", 0, false, "", 400, "transaction revoked"},
+		{"non-subscription from in_app", "one-time", "premium", "t4", 0, false, "", 0, ""},
+		{"sandbox receipt retried on sandbox", "sandbox-subscription", "premium", "o5", expires, false, "Sandbox", 0, ""},
+		{"product not in receipt", "subscription", "missing", "", 0, false, "Production", 0, ""},
+		{"apple status error", "malformed", "premium", "", 0, false, "", 400, "apple status 21003"},
+		{"no receipt", "", "premium", "", 0, false, "", 400, "receipt_data required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifier.Verify(context.Background(), &VerifyRequest{Platform: "ios", ReceiptData: tt.receipt, ProductID: tt.product})
+			if tt.status != 0 {
+				verr := asVerifyError(err)
+				if err == nil || verr.Status != tt.status || !strings.Contains(verr.Message, tt.message) {
+					t.Fatalf("error = %v, want %d %q", err, tt.status, tt.message)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantExpires := ""
+			if tt.expires > 0 {
+				wantExpires = formatUnixMs(tt.expires)
+			}
+			if got.OriginalTransactionID != tt.original || got.ExpiresAt != wantExpires || got.Revoked != tt.revoked || got.Environment != tt.env {
+				t.Fatalf("got %+v", got)
+			}
+			if strings.Contains(string(got.Raw), "latest_receipt\"") {
+				t.Fatalf("raw response keeps latest_receipt: %s", got.Raw)
+			}
+		})
+	}
+
+	t.Run("several products with one request", func(t *testing.T) {
+		results, err := verifier.VerifyProducts(context.Background(), &VerifyRequest{ReceiptData: "subscription"}, []string{"premium", "other", "missing"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 3 || results[0].TransactionID != "t2" || results[1].TransactionID != "t9" || results[2].TransactionID != "" {
+			t.Fatalf("results = %+v %+v %+v", results[0], results[1], results[2])
+		}
+	})
+
+	t.Run("restore returns refunded products with the cancellation date", func(t *testing.T) {
+		results, err := verifier.VerifyProducts(context.Background(), &VerifyRequest{ReceiptData: "refunded"}, []string{"premium"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || !results[0].Revoked || results[0].OriginalTransactionID != "o3" || results[0].ExpiresAt != formatUnixMs(cancelled) {
+			t.Fatalf("results = %+v", results)
+		}
+	})
+
+	t.Run("store unreachable", func(t *testing.T) {
+		down := &LegacyReceiptVerifier{SharedSecret: "shared", ProductionURL: "http://127.0.0.1:1", SandboxURL: sandbox.URL}
+		_, err := down.Verify(context.Background(), &VerifyRequest{ReceiptData: "subscription", ProductID: "premium"})
+		if err == nil || asVerifyError(err).Status != 502 {
+			t.Fatalf("error = %v, want status 502", err)
+		}
+	})
+}
+
+func TestAppStoreServerAPIVerifier(t *testing.T) {
+	ca := newTestAppleCA(t)
+	forged := newTestAppleCA(t)
+	apiKey := newTestKey(t)
+	expires := time.Now().Add(30 * 24 * time.Hour).UnixMilli()
+	revoked := time.Now().Add(-time.Hour).UnixMilli()
+
+	signed := func(ca *testAppleCA, id, product, bundle string, revocation int64) string {
+		return ca.sign(t, map[string]any{"transactionId": id, "originalTransactionId": "orig-" + id, "productId": product,
+			"bundleId": bundle, "expiresDate": expires, "revocationDate": revocation, "environment": "Production"})
+	}
+	// history pages by transaction id (and revision for later pages)
+	type page struct {
+		signed   []string
+		hasMore  bool
+		revision string
+	}
+	production := map[string]page{
+		"100":          {signed: []string{signed(ca, "100", "premium", "com.test.kids", 0)}},
+		"200":          {signed: []string{signed(ca, "201", "other", "com.test.kids", 0)}, hasMore: true, revision: "r2"},
+		"200?r2":       {signed: []string{signed(ca, "200", "premium", "com.test.kids", 0)}},
+		"300":          {signed: []string{signed(ca, "300", "premium", "com.test.kids", revoked)}},
+		"400":          {signed: []string{signed(ca, "400", "premium", "com.other.app", 0)}},
+		"500":          {signed: []string{signed(forged, "500", "premium", "com.test.kids", 0)}},
+		"600":          {signed: []string{signed(ca, "600", "other", "com.test.kids", 0)}},
+		"server-error": {},
+	}
+	sandboxPages := map[string]page{
+		"700": {signed: []string{signed(ca, "700", "premium", "com.test.kids", 0)}},
+	}
+	handler := func(pages map[string]page) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			token, err := jwt.Parse(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+				func(*jwt.Token) (any, error) { return &apiKey.PublicKey, nil },
+				jwt.WithValidMethods([]string{"ES256"}), jwt.WithAudience("appstoreconnect-v1"), jwt.WithIssuer("issuer"))
+			if err != nil || token.Header["kid"] != "KEY123" {
+				http.Error(w, "unauthorized", 401)
+				return
+			}
+			id := strings.TrimPrefix(r.URL.Path, "/inApps/v2/history/")
+			if id == "server-error" {
+				http.Error(w, "boom", 500)
+				return
+			}
+			if rev := r.URL.Query().Get("revision"); rev != "" {
+				id += "?" + rev
+			}
+			p, ok := pages[id]
+			if !ok || r.URL.Query().Get("sort") != "DESCENDING" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(appleHistoryResponse{SignedTransactions: p.signed, HasMore: p.hasMore, Revision: p.revision})
+		}
+	}
+	prod := httptest.NewServer(handler(production))
+	defer prod.Close()
+	sandbox := httptest.NewServer(handler(sandboxPages))
+	defer sandbox.Close()
+	verifier := &AppStoreServerAPIVerifier{
+		KeyID:         "KEY123",
+		IssuerID:      "issuer",
+		BundleID:      "com.test.kids",
+		PrivateKey:    apiKey,
+		Roots:         ca.roots,
+		ProductionURL: prod.URL,
+		SandboxURL:    sandbox.URL,
+	}
+
+	tests := []struct {
+		name        string
+		transaction string
+		original    string
+		status      int // verifyError status, 0 = success
+		message     string
+	}{
+		{"production history", "100", "orig-100", 0, ""},
+		{"second history page", "200", "orig-200", 0, ""},
+		{"sandbox after production 404", "700", "orig-700", 0, ""},
+		{"revoked", "300", "", 400, "transaction revoked"},
+		{"bundle mismatch", "400", "", 400, "bundle id mismatch"},
+		{"forged signed transaction", "500", "", 502, "signed transaction"},
+		{"product not purchased", "600", "", 400, "product not found"},
+		{"unknown transaction", "999", "", 400, "apple transaction not found"},
+		{"store error", "server-error", "", 502, "status 500"},
+		{"no transaction id", "", "", 400, "transaction_id required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifier.Verify(context.Background(), &VerifyRequest{Platform: "ios", TransactionID: tt.transaction, ProductID: "premium"})
+			if tt.status != 0 {
+				verr := asVerifyError(err)
+				if err == nil || verr.Status != tt.status || !strings.Contains(verr.Message, tt.message) {
+					t.Fatalf("error = %v, want %d %q", err, tt.status, tt.message)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.OriginalTransactionID != tt.original || got.ExpiresAt != formatUnixMs(expires) || got.Revoked {
+				t.Fatalf("got %+v", got)
+			}
+		})
+	}
+
+	t.Run("restore returns revoked products", func(t *testing.T) {
+		results, err := verifier.VerifyProducts(context.Background(), &VerifyRequest{TransactionID: "300"}, []string{"premium", "other"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || !results[0].Revoked || results[0].ExpiresAt != formatUnixMs(revoked) {
+			t.Fatalf("results = %+v", results)
+		}
+	})
+
+	t.Run("wrong API key", func(t *testing.T) {
+		other := *verifier
+		other.PrivateKey = newTestKey(t)
+		_, err := other.Verify(context.Background(), &VerifyRequest{TransactionID: "100", ProductID: "premium"})
+		if err == nil || asVerifyError(err).Status != 502 {
+			t.Fatalf("error = %v, want status 502", err)
+		}
+	})
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"korean-kids-stories/audiometa"
+	"korean-kids-stories/entitlements"
+	"korean-kids-stories/streaming"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// maxSegmentBytes caps the byte range of a segment request
+const maxSegmentBytes = 8 << 20
+
+// audioContentTypes by chapter_audios.audio_format
+var audioContentTypes = map[string]string{
+	audiometa.FormatMP3:  "audio/mpeg",
+	audiometa.FormatM4A:  "audio/mp4",
+	audiometa.FormatWAV:  "audio/wav",
+	audiometa.FormatWebM: "audio/webm",
+}
+
+// AudioStreamToken is returned by GET /api/audio/{id}/token
+type AudioStreamToken struct {
+	Token       string `json:"token"`
+	ExpiresAt   string `json:"expires_at"`
+	StreamURL   string `json:"stream_url"`             // the file, with HTTP range requests
+	PlaylistURL string `json:"playlist_url,omitempty"` // HLS, MP3 files only
+}
+
+// RegisterAudioStreamRoutes adds the chapter audio streaming routes: GET /api/audio/{id}/token
+// (public; locked chapters and premium narrators need premium), then with ?token=
+// GET /api/audio/{id}/stream (range requests), /playlist.m3u8 and /segment.mp3 (HLS)
+func RegisterAudioStreamRoutes(se *core.ServeEvent) {
+	se.Router.GET("/api/audio/{id}/token", audioStreamTokenHandler(se.App))
+	se.Router.GET("/api/audio/{id}/stream", audioStreamHandler(se.App))
+	se.Router.GET("/api/audio/{id}/playlist.m3u8", audioPlaylistHandler(se.App))
+	se.Router.GET("/api/audio/{id}/segment.mp3", audioSegmentHandler(se.App))
+}
+
+func audioTokenSecret() []byte {
+	return []byte(os.Getenv("AUDIO_TOKEN_SECRET"))
+}
+
+// audioNeedsPremium: the audio of a locked chapter (is_free=false) or of a premium narrator
+func audioNeedsPremium(app core.App, audio *core.Record) (bool, error) {
+	chapter, err := app.FindRecordById("chapters", audio.GetString("chapter"))
+	if err != nil {
+		return true, err
+	}
+	if !chapter.GetBool("is_free") {
+		return true, nil
+	}
+	if ref := audio.GetString("narrator_ref"); ref != "" {
+		narrator, err := app.FindRecordById("narrators", ref)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return true, err
+		}
+		return narrator != nil && narrator.GetBool("is_premium"), nil
+	}
+	return false, nil
+}
+
+// audioFormat is chapter_audios.audio_format, or the format of the file extension
+func audioFormat(audio *core.Record) string {
+	if f := audio.GetString("audio_format"); f != "" {
+		return f
+	}
+	return audiometa.FormatFromName(audio.GetString("audio_file"))
+}
+
+// audioStreamTokenHandler issues a stream token for the signed-in user (or the guest's
+// X-Device-ID) when they may play the audio
+func audioStreamTokenHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		secret := audioTokenSecret()
+		if len(secret) == 0 {
+			return e.JSON(500, map[string]string{"error": "server misconfigured: AUDIO_TOKEN_SECRET not set"})
+		}
+		audio, err := app.FindRecordById("chapter_audios", e.Request.PathValue("id"))
+		if err != nil || audio.GetString("audio_file") == "" {
+			return e.JSON(404, map[string]string{"error": "audio not found"})
+		}
+		locked, err := audioNeedsPremium(app, audio)
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+		userID, deviceID := authUserID(e), e.Request.Header.Get(entitlements.DeviceIDHeader)
+		if locked && !e.HasSuperuserAuth() && !entitlements.IsPremium(app, userID, deviceID) {
+			return e.JSON(403, map[string]string{"error": "premium required for this audio"})
+		}
+
+		subject := userID
+		switch {
+		case e.HasSuperuserAuth():
+			subject = streaming.SuperuserSubject + e.Auth.Id
+		case subject == "" && deviceID != "":
+			subject = streaming.DeviceSubject + deviceID
+		}
+		exp := time.Now().Add(streaming.TokenTTL)
+		token := streaming.SignToken(secret, audio.Id, subject, exp)
+		expiresAt, _ := types.ParseDateTime(exp)
+		base := "/api/audio/" + audio.Id
+		resp := AudioStreamToken{
+			Token:     token,
+			ExpiresAt: expiresAt.String(),
+			StreamURL: base + "/stream?token=" + url.QueryEscape(token),
+		}
+		if audioFormat(audio) == audiometa.FormatMP3 {
+			resp.PlaylistURL = base + "/playlist.m3u8?token=" + url.QueryEscape(token)
+		}
+		return e.JSON(200, resp)
+	}
+}
+
+// streamedAudio verifies ?token= for the audio of the path. The entitlement of the token's
+// user / device is checked again for premium audio on every request (refunds end access
+// before the token expires); a nil record means the error response was written.
+func streamedAudio(app core.App, e *core.RequestEvent) (*core.Record, error) {
+	secret := audioTokenSecret()
+	if len(secret) == 0 {
+		return nil, e.JSON(500, map[string]string{"error": "server misconfigured: AUDIO_TOKEN_SECRET not set"})
+	}
+	id := e.Request.PathValue("id")
+	subject, err := streaming.VerifyToken(secret, e.Request.URL.Query().Get("token"), id, time.Now())
+	if err != nil {
+		return nil, e.JSON(401, map[string]string{"error": err.Error()})
+	}
+	audio, err := app.FindRecordById("chapter_audios", id)
+	if err != nil || audio.GetString("audio_file") == "" {
+		return nil, e.JSON(404, map[string]string{"error": "audio not found"})
+	}
+	locked, err := audioNeedsPremium(app, audio)
+	if err != nil {
+		return nil, e.JSON(500, map[string]string{"error": err.Error()})
+	}
+	userID, deviceID := subject, ""
+	if d, ok := strings.CutPrefix(subject, streaming.DeviceSubject); ok {
+		userID, deviceID = "", d
+	}
+	if locked && !isSuperuserSubject(app, subject) && !entitlements.IsPremium(app, userID, deviceID) {
+		return nil, e.JSON(403, map[string]string{"error": "premium required for this audio"})
+	}
+	return audio, nil
+}
+
+// isSuperuserSubject: the token was issued to a superuser that still exists
+func isSuperuserSubject(app core.App, subject string) bool {
+	id, ok := strings.CutPrefix(subject, streaming.SuperuserSubject)
+	if !ok || id == "" {
+		return false
+	}
+	_, err := app.FindRecordById(core.CollectionNameSuperusers, id)
+	return err == nil
+}
+
+// audioStreamHandler serves the audio file with HTTP range requests (206 Partial Content)
+func audioStreamHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		audio, err := streamedAudio(app, e)
+		if audio == nil {
+			return err
+		}
+		fsys, err := app.NewFilesystem()
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+		defer fsys.Close()
+		name := audio.GetString("audio_file")
+		r, err := fsys.GetReader(audio.BaseFilesPath() + "/" + name)
+		if err != nil {
+			return e.JSON(404, map[string]string{"error": "audio file not found"})
+		}
+		defer r.Close()
+
+		contentType := audioContentTypes[audioFormat(audio)]
+		if contentType == "" {
+			contentType = r.ContentType()
+		}
+		e.Response.Header().Set("Content-Type", contentType)
+		e.Response.Header().Set("Cache-Control", "private, max-age=900")
+		http.ServeContent(e.Response, e.Request, name, r.ModTime(), r)
+		return nil
+	}
+}
+
+// audioPlaylistHandler builds the HLS playlist of an MP3 file: segments of whole frames of
+// about streaming.SegmentSeconds, each a /segment.mp3 byte range of the file
+func audioPlaylistHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		audio, err := streamedAudio(app, e)
+		if audio == nil {
+			return err
+		}
+		if audioFormat(audio) != audiometa.FormatMP3 {
+			return e.JSON(415, map[string]string{"error": "HLS is available for MP3 audio only, use stream_url"})
+		}
+		fsys, err := app.NewFilesystem()
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+		defer fsys.Close()
+		r, err := fsys.GetReader(audio.BaseFilesPath() + "/" + audio.GetString("audio_file"))
+		if err != nil {
+			return e.JSON(404, map[string]string{"error": "audio file not found"})
+		}
+		defer r.Close()
+		segments, _, err := audiometa.MP3Segments(r, r.Size(), streaming.SegmentSeconds)
+		if err != nil {
+			return e.JSON(422, map[string]string{"error": err.Error()})
+		}
+
+		token := url.QueryEscape(e.Request.URL.Query().Get("token"))
+		playlist := streaming.Playlist(segments, func(i int, s audiometa.Segment) string {
+			return "segment.mp3?token=" + token +
+				"&offset=" + strconv.FormatInt(s.Offset, 10) +
+				"&length=" + strconv.FormatInt(s.Length, 10) +
+				"&start=" + strconv.FormatFloat(s.Start, 'f', 3, 64)
+		})
+		e.Response.Header().Set("Cache-Control", "private, no-cache")
+		return e.Blob(200, "application/vnd.apple.mpegurl", []byte(playlist))
+	}
+}
+
+// audioSegmentHandler serves an HLS segment: the ID3 timestamp tag, then bytes
+// [offset, offset+length) of the MP3 file. The entitlement is checked for every segment as
+// for /stream, so any byte range of the file may be asked for.
+func audioSegmentHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		audio, err := streamedAudio(app, e)
+		if audio == nil {
+			return err
+		}
+		q := e.Request.URL.Query()
+		offset, err1 := strconv.ParseInt(q.Get("offset"), 10, 64)
+		length, err2 := strconv.ParseInt(q.Get("length"), 10, 64)
+		start, err3 := strconv.ParseFloat(q.Get("start"), 64)
+		if err1 != nil || err2 != nil || err3 != nil || offset < 0 || length <= 0 || length > maxSegmentBytes || start < 0 {
+			return e.JSON(400, map[string]string{"error": "invalid segment"})
+		}
+
+		fsys, err := app.NewFilesystem()
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+		defer fsys.Close()
+		r, err := fsys.GetReader(audio.BaseFilesPath() + "/" + audio.GetString("audio_file"))
+		if err != nil {
+			return e.JSON(404, map[string]string{"error": "audio file not found"})
+		}
+		defer r.Close()
+		if offset+length > r.Size() {
+			return e.JSON(416, map[string]string{"error": "segment outside the file"})
+		}
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+
+		tag := streaming.TimestampTag(start)
+		e.Response.Header().Set("Content-Type", "audio/mpeg")
+		e.Response.Header().Set("Content-Length", strconv.FormatInt(int64(len(tag))+length, 10))
+		e.Response.Header().Set("Cache-Control", "private, max-age=900")
+		e.Response.WriteHeader(200)
+		if _, err := e.Response.Write(tag); err != nil {
+			return nil
+		}
+		io.CopyN(e.Response, r, length)
+		return nil
+	}
+}
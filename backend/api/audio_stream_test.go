@@ -0,0 +1,256 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"korean-kids-stories/streaming"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// streamTestAudio saves a story chapter (free or locked) with tone.mp3 as its audio
+func streamTestAudio(t *testing.T, app core.App, free bool) *core.Record {
+	t.Helper()
+	save := func(collection string, fields map[string]any) *core.Record {
+		col, err := app.FindCollectionByNameOrId(collection)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record := core.NewRecord(col)
+		record.Load(fields)
+		if err := app.Save(record); err != nil {
+			t.Fatalf("%s: %v", collection, err)
+		}
+		return record
+	}
+	file, err := filesystem.NewFileFromPath("../audiometa/testdata/tone.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	story := save("stories", map[string]any{"title": "Story", "category": "folktale", "age_min": 3, "age_max": 7, "total_chapters": 1})
+	chapter := save("chapters", map[string]any{"story": story.Id, "chapter_number": 1, "title": "One", "content": "<p>text</p>", "is_free": free})
+	return save("chapter_audios", map[string]any{"chapter": chapter.Id, "audio_file": file})
+}
+
+// serveAudio runs an audio route handler for the audio id with an optional Range header
+func serveAudio(app core.App, handler func(*core.RequestEvent) error, id, target, rangeHeader string) *httptest.ResponseRecorder {
+	return serveTest(app, func(e *core.RequestEvent) error {
+		e.Request.SetPathValue("id", id)
+		if rangeHeader != "" {
+			e.Request.Header.Set("Range", rangeHeader)
+		}
+		return handler(e)
+	}, "GET", target, nil)
+}
+
+func TestAudioStreamToken(t *testing.T) {
+	app := newTestApp(t)
+	t.Setenv("AUDIO_TOKEN_SECRET", "s3cret")
+	free := streamTestAudio(t, app, true)
+	locked := streamTestAudio(t, app, false)
+	savePremiumDevice(t, app, "device-1", time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name    string
+		audio   string
+		device  string
+		status  int
+		subject string
+	}{
+		{"free chapter for guests", free.Id, "", 200, ""},
+		{"locked chapter for guests", locked.Id, "", 403, ""},
+		{"locked chapter for a premium device", locked.Id, "device-1", 200, streaming.DeviceSubject + "device-1"},
+		{"unknown audio", "missing", "", 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveTest(app, func(e *core.RequestEvent) error {
+				e.Request.SetPathValue("id", tt.audio)
+				if tt.device != "" {
+					e.Request.Header.Set("X-Device-ID", tt.device)
+				}
+				return audioStreamTokenHandler(app)(e)
+			}, "GET", "/api/audio/"+tt.audio+"/token", nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != 200 {
+				return
+			}
+			var resp AudioStreamToken
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			subject, err := streaming.VerifyToken([]byte("s3cret"), resp.Token, tt.audio, time.Now())
+			if err != nil || subject != tt.subject {
+				t.Fatalf("token subject = %q, err = %v, want %q", subject, err, tt.subject)
+			}
+			if resp.StreamURL != "/api/audio/"+tt.audio+"/stream?token="+url.QueryEscape(resp.Token) || resp.PlaylistURL == "" {
+				t.Fatalf("urls = %q %q", resp.StreamURL, resp.PlaylistURL)
+			}
+		})
+	}
+}
+
+func TestAudioStream(t *testing.T) {
+	app := newTestApp(t)
+	t.Setenv("AUDIO_TOKEN_SECRET", "s3cret")
+	secret := []byte("s3cret")
+	data, err := os.ReadFile("../audiometa/testdata/tone.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	free := streamTestAudio(t, app, true)
+	locked := streamTestAudio(t, app, false)
+	savePremiumDevice(t, app, "premium", time.Now().Add(time.Hour))
+	savePremiumDevice(t, app, "refunded", time.Now().Add(-time.Hour))
+	superusers, err := app.FindCollectionByNameOrId(core.CollectionNameSuperusers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := core.NewRecord(superusers)
+	admin.SetEmail("admin@example.com")
+	admin.SetPassword("Passw0rd123")
+	if err := app.Save(admin); err != nil {
+		t.Fatal(err)
+	}
+	token := func(audio *core.Record, subject string) string {
+		return url.QueryEscape(streaming.SignToken(secret, audio.Id, subject, time.Now().Add(time.Minute)))
+	}
+	size := len(data)
+
+	tests := []struct {
+		name   string
+		audio  *core.Record
+		query  string
+		rng    string
+		status int
+		body   []byte
+		header string // expected Content-Range
+	}{
+		{"whole file", free, "token=" + token(free, ""), "", 200, data, ""},
+		{"range", free, "token=" + token(free, ""), "bytes=100-199", 206, data[100:200], fmt.Sprintf("bytes 100-199/%d", size)},
+		{"open-ended range", free, "token=" + token(free, ""), fmt.Sprintf("bytes=%d-", size-10), 206, data[size-10:], fmt.Sprintf("bytes %d-%d/%d", size-10, size-1, size)},
+		{"suffix range", free, "token=" + token(free, ""), "bytes=-10", 206, data[size-10:], fmt.Sprintf("bytes %d-%d/%d", size-10, size-1, size)},
+		{"range past the end", free, "token=" + token(free, ""), fmt.Sprintf("bytes=%d-", size), 416, nil, ""},
+		{"no token", free, "", "", 401, nil, ""},
+		{"token of another audio", free, "token=" + token(locked, ""), "", 401, nil, ""},
+		{"expired token", free, "token=" + url.QueryEscape(streaming.SignToken(secret, free.Id, "", time.Now().Add(-time.Second))), "", 401, nil, ""},
+		{"locked chapter, premium device", locked, "token=" + token(locked, streaming.DeviceSubject+"premium"), "bytes=0-9", 206, data[:10], ""},
+		{"locked chapter, refunded since the token", locked, "token=" + token(locked, streaming.DeviceSubject+"refunded"), "", 403, nil, ""},
+		{"locked chapter, superuser", locked, "token=" + token(locked, streaming.SuperuserSubject+admin.Id), "bytes=0-9", 206, data[:10], ""},
+		{"locked chapter, unknown superuser", locked, "token=" + token(locked, streaming.SuperuserSubject+"missing"), "", 403, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAudio(app, audioStreamHandler(app), tt.audio.Id, "/api/audio/"+tt.audio.Id+"/stream?"+tt.query, tt.rng)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.body != nil && !bytes.Equal(rec.Body.Bytes(), tt.body) {
+				t.Fatalf("body = %d bytes, want %d", rec.Body.Len(), len(tt.body))
+			}
+			if tt.header != "" && rec.Header().Get("Content-Range") != tt.header {
+				t.Fatalf("Content-Range = %q, want %q", rec.Header().Get("Content-Range"), tt.header)
+			}
+			if tt.status/100 == 2 && rec.Header().Get("Content-Type") != "audio/mpeg" {
+				t.Fatalf("Content-Type = %q", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestAudioPlaylistAndSegments(t *testing.T) {
+	app := newTestApp(t)
+	t.Setenv("AUDIO_TOKEN_SECRET", "s3cret")
+	data, err := os.ReadFile("../audiometa/testdata/tone.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := streamTestAudio(t, app, false)
+	savePremiumDevice(t, app, "refunded", time.Now().Add(-time.Hour))
+	token := url.QueryEscape(streaming.SignToken([]byte("s3cret"), locked.Id, streaming.DeviceSubject+"refunded", time.Now().Add(time.Minute)))
+	base := "/api/audio/" + locked.Id + "/"
+
+	// the playlist checks the entitlement again
+	if rec := serveAudio(app, audioPlaylistHandler(app), locked.Id, base+"playlist.m3u8?token="+token, ""); rec.Code != 403 {
+		t.Fatalf("playlist for a refunded device: status = %d", rec.Code)
+	}
+	savePremiumDevice(t, app, "refunded", time.Now().Add(time.Hour))
+	rec := serveAudio(app, audioPlaylistHandler(app), locked.Id, base+"playlist.m3u8?token="+token, "")
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "application/vnd.apple.mpegurl" {
+		t.Fatalf("playlist: status = %d, Content-Type = %q (%s)", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+
+	// tone.mp3 is 1.3 s: a single segment of every frame after the ID3 tag
+	var uris []string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if strings.HasPrefix(line, "segment.mp3?") {
+			uris = append(uris, line)
+		}
+	}
+	want := fmt.Sprintf("segment.mp3?token=%s&offset=10&length=%d&start=0.000", token, len(data)-10)
+	if len(uris) != 1 || uris[0] != want {
+		t.Fatalf("segment uris = %q, want %q", uris, want)
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		body   []byte
+	}{
+		{"playlist segment", strings.TrimPrefix(uris[0], "segment.mp3?"), 200, append(streaming.TimestampTag(0), data[10:]...)},
+		{"later segment", "token=" + token + "&offset=427&length=418&start=0.026", 200, append(streaming.TimestampTag(0.026), data[427:845]...)},
+		{"outside the file", fmt.Sprintf("token=%s&offset=%d&length=10&start=0", token, len(data)-5), 416, nil},
+		{"negative offset", "token=" + token + "&offset=-1&length=10&start=0", 400, nil},
+		{"too long", fmt.Sprintf("token=%s&offset=0&length=%d&start=0", token, maxSegmentBytes+1), 400, nil},
+		{"missing start", "token=" + token + "&offset=0&length=10", 400, nil},
+		{"bad token", "token=x&offset=10&length=10&start=0", 401, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAudio(app, audioSegmentHandler(app), locked.Id, base+"segment.mp3?"+tt.query, "")
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.body != nil && !bytes.Equal(rec.Body.Bytes(), tt.body) {
+				t.Fatalf("body = %d bytes, want %d", rec.Body.Len(), len(tt.body))
+			}
+		})
+	}
+
+	// segments check the entitlement again: a refund stops the playback of a loaded playlist
+	savePremiumDevice(t, app, "refunded", time.Now().Add(-time.Hour))
+	if rec := serveAudio(app, audioSegmentHandler(app), locked.Id, base+uris[0], ""); rec.Code != 403 {
+		t.Fatalf("segment for a refunded device: status = %d", rec.Code)
+	}
+}
+
+// savePremiumDevice stores (or moves the expiry of) a monthly subscription of a guest device
+func savePremiumDevice(t *testing.T, app core.App, deviceID string, expires time.Time) {
+	t.Helper()
+	row, err := app.FindFirstRecordByData("iap_verifications", "device_id", deviceID)
+	if err != nil {
+		col, err := app.FindCollectionByNameOrId("iap_verifications")
+		if err != nil {
+			t.Fatal(err)
+		}
+		row = core.NewRecord(col)
+		row.Set("device_id", deviceID)
+		row.Set("transaction_id", "tx-"+deviceID)
+		row.Set("product_id", testMonthly)
+		row.Set("platform", "android")
+	}
+	row.Set("expires_at", formatUnixMs(expires.UnixMilli()))
+	if err := app.Save(row); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"korean-kids-stories/entitlements"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// EntitlementsResponse is returned by GET /api/entitlements
+type EntitlementsResponse struct {
+	IsPremium    bool                       `json:"is_premium"`
+	Entitlements []entitlements.Entitlement `json:"entitlements"`
+}
+
+// RegisterEntitlementRoutes adds GET /api/entitlements and POST /api/entitlements/claim
+func RegisterEntitlementRoutes(se *core.ServeEvent) {
+	se.Router.GET("/api/entitlements", entitlementsHandler(se.App))
+	// Claiming guest purchases needs store proof: the device id alone is not a secret, so the
+	// receipts/tokens are re-verified exactly like a restore and only those rows are linked
+	se.Router.POST("/api/entitlements/claim", iapRestoreHandler(se.App)).Bind(apis.RequireAuth("users"))
+}
+
+// entitlementsHandler: active products for the signed-in user and/or X-Device-ID (guest)
+func entitlementsHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		list := entitlements.Active(app, authUserID(e), e.Request.Header.Get(entitlements.DeviceIDHeader))
+		if list == nil {
+			list = []entitlements.Entitlement{}
+		}
+		return e.JSON(200, EntitlementsResponse{IsPremium: len(list) > 0, Entitlements: list})
+	}
+}
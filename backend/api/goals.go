@@ -0,0 +1,28 @@
+package api
+
+import (
+	"time"
+
+	"korean-kids-stories/gamification"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterGoalRoutes adds GET /api/goals/today (users)
+func RegisterGoalRoutes(se *core.ServeEvent) {
+	se.Router.GET("/api/goals/today", goalsTodayHandler(se.App)).Bind(apis.RequireAuth("users"))
+}
+
+// goalsTodayHandler returns the progress of the caller's reading_goals today (this week for
+// stories_per_week) in their timezone. Goals reached since the last check are recorded with
+// their XP here too (newly_met).
+func goalsTodayHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		report, err := gamification.CheckGoals(app, authUserID(e), time.Now())
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+		return e.JSON(200, report)
+	}
+}
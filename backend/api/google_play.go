@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"korean-kids-stories/entitlements"
+
+	"google.golang.org/api/androidpublisher/v3"
+	"google.golang.org/api/option"
+)
+
+// errGoogleNotConfigured: no service account credentials
+var errGoogleNotConfigured = errors.New("GOOGLE_APPLICATION_CREDENTIALS or GOOGLE_IAP_CREDENTIALS_JSON required")
+
+// googlePublisher is the part of the Play Developer API used for purchases
+type googlePublisher interface {
+	GetSubscription(ctx context.Context, packageName, token string) (*androidpublisher.SubscriptionPurchaseV2, error)
+	GetProduct(ctx context.Context, packageName, productID, token string) (*androidpublisher.ProductPurchase, error)
+}
+
+// newGooglePublisher creates the Play Developer API client. Replaced by a stub in tests;
+// GOOGLE_PLAY_API_ENDPOINT points the real client at a local fake server.
+var newGooglePublisher = func(ctx context.Context) (googlePublisher, error) {
+	opts := getGoogleAuthOptions()
+	endpoint := os.Getenv("GOOGLE_PLAY_API_ENDPOINT")
+	if len(opts) == 0 {
+		if endpoint == "" {
+			return nil, errGoogleNotConfigured
+		}
+		opts = append(opts, option.WithoutAuthentication())
+	}
+	if endpoint != "" {
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+	svc, err := androidpublisher.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &playPublisher{svc: svc}, nil
+}
+
+type playPublisher struct {
+	svc *androidpublisher.Service
+}
+
+func (p *playPublisher) GetSubscription(ctx context.Context, packageName, token string) (*androidpublisher.SubscriptionPurchaseV2, error) {
+	return p.svc.Purchases.Subscriptionsv2.Get(packageName, token).Context(ctx).Do()
+}
+
+func (p *playPublisher) GetProduct(ctx context.Context, packageName, productID, token string) (*androidpublisher.ProductPurchase, error) {
+	return p.svc.Purchases.Products.Get(packageName, productID, token).Context(ctx).Do()
+}
+
+// googleSubscription is the subscriptionsv2 state we store
+type googleSubscription struct {
+	ProductID     string          `json:"product_id"`
+	OrderID       string          `json:"order_id"` // first order of the subscription (renewal suffix "..N" removed)
+	ExpiresAt     string          `json:"expires_at"`
+	AutoRenewing  bool            `json:"auto_renewing"`
+	State         string          `json:"state"`    // active, canceled, in_grace_period, on_hold, paused, expired, pending...
+	Entitled      bool            `json:"entitled"` // premium access right now
+	LinkedToken   string          `json:"-"`        // previous purchase token (upgrade/downgrade/resubscribe)
+	PurchaseToken string          `json:"-"`
+	Raw           json.RawMessage `json:"-"` // subscriptionsv2 response
+}
+
+// fetchGoogleSubscription loads a subscription purchase; productID selects the line item
+// ("" = the line item that expires last).
+func fetchGoogleSubscription(ctx context.Context, pub googlePublisher, packageName, token, productID string) (*googleSubscription, error) {
+	purchase, err := pub.GetSubscription(ctx, packageName, token)
+	if err != nil {
+		return nil, err
+	}
+	sub := &googleSubscription{
+		OrderID:       baseOrderID(purchase.LatestOrderId),
+		State:         strings.ToLower(strings.TrimPrefix(purchase.SubscriptionState, "SUBSCRIPTION_STATE_")),
+		LinkedToken:   purchase.LinkedPurchaseToken,
+		PurchaseToken: token,
+	}
+	sub.Raw, _ = purchase.MarshalJSON()
+	var expiry time.Time
+	itemOrderID := ""
+	for _, item := range purchase.LineItems {
+		if item == nil || (productID != "" && item.ProductId != productID) {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, item.ExpiryTime)
+		if err != nil || (!expiry.IsZero() && !t.After(expiry)) {
+			continue
+		}
+		expiry = t
+		sub.ProductID = item.ProductId
+		sub.AutoRenewing = item.AutoRenewingPlan != nil && item.AutoRenewingPlan.AutoRenewEnabled
+		itemOrderID = baseOrderID(item.LatestSuccessfulOrderId)
+	}
+	if sub.ProductID == "" {
+		return nil, errors.New("product not found in subscription purchase")
+	}
+	if sub.OrderID == "" {
+		sub.OrderID = itemOrderID // order of the selected line item
+	}
+	sub.ExpiresAt = expiry.UTC().Format(entitlements.DateLayout)
+
+	switch sub.State {
+	case "active", "in_grace_period", "canceled": // canceled = auto-renew off, access until expiry
+		sub.Entitled = expiry.After(time.Now())
+	}
+	if sub.OrderID == "" {
+		sub.OrderID = token[:min(64, len(token))]
+	}
+	return sub, nil
+}
+
+// baseOrderID strips the renewal suffix: GPA.1234-5678-9012-34567..3 → GPA.1234-5678-9012-34567
+func baseOrderID(orderID string) string {
+	if i := strings.Index(orderID, ".."); i > 0 {
+		return orderID[:i]
+	}
+	return orderID
+}
+
+// isSubscriptionProduct: premium products are auto-renewing subscriptions on Google Play
+func isSubscriptionProduct(productID string) bool {
+	for _, p := range entitlements.PremiumProductIDs {
+		if p == productID {
+			return true
+		}
+	}
+	return false
+}
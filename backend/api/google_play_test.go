@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"korean-kids-stories/entitlements"
+
+	"github.com/pocketbase/pocketbase/core"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+const testMonthly = "com.hbstore.koreankids.monthly"
+
+// stubPublisher answers subscriptionsv2 lookups from a map of purchase tokens
+type stubPublisher struct {
+	subscriptions map[string]*androidpublisher.SubscriptionPurchaseV2
+}
+
+func (p *stubPublisher) GetSubscription(ctx context.Context, packageName, token string) (*androidpublisher.SubscriptionPurchaseV2, error) {
+	if sub := p.subscriptions[token]; sub != nil {
+		return sub, nil
+	}
+	return nil, errors.New("purchase token not found")
+}
+
+func (p *stubPublisher) GetProduct(ctx context.Context, packageName, productID, token string) (*androidpublisher.ProductPurchase, error) {
+	return nil, errors.New("not a one-time product")
+}
+
+// useStubPublisher replaces newGooglePublisher for the test
+func useStubPublisher(t *testing.T, subs map[string]*androidpublisher.SubscriptionPurchaseV2) {
+	t.Helper()
+	previous := newGooglePublisher
+	newGooglePublisher = func(ctx context.Context) (googlePublisher, error) {
+		return &stubPublisher{subscriptions: subs}, nil
+	}
+	t.Cleanup(func() { newGooglePublisher = previous })
+}
+
+func testSubscription(state string, expiry time.Time, items ...*androidpublisher.SubscriptionPurchaseLineItem) *androidpublisher.SubscriptionPurchaseV2 {
+	if len(items) == 0 {
+		items = []*androidpublisher.SubscriptionPurchaseLineItem{{
+			ProductId:        testMonthly,
+			ExpiryTime:       expiry.UTC().Format(time.RFC3339),
+			AutoRenewingPlan: &androidpublisher.AutoRenewingPlan{AutoRenewEnabled: state == "SUBSCRIPTION_STATE_ACTIVE"},
+		}}
+	}
+	return &androidpublisher.SubscriptionPurchaseV2{
+		SubscriptionState: state,
+		LatestOrderId:     "GPA.1234-5678-9012-34567..2",
+		LineItems:         items,
+	}
+}
+
+func TestFetchGoogleSubscriptionStates(t *testing.T) {
+	future := time.Now().Add(72 * time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name      string
+		state     string
+		expiry    time.Time
+		wantState string
+		entitled  bool
+		autoRenew bool
+	}{
+		{"active", "SUBSCRIPTION_STATE_ACTIVE", future, "active", true, true},
+		{"canceled keeps access until expiry", "SUBSCRIPTION_STATE_CANCELED", future, "canceled", true, false},
+		{"canceled after expiry", "SUBSCRIPTION_STATE_CANCELED", past, "canceled", false, false},
+		{"grace period", "SUBSCRIPTION_STATE_IN_GRACE_PERIOD", future, "in_grace_period", true, false},
+		{"active but expiry passed", "SUBSCRIPTION_STATE_ACTIVE", past, "active", false, true},
+		{"account hold", "SUBSCRIPTION_STATE_ON_HOLD", future, "on_hold", false, false},
+		{"paused", "SUBSCRIPTION_STATE_PAUSED", future, "paused", false, false},
+		{"expired", "SUBSCRIPTION_STATE_EXPIRED", past, "expired", false, false},
+		{"pending", "SUBSCRIPTION_STATE_PENDING", future, "pending", false, false},
+		{"pending purchase canceled", "SUBSCRIPTION_STATE_PENDING_PURCHASE_CANCELED", past, "pending_purchase_canceled", false, false},
+		{"unspecified", "SUBSCRIPTION_STATE_UNSPECIFIED", future, "unspecified", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &stubPublisher{subscriptions: map[string]*androidpublisher.SubscriptionPurchaseV2{
+				"token": testSubscription(tt.state, tt.expiry),
+			}}
+			sub, err := fetchGoogleSubscription(context.Background(), pub, "pkg", "token", testMonthly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sub.State != tt.wantState || sub.Entitled != tt.entitled || sub.AutoRenewing != tt.autoRenew {
+				t.Fatalf("state=%q entitled=%v autoRenewing=%v, want %q %v %v",
+					sub.State, sub.Entitled, sub.AutoRenewing, tt.wantState, tt.entitled, tt.autoRenew)
+			}
+			if sub.OrderID != "GPA.1234-5678-9012-34567" {
+				t.Fatalf("order id = %q, renewal suffix not removed", sub.OrderID)
+			}
+			if want := tt.expiry.UTC().Truncate(time.Second).Format(entitlements.DateLayout); sub.ExpiresAt != want {
+				t.Fatalf("expires_at = %q, want %q", sub.ExpiresAt, want)
+			}
+		})
+	}
+}
+
+func TestFetchGoogleSubscriptionLineItems(t *testing.T) {
+	soon := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	later := soon.Add(30 * 24 * time.Hour)
+	items := []*androidpublisher.SubscriptionPurchaseLineItem{
+		{ProductId: testMonthly, ExpiryTime: soon.Format(time.RFC3339), LatestSuccessfulOrderId: "GPA.monthly"},
+		{ProductId: "com.hbstore.koreankids.yearly", ExpiryTime: later.Format(time.RFC3339), LatestSuccessfulOrderId: "GPA.yearly..0"},
+		{ProductId: "com.hbstore.koreankids.threemonth", ExpiryTime: "not a date"},
+	}
+	purchase := testSubscription("SUBSCRIPTION_STATE_ACTIVE", soon, items...)
+	purchase.LatestOrderId = ""
+	pub := &stubPublisher{subscriptions: map[string]*androidpublisher.SubscriptionPurchaseV2{"token": purchase}}
+
+	tests := []struct {
+		name      string
+		productID string
+		want      string
+		expires   time.Time
+		order     string
+		err       bool
+	}{
+		{"latest expiry when no product given", "", "com.hbstore.koreankids.yearly", later, "GPA.yearly", false},
+		{"requested product", testMonthly, testMonthly, soon, "GPA.monthly", false},
+		{"product without a valid expiry", "com.hbstore.koreankids.threemonth", "", time.Time{}, "", true},
+		{"product not in the purchase", "other", "", time.Time{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, err := fetchGoogleSubscription(context.Background(), pub, "pkg", "token", tt.productID)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", sub)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sub.ProductID != tt.want || sub.ExpiresAt != tt.expires.Format(entitlements.DateLayout) || sub.OrderID != tt.order {
+				t.Fatalf("got %s %s %s, want %s %s %s", sub.ProductID, sub.ExpiresAt, sub.OrderID,
+					tt.want, tt.expires.Format(entitlements.DateLayout), tt.order)
+			}
+		})
+	}
+}
+
+func TestVerifyAndRestoreGoogleSubscription(t *testing.T) {
+	future := time.Now().Add(72 * time.Hour)
+	useStubPublisher(t, map[string]*androidpublisher.SubscriptionPurchaseV2{
+		"active":  testSubscription("SUBSCRIPTION_STATE_ACTIVE", future),
+		"on-hold": testSubscription("SUBSCRIPTION_STATE_ON_HOLD", future),
+		"expired": testSubscription("SUBSCRIPTION_STATE_EXPIRED", time.Now().Add(-time.Hour)),
+		"other-product": testSubscription("SUBSCRIPTION_STATE_ACTIVE", future,
+			&androidpublisher.SubscriptionPurchaseLineItem{ProductId: "coins", ExpiryTime: future.UTC().Format(time.RFC3339)}),
+	})
+
+	tests := []struct {
+		token      string
+		verifyErr  string
+		restoreErr string
+		restored   bool
+	}{
+		{"active", "", "", true},
+		{"on-hold", "subscription not active: on_hold", "subscription not active: on_hold", false},
+		{"expired", "subscription not active: expired", "subscription not active: expired", false},
+		{"other-product", "google verify failed", "", false},
+		{"unknown", "google verify failed", "google verify failed", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			result, err := verifyGoogle(context.Background(), &VerifyRequest{Platform: "android", PurchaseToken: tt.token, ProductID: testMonthly})
+			checkVerifyError(t, "verify", err, tt.verifyErr)
+			if err == nil && (result.State != "active" || result.PurchaseToken != tt.token) {
+				t.Fatalf("verify result = %+v", result)
+			}
+
+			item, err := restoreGoogleToken(context.Background(), tt.token)
+			checkVerifyError(t, "restore", err, tt.restoreErr)
+			if (item != nil) != tt.restored {
+				t.Fatalf("restore item = %+v, want restored=%v", item, tt.restored)
+			}
+		})
+	}
+}
+
+func checkVerifyError(t *testing.T, step string, err error, want string) {
+	t.Helper()
+	if want == "" {
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		return
+	}
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("%s: error = %v, want %q", step, err, want)
+	}
+	if status := asVerifyError(err).Status; status != 400 {
+		t.Fatalf("%s: status = %d, want 400", step, status)
+	}
+}
+
+func TestGoogleSubscriptionNotification(t *testing.T) {
+	app := newTestApp(t)
+	future := time.Now().Add(72 * time.Hour)
+	useStubPublisher(t, map[string]*androidpublisher.SubscriptionPurchaseV2{
+		"token-1": testSubscription("SUBSCRIPTION_STATE_ON_HOLD", time.Now().Add(-time.Hour)),
+		"token-2": testSubscription("SUBSCRIPTION_STATE_ACTIVE", future),
+	})
+
+	col, err := app.FindCollectionByNameOrId("iap_verifications")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := core.NewRecord(col)
+	row.Set("device_id", "device-1")
+	row.Set("transaction_id", "GPA.1234-5678-9012-34567")
+	row.Set("product_id", testMonthly)
+	row.Set("platform", "android")
+	row.Set("purchase_token", "token-1")
+	if err := app.Save(row); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		kind      int
+		state     string
+		premium   bool
+		autoRenew bool
+	}{
+		{"on hold ends access", "token-1", 5, "on_hold", false, false},
+		{"recovered", "token-2", 1, "active", true, true},
+		{"revoked", "token-2", googleSubscriptionRevoked, "revoked", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, matched, err := applyGoogleSubscriptionNotification(app, "pkg", tt.token, testMonthly, tt.kind)
+			if err != nil || matched != 1 {
+				t.Fatalf("matched=%d err=%v", matched, err)
+			}
+			stored, err := app.FindRecordById("iap_verifications", row.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.GetString("subscription_state") != tt.state || stored.GetBool("auto_renewing") != tt.autoRenew {
+				t.Fatalf("state=%q auto_renewing=%v", stored.GetString("subscription_state"), stored.GetBool("auto_renewing"))
+			}
+			if got := entitlements.IsPremium(app, "", "device-1"); got != tt.premium {
+				t.Fatalf("premium = %v, want %v", got, tt.premium)
+			}
+		})
+	}
+}
+
+func TestGoogleSubscriptionUpgradeNotification(t *testing.T) {
+	app := newTestApp(t)
+	const yearly = "com.hbstore.koreankids.yearly"
+	upgraded := testSubscription("SUBSCRIPTION_STATE_ACTIVE", time.Time{}, &androidpublisher.SubscriptionPurchaseLineItem{
+		ProductId:        yearly,
+		ExpiryTime:       time.Now().Add(365 * 24 * time.Hour).UTC().Format(time.RFC3339),
+		AutoRenewingPlan: &androidpublisher.AutoRenewingPlan{AutoRenewEnabled: true},
+	})
+	upgraded.LatestOrderId = "GPA.9999-8888-7777-66666"
+	upgraded.LinkedPurchaseToken = "token-monthly"
+	useStubPublisher(t, map[string]*androidpublisher.SubscriptionPurchaseV2{"token-yearly": upgraded})
+
+	col, err := app.FindCollectionByNameOrId("iap_verifications")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := core.NewRecord(col)
+	row.Set("device_id", "device-1")
+	row.Set("transaction_id", "GPA.1234-5678-9012-34567")
+	row.Set("product_id", testMonthly)
+	row.Set("platform", "android")
+	row.Set("purchase_token", "token-monthly")
+	row.Set("expires_at", time.Now().Add(-time.Hour).UTC().Format(entitlements.DateLayout))
+	if err := app.Save(row); err != nil {
+		t.Fatal(err)
+	}
+
+	// SUBSCRIPTION_PURCHASED for the new token, linked to the monthly purchase
+	_, matched, err := applyGoogleSubscriptionNotification(app, "pkg", "token-yearly", yearly, 4)
+	if err != nil || matched != 1 {
+		t.Fatalf("matched=%d err=%v", matched, err)
+	}
+	stored, err := app.FindRecordById("iap_verifications", row.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.GetString("product_id") != yearly || stored.GetString("purchase_token") != "token-yearly" ||
+		stored.GetString("transaction_id") != "GPA.9999-8888-7777-66666" {
+		t.Fatalf("product_id=%q purchase_token=%q transaction_id=%q", stored.GetString("product_id"),
+			stored.GetString("purchase_token"), stored.GetString("transaction_id"))
+	}
+	if !entitlements.IsPremium(app, "", "device-1") {
+		t.Fatal("upgraded subscription is not premium")
+	}
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"korean-kids-stories/entitlements"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// pubsubPushEnvelope is the body of a Pub/Sub push subscription
+type pubsubPushEnvelope struct {
+	Message struct {
+		Data       string            `json:"data"` // base64 DeveloperNotification
+		MessageID  string            `json:"messageId"`
+		Attributes map[string]string `json:"attributes"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// googleDeveloperNotification is the RTDN payload
+type googleDeveloperNotification struct {
+	Version                  string `json:"version"`
+	PackageName              string `json:"packageName"`
+	EventTimeMillis          string `json:"eventTimeMillis"`
+	SubscriptionNotification *struct {
+		NotificationType int    `json:"notificationType"`
+		PurchaseToken    string `json:"purchaseToken"`
+		SubscriptionID   string `json:"subscriptionId"`
+	} `json:"subscriptionNotification,omitempty"`
+	VoidedPurchaseNotification *struct {
+		PurchaseToken string `json:"purchaseToken"`
+		OrderID       string `json:"orderId"`
+		ProductType   int    `json:"productType"`
+		RefundType    int    `json:"refundType"`
+	} `json:"voidedPurchaseNotification,omitempty"`
+	OneTimeProductNotification *struct {
+		NotificationType int    `json:"notificationType"`
+		PurchaseToken    string `json:"purchaseToken"`
+		SKU              string `json:"sku"`
+	} `json:"oneTimeProductNotification,omitempty"`
+	TestNotification *struct {
+		Version string `json:"version"`
+	} `json:"testNotification,omitempty"`
+}
+
+// googleSubscriptionNotificationTypes names subscriptionNotification.notificationType
+var googleSubscriptionNotificationTypes = map[int]string{
+	1:  "SUBSCRIPTION_RECOVERED",
+	2:  "SUBSCRIPTION_RENEWED",
+	3:  "SUBSCRIPTION_CANCELED",
+	4:  "SUBSCRIPTION_PURCHASED",
+	5:  "SUBSCRIPTION_ON_HOLD",
+	6:  "SUBSCRIPTION_IN_GRACE_PERIOD",
+	7:  "SUBSCRIPTION_RESTARTED",
+	8:  "SUBSCRIPTION_PRICE_CHANGE_CONFIRMED",
+	9:  "SUBSCRIPTION_DEFERRED",
+	10: "SUBSCRIPTION_PAUSED",
+	11: "SUBSCRIPTION_PAUSE_SCHEDULE_CHANGED",
+	12: "SUBSCRIPTION_REVOKED",
+	13: "SUBSCRIPTION_EXPIRED",
+	17: "SUBSCRIPTION_ITEMS_CHANGED",
+	18: "SUBSCRIPTION_CANCELLATION_SCHEDULED",
+	19: "SUBSCRIPTION_PRICE_CHANGE_UPDATED",
+	20: "SUBSCRIPTION_PENDING_PURCHASE_CANCELED",
+	22: "SUBSCRIPTION_PRICE_STEP_UP_CONSENT_UPDATED",
+}
+
+const googleSubscriptionRevoked = 12
+
+// googleRTDNHandler: POST /api/iap/google/rtdn?token=... (Pub/Sub push endpoint).
+// Subscription events re-read the purchase from subscriptionsv2 (source of truth) and update
+// iap_verifications; voided purchases end access. Non-2xx makes Pub/Sub redeliver.
+func googleRTDNHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		secret := os.Getenv("GOOGLE_RTDN_TOKEN")
+		if secret == "" {
+			return e.JSON(500, map[string]string{"error": "server misconfigured: GOOGLE_RTDN_TOKEN not set"})
+		}
+		if subtle.ConstantTimeCompare([]byte(e.Request.URL.Query().Get("token")), []byte(secret)) != 1 {
+			return e.JSON(401, map[string]string{"error": "invalid token"})
+		}
+
+		var envelope pubsubPushEnvelope
+		if err := json.NewDecoder(e.Request.Body).Decode(&envelope); err != nil || envelope.Message.Data == "" {
+			return e.JSON(400, map[string]string{"error": "invalid pub/sub envelope"})
+		}
+		data, err := base64.StdEncoding.DecodeString(envelope.Message.Data)
+		var dn googleDeveloperNotification
+		if err == nil {
+			err = json.Unmarshal(data, &dn)
+		}
+		if err != nil {
+			_ = saveIAPNotification(app, nil, iapNotification{Platform: "android", NotificationID: envelope.Message.MessageID, Result: "rejected", Error: "invalid message data: " + err.Error()})
+			return e.JSON(400, map[string]string{"error": "invalid message data"})
+		}
+
+		existing := findIAPNotification(app, "android", envelope.Message.MessageID)
+		if existing != nil && existing.GetString("result") != "failed" {
+			return e.JSON(200, map[string]string{"status": "duplicate"})
+		}
+
+		n := iapNotification{
+			Platform:       "android",
+			NotificationID: envelope.Message.MessageID,
+		}
+		payloadLog := map[string]any{"notification": dn}
+		n.Payload = payloadLog
+
+		status := 200
+		var matched int
+		switch {
+		case dn.PackageName != getGooglePackageName():
+			n.Result, n.Error = "ignored", "package "+dn.PackageName
+		case dn.TestNotification != nil:
+			n.Type, n.Result = "TEST", "ignored"
+		case dn.SubscriptionNotification != nil:
+			sn := dn.SubscriptionNotification
+			n.Type = googleSubscriptionNotificationTypes[sn.NotificationType]
+			if n.Type == "" {
+				n.Type = "SUBSCRIPTION_" + strconv.Itoa(sn.NotificationType)
+			}
+			n.ProductID = sn.SubscriptionID
+			var sub *googleSubscription
+			sub, matched, err = applyGoogleSubscriptionNotification(app, dn.PackageName, sn.PurchaseToken, sn.SubscriptionID, sn.NotificationType)
+			if sub != nil {
+				n.TransactionID = sub.OrderID
+				payloadLog["subscription"] = sub
+			}
+		case dn.VoidedPurchaseNotification != nil:
+			vn := dn.VoidedPurchaseNotification
+			n.Type = "VOIDED_PURCHASE"
+			n.TransactionID = baseOrderID(vn.OrderID)
+			matched, err = revokeGooglePurchase(app, vn.PurchaseToken, n.TransactionID)
+		default:
+			n.Type, n.Result = "ONE_TIME_PRODUCT", "ignored"
+		}
+		if n.Result == "" {
+			switch {
+			case err != nil:
+				n.Result, n.Error = "failed", err.Error()
+				status = 500
+			case matched == 0:
+				n.Result = "unmatched" // not verified from the app yet
+			default:
+				n.Result = "processed"
+			}
+		}
+
+		if err := saveIAPNotification(app, existing, n); err != nil {
+			log.Printf("google rtdn: audit %s: %v", n.NotificationID, err)
+		}
+		if status != 200 {
+			return e.JSON(status, map[string]string{"error": n.Error})
+		}
+		return e.JSON(200, map[string]string{"status": n.Result})
+	}
+}
+
+// applyGoogleSubscriptionNotification re-reads the subscription and updates every matching
+// iap_verifications row (one per device). Rows of the linked (previous) purchase token move
+// to the new token and product (the selected line item) after an upgrade/downgrade.
+func applyGoogleSubscriptionNotification(app core.App, packageName, token, productID string, notificationType int) (*googleSubscription, int, error) {
+	if token == "" {
+		return nil, 0, errors.New("missing purchase token")
+	}
+	ctx := context.Background()
+	pub, err := newGooglePublisher(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	sub, err := fetchGoogleSubscription(ctx, pub, packageName, token, productID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if notificationType == googleSubscriptionRevoked {
+		sub.ExpiresAt = time.Now().UTC().Format(entitlements.DateLayout)
+		sub.State = "revoked"
+		sub.Entitled = false
+	}
+
+	filter := "platform = 'android' && (purchase_token = {:token} || transaction_id = {:order}"
+	params := dbx.Params{"token": token, "order": sub.OrderID}
+	if sub.LinkedToken != "" {
+		filter += " || purchase_token = {:linked}"
+		params["linked"] = sub.LinkedToken
+	}
+	records, err := app.FindRecordsByFilter("iap_verifications", filter+")", "", 0, 0, params)
+	if err != nil || len(records) == 0 {
+		return sub, 0, err
+	}
+	err = app.RunInTransaction(func(txApp core.App) error {
+		for _, r := range records {
+			r.Set("purchase_token", token)
+			r.Set("transaction_id", sub.OrderID)
+			r.Set("product_id", sub.ProductID)
+			r.Set("expires_at", sub.ExpiresAt)
+			r.Set("auto_renewing", sub.AutoRenewing)
+			r.Set("subscription_state", sub.State)
+			if err := txApp.Save(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return sub, len(records), err
+}
+
+// revokeGooglePurchase ends access for a refunded/charged-back purchase
+func revokeGooglePurchase(app core.App, token, orderID string) (int, error) {
+	// Empty values must not match legacy rows without a purchase token
+	var conditions []string
+	if token != "" {
+		conditions = append(conditions, "purchase_token = {:token}")
+	}
+	if orderID != "" {
+		conditions = append(conditions, "transaction_id = {:order}")
+	}
+	if len(conditions) == 0 {
+		return 0, nil
+	}
+	records, err := app.FindRecordsByFilter("iap_verifications",
+		"platform = 'android' && ("+strings.Join(conditions, " || ")+")", "", 0, 0,
+		dbx.Params{"token": token, "order": orderID})
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+	now := time.Now().UTC().Format(entitlements.DateLayout)
+	err = app.RunInTransaction(func(txApp core.App) error {
+		for _, r := range records {
+			r.Set("expires_at", now)
+			r.Set("auto_renewing", false)
+			r.Set("subscription_state", "revoked")
+			if err := txApp.Save(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(records), err
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"korean-kids-stories/migrations"
+
+	"github.com/pocketbase/pocketbase/core"
+	_ "github.com/pocketbase/pocketbase/migrations" // system collections, run by Bootstrap
+)
+
+// newTestApp bootstraps an app in a temporary directory with every migration applied
+func newTestApp(t *testing.T) core.App {
+	t.Helper()
+	app := core.NewBaseApp(core.BaseAppConfig{DataDir: t.TempDir()})
+	if err := app.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.ResetBootstrapState() })
+	if _, err := migrations.Up(app, 0); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// serveTest runs a route handler on a request and returns the recorded response
+func serveTest(app core.App, handler func(*core.RequestEvent) error, method, target string, body io.Reader) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e := &core.RequestEvent{App: app}
+	e.Request = httptest.NewRequest(method, target, body)
+	e.Response = rec
+	if err := handler(e); err != nil {
+		rec.Code = 500
+	}
+	return rec
+}
@@ -1,19 +1,14 @@
 package api
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
+	"errors"
+	"log"
 	"os"
-	"strconv"
-	"strings"
-	"time"
 
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
-	"google.golang.org/api/androidpublisher/v3"
 	"google.golang.org/api/option"
 )
 
@@ -26,11 +21,11 @@ const (
 // VerifyRequest is the incoming JSON from the app
 type VerifyRequest struct {
 	Platform      string `json:"platform"`       // "ios" or "android"
-	ReceiptData   string `json:"receipt_data"`  // base64 (iOS)
+	ReceiptData   string `json:"receipt_data"`   // base64 (iOS)
 	PurchaseToken string `json:"purchase_token"` // Android
-	ProductID     string `json:"product_id"`    // e.g. "premium"
-	TransactionID string `json:"transaction_id"` // optional, for idempotency
-	DeviceID      string `json:"device_id"`     // required - used to store verified purchase for chapter is_premium
+	ProductID     string `json:"product_id"`     // e.g. "premium"
+	TransactionID string `json:"transaction_id"` // iOS (App Store Server API): StoreKit transaction id
+	DeviceID      string `json:"device_id"`      // required - used to store verified purchase for chapter is_premium
 }
 
 // VerifyResponse is returned to the app
@@ -40,34 +35,23 @@ type VerifyResponse struct {
 	Error         string `json:"error,omitempty"`
 }
 
-// appleVerifyReq is sent to Apple
-type appleVerifyReq struct {
-	ReceiptData          string `json:"receipt-data"`
-	Password             string `json:"password"` // shared secret
-	ExcludeOldTxs        bool   `json:"exclude-old-transactions"`
-}
-
-// appleVerifyResp is Apple's response
-type appleVerifyResp struct {
-	Status      int `json:"status"`
-	Receipt     struct {
-		InApp []struct {
-			ProductID             string `json:"product_id"`
-			TransactionID         string `json:"transaction_id"`
-			OriginalTransactionID string `json:"original_transaction_id"`
-		} `json:"in_app"`
-	} `json:"receipt"`
-	LatestReceiptInfo []struct {
-		ProductID             string `json:"product_id"`
-		TransactionID         string `json:"transaction_id"`
-		OriginalTransactionID string `json:"original_transaction_id"`
-		ExpiresDateMs         string `json:"expires_date_ms"`
-	} `json:"latest_receipt_info"`
-}
-
-// RegisterIAPRoutes adds POST /api/iap/verify
+// RegisterIAPRoutes adds POST /api/iap/verify, /api/iap/restore and the store server notification webhooks
 func RegisterIAPRoutes(se *core.ServeEvent) {
 	se.Router.POST("/api/iap/verify", iapVerifyHandler(se.App))
+	se.Router.POST("/api/iap/restore", iapRestoreHandler(se.App))
+	se.Router.POST("/api/iap/apple/notifications", appleNotificationsHandler(se.App))
+	se.Router.POST("/api/iap/google/rtdn", googleRTDNHandler(se.App))
+}
+
+// storeResult is a purchase verified with Apple or Google
+type storeResult struct {
+	TransactionID string // original transaction id (ios) / first order id (android)
+	ExpiresAt     string
+	PurchaseToken string
+	AutoRenewing  *bool
+	State         string
+	Environment   string
+	Raw           json.RawMessage // store response, kept in purchase_events
 }
 
 func iapVerifyHandler(app core.App) func(*core.RequestEvent) error {
@@ -80,161 +64,138 @@ func iapVerifyHandler(app core.App) func(*core.RequestEvent) error {
 			req.ProductID = productIDPremium
 		}
 
-		switch req.Platform {
-		case "ios":
-			return handleAppleVerify(app, e, &req)
-		case "android":
-			return handleGoogleVerify(app, e, &req)
-		default:
-			return e.JSON(400, VerifyResponse{Error: "platform must be ios or android"})
-		}
-	}
-}
-
-func handleAppleVerify(app core.App, e *core.RequestEvent, req *VerifyRequest) error {
-	if req.ReceiptData == "" {
-		return e.JSON(400, VerifyResponse{Error: "receipt_data required for ios"})
-	}
-	secret := getAppleSharedSecret()
-	if secret == "" {
-		return e.JSON(500, VerifyResponse{Error: "server misconfigured: IAP_SHARED_SECRET not set"})
-	}
-
-	body := appleVerifyReq{
-		ReceiptData:   req.ReceiptData,
-		Password:      secret,
-		ExcludeOldTxs: true,
-	}
-	bodyBytes, _ := json.Marshal(body)
-
-	// Try production first
-	resp, err := doAppleVerify(appleVerifyProd, bodyBytes)
-	if err != nil {
-		return e.JSON(502, VerifyResponse{Error: "apple request failed: " + err.Error()})
-	}
-
-	// 21007 = sandbox receipt sent to prod → retry sandbox
-	if resp.Status == 21007 {
-		resp, err = doAppleVerify(appleVerifySand, bodyBytes)
+		event := newPurchaseEvent(e, &req)
+		result, err := verifyStorePurchase(e.Request.Context(), &req)
 		if err != nil {
-			return e.JSON(502, VerifyResponse{Error: "apple sandbox request failed: " + err.Error()})
+			verr := asVerifyError(err)
+			event.reject(verr)
+			logPurchaseEvent(app, event)
+			return e.JSON(verr.Status, VerifyResponse{Error: verr.Message})
 		}
-	}
-
-	if resp.Status != 0 {
-		return e.JSON(400, VerifyResponse{
-			Error: fmt.Sprintf("apple status %d", resp.Status),
-		})
-	}
+		event.accept(result)
 
-	// Find our product in in_app or latest_receipt_info (subscription: use latest_receipt_info for expires_date_ms)
-	productID := req.ProductID
-	if productID == "" {
-		productID = productIDPremium
-	}
-	txID := ""
-	var expiresAt string
-	// Prefer latest_receipt_info for subscriptions (has expires_date_ms)
-	for _, item := range resp.LatestReceiptInfo {
-		if item.ProductID == productID {
-			txID = item.OriginalTransactionID
-			if txID == "" {
-				txID = item.TransactionID
+		userID := authUserID(e)
+		if req.DeviceID != "" && result.TransactionID != "" {
+			reason, err := checkReplay(app, req.Platform, result.TransactionID, req.DeviceID, userID)
+			if err != nil {
+				log.Printf("iap verify: replay check %s: %v", result.TransactionID, err)
 			}
-			if item.ExpiresDateMs != "" {
-				if ms, err := strconv.ParseInt(item.ExpiresDateMs, 10, 64); err == nil && ms > 0 {
-					expiresAt = time.UnixMilli(ms).UTC().Format("2006-01-02 15:04:05.000Z")
-				}
+			if reason != "" {
+				event.block(reason)
+				logPurchaseEvent(app, event)
+				return e.JSON(403, VerifyResponse{Error: "purchase already used on too many devices or accounts"})
 			}
-			break
-		}
-	}
-	if txID == "" {
-		for _, item := range resp.Receipt.InApp {
-			if item.ProductID == productID {
-				txID = item.OriginalTransactionID
-				if txID == "" {
-					txID = item.TransactionID
-				}
-				break
+			if err := saveVerifiedPurchase(app, verifiedPurchase{
+				DeviceID:      req.DeviceID,
+				UserID:        userID,
+				TransactionID: result.TransactionID,
+				ProductID:     req.ProductID,
+				Platform:      req.Platform,
+				ExpiresAt:     result.ExpiresAt,
+				PurchaseToken: result.PurchaseToken,
+				AutoRenewing:  result.AutoRenewing,
+				State:         result.State,
+			}); err != nil {
+				log.Printf("iap verify: save %s: %v", result.TransactionID, err)
 			}
 		}
+		logPurchaseEvent(app, event)
+		return e.JSON(200, VerifyResponse{Verified: true, TransactionID: result.TransactionID})
 	}
+}
 
-	if req.DeviceID != "" && txID != "" {
-		_ = saveVerifiedPurchase(app, req.DeviceID, txID, productID, "ios", expiresAt)
+// verifyStorePurchase checks the purchase with the store of req.Platform
+func verifyStorePurchase(ctx context.Context, req *VerifyRequest) (*storeResult, error) {
+	switch req.Platform {
+	case "ios":
+		return verifyApple(ctx, req)
+	case "android":
+		return verifyGoogle(ctx, req)
+	default:
+		return nil, &verifyError{Status: 400, Message: "platform must be ios or android"}
 	}
-	return e.JSON(200, VerifyResponse{Verified: true, TransactionID: txID})
 }
 
-func doAppleVerify(url string, body []byte) (*appleVerifyResp, error) {
-	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(body))
-	if err != nil {
-		return nil, err
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	res, err := client.Do(httpReq)
+func verifyApple(ctx context.Context, req *VerifyRequest) (*storeResult, error) {
+	verifier, err := newAppleVerifier()
 	if err != nil {
-		return nil, err
+		return nil, &verifyError{Status: 500, Message: "server misconfigured: " + err.Error()}
 	}
-	defer res.Body.Close()
-	data, err := io.ReadAll(res.Body)
+	result, err := verifier.Verify(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	var v appleVerifyResp
-	if err := json.Unmarshal(data, &v); err != nil {
-		return nil, err
-	}
-	return &v, nil
+	return &storeResult{
+		TransactionID: result.OriginalTransactionID,
+		ExpiresAt:     result.ExpiresAt,
+		Environment:   result.Environment,
+		Raw:           result.Raw,
+	}, nil
 }
 
-func handleGoogleVerify(app core.App, e *core.RequestEvent, req *VerifyRequest) error {
+func verifyGoogle(ctx context.Context, req *VerifyRequest) (*storeResult, error) {
 	if req.PurchaseToken == "" {
-		return e.JSON(400, VerifyResponse{Error: "purchase_token required for android"})
+		return nil, &verifyError{Status: 400, Message: "purchase_token required for android"}
 	}
 	pkg := getGooglePackageName()
 	if pkg == "" {
-		return e.JSON(500, VerifyResponse{Error: "server misconfigured: GOOGLE_PACKAGE_NAME not set"})
+		return nil, &verifyError{Status: 500, Message: "server misconfigured: GOOGLE_PACKAGE_NAME not set"}
 	}
 
 	// Create androidpublisher client (uses GOOGLE_APPLICATION_CREDENTIALS or GOOGLE_IAP_CREDENTIALS_JSON)
-	ctx := context.Background()
-	opts := getGoogleAuthOptions()
-	if len(opts) == 0 {
-		return e.JSON(500, VerifyResponse{Error: "server misconfigured: GOOGLE_APPLICATION_CREDENTIALS or GOOGLE_IAP_CREDENTIALS_JSON required"})
+	pub, err := newGooglePublisher(ctx)
+	if errors.Is(err, errGoogleNotConfigured) {
+		return nil, &verifyError{Status: 500, Message: "server misconfigured: " + err.Error()}
 	}
-
-	svc, err := androidpublisher.NewService(ctx, opts...)
 	if err != nil {
-		return e.JSON(502, VerifyResponse{Error: "google api init failed: " + err.Error()})
+		return nil, &verifyError{Status: 502, Message: "google api init failed: " + err.Error()}
 	}
 
-	// Verify purchase via Google Play API
-	productID := req.ProductID
-	if productID == "" {
-		productID = productIDPremium
+	// Premium products are subscriptions: verify via subscriptionsv2 and store expiry/renewal state
+	if isSubscriptionProduct(req.ProductID) {
+		sub, err := fetchGoogleSubscription(ctx, pub, pkg, req.PurchaseToken, req.ProductID)
+		if err != nil {
+			return nil, &verifyError{Status: 400, Message: "google verify failed: " + err.Error()}
+		}
+		if !sub.Entitled {
+			return nil, &verifyError{Status: 400, Message: "subscription not active: " + sub.State, Raw: sub.Raw}
+		}
+		return &storeResult{
+			TransactionID: sub.OrderID,
+			ExpiresAt:     sub.ExpiresAt,
+			PurchaseToken: req.PurchaseToken,
+			AutoRenewing:  &sub.AutoRenewing,
+			State:         sub.State,
+			Raw:           sub.Raw,
+		}, nil
 	}
-	purchase, err := svc.Purchases.Products.Get(pkg, productID, req.PurchaseToken).Do()
+
+	// One-time products
+	purchase, err := pub.GetProduct(ctx, pkg, req.ProductID, req.PurchaseToken)
 	if err != nil {
-		return e.JSON(400, VerifyResponse{Error: "google verify failed: " + err.Error()})
+		return nil, &verifyError{Status: 400, Message: "google verify failed: " + err.Error()}
 	}
+	raw, _ := purchase.MarshalJSON()
 
 	// purchaseState: 0=Purchased, 1=Canceled, 2=Pending
 	if purchase.PurchaseState != 0 {
-		return e.JSON(400, VerifyResponse{Error: "purchase not completed"})
+		return nil, &verifyError{Status: 400, Message: "purchase not completed", Raw: raw}
 	}
 
 	txID := purchase.OrderId
 	if txID == "" {
 		txID = req.PurchaseToken[:min(64, len(req.PurchaseToken))]
 	}
+	return &storeResult{TransactionID: txID, PurchaseToken: req.PurchaseToken, Raw: raw}, nil
+}
 
-	if req.DeviceID != "" && txID != "" {
-		_ = saveVerifiedPurchase(app, req.DeviceID, txID, productID, "android", "")
+// asVerifyError maps any verification error to the response status (502 for unexpected errors)
+func asVerifyError(err error) *verifyError {
+	var verr *verifyError
+	if errors.As(err, &verr) {
+		return verr
 	}
-	return e.JSON(200, VerifyResponse{Verified: true, TransactionID: txID})
+	return &verifyError{Status: 502, Message: err.Error()}
 }
 
 func getAppleSharedSecret() string {
@@ -261,31 +222,66 @@ func getGoogleAuthOptions() []option.ClientOption {
 	return nil
 }
 
-func saveVerifiedPurchase(app core.App, deviceID, transactionID, productID, platform, expiresAt string) error {
+// verifiedPurchase is a store-verified purchase saved to iap_verifications
+type verifiedPurchase struct {
+	DeviceID      string
+	UserID        string // signed-in caller: links the purchase to the account
+	TransactionID string
+	ProductID     string
+	Platform      string
+	ExpiresAt     string
+	PurchaseToken string // android: used to re-query Google on RTDN
+	AutoRenewing  *bool
+	State         string // android subscription state (active, canceled, in_grace_period...)
+}
+
+// saveVerifiedPurchase upserts the row of the purchase (platform, transaction and product) for
+// the caller: the signed-in user's row, else the guest row of this device, which a signed-in
+// caller then links to the account. Rows linked to another account are never reassigned; the
+// store receipt proves ownership, checkReplay limits how many accounts share a transaction.
+func saveVerifiedPurchase(app core.App, p verifiedPurchase) error {
 	col, err := app.FindCollectionByNameOrId("iap_verifications")
 	if err != nil {
 		return err
 	}
-	existing, _ := app.FindFirstRecordByFilter(col.Id, `device_id="`+escapeFilter(deviceID)+`" && product_id="`+escapeFilter(productID)+`"`)
-	if existing != nil {
-		existing.Set("transaction_id", transactionID)
-		existing.Set("platform", platform)
-		if expiresAt != "" {
-			existing.Set("expires_at", expiresAt)
-		}
-		return app.Save(existing)
+	params := dbx.Params{"platform": p.Platform, "tx": p.TransactionID, "product": p.ProductID, "user": p.UserID, "device": p.DeviceID}
+	purchase := "platform = {:platform} && transaction_id = {:tx} && product_id = {:product}"
+	var record *core.Record
+	if p.UserID != "" {
+		record, _ = app.FindFirstRecordByFilter(col.Id, purchase+" && user = {:user}", params)
+	}
+	if record == nil {
+		record, _ = app.FindFirstRecordByFilter(col.Id, purchase+" && user = '' && device_id = {:device}", params)
 	}
-	record := core.NewRecord(col)
-	record.Set("device_id", deviceID)
-	record.Set("transaction_id", transactionID)
-	record.Set("product_id", productID)
-	record.Set("platform", platform)
-	if expiresAt != "" {
-		record.Set("expires_at", expiresAt)
+	if record == nil {
+		record = core.NewRecord(col)
+		record.Set("device_id", p.DeviceID)
+		record.Set("product_id", p.ProductID)
+	}
+	record.Set("transaction_id", p.TransactionID)
+	record.Set("platform", p.Platform)
+	if p.UserID != "" {
+		record.Set("user", p.UserID)
+	}
+	if p.ExpiresAt != "" {
+		record.Set("expires_at", p.ExpiresAt)
+	}
+	if p.PurchaseToken != "" {
+		record.Set("purchase_token", p.PurchaseToken)
+	}
+	if p.AutoRenewing != nil {
+		record.Set("auto_renewing", *p.AutoRenewing)
+	}
+	if p.State != "" {
+		record.Set("subscription_state", p.State)
 	}
 	return app.Save(record)
 }
 
-func escapeFilter(s string) string {
-	return strings.ReplaceAll(strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`), `'`, `\'`)
+// authUserID returns the signed-in users record id ("" for guests / superusers)
+func authUserID(e *core.RequestEvent) string {
+	if e.Auth != nil && e.Auth.Collection().Name == "users" {
+		return e.Auth.Id
+	}
+	return ""
 }
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// iapNotification is one row of the iap_notifications audit log
+type iapNotification struct {
+	Platform       string
+	NotificationID string
+	Type           string
+	Subtype        string
+	Environment    string
+	TransactionID  string
+	ProductID      string
+	Result         string // processed | unmatched | ignored | rejected | failed
+	Error          string
+	Payload        any
+}
+
+// findIAPNotification returns the audit row of an already received notification (nil if new)
+func findIAPNotification(app core.App, platform, notificationID string) *core.Record {
+	if notificationID == "" {
+		return nil
+	}
+	record, _ := app.FindFirstRecordByFilter("iap_notifications",
+		"platform = {:platform} && notification_id = {:id}",
+		dbx.Params{"platform": platform, "id": notificationID})
+	return record
+}
+
+// saveIAPNotification writes the audit row; existing is the row of a previously failed
+// attempt (store retries reuse the same notification id)
+func saveIAPNotification(app core.App, existing *core.Record, n iapNotification) error {
+	record := existing
+	if record == nil {
+		col, err := app.FindCollectionByNameOrId("iap_notifications")
+		if err != nil {
+			return err
+		}
+		record = core.NewRecord(col)
+	}
+	record.Set("platform", n.Platform)
+	record.Set("notification_id", n.NotificationID)
+	record.Set("notification_type", n.Type)
+	record.Set("subtype", n.Subtype)
+	record.Set("environment", n.Environment)
+	record.Set("transaction_id", n.TransactionID)
+	record.Set("product_id", n.ProductID)
+	record.Set("result", n.Result)
+	record.Set("error", n.Error)
+	record.Set("payload", n.Payload)
+	return app.Save(record)
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"korean-kids-stories/entitlements"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxRestoreItems caps transaction_ids / purchase_tokens: each one is a store API call
+const maxRestoreItems = 20
+
+// RestoreRequest is the body of POST /api/iap/restore
+type RestoreRequest struct {
+	Platform       string   `json:"platform"`        // "ios" or "android"
+	ReceiptData    string   `json:"receipt_data"`    // iOS (legacy verifier): full app receipt
+	TransactionIDs []string `json:"transaction_ids"` // iOS (App Store Server API): original transaction ids
+	PurchaseTokens []string `json:"purchase_tokens"` // Android: every purchase token from queryPurchasesAsync
+	DeviceID       string   `json:"device_id"`       // defaults to X-Device-ID
+}
+
+// RestoredPurchase is one premium product found in the store
+type RestoredPurchase struct {
+	ProductID     string `json:"product_id"`
+	TransactionID string `json:"transaction_id"`
+	ExpiresAt     string `json:"expires_at,omitempty"`
+	Active        bool   `json:"active"`
+	Error         string `json:"error,omitempty"` // e.g. blocked by the replay policy
+}
+
+// RestoreResponse is the resulting entitlement summary
+type RestoreResponse struct {
+	Restored     []RestoredPurchase         `json:"restored"`
+	IsPremium    bool                       `json:"is_premium"`
+	Entitlements []entitlements.Entitlement `json:"entitlements"`
+	Errors       []string                   `json:"errors,omitempty"` // receipts/tokens the store rejected
+}
+
+// restoreItem is a store-verified product waiting to be reconciled
+type restoreItem struct {
+	ProductID string
+	Result    *storeResult
+}
+
+// iapRestoreHandler: POST /api/iap/restore. Verifies every premium product at once, reconciles
+// the caller's iap_verifications rows (this device + rows linked to the account) and returns
+// the entitlement summary.
+func iapRestoreHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		var req RestoreRequest
+		if err := json.NewDecoder(e.Request.Body).Decode(&req); err != nil {
+			return e.JSON(400, map[string]string{"error": "invalid json"})
+		}
+		if req.DeviceID == "" {
+			req.DeviceID = e.Request.Header.Get(entitlements.DeviceIDHeader)
+		}
+		userID := authUserID(e)
+		if req.DeviceID == "" && userID == "" {
+			return e.JSON(400, map[string]string{"error": "device_id or sign-in required"})
+		}
+		if len(req.TransactionIDs) > maxRestoreItems || len(req.PurchaseTokens) > maxRestoreItems {
+			return e.JSON(400, map[string]string{"error": fmt.Sprintf("at most %d transaction_ids / purchase_tokens", maxRestoreItems)})
+		}
+
+		ctx := e.Request.Context()
+		var items []restoreItem
+		var errs []string
+		reject := func(source VerifyRequest, err error) {
+			verr := asVerifyError(err)
+			event := newPurchaseEvent(e, &source)
+			event.reject(verr)
+			logPurchaseEvent(app, event)
+			errs = append(errs, verr.Message)
+		}
+
+		switch req.Platform {
+		case "ios":
+			if req.ReceiptData == "" && len(req.TransactionIDs) == 0 {
+				return e.JSON(400, map[string]string{"error": "receipt_data or transaction_ids required for ios"})
+			}
+			verifier, err := newAppleVerifier()
+			if err != nil {
+				return e.JSON(500, map[string]string{"error": "server misconfigured: " + err.Error()})
+			}
+			var sources []VerifyRequest
+			if req.ReceiptData != "" {
+				sources = append(sources, VerifyRequest{Platform: "ios", ReceiptData: req.ReceiptData, DeviceID: req.DeviceID})
+			}
+			for _, id := range req.TransactionIDs {
+				sources = append(sources, VerifyRequest{Platform: "ios", TransactionID: id, DeviceID: req.DeviceID})
+			}
+			for _, source := range sources {
+				results, err := verifier.VerifyProducts(ctx, &source, entitlements.PremiumProductIDs)
+				if err != nil {
+					reject(source, err)
+					continue
+				}
+				for _, r := range results {
+					if r.OriginalTransactionID == "" {
+						continue
+					}
+					items = append(items, restoreItem{ProductID: r.ProductID, Result: &storeResult{
+						TransactionID: r.OriginalTransactionID,
+						ExpiresAt:     r.ExpiresAt,
+						Environment:   r.Environment,
+						Raw:           r.Raw,
+					}})
+				}
+			}
+		case "android":
+			if len(req.PurchaseTokens) == 0 {
+				return e.JSON(400, map[string]string{"error": "purchase_tokens required for android"})
+			}
+			for _, token := range req.PurchaseTokens {
+				source := VerifyRequest{Platform: "android", PurchaseToken: token, DeviceID: req.DeviceID}
+				item, err := restoreGoogleToken(ctx, token)
+				if err != nil {
+					reject(source, err)
+					continue
+				}
+				if item != nil {
+					items = append(items, *item)
+				}
+			}
+		default:
+			return e.JSON(400, map[string]string{"error": "platform must be ios or android"})
+		}
+
+		resp := RestoreResponse{Restored: []RestoredPurchase{}, Errors: errs}
+		now := time.Now().UTC().Format(entitlements.DateLayout)
+		for _, item := range latestPerProduct(items) {
+			restored := RestoredPurchase{
+				ProductID:     item.ProductID,
+				TransactionID: item.Result.TransactionID,
+				ExpiresAt:     item.Result.ExpiresAt,
+				Active:        item.Result.ExpiresAt == "" || item.Result.ExpiresAt > now,
+			}
+			event := newPurchaseEvent(e, &VerifyRequest{Platform: req.Platform, ProductID: item.ProductID, DeviceID: req.DeviceID})
+			event.accept(item.Result)
+
+			if req.DeviceID != "" {
+				reason, err := checkReplay(app, req.Platform, item.Result.TransactionID, req.DeviceID, userID)
+				if err != nil {
+					log.Printf("iap restore: replay check %s: %v", item.Result.TransactionID, err)
+				}
+				if reason != "" {
+					event.block(reason)
+					logPurchaseEvent(app, event)
+					restored.Active = false
+					restored.Error = "purchase already used on too many devices or accounts"
+					resp.Restored = append(resp.Restored, restored)
+					continue
+				}
+			}
+			if err := reconcileRestoredPurchase(app, req.Platform, req.DeviceID, userID, item); err != nil {
+				log.Printf("iap restore: reconcile %s: %v", item.Result.TransactionID, err)
+			}
+			logPurchaseEvent(app, event)
+			resp.Restored = append(resp.Restored, restored)
+		}
+
+		resp.Entitlements = entitlements.Active(app, userID, req.DeviceID)
+		if resp.Entitlements == nil {
+			resp.Entitlements = []entitlements.Entitlement{}
+		}
+		resp.IsPremium = len(resp.Entitlements) > 0
+		return e.JSON(200, resp)
+	}
+}
+
+// restoreGoogleToken reads one purchase token; nil when it is not a premium subscription
+func restoreGoogleToken(ctx context.Context, token string) (*restoreItem, error) {
+	pub, err := newGooglePublisher(ctx)
+	if err != nil {
+		return nil, &verifyError{Status: 500, Message: "server misconfigured: " + err.Error()}
+	}
+	sub, err := fetchGoogleSubscription(ctx, pub, getGooglePackageName(), token, "")
+	if err != nil {
+		return nil, &verifyError{Status: 400, Message: "google verify failed: " + err.Error()}
+	}
+	if !isSubscriptionProduct(sub.ProductID) {
+		return nil, nil
+	}
+	if !sub.Entitled {
+		return nil, &verifyError{Status: 400, Message: "subscription not active: " + sub.State, Raw: sub.Raw}
+	}
+	return &restoreItem{ProductID: sub.ProductID, Result: &storeResult{
+		TransactionID: sub.OrderID,
+		ExpiresAt:     sub.ExpiresAt,
+		PurchaseToken: token,
+		AutoRenewing:  &sub.AutoRenewing,
+		State:         sub.State,
+		Raw:           sub.Raw,
+	}}, nil
+}
+
+// latestPerProduct keeps the purchase that expires last for each product ("" = no expiry)
+func latestPerProduct(items []restoreItem) []restoreItem {
+	var result []restoreItem
+	index := map[string]int{}
+	for _, item := range items {
+		i, ok := index[item.ProductID]
+		if !ok {
+			index[item.ProductID] = len(result)
+			result = append(result, item)
+			continue
+		}
+		current := result[i].Result.ExpiresAt
+		if current != "" && (item.Result.ExpiresAt == "" || item.Result.ExpiresAt > current) {
+			result[i] = item
+		}
+	}
+	return result
+}
+
+// reconcileRestoredPurchase upserts the caller's row of the purchase (saveVerifiedPurchase never
+// takes over a row of another account) and refreshes every other row of the account with the
+// same transaction
+func reconcileRestoredPurchase(app core.App, platform, deviceID, userID string, item restoreItem) error {
+	r := item.Result
+	if deviceID != "" {
+		if err := saveVerifiedPurchase(app, verifiedPurchase{
+			DeviceID:      deviceID,
+			UserID:        userID,
+			TransactionID: r.TransactionID,
+			ProductID:     item.ProductID,
+			Platform:      platform,
+			ExpiresAt:     r.ExpiresAt,
+			PurchaseToken: r.PurchaseToken,
+			AutoRenewing:  r.AutoRenewing,
+			State:         r.State,
+		}); err != nil {
+			return err
+		}
+	}
+	if userID == "" {
+		return nil
+	}
+	records, err := app.FindRecordsByFilter("iap_verifications",
+		"platform = {:platform} && transaction_id = {:tx} && user = {:user}", "", 0, 0,
+		dbx.Params{"platform": platform, "tx": r.TransactionID, "user": userID})
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if r.ExpiresAt != "" {
+			record.Set("expires_at", r.ExpiresAt)
+		}
+		if r.AutoRenewing != nil {
+			record.Set("auto_renewing", *r.AutoRenewing)
+		}
+		if r.State != "" {
+			record.Set("subscription_state", r.State)
+		}
+		if err := app.Save(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
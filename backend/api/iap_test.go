@@ -0,0 +1,132 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"korean-kids-stories/entitlements"
+
+	"github.com/pocketbase/pocketbase/core"
+	"google.golang.org/api/androidpublisher/v3"
+)
+
+// newTestUser saves a users record
+func newTestUser(t *testing.T, app core.App, email string) *core.Record {
+	t.Helper()
+	col, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := core.NewRecord(col)
+	user.SetEmail(email)
+	user.SetPassword("Passw0rd123")
+	if err := app.Save(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// serveAsUser runs a POST handler signed in as user (nil = guest)
+func serveAsUser(app core.App, handler func(*core.RequestEvent) error, user *core.Record, target, body string) (int, string) {
+	rec := serveTest(app, func(e *core.RequestEvent) error {
+		e.Auth = user
+		return handler(e)
+	}, "POST", target, strings.NewReader(body))
+	return rec.Code, rec.Body.String()
+}
+
+// twoGooglePurchases stubs the publisher with the subscriptions of two accounts
+func twoGooglePurchases(t *testing.T) {
+	future := time.Now().Add(72 * time.Hour)
+	first := testSubscription("SUBSCRIPTION_STATE_ACTIVE", future)
+	first.LatestOrderId = "GPA.first"
+	second := testSubscription("SUBSCRIPTION_STATE_ACTIVE", future)
+	second.LatestOrderId = "GPA.second"
+	useStubPublisher(t, map[string]*androidpublisher.SubscriptionPurchaseV2{"token-1": first, "token-2": second})
+}
+
+// purchaseRows returns the transaction of every iap_verifications row by user ("" = guest)
+func purchaseRows(t *testing.T, app core.App) map[string][]string {
+	t.Helper()
+	records, err := app.FindAllRecords("iap_verifications")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := map[string][]string{}
+	for _, r := range records {
+		rows[r.GetString("user")] = append(rows[r.GetString("user")], r.GetString("device_id")+"/"+r.GetString("transaction_id"))
+	}
+	return rows
+}
+
+func TestVerifyTwoAccountsOnOneDevice(t *testing.T) {
+	app := newTestApp(t)
+	twoGooglePurchases(t)
+	alice := newTestUser(t, app, "alice@example.com")
+	bob := newTestUser(t, app, "bob@example.com")
+	verify := func(user *core.Record, token string) {
+		t.Helper()
+		body := `{"platform":"android","product_id":"` + testMonthly + `","purchase_token":"` + token + `","device_id":"device-1"}`
+		if code, resp := serveAsUser(app, iapVerifyHandler(app), user, "/api/iap/verify", body); code != 200 {
+			t.Fatalf("verify %s: %d %s", token, code, resp)
+		}
+	}
+
+	// a guest purchase, then linked when its buyer signs in on the device
+	verify(nil, "token-1")
+	verify(alice, "token-1")
+	// another account buys on the same device
+	verify(bob, "token-2")
+	// a guest verifies alice's purchase again on the device
+	verify(nil, "token-1")
+
+	rows := purchaseRows(t, app)
+	if got := rows[alice.Id]; len(got) != 1 || got[0] != "device-1/GPA.first" {
+		t.Fatalf("alice rows = %v", got)
+	}
+	if got := rows[bob.Id]; len(got) != 1 || got[0] != "device-1/GPA.second" {
+		t.Fatalf("bob rows = %v", got)
+	}
+	if got := rows[""]; len(got) != 1 || got[0] != "device-1/GPA.first" {
+		t.Fatalf("guest rows = %v", got)
+	}
+	for _, user := range []*core.Record{alice, bob} {
+		if !entitlements.IsPremium(app, user.Id, "") {
+			t.Fatalf("%s lost premium", user.Email())
+		}
+	}
+}
+
+func TestRestoreKeepsRowsOfOtherAccounts(t *testing.T) {
+	app := newTestApp(t)
+	twoGooglePurchases(t)
+	alice := newTestUser(t, app, "alice@example.com")
+	bob := newTestUser(t, app, "bob@example.com")
+	restore := func(user *core.Record, token string) {
+		t.Helper()
+		body := `{"platform":"android","purchase_tokens":["` + token + `"],"device_id":"device-1"}`
+		if code, resp := serveAsUser(app, iapRestoreHandler(app), user, "/api/iap/restore", body); code != 200 || !strings.Contains(resp, `"is_premium":true`) {
+			t.Fatalf("restore %s: %d %s", token, code, resp)
+		}
+	}
+
+	restore(alice, "token-1")
+	restore(bob, "token-2")
+	// the same purchase restored by a second account (within IAP_MAX_USERS_PER_TRANSACTION)
+	restore(bob, "token-1")
+
+	rows := purchaseRows(t, app)
+	if got := rows[alice.Id]; len(got) != 1 || got[0] != "device-1/GPA.first" {
+		t.Fatalf("alice rows = %v", got)
+	}
+	if got := strings.Join(rows[bob.Id], ","); got != "device-1/GPA.second,device-1/GPA.first" {
+		t.Fatalf("bob rows = %v", got)
+	}
+	if len(rows[""]) != 0 {
+		t.Fatalf("guest rows = %v", rows[""])
+	}
+	if !entitlements.IsPremium(app, alice.Id, "") {
+		t.Fatal("alice lost premium")
+	}
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"slices"
+	"strconv"
+	"time"
+
+	"korean-kids-stories/gamification"
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// LeaderboardEntry is one ranked child: nickname and avatar sticker only
+type LeaderboardEntry struct {
+	Rank          int     `json:"rank"`
+	Nickname      string  `json:"nickname"`
+	AvatarSticker string  `json:"avatar_sticker"`
+	AvatarURL     string  `json:"avatar_url"`
+	XP            float64 `json:"xp"`
+	Level         int     `json:"level"`
+	StreakDays    int     `json:"streak_days"`
+	IsMe          bool    `json:"is_me"`
+}
+
+// LeaderboardResponse is returned by GET /api/leaderboards
+type LeaderboardResponse struct {
+	Period      string             `json:"period"`
+	PeriodStart string             `json:"period_start"`
+	Scope       string             `json:"scope"`
+	ClassName   string             `json:"class_name,omitempty"`
+	Entries     []LeaderboardEntry `json:"entries"`
+	Me          *LeaderboardEntry  `json:"me"`
+	OptedOut    bool               `json:"opted_out"`
+}
+
+// RegisterLeaderboardRoutes adds GET /api/leaderboards (users)
+func RegisterLeaderboardRoutes(se *core.ServeEvent) {
+	se.Router.GET("/api/leaderboards", leaderboardHandler(se.App)).Bind(apis.RequireAuth("users"))
+}
+
+// leaderboardHandler: ?period=weekly|monthly|all_time&scope=global|class[&previous=true][&limit=50].
+// Boards are computed hourly by the leaderboard job; scope=class is the board of the class
+// joined with user_preferences.class_code. me is the caller's row, also outside the limit.
+func leaderboardHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		q := e.Request.URL.Query()
+		period := q.Get("period")
+		if period == "" {
+			period = schema.LeaderboardWeekly
+		}
+		if !slices.Contains(schema.LeaderboardPeriods, period) {
+			return e.JSON(400, map[string]string{"error": "invalid period"})
+		}
+		scope := q.Get("scope")
+		if scope == "" {
+			scope = schema.LeaderboardGlobal
+		}
+		if !slices.Contains(schema.LeaderboardScopes, scope) {
+			return e.JSON(400, map[string]string{"error": "invalid scope"})
+		}
+		previous := false
+		if s := q.Get("previous"); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return e.JSON(400, map[string]string{"error": "previous must be true or false"})
+			}
+			previous = b
+		}
+		limit := 50
+		if s := q.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > 100 {
+				return e.JSON(400, map[string]string{"error": "limit must be 1-100"})
+			}
+			limit = n
+		}
+
+		userID := authUserID(e)
+		resp := LeaderboardResponse{
+			Period:      period,
+			PeriodStart: gamification.LeaderboardPeriodStart(period, time.Now(), previous),
+			Scope:       scope,
+			Entries:     []LeaderboardEntry{},
+		}
+		pref, _ := app.FindFirstRecordByFilter("user_preferences", "user = {:user}", dbx.Params{"user": userID})
+		if pref != nil {
+			resp.OptedOut = pref.GetBool("leaderboard_opt_out")
+		}
+
+		// empty placeholders are not bound as '' (all_time has no period_start)
+		filter := "period = {:period} && period_start = '' && scope = {:scope}"
+		if resp.PeriodStart != "" {
+			filter = "period = {:period} && period_start = {:start} && scope = {:scope}"
+		}
+		params := dbx.Params{"period": period, "start": resp.PeriodStart, "scope": scope, "user": userID}
+		if scope == schema.LeaderboardClass {
+			code := ""
+			if pref != nil {
+				code = gamification.NormalizeClassCode(pref.GetString("class_code"))
+			}
+			class, err := app.FindFirstRecordByFilter("class_groups", "code = {:code}", dbx.Params{"code": code})
+			if code == "" || err != nil {
+				return e.JSON(404, map[string]string{"error": "not in a class"})
+			}
+			resp.ClassName = class.GetString("name")
+			filter += " && class_group = {:class}"
+			params["class"] = class.Id
+		}
+
+		records, err := app.FindRecordsByFilter("leaderboard", filter, "rank,nickname", limit, 0, params)
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+		mine, _ := app.FindFirstRecordByFilter("leaderboard", filter+" && user = {:user}", params)
+		if mine != nil {
+			records = append(records, mine)
+		}
+		avatars := leaderboardAvatars(app, records)
+
+		for i, r := range records {
+			entry := LeaderboardEntry{
+				Rank:          r.GetInt("rank"),
+				Nickname:      r.GetString("nickname"),
+				AvatarSticker: r.GetString("avatar_sticker"),
+				AvatarURL:     avatars[r.GetString("avatar_sticker")],
+				XP:            r.GetFloat("xp"),
+				Level:         r.GetInt("level"),
+				StreakDays:    r.GetInt("streak_days"),
+				IsMe:          r.GetString("user") == userID,
+			}
+			if mine != nil && i == len(records)-1 {
+				resp.Me = &entry
+				continue
+			}
+			resp.Entries = append(resp.Entries, entry)
+		}
+		return e.JSON(200, resp)
+	}
+}
+
+// leaderboardAvatars maps the avatar stickers of the rows to their image URL
+func leaderboardAvatars(app core.App, records []*core.Record) map[string]string {
+	var ids []string
+	for _, r := range records {
+		if id := r.GetString("avatar_sticker"); id != "" && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	urls := map[string]string{}
+	if len(ids) == 0 {
+		return urls
+	}
+	stickers, err := app.FindRecordsByIds("stickers", ids)
+	if err != nil {
+		return urls
+	}
+	for _, s := range stickers {
+		if image := s.GetString("image"); image != "" {
+			urls[s.Id] = "/api/files/" + s.Collection().Id + "/" + s.Id + "/" + image
+		}
+	}
+	return urls
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"korean-kids-stories/entitlements"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// purchaseEvent is one purchase_events row (append-only audit of verify/restore calls)
+type purchaseEvent struct {
+	Platform      string
+	DeviceID      string
+	UserID        string
+	ProductID     string
+	TransactionID string
+	Environment   string
+	Outcome       string // verified | rejected | blocked
+	HTTPStatus    int
+	Error         string
+	StoreResponse json.RawMessage
+	Request       map[string]any
+	FlagReason    string
+}
+
+// newPurchaseEvent captures request metadata (never the receipt or purchase token itself)
+func newPurchaseEvent(e *core.RequestEvent, req *VerifyRequest) *purchaseEvent {
+	return &purchaseEvent{
+		Platform:  req.Platform,
+		DeviceID:  req.DeviceID,
+		UserID:    authUserID(e),
+		ProductID: req.ProductID,
+		Request: map[string]any{
+			"path":               e.Request.URL.Path,
+			"ip":                 e.RealIP(),
+			"user_agent":         e.Request.UserAgent(),
+			"header_device_id":   e.Request.Header.Get(entitlements.DeviceIDHeader),
+			"transaction_id":     req.TransactionID,
+			"has_receipt":        req.ReceiptData != "",
+			"has_purchase_token": req.PurchaseToken != "",
+		},
+	}
+}
+
+func (ev *purchaseEvent) reject(verr *verifyError) {
+	ev.Outcome = "rejected"
+	ev.HTTPStatus = verr.Status
+	ev.Error = verr.Message
+	ev.StoreResponse = verr.Raw
+}
+
+func (ev *purchaseEvent) accept(result *storeResult) {
+	ev.Outcome = "verified"
+	ev.HTTPStatus = 200
+	ev.TransactionID = result.TransactionID
+	ev.Environment = result.Environment
+	ev.StoreResponse = result.Raw
+}
+
+// block marks a store-valid purchase refused by the replay policy; flagged for admins
+func (ev *purchaseEvent) block(reason string) {
+	ev.Outcome = "blocked"
+	ev.HTTPStatus = 403
+	ev.Error = reason
+	ev.FlagReason = reason
+}
+
+// logPurchaseEvent appends the event; failures are only logged (never fail the purchase)
+func logPurchaseEvent(app core.App, ev *purchaseEvent) {
+	col, err := app.FindCollectionByNameOrId("purchase_events")
+	if err != nil {
+		log.Printf("purchase_events: %v", err)
+		return
+	}
+	record := core.NewRecord(col)
+	record.Set("platform", ev.Platform)
+	record.Set("device_id", ev.DeviceID)
+	record.Set("user", ev.UserID)
+	record.Set("product_id", ev.ProductID)
+	record.Set("transaction_id", ev.TransactionID)
+	record.Set("environment", ev.Environment)
+	record.Set("outcome", ev.Outcome)
+	record.Set("http_status", ev.HTTPStatus)
+	record.Set("error", ev.Error)
+	if len(ev.StoreResponse) > 0 {
+		record.Set("store_response", ev.StoreResponse)
+	}
+	record.Set("request", ev.Request)
+	record.Set("flagged", ev.FlagReason != "")
+	record.Set("flag_reason", ev.FlagReason)
+	if err := app.Save(record); err != nil {
+		log.Printf("purchase_events: save %s/%s: %v", ev.Platform, ev.TransactionID, err)
+	}
+	if ev.FlagReason != "" {
+		log.Printf("⚠️ purchase flagged: %s %s device=%s user=%s: %s", ev.Platform, ev.TransactionID, ev.DeviceID, ev.UserID, ev.FlagReason)
+	}
+}
+
+// checkReplay applies the replay policy: one original transaction may unlock at most
+// IAP_MAX_DEVICES_PER_TRANSACTION devices (default 5) and IAP_MAX_USERS_PER_TRANSACTION
+// accounts (default 2). Devices/accounts already linked keep working. Returns the reason
+// when the new device/user would exceed a cap.
+func checkReplay(app core.App, platform, transactionID, deviceID, userID string) (string, error) {
+	records, err := app.FindRecordsByFilter("iap_verifications",
+		"platform = {:platform} && transaction_id = {:tx}", "", 0, 0,
+		dbx.Params{"platform": platform, "tx": transactionID})
+	if err != nil {
+		return "", err
+	}
+	devices := map[string]bool{deviceID: true}
+	users := map[string]bool{}
+	if userID != "" {
+		users[userID] = true
+	}
+	for _, r := range records {
+		devices[r.GetString("device_id")] = true
+		if u := r.GetString("user"); u != "" {
+			users[u] = true
+		}
+	}
+	if max := replayLimit("IAP_MAX_DEVICES_PER_TRANSACTION", 5); len(devices) > max {
+		return fmt.Sprintf("transaction used on %d devices (max %d)", len(devices), max), nil
+	}
+	if max := replayLimit("IAP_MAX_USERS_PER_TRANSACTION", 2); len(users) > max {
+		return fmt.Sprintf("transaction used by %d accounts (max %d)", len(users), max), nil
+	}
+	return "", nil
+}
+
+func replayLimit(env string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(env)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"korean-kids-stories/entitlements"
+	"korean-kids-stories/gamification"
+	"korean-kids-stories/quiz"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// QuizSubmitRequest is the body of POST /api/quizzes/{storyId}/submit:
+// answers maps a quiz id to its answer, shaped by the question_type (see package quiz)
+type QuizSubmitRequest struct {
+	Answers map[string]json.RawMessage `json:"answers"`
+}
+
+// RegisterQuizRoutes adds POST /api/quizzes/{storyId}/submit (users)
+func RegisterQuizRoutes(se *core.ServeEvent) {
+	se.Router.POST("/api/quizzes/{storyId}/submit", quizSubmitHandler(se.App)).Bind(apis.RequireAuth("users"))
+}
+
+// quizSubmitHandler grades the story quiz server-side (quizzes.correct_answer is hidden from
+// clients) and saves a quiz_attempts row. The response has the per-question results with
+// the correct answers and explanations, and the XP earned by the first passed attempt.
+func quizSubmitHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		storyID := e.Request.PathValue("storyId")
+		story, err := app.FindRecordById("stories", storyID)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !story.GetBool("is_published")) {
+			return e.JSON(404, map[string]string{"error": "story not found"})
+		}
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+
+		var req QuizSubmitRequest
+		if err := json.NewDecoder(e.Request.Body).Decode(&req); err != nil {
+			return e.JSON(400, map[string]string{"error": "invalid json"})
+		}
+		if len(req.Answers) == 0 {
+			return e.JSON(400, map[string]string{"error": "answers required"})
+		}
+
+		userID := authUserID(e)
+		premium := entitlements.IsPremium(app, userID, e.Request.Header.Get(entitlements.DeviceIDHeader))
+		quizzes, err := quiz.StoryQuizzes(app, story.Id, premium)
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+		if len(quizzes) == 0 {
+			return e.JSON(404, map[string]string{"error": "story has no quiz"})
+		}
+		grade, err := quiz.GradeSubmission(quizzes, req.Answers)
+		if err != nil {
+			return e.JSON(400, map[string]string{"error": err.Error()})
+		}
+
+		outcome, err := gamification.SubmitQuiz(app, userID, story.Id, req.Answers, grade)
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+		return e.JSON(200, outcome)
+	}
+}
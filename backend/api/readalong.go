@@ -0,0 +1,303 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"korean-kids-stories/entitlements"
+	"korean-kids-stories/readalong"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ReadAlongWord is a dictionary word found in a sentence; Offset is in runes of the text
+type ReadAlongWord struct {
+	ID     string `json:"id"`
+	Word   string `json:"word"`
+	Offset int    `json:"offset"`
+}
+
+// ReadAlongSentence is a readalong.Sentence with its dictionary words
+type ReadAlongSentence struct {
+	readalong.Sentence
+	Words []ReadAlongWord `json:"words"`
+}
+
+// ReadAlongParagraph is a readalong.Paragraph with ReadAlongSentence sentences
+type ReadAlongParagraph struct {
+	Index     int                 `json:"index"`
+	StartMs   int                 `json:"start_ms"`
+	EndMs     int                 `json:"end_ms"`
+	Sentences []ReadAlongSentence `json:"sentences"`
+}
+
+// DictionaryEntry is a dictionary record referenced by ReadAlongWord.ID
+type DictionaryEntry struct {
+	ID       string `db:"id" json:"id"`
+	Word     string `db:"word" json:"word"`
+	Reading  string `db:"reading" json:"reading"`
+	Meaning  string `db:"meaning" json:"meaning"`
+	Example  string `db:"example" json:"example"`
+	Category string `db:"category" json:"category"`
+}
+
+// ReadAlongAudio is the chapter_audios record used for the timings
+type ReadAlongAudio struct {
+	ID            string  `json:"id"`
+	Narrator      string  `json:"narrator"`
+	AudioURL      string  `json:"audio_url"`
+	AudioDuration float64 `json:"audio_duration"`
+	HasTimings    bool    `json:"has_timings"`
+}
+
+// ReadAlongResponse is returned by GET /api/chapters/{id}/read-along
+type ReadAlongResponse struct {
+	ChapterID     string                     `json:"chapter_id"`
+	StoryID       string                     `json:"story_id"`
+	ChapterNumber int                        `json:"chapter_number"`
+	Title         string                     `json:"title"`
+	Audio         *ReadAlongAudio            `json:"audio"`
+	Paragraphs    []ReadAlongParagraph       `json:"paragraphs"`
+	Dictionary    map[string]DictionaryEntry `json:"dictionary"`
+}
+
+// RegisterReadAlongRoutes adds GET /api/chapters/{id}/read-along (public, locked chapters need
+// premium) and GET /api/reports/word-timings (superusers)
+func RegisterReadAlongRoutes(se *core.ServeEvent) {
+	se.Router.GET("/api/chapters/{id}/read-along", readAlongHandler(se.App))
+	se.Router.GET("/api/reports/word-timings", wordTimingsReportHandler(se.App)).Bind(apis.RequireSuperuserAuth())
+}
+
+// wordTimingsReportHandler: [?all=true]. Checks the word_timings of every chapter_audios
+// record and lists the broken ones (invalid structure or times) and the drifted ones (no
+// longer matching the chapter text, e.g. after an edit); all=true lists the valid ones too.
+func wordTimingsReportHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		checks, checked, err := readalong.CheckAllAudios(app, e.Request.URL.Query().Get("all") == "true")
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+		broken, drifted := 0, 0
+		for _, c := range checks {
+			switch c.Status {
+			case readalong.StatusBroken:
+				broken++
+			case readalong.StatusDrifted:
+				drifted++
+			}
+		}
+		return e.JSON(200, map[string]any{
+			"checked": checked,
+			"broken":  broken,
+			"drifted": drifted,
+			"audios":  checks,
+		})
+	}
+}
+
+// readAlongHandler: [?audio=ID]. Returns the chapter text as paragraphs and sentences with
+// their time ranges in the audio (chapter_audios.segments, derived from word_timings) and the
+// dictionary words found in each sentence, so the player needs no other request. Without
+// audio param the audio of the user's preferred narrator (else the first one) is used;
+// without timings every range is 0.
+func readAlongHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		chapter, err := app.FindRecordById("chapters", e.Request.PathValue("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return e.JSON(404, map[string]string{"error": "chapter not found"})
+		}
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+		story, err := app.FindRecordById("stories", chapter.GetString("story"))
+		if err != nil || !story.GetBool("is_published") {
+			return e.JSON(404, map[string]string{"error": "chapter not found"})
+		}
+		premium := e.HasSuperuserAuth() ||
+			entitlements.IsPremium(app, authUserID(e), e.Request.Header.Get(entitlements.DeviceIDHeader))
+		if !chapter.GetBool("is_free") && !premium {
+			return e.JSON(403, map[string]string{"error": "premium required for this chapter"})
+		}
+
+		audios, err := app.FindRecordsByFilter("chapter_audios", "chapter = {:chapter}", "created", 0, 0,
+			dbx.Params{"chapter": chapter.Id})
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+		audio, status := pickReadAlongAudio(app, audios, e.Request.URL.Query().Get("audio"), authUserID(e), premium)
+		switch status {
+		case 404:
+			return e.JSON(404, map[string]string{"error": "audio not found"})
+		case 403:
+			return e.JSON(403, map[string]string{"error": "premium required for this narrator"})
+		}
+
+		resp := ReadAlongResponse{
+			ChapterID:     chapter.Id,
+			StoryID:       story.Id,
+			ChapterNumber: chapter.GetInt("chapter_number"),
+			Title:         chapter.GetString("title"),
+			Paragraphs:    []ReadAlongParagraph{},
+			Dictionary:    map[string]DictionaryEntry{},
+		}
+		var paragraphs []readalong.Paragraph
+		if audio != nil {
+			resp.Audio = &ReadAlongAudio{
+				ID:            audio.Id,
+				Narrator:      audio.GetString("narrator"),
+				AudioDuration: audio.GetFloat("audio_duration"),
+			}
+			if file := audio.GetString("audio_file"); file != "" {
+				resp.Audio.AudioURL = "/api/files/" + audio.Collection().Id + "/" + audio.Id + "/" + file
+			}
+			if raw := audio.GetString("segments"); raw != "" && raw != "null" {
+				if err := json.Unmarshal([]byte(raw), &paragraphs); err != nil {
+					paragraphs = nil
+				}
+			}
+			resp.Audio.HasTimings = len(paragraphs) > 0
+		}
+		if paragraphs == nil {
+			paragraphs = readalong.Segment(chapter.GetString("content"), nil)
+		}
+
+		var texts []string
+		for _, p := range paragraphs {
+			for _, s := range p.Sentences {
+				texts = append(texts, s.Text)
+			}
+		}
+		entries, err := dictionaryEntries(app, strings.Join(texts, "\n"))
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": err.Error()})
+		}
+		byID := make(map[string]DictionaryEntry, len(entries))
+		for _, entry := range entries {
+			byID[entry.ID] = entry
+		}
+		for _, p := range paragraphs {
+			rp := ReadAlongParagraph{Index: p.Index, StartMs: p.StartMs, EndMs: p.EndMs, Sentences: []ReadAlongSentence{}}
+			for _, s := range p.Sentences {
+				rs := ReadAlongSentence{Sentence: s, Words: dictionaryHits(s.Text, entries)}
+				for _, w := range rs.Words {
+					resp.Dictionary[w.ID] = byID[w.ID]
+				}
+				rp.Sentences = append(rp.Sentences, rs)
+			}
+			resp.Paragraphs = append(resp.Paragraphs, rp)
+		}
+		return e.JSON(200, resp)
+	}
+}
+
+// pickReadAlongAudio returns the requested audio (404 when not of the chapter, 403 when its
+// narrator is premium and the caller is not), else the first audio of the user's preferred
+// narrator, else the first one the caller can play
+func pickReadAlongAudio(app core.App, audios []*core.Record, id, userID string, premium bool) (*core.Record, int) {
+	locked := map[string]bool{}
+	if !premium {
+		var ids []string
+		for _, a := range audios {
+			if ref := a.GetString("narrator_ref"); ref != "" {
+				ids = append(ids, ref)
+			}
+		}
+		if len(ids) > 0 {
+			narrators, _ := app.FindRecordsByIds("narrators", ids)
+			for _, n := range narrators {
+				locked[n.Id] = n.GetBool("is_premium")
+			}
+		}
+	}
+
+	if id != "" {
+		for _, a := range audios {
+			if a.Id == id {
+				if locked[a.GetString("narrator_ref")] {
+					return nil, 403
+				}
+				return a, 200
+			}
+		}
+		return nil, 404
+	}
+
+	preferred := ""
+	if userID != "" {
+		if pref, err := app.FindFirstRecordByFilter("user_preferences", "user = {:user}", dbx.Params{"user": userID}); err == nil {
+			preferred = pref.GetString("preferred_narrator")
+		}
+	}
+	var first *core.Record
+	for _, a := range audios {
+		ref := a.GetString("narrator_ref")
+		if locked[ref] {
+			continue
+		}
+		if preferred != "" && ref == preferred {
+			return a, 200
+		}
+		if first == nil {
+			first = a
+		}
+	}
+	return first, 200
+}
+
+// dictionaryEntries loads the dictionary words contained in text
+func dictionaryEntries(app core.App, text string) ([]DictionaryEntry, error) {
+	var entries []DictionaryEntry
+	if text == "" {
+		return entries, nil
+	}
+	err := app.DB().NewQuery(`SELECT id, word, reading, meaning, example, category FROM dictionary
+		WHERE word != '' AND instr({:text}, word) > 0`).Bind(dbx.Params{"text": text}).All(&entries)
+	return entries, err
+}
+
+// dictionaryHits finds the dictionary words in a sentence, longest first where they overlap
+// (호랑이 before 호랑), ordered by offset
+func dictionaryHits(text string, entries []DictionaryEntry) []ReadAlongWord {
+	sorted := append([]DictionaryEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return utf8.RuneCountInString(sorted[i].Word) > utf8.RuneCountInString(sorted[j].Word)
+	})
+	taken := make([]bool, utf8.RuneCountInString(text))
+	hits := []ReadAlongWord{}
+	for _, entry := range sorted {
+		length := utf8.RuneCountInString(entry.Word)
+		for from := 0; ; {
+			i := strings.Index(text[from:], entry.Word)
+			if i < 0 {
+				break
+			}
+			offset := utf8.RuneCountInString(text[:from+i])
+			from += i + len(entry.Word)
+			if !runesFree(taken, offset, length) {
+				continue
+			}
+			for k := offset; k < offset+length; k++ {
+				taken[k] = true
+			}
+			hits = append(hits, ReadAlongWord{ID: entry.ID, Word: entry.Word, Offset: offset})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Offset < hits[j].Offset })
+	return hits
+}
+
+// runesFree: no rune of [offset, offset+length) is part of an earlier hit
+func runesFree(taken []bool, offset, length int) bool {
+	for k := offset; k < offset+length; k++ {
+		if taken[k] {
+			return false
+		}
+	}
+	return true
+}
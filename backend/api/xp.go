@@ -0,0 +1,107 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"korean-kids-stories/gamification"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// XPDryRunResponse is returned by GET /api/xp/dry-run
+type XPDryRunResponse struct {
+	UserID string `json:"user_id"`
+	*gamification.ChapterOutcome
+	NextLevelXP float64 `json:"next_level_xp"` // -1 at the max level
+}
+
+// XPRebuildRequest is the body of POST /api/xp/rebuild (empty user = all users)
+type XPRebuildRequest struct {
+	UserID string `json:"user"`
+}
+
+// RegisterXPRoutes adds GET /api/xp/dry-run and POST /api/xp/rebuild (superusers)
+func RegisterXPRoutes(se *core.ServeEvent) {
+	se.Router.GET("/api/xp/dry-run", xpDryRunHandler(se.App)).Bind(apis.RequireAuth("users", core.CollectionNameSuperusers))
+	se.Router.POST("/api/xp/rebuild", xpRebuildHandler(se.App)).Bind(apis.RequireSuperuserAuth())
+}
+
+// xpDryRunHandler: ?chapter=ID[&user=ID][&listened=true|false]. What completing the chapter
+// would earn with the current xp_rules/xp_levels, without saving. Users get their own result;
+// superusers pass user (e.g. to check a new rule before activating it).
+func xpDryRunHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		q := e.Request.URL.Query()
+		chapterID := q.Get("chapter")
+		if chapterID == "" {
+			return e.JSON(400, map[string]string{"error": "chapter required"})
+		}
+		userID := authUserID(e)
+		if e.HasSuperuserAuth() {
+			userID = q.Get("user")
+		}
+		if userID == "" {
+			return e.JSON(400, map[string]string{"error": "user required"})
+		}
+		if _, err := app.FindRecordById("users", userID); err != nil {
+			return e.JSON(404, map[string]string{"error": "user not found"})
+		}
+		var listened *bool
+		if s := q.Get("listened"); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return e.JSON(400, map[string]string{"error": "listened must be true or false"})
+			}
+			listened = &b
+		}
+
+		outcome, err := gamification.PlanChapterCompleted(app, userID, chapterID, listened)
+		if errors.Is(err, sql.ErrNoRows) {
+			return e.JSON(404, map[string]string{"error": "chapter not found"})
+		}
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": "failed to evaluate xp rules"})
+		}
+		levels, err := gamification.LoadLevels(app)
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": "failed to load levels"})
+		}
+		return e.JSON(200, XPDryRunResponse{
+			UserID:         userID,
+			ChapterOutcome: outcome,
+			NextLevelXP:    levels.Next(outcome.LevelAfter),
+		})
+	}
+}
+
+// xpRebuildHandler: appends missing awards to xp_transactions and rebuilds user_stats and
+// user_stickers for one user or all users (same as the rebuild-stats command)
+func xpRebuildHandler(app core.App) func(*core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		var req XPRebuildRequest
+		if e.Request.ContentLength != 0 {
+			if err := json.NewDecoder(e.Request.Body).Decode(&req); err != nil {
+				return e.JSON(400, map[string]string{"error": "invalid json"})
+			}
+		}
+		if req.UserID == "" {
+			results, err := gamification.RebuildAll(app)
+			if err != nil {
+				return e.JSON(500, map[string]string{"error": "rebuild failed: " + err.Error()})
+			}
+			return e.JSON(200, map[string]any{"results": results})
+		}
+		if _, err := app.FindRecordById("users", req.UserID); err != nil {
+			return e.JSON(404, map[string]string{"error": "user not found"})
+		}
+		result, err := gamification.RebuildUser(app, req.UserID)
+		if err != nil {
+			return e.JSON(500, map[string]string{"error": "rebuild failed: " + err.Error()})
+		}
+		return e.JSON(200, map[string]any{"results": []*gamification.RebuildResult{result}})
+	}
+}
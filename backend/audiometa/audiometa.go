@@ -0,0 +1,166 @@
+// Package audiometa reads the format, duration, sample rate, channels and bitrate of
+// uploaded audio files (MP3, M4A, WAV) from their headers, in pure Go, and rejects
+// truncated or mislabelled files.
+package audiometa
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// Formats (chapter_audios.audio_format)
+const (
+	FormatMP3  = "mp3"
+	FormatM4A  = "m4a"
+	FormatWAV  = "wav"
+	FormatWebM = "webm" // detected only, not parsed
+)
+
+var (
+	// ErrUnknownFormat: not a supported audio file
+	ErrUnknownFormat = errors.New("not an MP3, M4A, WAV or WebM file")
+	// ErrTruncated: the file ends before its headers say it should (incomplete upload)
+	ErrTruncated = errors.New("file is truncated")
+	// ErrInvalid: broken headers or no audio
+	ErrInvalid = errors.New("invalid audio file")
+)
+
+// Info is the metadata read from an audio file
+type Info struct {
+	Format     string  `json:"format"`
+	Duration   float64 `json:"duration"`    // seconds, 0 when not parsed (WebM)
+	SampleRate int     `json:"sample_rate"` // Hz
+	Channels   int     `json:"channels"`
+	Bitrate    int     `json:"bitrate"`     // average kbps
+	LoudnessDB float64 `json:"loudness_db"` // RMS level in dBFS (PCM WAV only, 0 = not measured)
+}
+
+// Detect returns the format of a file from its first 12 bytes ("" when unknown)
+func Detect(head []byte) string {
+	switch {
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		return FormatWAV
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return FormatM4A
+	case len(head) >= 4 && bytes.Equal(head[:4], []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		return FormatWebM
+	case len(head) >= 3 && string(head[:3]) == "ID3":
+		return FormatMP3
+	case len(head) >= 2 && head[0] == 0xff && head[1]&0xe0 == 0xe0 && head[1]&0x06 != 0:
+		return FormatMP3 // frame sync, layer I-III (not ADTS)
+	}
+	return ""
+}
+
+// FormatFromName returns the format expected from the file extension ("" when unknown)
+func FormatFromName(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mp3":
+		return FormatMP3
+	case ".m4a", ".mp4", ".m4b":
+		return FormatM4A
+	case ".wav", ".wave":
+		return FormatWAV
+	case ".webm", ".weba":
+		return FormatWebM
+	}
+	return ""
+}
+
+// Read parses an audio file of size bytes
+func Read(r io.ReadSeeker, size int64) (*Info, error) {
+	head := make([]byte, 12)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: empty file", ErrInvalid)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	switch Detect(head[:n]) {
+	case FormatMP3:
+		return readMP3(r, size)
+	case FormatM4A:
+		return readMP4(r, size)
+	case FormatWAV:
+		return readWAV(r, size)
+	case FormatWebM:
+		return &Info{Format: FormatWebM}, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// ReadFile reads an uploaded file and checks that its content matches its extension
+func ReadFile(f *filesystem.File) (*Info, error) {
+	rs, err := f.Reader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	size := f.Size
+	if size <= 0 {
+		if size, err = rs.Seek(0, io.SeekEnd); err != nil {
+			return nil, err
+		}
+	}
+	info, err := Read(rs, size)
+	if err != nil {
+		return nil, err
+	}
+	name := f.OriginalName
+	if name == "" {
+		name = f.Name
+	}
+	if want := FormatFromName(name); want != "" && want != info.Format {
+		return nil, fmt.Errorf("%s is a %s file, not %s", name, strings.ToUpper(info.Format), strings.ToUpper(want))
+	}
+	return info, nil
+}
+
+// ReadStored reads the stored audio_file of a chapter_audios record
+func ReadStored(app core.App, record *core.Record) (*Info, error) {
+	name := record.GetString("audio_file")
+	if name == "" {
+		return nil, nil
+	}
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	defer fsys.Close()
+	r, err := fsys.GetReader(record.BaseFilesPath() + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return Read(r, r.Size())
+}
+
+// Apply sets the metadata fields of a chapter_audios record (not saved). audio_duration is
+// overwritten when the duration is known.
+func Apply(record *core.Record, info *Info) {
+	record.Set("audio_format", info.Format)
+	record.Set("sample_rate", info.SampleRate)
+	record.Set("channels", info.Channels)
+	record.Set("bitrate", info.Bitrate)
+	record.Set("loudness_db", info.LoudnessDB)
+	if info.Duration > 0 {
+		record.Set("audio_duration", math.Round(info.Duration*1000)/1000)
+	}
+}
+
+// averageKbps is the average bitrate of n bytes over seconds
+func averageKbps(n int64, seconds float64) int {
+	if seconds <= 0 {
+		return 0
+	}
+	return int(math.Round(float64(n) * 8 / seconds / 1000))
+}
@@ -0,0 +1,165 @@
+package audiometa_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"korean-kids-stories/audiometa"
+
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// testdata: tone.mp3 (ID3v2 tag + 50 silent MPEG-1 layer III frames, 128 kbps 44.1 kHz
+// stereo), tone.m4a (3 s mono AAC track), pluck-pcm*.wav (0.3 s stereo 11.025 kHz PCM)
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// xingFrame is an info frame matching the tone.mp3 frames that announces frames audio frames
+func xingFrame(frames uint32) []byte {
+	frame := make([]byte, 417)
+	binary.BigEndian.PutUint32(frame, 0xfffb9000)
+	copy(frame[36:], "Xing")
+	binary.BigEndian.PutUint32(frame[40:], 1) // frame count present
+	binary.BigEndian.PutUint32(frame[44:], frames)
+	return frame
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestRead(t *testing.T) {
+	mp3 := fixture(t, "tone.mp3")
+	m4a := fixture(t, "tone.m4a")
+	wav := fixture(t, "pluck-pcm16.wav")
+	id3, frames := mp3[:10], mp3[10:]
+	id3v1 := append([]byte("TAG"), make([]byte, 125)...)
+	ape := append([]byte("APETAGEX"), make([]byte, 24)...)
+	// tone.m4a: ftyp (0x18 bytes), moov, then mdat from 0x120
+	noMoov := join(m4a[:0x18], m4a[0x120:])
+
+	tests := []struct {
+		name       string
+		data       []byte
+		format     string
+		duration   float64
+		sampleRate int
+		channels   int
+		bitrate    int
+		loudness   float64
+		err        error
+	}{
+		{"mp3", mp3, "mp3", 50 * 1152 / 44100.0, 44100, 2, 128, 0, nil},
+		{"mp3 without ID3 tag", frames, "mp3", 50 * 1152 / 44100.0, 44100, 2, 128, 0, nil},
+		{"mp3 with ID3v1 tag", join(mp3, id3v1), "mp3", 50 * 1152 / 44100.0, 44100, 2, 128, 0, nil},
+		{"mp3 with APE tag", join(mp3, ape), "mp3", 50 * 1152 / 44100.0, 44100, 2, 128, 0, nil},
+		{"mp3 with Xing header", join(id3, xingFrame(50), frames), "mp3", 50 * 1152 / 44100.0, 44100, 2, 128, 0, nil},
+		{"mp3 shorter than its Xing header", join(id3, xingFrame(80), frames), "", 0, 0, 0, 0, 0, audiometa.ErrTruncated},
+		{"mp3 last frame cut", mp3[:len(mp3)-200], "", 0, 0, 0, 0, 0, audiometa.ErrTruncated},
+		{"mp3 ID3 tag longer than the file", join([]byte("ID3\x03\x00\x00\x7f\x7f\x7f\x7f"), frames), "", 0, 0, 0, 0, 0, audiometa.ErrTruncated},
+		{"ID3 tag without frames", join(id3, bytes.Repeat([]byte{0x55}, 1000)), "", 0, 0, 0, 0, 0, audiometa.ErrInvalid},
+		{"m4a", m4a, "m4a", 3, 44100, 1, 128, 0, nil},
+		{"m4a mdat cut", m4a[:len(m4a)-1000], "", 0, 0, 0, 0, 0, audiometa.ErrTruncated},
+		{"m4a without moov", noMoov, "", 0, 0, 0, 0, 0, audiometa.ErrTruncated},
+		{"wav 16-bit", wav, "wav", 0.3, 11025, 2, 353, -15.5, nil},
+		{"wav 8-bit", fixture(t, "pluck-pcm8.wav"), "wav", 0.3, 11025, 2, 176, -15.5, nil},
+		{"wav 24-bit extensible", fixture(t, "pluck-pcm24-ext.wav"), "wav", 0.3, 11025, 2, 529, -15.5, nil},
+		{"wav data cut", wav[:len(wav)-500], "", 0, 0, 0, 0, 0, audiometa.ErrTruncated},
+		{"wav without data chunk", wav[:36], "", 0, 0, 0, 0, 0, audiometa.ErrTruncated},
+		{"webm", []byte{0x1a, 0x45, 0xdf, 0xa3, 0, 0, 0, 0, 0, 0, 0, 0}, "webm", 0, 0, 0, 0, 0, nil},
+		{"unknown", bytes.Repeat([]byte{0x55}, 1100), "", 0, 0, 0, 0, 0, audiometa.ErrUnknownFormat},
+		{"empty", nil, "", 0, 0, 0, 0, 0, audiometa.ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := audiometa.Read(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Format != tt.format || math.Abs(info.Duration-tt.duration) > 0.001 || info.SampleRate != tt.sampleRate ||
+				info.Channels != tt.channels || info.Bitrate != tt.bitrate || info.LoudnessDB != tt.loudness {
+				t.Fatalf("info = %+v", info)
+			}
+		})
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	wav := fixture(t, "pluck-pcm16.wav")
+
+	tests := []struct {
+		name string
+		file string
+		err  string
+	}{
+		{"matching extension", "story.wav", ""},
+		{"unknown extension", "story.bin", ""},
+		{"mislabelled", "story.mp3", "story.mp3 is a WAV file, not MP3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := filesystem.NewFileFromBytes(wav, tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := audiometa.ReadFile(f)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || info.Format != audiometa.FormatWAV {
+				t.Fatalf("info = %+v, err = %v", info, err)
+			}
+		})
+	}
+}
+
+func TestMP3Segments(t *testing.T) {
+	mp3 := fixture(t, "tone.mp3")
+	frame := 1152 / 44100.0
+
+	segments, info, err := audiometa.MP3Segments(bytes.NewReader(mp3), int64(len(mp3)), 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0.5 s is 19.1 frames: segments close on the frame that reaches the target
+	want := []int{20, 20, 10}
+	if len(segments) != len(want) {
+		t.Fatalf("segments = %+v", segments)
+	}
+	offset, start := int64(10), 0.0
+	for i, s := range segments {
+		if s.Offset != offset || math.Abs(s.Start-start) > 1e-9 || math.Abs(s.Duration-float64(want[i])*frame) > 1e-9 {
+			t.Fatalf("segment %d = %+v, want offset %d start %f", i, s, offset, start)
+		}
+		offset += s.Length
+		start += s.Duration
+	}
+	if offset != int64(len(mp3)) || math.Abs(start-info.Duration) > 1e-9 {
+		t.Fatalf("segments end at byte %d / %f s, file is %d bytes / %f s", offset, start, len(mp3), info.Duration)
+	}
+
+	if _, _, err := audiometa.MP3Segments(bytes.NewReader(mp3[:len(mp3)-200]), int64(len(mp3)-200), 0.5); !errors.Is(err, audiometa.ErrTruncated) {
+		t.Fatalf("truncated file: error = %v", err)
+	}
+}
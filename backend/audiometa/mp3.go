@@ -0,0 +1,246 @@
+package audiometa
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// maxMP3Junk: bytes skipped looking for the first frame (album art in broken tags...)
+const maxMP3Junk = 64 << 10
+
+// kbps by [MPEG-1][layer I, II, III] and [MPEG-2/2.5][layer I, II/III]; index 0 is free format
+var (
+	mp3BitratesV1 = [3][15]int{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	}
+	mp3BitratesV2 = [2][15]int{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+	// Hz by version bits (00 MPEG-2.5, 10 MPEG-2, 11 MPEG-1)
+	mp3SampleRates = map[uint32][3]int{
+		0: {11025, 12000, 8000},
+		2: {22050, 24000, 16000},
+		3: {44100, 48000, 32000},
+	}
+)
+
+// mp3Frame is a parsed MPEG audio frame header
+type mp3Frame struct {
+	mpeg1      bool
+	layer      int // 1-3
+	sampleRate int
+	channels   int
+	samples    int // per frame
+	length     int // bytes, header included
+}
+
+func parseMP3Header(h uint32) (mp3Frame, bool) {
+	version, layerBits := (h>>19)&3, (h>>17)&3
+	bitrateIdx, rateIdx := (h>>12)&0xf, (h>>10)&3
+	if h>>21 != 0x7ff || version == 1 || layerBits == 0 || bitrateIdx == 0 || bitrateIdx == 15 || rateIdx == 3 {
+		return mp3Frame{}, false
+	}
+	f := mp3Frame{mpeg1: version == 3, layer: 4 - int(layerBits), channels: 2}
+	f.sampleRate = mp3SampleRates[version][rateIdx]
+	if (h>>6)&3 == 3 {
+		f.channels = 1
+	}
+	var kbps int
+	switch {
+	case f.mpeg1:
+		kbps = mp3BitratesV1[f.layer-1][bitrateIdx]
+	case f.layer == 1:
+		kbps = mp3BitratesV2[0][bitrateIdx]
+	default:
+		kbps = mp3BitratesV2[1][bitrateIdx]
+	}
+	padding := int((h >> 9) & 1)
+	switch {
+	case f.layer == 1:
+		f.samples = 384
+		f.length = (12*kbps*1000/f.sampleRate + padding) * 4
+	case f.layer == 3 && !f.mpeg1:
+		f.samples = 576
+		f.length = 72*kbps*1000/f.sampleRate + padding
+	default:
+		f.samples = 1152
+		f.length = 144*kbps*1000/f.sampleRate + padding
+	}
+	return f, true
+}
+
+// vbrFrames returns the frame count of a Xing / Info (LAME) or VBRI header in the first
+// frame, which is then an info frame without audio (0, false when there is none)
+func vbrFrames(f mp3Frame, data []byte) (int, bool) {
+	side := 32
+	switch {
+	case f.mpeg1 && f.channels == 1:
+		side = 17
+	case !f.mpeg1 && f.channels == 1:
+		side = 9
+	case !f.mpeg1:
+		side = 17
+	}
+	if x := data[min(4+side, len(data)):]; len(x) >= 8 && (bytes.HasPrefix(x, []byte("Xing")) || bytes.HasPrefix(x, []byte("Info"))) {
+		if binary.BigEndian.Uint32(x[4:8])&1 != 0 && len(x) >= 12 {
+			return int(binary.BigEndian.Uint32(x[8:12])), true
+		}
+		return 0, true
+	}
+	if v := data[min(36, len(data)):]; len(v) >= 18 && bytes.HasPrefix(v, []byte("VBRI")) {
+		return int(binary.BigEndian.Uint32(v[14:18])), true
+	}
+	return 0, false
+}
+
+// Segment is a run of whole MP3 frames (an HLS segment): bytes [Offset, Offset+Length) of
+// the file, starting Start seconds into the audio
+type Segment struct {
+	Offset   int64   `json:"offset"`
+	Length   int64   `json:"length"`
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+}
+
+// MP3Segments splits an MP3 file into segments of whole frames of about target seconds
+// (the last one may be shorter)
+func MP3Segments(r io.ReadSeeker, size int64, target float64) ([]Segment, *Info, error) {
+	var segments []Segment
+	var elapsed float64
+	info, err := scanMP3(r, size, func(offset int64, f mp3Frame) {
+		n := len(segments)
+		if n == 0 || segments[n-1].Duration >= target {
+			segments = append(segments, Segment{Offset: offset, Start: elapsed})
+			n++
+		}
+		seconds := float64(f.samples) / float64(f.sampleRate)
+		segments[n-1].Length = offset + int64(f.length) - segments[n-1].Offset
+		segments[n-1].Duration += seconds
+		elapsed += seconds
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return segments, info, nil
+}
+
+func readMP3(r io.ReadSeeker, size int64) (*Info, error) {
+	return scanMP3(r, size, nil)
+}
+
+// scanMP3 walks the frames between the ID3v2 tag and the trailing ID3v1 / APE / Lyrics tags,
+// calling onFrame (if set) for each audio frame. A last frame cut short, or fewer frames than
+// the Xing / VBRI header announces, means the file is truncated.
+func scanMP3(r io.ReadSeeker, size int64, onFrame func(offset int64, f mp3Frame)) (*Info, error) {
+	end := size
+	if size >= 128 {
+		tag := make([]byte, 3)
+		if _, err := r.Seek(size-128, io.SeekStart); err == nil {
+			if _, err := io.ReadFull(r, tag); err == nil && string(tag) == "TAG" {
+				end -= 128
+			}
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	br := bufio.NewReaderSize(r, 64<<10)
+	var pos int64
+	if head, _ := br.Peek(10); len(head) == 10 && string(head[:3]) == "ID3" {
+		tagSize := int64(head[6]&0x7f)<<21 | int64(head[7]&0x7f)<<14 | int64(head[8]&0x7f)<<7 | int64(head[9]&0x7f) + 10
+		if head[5]&0x10 != 0 {
+			tagSize += 10 // footer
+		}
+		if tagSize > end {
+			return nil, fmt.Errorf("%w: ID3 tag of %d bytes in a %d-byte file", ErrTruncated, tagSize, size)
+		}
+		if _, err := br.Discard(int(tagSize)); err != nil {
+			return nil, err
+		}
+		pos = tagSize
+	}
+
+	var first mp3Frame
+	var frames, samples, expected int
+	var audioBytes, junk int64
+	for pos+4 <= end {
+		h, err := br.Peek(4)
+		if err != nil {
+			return nil, err
+		}
+		f, ok := parseMP3Header(binary.BigEndian.Uint32(h))
+		if ok && frames > 0 && (f.sampleRate != first.sampleRate || f.layer != first.layer) {
+			ok = false
+		}
+		if ok && frames == 0 {
+			// A real first frame is followed by another one (or the end of the file)
+			data, _ := br.Peek(f.length + 4)
+			if len(data) == f.length+4 {
+				_, ok = parseMP3Header(binary.BigEndian.Uint32(data[f.length:]))
+			}
+		}
+		if !ok {
+			if frames > 0 && (bytes.Equal(h, []byte("APET")) || bytes.Equal(h, []byte("LYRI"))) {
+				break
+			}
+			if _, err := br.Discard(1); err != nil {
+				return nil, err
+			}
+			pos++
+			junk++
+			if frames == 0 && junk > maxMP3Junk {
+				return nil, fmt.Errorf("%w: no MPEG audio frames", ErrInvalid)
+			}
+			continue
+		}
+		if pos+int64(f.length) > end {
+			return nil, fmt.Errorf("%w: the last MP3 frame needs %d more bytes", ErrTruncated, pos+int64(f.length)-end)
+		}
+
+		info := false
+		if frames == 0 {
+			first = f
+			data, _ := br.Peek(min(f.length, 64))
+			expected, info = vbrFrames(f, data)
+		}
+		if _, err := br.Discard(f.length); err != nil {
+			return nil, err
+		}
+		pos += int64(f.length)
+		frames++
+		if info {
+			continue // no audio in the info frame
+		}
+		if onFrame != nil {
+			onFrame(pos-int64(f.length), f)
+		}
+		samples += f.samples
+		audioBytes += int64(f.length)
+	}
+
+	if samples == 0 {
+		return nil, fmt.Errorf("%w: no MPEG audio frames", ErrInvalid)
+	}
+	if junk > size/10 {
+		return nil, fmt.Errorf("%w: %d bytes are not MPEG audio frames", ErrInvalid, junk)
+	}
+	// the info frame is not in the Xing count; allow a frame or two of encoder slack
+	if expected > 0 && frames-1 < expected-2 {
+		return nil, fmt.Errorf("%w: %d of %d MP3 frames", ErrTruncated, frames-1, expected)
+	}
+	duration := float64(samples) / float64(first.sampleRate)
+	return &Info{
+		Format:     FormatMP3,
+		Duration:   duration,
+		SampleRate: first.sampleRate,
+		Channels:   first.channels,
+		Bitrate:    averageKbps(audioBytes, duration),
+	}, nil
+}
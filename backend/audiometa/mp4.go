@@ -0,0 +1,168 @@
+package audiometa
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// maxMoovSize: larger moov boxes are not read (an audio-only moov is a few hundred KB)
+const maxMoovSize = 32 << 20
+
+// readMP4 walks the top-level boxes (each must fit in the file) and reads the sound track
+// of the moov box: duration from mdhd, sample rate and channels from the stsd sample entry.
+// A file without moov is an incomplete upload when the moov was to be written last.
+func readMP4(r io.ReadSeeker, size int64) (*Info, error) {
+	var moov []byte
+	var mdatBytes int64
+	for pos := int64(0); pos < size; {
+		if size-pos < 8 {
+			return nil, fmt.Errorf("%w: %d stray bytes at the end", ErrTruncated, size-pos)
+		}
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+		head := make([]byte, 16)
+		n, _ := io.ReadFull(r, head)
+		boxSize, headerSize := int64(binary.BigEndian.Uint32(head[:4])), int64(8)
+		typ := string(head[4:8])
+		switch boxSize {
+		case 0:
+			boxSize = size - pos // to the end of the file
+		case 1:
+			if n < 16 {
+				return nil, fmt.Errorf("%w: box %q", ErrTruncated, typ)
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(head[8:16])), 16
+		}
+		if boxSize < headerSize {
+			return nil, fmt.Errorf("%w: box %q has size %d", ErrInvalid, typ, boxSize)
+		}
+		if pos+boxSize > size {
+			return nil, fmt.Errorf("%w: box %q needs %d more bytes", ErrTruncated, typ, pos+boxSize-size)
+		}
+		switch typ {
+		case "moov":
+			if boxSize > maxMoovSize {
+				return nil, fmt.Errorf("%w: moov box of %d bytes", ErrInvalid, boxSize)
+			}
+			moov = make([]byte, boxSize-headerSize)
+			if _, err := r.Seek(pos+headerSize, io.SeekStart); err != nil {
+				return nil, err
+			}
+			if _, err := io.ReadFull(r, moov); err != nil {
+				return nil, err
+			}
+		case "mdat":
+			mdatBytes += boxSize - headerSize
+		}
+		pos += boxSize
+	}
+	if moov == nil {
+		return nil, fmt.Errorf("%w: no moov box", ErrTruncated)
+	}
+	if mdatBytes == 0 {
+		return nil, fmt.Errorf("%w: no mdat box", ErrInvalid)
+	}
+
+	info := &Info{Format: FormatM4A}
+	var movieDuration float64
+	var found bool
+	eachBox(moov, func(typ string, body []byte) {
+		switch typ {
+		case "mvhd":
+			movieDuration = headerDuration(body)
+		case "trak":
+			if !found {
+				found = soundTrack(body, info)
+			}
+		}
+	})
+	if !found {
+		return nil, fmt.Errorf("%w: no audio track", ErrInvalid)
+	}
+	if info.Duration == 0 {
+		info.Duration = movieDuration
+	}
+	if info.Duration <= 0 {
+		return nil, fmt.Errorf("%w: no duration", ErrInvalid)
+	}
+	info.Bitrate = averageKbps(mdatBytes, info.Duration)
+	return info, nil
+}
+
+// eachBox calls fn with the child boxes of data (stops at the first malformed one)
+func eachBox(data []byte, fn func(typ string, body []byte)) {
+	for len(data) >= 8 {
+		size, header := uint64(binary.BigEndian.Uint32(data[:4])), uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			size, header = binary.BigEndian.Uint64(data[8:16]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return
+		}
+		fn(string(data[4:8]), data[header:size])
+		data = data[size:]
+	}
+}
+
+// headerDuration reads duration / timescale of a mvhd or mdhd box (seconds)
+func headerDuration(body []byte) float64 {
+	var timescale, duration uint64
+	switch {
+	case len(body) >= 32 && body[0] == 1:
+		timescale, duration = uint64(binary.BigEndian.Uint32(body[20:24])), binary.BigEndian.Uint64(body[24:32])
+	case len(body) >= 20 && body[0] == 0:
+		timescale, duration = uint64(binary.BigEndian.Uint32(body[12:16])), uint64(binary.BigEndian.Uint32(body[16:20]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return float64(duration) / float64(timescale)
+}
+
+// soundTrack fills info from a trak box when its handler is "soun"
+func soundTrack(trak []byte, info *Info) bool {
+	sound := false
+	var duration float64
+	var channels, sampleRate int
+	eachBox(trak, func(typ string, mdia []byte) {
+		if typ != "mdia" {
+			return
+		}
+		eachBox(mdia, func(typ string, body []byte) {
+			switch typ {
+			case "hdlr":
+				sound = len(body) >= 12 && string(body[8:12]) == "soun"
+			case "mdhd":
+				duration = headerDuration(body)
+			case "minf":
+				eachBox(body, func(typ string, stbl []byte) {
+					if typ != "stbl" {
+						return
+					}
+					eachBox(stbl, func(typ string, stsd []byte) {
+						// version/flags, entry count, then the first sample entry: 8-byte box
+						// header, 8 reserved / data reference, 8 version / vendor, channels,
+						// sample size, 4 reserved, 16.16 sample rate
+						if typ == "stsd" && len(stsd) >= 8+8+28 {
+							entry := stsd[8+8:]
+							channels = int(binary.BigEndian.Uint16(entry[16:18]))
+							sampleRate = int(binary.BigEndian.Uint32(entry[24:28]) >> 16)
+						}
+					})
+				})
+			}
+		})
+	})
+	if sound {
+		info.Duration, info.Channels, info.SampleRate = duration, channels, sampleRate
+	}
+	return sound
+}
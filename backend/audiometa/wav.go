@@ -0,0 +1,145 @@
+package audiometa
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// WAVE format tags
+const (
+	wavePCM        = 1
+	waveFloat      = 3
+	waveExtensible = 0xfffe
+)
+
+// readWAV reads the fmt chunk and the size of the data chunk, which must fit in the file
+// (0 or 0xffffffff, written by streaming encoders, means up to the end of the file). The
+// loudness of PCM and float data is the RMS level of all samples.
+func readWAV(r io.ReadSeeker, size int64) (*Info, error) {
+	var format, channels, blockAlign, bits int
+	var sampleRate, byteRate int64
+	haveFmt := false
+	for pos := int64(12); ; {
+		if pos+8 > size {
+			return nil, fmt.Errorf("%w: no data chunk", ErrTruncated)
+		}
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+		head := make([]byte, 8)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return nil, err
+		}
+		id, chunkSize := string(head[:4]), int64(binary.LittleEndian.Uint32(head[4:8]))
+		body := pos + 8
+
+		if id == "data" {
+			if !haveFmt {
+				return nil, fmt.Errorf("%w: data chunk before fmt", ErrInvalid)
+			}
+			if chunkSize == 0 || chunkSize == 0xffffffff {
+				chunkSize = size - body
+			}
+			if body+chunkSize > size {
+				return nil, fmt.Errorf("%w: data chunk needs %d more bytes", ErrTruncated, body+chunkSize-size)
+			}
+			if byteRate == 0 {
+				byteRate = sampleRate * int64(blockAlign)
+			}
+			if byteRate == 0 || chunkSize == 0 {
+				return nil, fmt.Errorf("%w: no audio data", ErrInvalid)
+			}
+			duration := float64(chunkSize) / float64(byteRate)
+			info := &Info{
+				Format:     FormatWAV,
+				Duration:   duration,
+				SampleRate: int(sampleRate),
+				Channels:   channels,
+				Bitrate:    averageKbps(chunkSize, duration),
+			}
+			if _, err := r.Seek(body, io.SeekStart); err != nil {
+				return nil, err
+			}
+			db, err := rmsLevel(io.LimitReader(r, chunkSize), format, bits)
+			if err != nil {
+				return nil, err
+			}
+			info.LoudnessDB = db
+			return info, nil
+		}
+
+		if body+chunkSize > size {
+			return nil, fmt.Errorf("%w: %q chunk needs %d more bytes", ErrTruncated, id, body+chunkSize-size)
+		}
+		if id == "fmt " {
+			if chunkSize < 16 {
+				return nil, fmt.Errorf("%w: fmt chunk of %d bytes", ErrInvalid, chunkSize)
+			}
+			f := make([]byte, min(chunkSize, 40))
+			if _, err := io.ReadFull(r, f); err != nil {
+				return nil, err
+			}
+			format = int(binary.LittleEndian.Uint16(f[0:2]))
+			channels = int(binary.LittleEndian.Uint16(f[2:4]))
+			sampleRate = int64(binary.LittleEndian.Uint32(f[4:8]))
+			byteRate = int64(binary.LittleEndian.Uint32(f[8:12]))
+			blockAlign = int(binary.LittleEndian.Uint16(f[12:14]))
+			bits = int(binary.LittleEndian.Uint16(f[14:16]))
+			if format == waveExtensible && len(f) >= 26 {
+				format = int(binary.LittleEndian.Uint16(f[24:26])) // sub format GUID
+			}
+			if channels == 0 || sampleRate == 0 {
+				return nil, fmt.Errorf("%w: %d channels at %d Hz", ErrInvalid, channels, sampleRate)
+			}
+			haveFmt = true
+		}
+		pos = body + chunkSize + chunkSize%2 // chunks are word-aligned
+	}
+}
+
+// rmsLevel is the RMS level of the samples in dBFS, rounded to 0.1 dB and floored at
+// -100 (silence); 0 for formats it cannot decode
+func rmsLevel(data io.Reader, format, bits int) (float64, error) {
+	var decode func([]byte) float64
+	switch {
+	case format == wavePCM && bits == 8:
+		decode = func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case format == wavePCM && bits == 16:
+		decode = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }
+	case format == wavePCM && bits == 24:
+		decode = func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}
+	case format == wavePCM && bits == 32:
+		decode = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case format == waveFloat && bits == 32:
+		decode = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	case format == waveFloat && bits == 64:
+		decode = func(b []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b)) }
+	default:
+		return 0, nil
+	}
+
+	br := bufio.NewReaderSize(data, 64<<10)
+	sample := make([]byte, bits/8)
+	var sum float64
+	var n int64
+	for {
+		if _, err := io.ReadFull(br, sample); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return 0, err
+		}
+		v := decode(sample)
+		sum += v * v
+		n++
+	}
+	if n == 0 || sum == 0 {
+		return -100, nil
+	}
+	return math.Max(math.Round(10*math.Log10(sum/float64(n))*10)/10, -100), nil
+}
@@ -0,0 +1,13 @@
+package commands
+
+import (
+	"github.com/pocketbase/pocketbase"
+)
+
+// RegisterCommands adds the custom console commands to the PocketBase root command
+func RegisterCommands(app *pocketbase.PocketBase) {
+	app.RootCmd.AddCommand(newImportStoriesCommand(app))
+	app.RootCmd.AddCommand(newMigrateCommand(app))
+	app.RootCmd.AddCommand(newDriftCommand(app))
+	app.RootCmd.AddCommand(newRebuildStatsCommand(app))
+}
@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"fmt"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/spf13/cobra"
+)
+
+// newDriftCommand: drift [--apply]
+// Compares the declared schema with the live collections; exits non-zero on unapplied drift.
+func newDriftCommand(app *pocketbase.PocketBase) *cobra.Command {
+	var apply bool
+
+	cmd := &cobra.Command{
+		Use:          "drift",
+		Short:        "Report differences between the declared schema and the database",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			items := schema.CheckDrift(app, apply)
+			for _, d := range items {
+				fmt.Println(d)
+			}
+			pending, unsafe := schema.PendingDrift(items)
+			if pending == 0 {
+				fmt.Printf("no pending drift (%d applied)\n", len(items))
+				return nil
+			}
+			return fmt.Errorf("%d pending difference(s), %d unsafe", pending, unsafe)
+		},
+	}
+	cmd.Flags().BoolVar(&apply, "apply", false, "apply safe updates (unsafe ones still need a migration)")
+
+	return cmd
+}
@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"korean-kids-stories/importer"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/spf13/cobra"
+)
+
+// newImportStoriesCommand: import-stories [--dry-run] <file.md|dir>...
+// Parses content/*.md files and upserts stories, chapters and dictionary words.
+func newImportStoriesCommand(app *pocketbase.PocketBase) *cobra.Command {
+	var opts importer.Options
+
+	cmd := &cobra.Command{
+		Use:          "import-stories <file.md|dir>...",
+		Short:        "Import content/*.md stories into stories, chapters and dictionary",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			files, err := collectMarkdownFiles(args)
+			if err != nil {
+				return err
+			}
+
+			failed := 0
+			for _, file := range files {
+				doc, err := importer.ParseFile(file)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+					failed++
+					continue
+				}
+				changes, err := importer.Import(app, doc, opts)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "✗ %s: %v\n", file, err)
+					failed++
+					continue
+				}
+				fmt.Printf("%s (%s)\n", file, doc.Title)
+				for _, c := range changes {
+					if c.Action == "unchanged" && !opts.DryRun {
+						continue
+					}
+					fmt.Printf("  %s\n", c)
+				}
+			}
+
+			if opts.DryRun {
+				fmt.Println("Dry run: no changes were written")
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d file(s) failed", failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "show the diff without writing")
+	cmd.Flags().IntVar(&opts.FreeChapters, "free-chapters", 0, "mark chapters 1..N as is_free when they are created")
+	cmd.Flags().StringVar(&opts.DefaultCategory, "dict-category", "old_korean", "dictionary category for words without a Category column")
+
+	return cmd
+}
+
+// collectMarkdownFiles expands directories to their *.md files
+func collectMarkdownFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.md"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
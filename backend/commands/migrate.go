@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"fmt"
+
+	"korean-kids-stories/migrations"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/spf13/cobra"
+)
+
+// newMigrateCommand: migrate status | up [--to N] | down [--steps N]
+func newMigrateCommand(app *pocketbase.PocketBase) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Versioned schema migrations (status, up, down)",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:          "status",
+		Short:        "List migrations and whether they are applied",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			list, err := migrations.StatusAll(app)
+			if err != nil {
+				return err
+			}
+			pending := 0
+			for _, s := range list {
+				state := "pending"
+				if s.Applied {
+					state = "applied " + s.AppliedAt
+				} else {
+					pending++
+				}
+				fmt.Printf("%4d  %-40s %s\n", s.Version, s.Name, state)
+			}
+			fmt.Printf("%d migration(s), %d pending\n", len(list), pending)
+			return nil
+		},
+	})
+
+	var to int
+	upCmd := &cobra.Command{
+		Use:          "up",
+		Short:        "Apply pending migrations",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ran, err := migrations.Up(app, to)
+			for _, m := range ran {
+				fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+			}
+			if err != nil {
+				return err
+			}
+			if len(ran) == 0 {
+				fmt.Println("nothing to apply")
+			}
+			return nil
+		},
+	}
+	upCmd.Flags().IntVar(&to, "to", 0, "apply up to this version (0 = latest)")
+	cmd.AddCommand(upCmd)
+
+	var steps int
+	downCmd := &cobra.Command{
+		Use:          "down",
+		Short:        "Revert the most recently applied migrations",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ran, err := migrations.Down(app, steps)
+			for _, m := range ran {
+				fmt.Printf("reverted %d_%s\n", m.Version, m.Name)
+			}
+			if err != nil {
+				return err
+			}
+			if len(ran) == 0 {
+				fmt.Println("nothing to revert")
+			}
+			return nil
+		},
+	}
+	downCmd.Flags().IntVar(&steps, "steps", 1, "number of migrations to revert")
+	cmd.AddCommand(downCmd)
+
+	return cmd
+}
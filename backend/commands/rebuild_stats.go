@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"fmt"
+
+	"korean-kids-stories/gamification"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/spf13/cobra"
+)
+
+// newRebuildStatsCommand: rebuild-stats [--user ID]
+// Appends missing XP awards to xp_transactions and rebuilds user_stats / user_stickers.
+func newRebuildStatsCommand(app *pocketbase.PocketBase) *cobra.Command {
+	var userID string
+
+	cmd := &cobra.Command{
+		Use:          "rebuild-stats",
+		Short:        "Rebuild XP ledger, user_stats and user_stickers from reading_progress",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var results []*gamification.RebuildResult
+			if userID != "" {
+				result, err := gamification.RebuildUser(app, userID)
+				if err != nil {
+					return err
+				}
+				results = append(results, result)
+			} else {
+				var err error
+				if results, err = gamification.RebuildAll(app); err != nil {
+					return err
+				}
+			}
+			for _, r := range results {
+				fmt.Printf("%s  +%d tx (+%g xp)  total_xp=%g level=%d  +%d sticker(s)\n",
+					r.UserID, r.TransactionsAdded, r.XPAdded, r.TotalXP, r.Level, r.StickersAdded)
+			}
+			fmt.Printf("%d user(s) rebuilt\n", len(results))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&userID, "user", "", "rebuild one user (default: all users)")
+
+	return cmd
+}
@@ -0,0 +1,93 @@
+package entitlements
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// DeviceIDHeader identifies guest devices (app sends it on every request)
+const DeviceIDHeader = "X-Device-ID"
+
+// PremiumProductIDs are the subscriptions that unlock premium chapters
+var PremiumProductIDs = []string{
+	"com.hbstore.koreankids.monthly",
+	"com.hbstore.koreankids.threemonth",
+	"com.hbstore.koreankids.yearly",
+}
+
+// DateLayout is the format of iap_verifications.expires_at
+const DateLayout = "2006-01-02 15:04:05.000Z"
+
+// Entitlement is one active premium product
+type Entitlement struct {
+	ProductID     string `json:"product_id"`
+	Platform      string `json:"platform"`
+	TransactionID string `json:"transaction_id"`
+	ExpiresAt     string `json:"expires_at,omitempty"` // empty = no expiry (non-consumable / legacy)
+	Source        string `json:"source"`               // "user" (linked to account) | "device" (guest purchase)
+}
+
+// Active returns the caller's active premium entitlements.
+// Purchases linked to the user account always count; a device ID only unlocks purchases
+// that are not linked to any account yet (guest purchases), so a spoofed X-Device-ID
+// cannot borrow a signed-in subscriber's purchase.
+func Active(app core.App, userID, deviceID string) []Entitlement {
+	col, err := app.FindCollectionByNameOrId("iap_verifications")
+	if err != nil {
+		return nil
+	}
+
+	var records []*core.Record
+	if userID != "" {
+		recs, _ := app.FindRecordsByFilter(col.Id, "user = {:user}", "-expires_at", 0, 0, dbx.Params{"user": userID})
+		records = append(records, recs...)
+	}
+	if deviceID != "" {
+		recs, _ := app.FindRecordsByFilter(col.Id, "device_id = {:device} && user = ''", "-expires_at", 0, 0, dbx.Params{"device": deviceID})
+		records = append(records, recs...)
+	}
+
+	now := time.Now().UTC().Format(DateLayout)
+	seen := map[string]bool{}
+	var result []Entitlement
+	for _, r := range records {
+		productID := r.GetString("product_id")
+		if !isPremiumProduct(productID) || seen[productID] {
+			continue
+		}
+		exp := strings.TrimSpace(r.GetString("expires_at"))
+		if exp != "" && exp <= now {
+			continue // subscription expired
+		}
+		source := "device"
+		if r.GetString("user") != "" {
+			source = "user"
+		}
+		seen[productID] = true
+		result = append(result, Entitlement{
+			ProductID:     productID,
+			Platform:      r.GetString("platform"),
+			TransactionID: r.GetString("transaction_id"),
+			ExpiresAt:     exp,
+			Source:        source,
+		})
+	}
+	return result
+}
+
+// IsPremium reports whether the user (or guest device) has any active premium entitlement
+func IsPremium(app core.App, userID, deviceID string) bool {
+	return len(Active(app, userID, deviceID)) > 0
+}
+
+func isPremiumProduct(productID string) bool {
+	for _, p := range PremiumProductIDs {
+		if p == productID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+package gamification
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Achievement metrics usable in stickers.criteria
+const (
+	MetricStreakDays        = "streak_days"
+	MetricLongestStreak     = "longest_streak"
+	MetricChaptersRead      = "chapters_read"
+	MetricChaptersListened  = "chapters_listened"
+	MetricStoriesCompleted  = "stories_completed"  // optional category
+	MetricCategoryCompleted = "category_completed" // 1 when every published story of category is completed
+	MetricQuizzesPassed     = "quizzes_passed"     // stories with a passed quiz attempt
+	MetricQuizzesMastered   = "quizzes_mastered"   // stories with a perfect quiz attempt
+	MetricListeningMinutes  = "listening_minutes"  // sum of listening_sessions.duration_listened (seconds) / 60
+	MetricReviewsWritten    = "reviews_written"
+	MetricGoalsMet          = "goals_met" // goal_completions rows (reading goals met)
+)
+
+var achievementMetrics = []string{
+	MetricStreakDays, MetricLongestStreak, MetricChaptersRead, MetricChaptersListened,
+	MetricStoriesCompleted, MetricCategoryCompleted, MetricQuizzesPassed, MetricQuizzesMastered,
+	MetricListeningMinutes, MetricReviewsWritten, MetricGoalsMet,
+}
+
+// Criteria is the declarative unlock condition of an achievement sticker (stickers.criteria):
+// {"metric": "longest_streak", "min": 7}, {"metric": "stories_completed", "category": "folktale", "min": 3}
+// or {"all": [...]} (every condition must hold).
+type Criteria struct {
+	Metric   string     `json:"metric,omitempty"`
+	Min      float64    `json:"min,omitempty"`
+	Category string     `json:"category,omitempty"`
+	All      []Criteria `json:"all,omitempty"`
+}
+
+// ParseCriteria decodes and validates stickers.criteria; nil for an empty value
+func ParseCriteria(raw []byte) (*Criteria, error) {
+	if len(raw) == 0 || string(raw) == "null" || string(raw) == `""` {
+		return nil, nil
+	}
+	var c Criteria
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, fmt.Errorf("criteria: %w", err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c Criteria) validate() error {
+	if len(c.All) > 0 {
+		if c.Metric != "" {
+			return fmt.Errorf("criteria: use either metric or all")
+		}
+		for _, sub := range c.All {
+			if err := sub.validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !slices.Contains(achievementMetrics, c.Metric) {
+		return fmt.Errorf("criteria: unknown metric %q (allowed: %v)", c.Metric, achievementMetrics)
+	}
+	if c.Category != "" && !slices.Contains(schema.StoryCategories, c.Category) {
+		return fmt.Errorf("criteria: unknown category %q", c.Category)
+	}
+	if c.Metric == MetricCategoryCompleted && c.Category == "" {
+		return fmt.Errorf("criteria: %s needs a category", c.Metric)
+	}
+	if c.Min <= 0 {
+		return fmt.Errorf("criteria: min must be > 0")
+	}
+	return nil
+}
+
+// met evaluates the criteria with the user's metrics
+func (c Criteria) met(m *userMetrics) (bool, error) {
+	if len(c.All) > 0 {
+		for _, sub := range c.All {
+			ok, err := sub.met(m)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	v, err := m.value(c.Metric, c.Category)
+	if err != nil {
+		return false, err
+	}
+	return v >= c.Min, nil
+}
+
+// EvaluateAchievements unlocks every published sticker with criteria the user now meets.
+// Returns the ids of the stickers unlocked.
+func EvaluateAchievements(app core.App, userID string) ([]string, error) {
+	stickers, err := app.FindRecordsByFilter("stickers", "is_published = true && criteria != null && criteria != ''",
+		"sort_order", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(stickers) == 0 {
+		return nil, nil
+	}
+	owned, err := app.FindRecordsByFilter("user_stickers", "user = {:user}", "", 0, 0, dbx.Params{"user": userID})
+	if err != nil {
+		return nil, err
+	}
+	have := map[string]bool{}
+	for _, us := range owned {
+		have[us.GetString("sticker")] = true
+	}
+
+	m := &userMetrics{app: app, userID: userID, cache: map[string]float64{}}
+	var unlocked []string
+	for _, sticker := range stickers {
+		if have[sticker.Id] {
+			continue
+		}
+		criteria, err := ParseCriteria([]byte(sticker.GetString("criteria")))
+		if err != nil || criteria == nil {
+			continue
+		}
+		ok, err := criteria.met(m)
+		if err != nil {
+			return unlocked, err
+		}
+		if !ok {
+			continue
+		}
+		added, err := grantSticker(app, userID, "id = {:id}", dbx.Params{"id": sticker.Id}, "achievement")
+		if err != nil {
+			return unlocked, err
+		}
+		if added {
+			unlocked = append(unlocked, sticker.Id)
+		}
+	}
+	return unlocked, nil
+}
+
+// userMetrics computes metric values on demand (cached for one evaluation)
+type userMetrics struct {
+	app     core.App
+	userID  string
+	cache   map[string]float64
+	stats   *core.Record
+	stories []string // completed stories, nil until loaded
+}
+
+func (m *userMetrics) value(metric, category string) (float64, error) {
+	key := metric + ":" + category
+	if v, ok := m.cache[key]; ok {
+		return v, nil
+	}
+	v, err := m.compute(metric, category)
+	if err != nil {
+		return 0, err
+	}
+	m.cache[key] = v
+	return v, nil
+}
+
+func (m *userMetrics) compute(metric, category string) (float64, error) {
+	switch metric {
+	case MetricStreakDays, MetricLongestStreak, MetricChaptersRead, MetricChaptersListened, MetricQuizzesPassed:
+		if m.stats == nil {
+			stats, err := m.app.FindFirstRecordByFilter("user_stats", "user = {:user}", dbx.Params{"user": m.userID})
+			if err != nil {
+				return 0, nil
+			}
+			m.stats = stats
+		}
+		return m.stats.GetFloat(metric), nil
+	case MetricStoriesCompleted, MetricCategoryCompleted:
+		stories, err := m.completedStories()
+		if err != nil {
+			return 0, err
+		}
+		count := 0
+		completed := map[string]bool{}
+		for _, id := range stories {
+			completed[id] = true
+		}
+		if metric == MetricStoriesCompleted && category == "" {
+			return float64(len(stories)), nil
+		}
+		inCategory, err := m.app.FindRecordsByFilter("stories", "category = {:category} && is_published = true",
+			"", 0, 0, dbx.Params{"category": category})
+		if err != nil {
+			return 0, err
+		}
+		for _, s := range inCategory {
+			if completed[s.Id] {
+				count++
+			}
+		}
+		if metric == MetricCategoryCompleted {
+			if len(inCategory) > 0 && count == len(inCategory) {
+				return 1, nil
+			}
+			return 0, nil
+		}
+		return float64(count), nil
+	case MetricQuizzesMastered:
+		if _, err := m.app.FindCollectionByNameOrId("quiz_attempts"); err != nil {
+			return 0, nil
+		}
+		var n float64
+		err := m.app.DB().Select("COUNT(DISTINCT story)").From("quiz_attempts").
+			Where(dbx.HashExp{"user": m.userID}).
+			AndWhere(dbx.NewExp("total > 0 AND score >= total")).Row(&n)
+		return n, err
+	case MetricListeningMinutes:
+		var seconds float64
+		err := m.app.DB().Select("COALESCE(SUM(duration_listened), 0)").From("listening_sessions").
+			Where(dbx.HashExp{"user": m.userID}).Row(&seconds)
+		return seconds / 60, err
+	case MetricReviewsWritten:
+		n, err := m.app.CountRecords("reviews", dbx.HashExp{"user": m.userID})
+		return float64(n), err
+	case MetricGoalsMet:
+		if _, err := m.app.FindCollectionByNameOrId("goal_completions"); err != nil {
+			return 0, nil
+		}
+		n, err := m.app.CountRecords("goal_completions", dbx.HashExp{"user": m.userID})
+		return float64(n), err
+	}
+	return 0, fmt.Errorf("unknown metric %q", metric)
+}
+
+func (m *userMetrics) completedStories() ([]string, error) {
+	if m.stories != nil {
+		return m.stories, nil
+	}
+	stories, err := CompletedStoryIDs(m.app, m.userID)
+	if err != nil {
+		return nil, err
+	}
+	m.stories = append([]string{}, stories...)
+	return m.stories, nil
+}
@@ -0,0 +1,182 @@
+package gamification
+
+import (
+	"time"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ChapterOutcome is what completing a chapter earns a user
+type ChapterOutcome struct {
+	ChapterID      string       `json:"chapter_id"`
+	StoryID        string       `json:"story_id"`
+	Category       string       `json:"category"`
+	Listened       bool         `json:"listened"`
+	StoryCompleted bool         `json:"story_completed"`
+	Awards         []Award      `json:"awards"` // only new awards: a chapter/story is rewarded once
+	XP             float64      `json:"xp"`     // total of awards
+	XPBefore       float64      `json:"xp_before"`
+	XPAfter        float64      `json:"xp_after"`
+	LevelBefore    int          `json:"level_before"`
+	LevelAfter     int          `json:"level_after"`
+	Stats          *core.Record `json:"-"` // user_stats, nil for a new user
+}
+
+// PlanChapterCompleted evaluates the XP rules for userID completing chapterID without
+// saving anything. listened overrides the listening_sessions lookup (dry-run); the chapter
+// itself counts as completed for the story completion check.
+func PlanChapterCompleted(app core.App, userID, chapterID string, listened *bool) (*ChapterOutcome, error) {
+	chapter, err := app.FindRecordById("chapters", chapterID)
+	if err != nil {
+		return nil, err
+	}
+	out := &ChapterOutcome{ChapterID: chapterID, StoryID: chapter.GetString("story"), Awards: []Award{}}
+	if out.StoryID == "" {
+		return out, nil
+	}
+	if story, err := app.FindRecordById("stories", out.StoryID); err == nil {
+		out.Category = story.GetString("category")
+	}
+
+	if listened != nil {
+		out.Listened = *listened
+	} else {
+		out.Listened = hasListened(app, userID, chapterID)
+	}
+
+	out.Stats, _ = app.FindFirstRecordByFilter("user_stats", "user = {:user}", dbx.Params{"user": userID})
+	var chaptersRead, chaptersListened, storiesCompleted float64
+	if out.Stats != nil {
+		out.XPBefore = out.Stats.GetFloat("total_xp")
+		out.LevelBefore = out.Stats.GetInt("level")
+		chaptersRead = out.Stats.GetFloat("chapters_read")
+		chaptersListened = out.Stats.GetFloat("chapters_listened")
+		storiesCompleted = out.Stats.GetFloat("stories_completed")
+	}
+	if out.LevelBefore < 1 {
+		out.LevelBefore = 1
+	}
+
+	out.StoryCompleted, err = storyCompletedWith(app, userID, chapter)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := LoadRules(app)
+	if err != nil {
+		return nil, err
+	}
+	c := chapterCompletion{
+		ChapterID:      chapterID,
+		StoryID:        out.StoryID,
+		Category:       out.Category,
+		Listened:       out.Listened,
+		StoryCompleted: out.StoryCompleted,
+		At:             time.Now(),
+	}
+	c.FirstRead = chaptersRead == 0
+	c.FirstListen = chaptersListened == 0
+	c.FirstStory = storiesCompleted == 0
+	c.ChapterAwarded, err = awarded(app, userID, ChapterSource(chapterID))
+	if err != nil {
+		return nil, err
+	}
+	c.StoryAwarded, err = awarded(app, userID, StorySource(out.StoryID))
+	if err != nil {
+		return nil, err
+	}
+	out.Awards = append(out.Awards, c.awards(rules)...)
+	out.XP = TotalXP(out.Awards)
+	out.XPAfter = out.XPBefore + out.XP
+
+	levels, err := LoadLevels(app)
+	if err != nil {
+		return nil, err
+	}
+	out.LevelAfter = levels.LevelFor(out.XPAfter)
+	return out, nil
+}
+
+// chapterCompletion is the state a chapter completion is evaluated in (live hook or rebuild)
+type chapterCompletion struct {
+	ChapterID, StoryID, Category string
+	Listened, StoryCompleted     bool
+	FirstRead, FirstListen       bool // no chapter read / listened before
+	FirstStory                   bool // no story completed before
+	ChapterAwarded, StoryAwarded bool // already in the ledger
+	At                           time.Time
+}
+
+// awards: chapter_read or chapter_listened, plus story_completed for the last chapter
+// the story needs
+func (c chapterCompletion) awards(rules []Rule) []Award {
+	var awards []Award
+	if !c.ChapterAwarded {
+		ev := Event{Type: schema.XPEventChapterRead, Category: c.Category, FirstTime: c.FirstRead, At: c.At}
+		if c.Listened {
+			ev = Event{Type: schema.XPEventChapterListened, Category: c.Category, FirstTime: c.FirstListen, At: c.At}
+		}
+		for _, a := range Evaluate(rules, ev) {
+			a.Source, a.ChapterID, a.StoryID = ChapterSource(c.ChapterID), c.ChapterID, c.StoryID
+			awards = append(awards, a)
+		}
+	}
+	if c.StoryCompleted && !c.StoryAwarded {
+		ev := Event{Type: schema.XPEventStoryCompleted, Category: c.Category, FirstTime: c.FirstStory, At: c.At}
+		for _, a := range Evaluate(rules, ev) {
+			a.Source, a.StoryID = StorySource(c.StoryID), c.StoryID
+			awards = append(awards, a)
+		}
+	}
+	return awards
+}
+
+// hasListened: a completed listening_sessions row for this chapter
+func hasListened(app core.App, userID, chapterID string) bool {
+	sessions, _ := app.FindRecordsByFilter("listening_sessions",
+		"user = {:user} && chapter = {:chapter} && completed = true", "-created", 1, 0,
+		dbx.Params{"user": userID, "chapter": chapterID})
+	return len(sessions) > 0
+}
+
+// storyCompletedWith: the story is not completed yet and the user has completed all of
+// its chapters (see StoryChapterIDs), counting chapter as completed
+func storyCompletedWith(app core.App, userID string, chapter *core.Record) (bool, error) {
+	storyID := chapter.GetString("story")
+	if storyCompleted(app, userID, storyID) {
+		return false, nil
+	}
+	counts, err := StoryProgressCounts(app, userID, storyID)
+	if err != nil {
+		return false, err
+	}
+	done, err := app.CountRecords("reading_progress", dbx.HashExp{"user": userID, "chapter": chapter.Id, "is_completed": true})
+	if err != nil {
+		return false, err
+	}
+	if done == 0 {
+		counts.Completed++
+		if chapter.GetBool("is_free") {
+			counts.CompletedFree++
+		}
+	}
+	return counts.Complete(isPremium(app, userID)), nil
+}
+
+// freeChapterIDs returns the free chapters of a story (they define story completion for
+// users without premium)
+func freeChapterIDs(app core.App, storyID string) ([]string, error) {
+	chapters, err := app.FindRecordsByFilter("chapters",
+		"story = {:story} && is_free = true", "chapter_number", 500, 0, dbx.Params{"story": storyID})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(chapters))
+	for _, ch := range chapters {
+		ids = append(ids, ch.Id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,235 @@
+package gamification
+
+import (
+	"log"
+	"time"
+
+	"korean-kids-stories/entitlements"
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// isPremium: premium entitlement of the user account (completion rules, not device purchases)
+func isPremium(app core.App, userID string) bool {
+	return entitlements.IsPremium(app, userID, "")
+}
+
+// StoryChapterIDs returns the chapters to complete to complete the story: every chapter
+// for premium users, the free chapters for the others
+func StoryChapterIDs(app core.App, storyID string, premium bool) ([]string, error) {
+	if !premium {
+		return freeChapterIDs(app, storyID)
+	}
+	chapters, err := app.FindRecordsByFilter("chapters", "story = {:story}", "chapter_number", 500, 0,
+		dbx.Params{"story": storyID})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(chapters))
+	for _, ch := range chapters {
+		ids = append(ids, ch.Id)
+	}
+	return ids, nil
+}
+
+// hasStoryCompletions: story_completions exists (not yet during the older migrations)
+func hasStoryCompletions(app core.App) bool {
+	_, err := app.FindCollectionByNameOrId("story_completions")
+	return err == nil
+}
+
+// storyCompleted reports whether story_completions has the story for the user
+func storyCompleted(app core.App, userID, storyID string) bool {
+	if !hasStoryCompletions(app) {
+		return false
+	}
+	_, err := app.FindFirstRecordByFilter("story_completions", "user = {:user} && story = {:story}",
+		dbx.Params{"user": userID, "story": storyID})
+	return err == nil
+}
+
+// CompletedStoryIDs returns the user's completed stories (story_completions). Before that
+// collection exists: the stories whose free chapters are all completed.
+func CompletedStoryIDs(app core.App, userID string) ([]string, error) {
+	if !hasStoryCompletions(app) {
+		return completableStories(app, userID, false)
+	}
+	records, err := app.FindRecordsByFilter("story_completions", "user = {:user}", "completed_at", 0, 0,
+		dbx.Params{"user": userID})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(records))
+	for _, r := range records {
+		ids = append(ids, r.GetString("story"))
+	}
+	return ids, nil
+}
+
+// CompleteStories adds to story_completions the stories the user has newly completed under
+// their current entitlement, appends the story_completed XP missing from the ledger (note as
+// in RecordAwards) and unlocks the story stickers. Completed stories are never removed, so
+// losing premium can complete stories but never undoes one. Returns the new completions.
+func CompleteStories(app core.App, userID, note string) ([]string, error) {
+	col, err := app.FindCollectionByNameOrId("story_completions")
+	if err != nil {
+		return nil, nil
+	}
+	premium := isPremium(app, userID)
+	candidates, err := completableStories(app, userID, premium)
+	if err != nil || len(candidates) == 0 {
+		return nil, err
+	}
+	completed, err := CompletedStoryIDs(app, userID)
+	if err != nil {
+		return nil, err
+	}
+	isCompleted := map[string]bool{}
+	for _, id := range completed {
+		isCompleted[id] = true
+	}
+	rules, err := LoadRules(app)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []string
+	for _, storyID := range candidates {
+		if isCompleted[storyID] {
+			continue
+		}
+		completedAt, err := lastCompletedAt(app, userID, storyID, premium)
+		if err != nil {
+			return added, err
+		}
+
+		record := core.NewRecord(col)
+		record.Set("user", userID)
+		record.Set("story", storyID)
+		record.Set("completed_at", completedAt)
+		record.Set("premium", premium)
+		if err := app.Save(record); err != nil {
+			return added, err
+		}
+
+		if err := awardStoryCompleted(app, rules, userID, storyID, len(completed)+len(added) == 0, completedAt, note); err != nil {
+			return added, err
+		}
+		if _, err := GrantStorySticker(app, userID, storyID); err != nil {
+			return added, err
+		}
+		added = append(added, storyID)
+	}
+	return added, nil
+}
+
+// awardStoryCompleted appends the story_completed XP of the story if not in the ledger yet
+func awardStoryCompleted(app core.App, rules []Rule, userID, storyID string, firstStory bool, at time.Time, note string) error {
+	rewarded, err := awarded(app, userID, StorySource(storyID))
+	if err != nil || rewarded {
+		return err
+	}
+	category := ""
+	if story, err := app.FindRecordById("stories", storyID); err == nil {
+		category = story.GetString("category")
+	}
+	c := chapterCompletion{StoryID: storyID, Category: category, StoryCompleted: true,
+		FirstStory: firstStory, ChapterAwarded: true, At: at}
+	return RecordAwards(app, userID, c.awards(rules), note)
+}
+
+// RecomputeCompletions applies the user's current entitlement to story completion (after a
+// purchase, a restore or an expiry): new completions earn their XP and stickers, then
+// user_stats and achievements are refreshed. Returns the new completions.
+func RecomputeCompletions(app core.App, userID string) ([]string, error) {
+	var added []string
+	err := app.RunInTransaction(func(txApp core.App) error {
+		var err error
+		if added, err = CompleteStories(txApp, userID, ""); err != nil || len(added) == 0 {
+			return err
+		}
+		if _, _, err := refreshStats(txApp, userID); err != nil {
+			return err
+		}
+		_, err = EvaluateAchievements(txApp, userID)
+		return err
+	})
+	return added, err
+}
+
+// RecomputeExpiredEntitlements runs RecomputeCompletions for the users whose premium
+// purchase expired in [from, to). Returns how many stories were completed.
+func RecomputeExpiredEntitlements(app core.App, from, to time.Time) (int, error) {
+	records, err := app.FindRecordsByFilter("iap_verifications",
+		"user != '' && expires_at >= {:from} && expires_at < {:to}", "", 0, 0,
+		dbx.Params{"from": from.UTC().Format(entitlements.DateLayout), "to": to.UTC().Format(entitlements.DateLayout)})
+	if err != nil {
+		return 0, err
+	}
+	seen := map[string]bool{}
+	completed := 0
+	for _, r := range records {
+		userID := r.GetString("user")
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		added, err := RecomputeCompletions(app, userID)
+		if err != nil {
+			log.Printf("story completions: user %s: %v", userID, err)
+			continue
+		}
+		completed += len(added)
+	}
+	return completed, nil
+}
+
+// BackfillStoryCompletions fills story_completions for every user: stories already
+// rewarded in the ledger (completed under the free-chapter rule) keep their completion,
+// then CompleteStories applies the current rule
+func BackfillStoryCompletions(app core.App) error {
+	col, err := app.FindCollectionByNameOrId("story_completions")
+	if err != nil {
+		return err
+	}
+	rewards, err := app.FindRecordsByFilter("xp_transactions", "event = {:event}", "created", 0, 0,
+		dbx.Params{"event": schema.XPEventStoryCompleted})
+	if err != nil {
+		return err
+	}
+	for _, r := range rewards {
+		userID, storyID := r.GetString("user"), r.GetString("story")
+		if storyID == "" || storyCompleted(app, userID, storyID) {
+			continue
+		}
+		if _, err := app.FindRecordById("stories", storyID); err != nil {
+			continue // story deleted
+		}
+		record := core.NewRecord(col)
+		record.Set("user", userID)
+		record.Set("story", storyID)
+		record.Set("completed_at", r.GetDateTime("created"))
+		if err := app.Save(record); err != nil {
+			return err
+		}
+	}
+
+	users, err := app.FindAllRecords("users")
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		added, err := CompleteStories(app, u.Id, RebuildNote)
+		if err != nil {
+			return err
+		}
+		if len(added) > 0 {
+			if _, _, err := refreshStats(app, u.Id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package gamification
+
+import (
+	"time"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// GoalSource is the xp_transactions.source of a reading goal met in a period
+func GoalSource(goal, periodStart string) string { return "goal:" + goal + ":" + periodStart }
+
+// GoalProgress is the progress of one reading goal in its current period
+type GoalProgress struct {
+	Goal        string  `json:"goal"`
+	Target      float64 `json:"target"`
+	Value       float64 `json:"value"`
+	Met         bool    `json:"met"`
+	PeriodStart string  `json:"period_start"` // the day, or the Monday of the week
+}
+
+// GoalsReport is returned by CheckGoals (GET /api/goals/today)
+type GoalsReport struct {
+	Date      string         `json:"date"`
+	WeekStart string         `json:"week_start"`
+	Timezone  string         `json:"timezone"`
+	Goals     []GoalProgress `json:"goals"`     // goals set (target > 0)
+	NewlyMet  []string       `json:"newly_met"` // goals met by this check
+	XP        float64        `json:"xp"`        // goal_met XP earned by this check
+}
+
+// weekStart returns the Monday of the week of day (a local midnight)
+func weekStart(day time.Time) time.Time {
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// dbTime formats t like the datetime columns (UTC) for range comparisons
+func dbTime(t time.Time) string {
+	dt, _ := types.ParseDateTime(t)
+	return dt.String()
+}
+
+// CheckGoals computes the user's reading goals for the day / week of now (user's timezone):
+// chapters completed (reading_history "complete" rows and finished listening_sessions),
+// minutes read and listened (reading_history "read" durations and listening_sessions), and
+// stories completed in the week (story_completions). A goal met for the first time in its
+// period gets a goal_completions row and the goal_met XP, then stats and achievement
+// stickers are refreshed.
+func CheckGoals(app core.App, userID string, now time.Time) (*GoalsReport, error) {
+	loc := UserLocation(app, userID)
+	local := now.In(loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	week := weekStart(day)
+	report := &GoalsReport{
+		Date:      day.Format(dayLayout),
+		WeekStart: week.Format(dayLayout),
+		Timezone:  loc.String(),
+		Goals:     []GoalProgress{},
+		NewlyMet:  []string{},
+	}
+
+	if _, err := app.FindCollectionByNameOrId("reading_goals"); err != nil {
+		return report, nil
+	}
+	goals, err := app.FindFirstRecordByFilter("reading_goals", "user = {:user}", dbx.Params{"user": userID})
+	if err != nil {
+		return report, nil
+	}
+
+	params := dbx.Params{"user": userID, "from": dbTime(day), "to": dbTime(day.AddDate(0, 0, 1)),
+		"week_from": dbTime(week), "week_to": dbTime(week.AddDate(0, 0, 7))}
+	for _, goal := range schema.ReadingGoalTypes {
+		target := goals.GetFloat(goal)
+		if target <= 0 {
+			continue
+		}
+		p := GoalProgress{Goal: goal, Target: target, PeriodStart: report.Date}
+		switch goal {
+		case schema.GoalChaptersPerDay:
+			err = app.DB().NewQuery(`SELECT COUNT(*) FROM (
+				SELECT chapter FROM reading_history WHERE user = {:user} AND action = 'complete' AND chapter != ''
+					AND created >= {:from} AND created < {:to}
+				UNION
+				SELECT chapter FROM listening_sessions WHERE user = {:user} AND completed = 1
+					AND updated >= {:from} AND updated < {:to})`).Bind(params).Row(&p.Value)
+		case schema.GoalMinutesPerDay:
+			var seconds float64
+			err = app.DB().NewQuery(`SELECT
+				(SELECT COALESCE(SUM(duration_seconds), 0) FROM reading_history WHERE user = {:user} AND action = 'read'
+					AND created >= {:from} AND created < {:to}) +
+				(SELECT COALESCE(SUM(duration_listened), 0) FROM listening_sessions WHERE user = {:user}
+					AND created >= {:from} AND created < {:to})`).Bind(params).Row(&seconds)
+			p.Value = float64(int(seconds / 60))
+		case schema.GoalStoriesPerWeek:
+			p.PeriodStart = report.WeekStart
+			err = app.DB().NewQuery(`SELECT COUNT(*) FROM story_completions WHERE user = {:user}
+				AND completed_at >= {:week_from} AND completed_at < {:week_to}`).Bind(params).Row(&p.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.Goals = append(report.Goals, p)
+	}
+
+	err = app.RunInTransaction(func(txApp core.App) error {
+		col, err := txApp.FindCollectionByNameOrId("goal_completions")
+		if err != nil {
+			return err
+		}
+		for i, p := range report.Goals {
+			_, err := txApp.FindFirstRecordByFilter(col.Id, "user = {:user} && goal = {:goal} && period_start = {:start}",
+				dbx.Params{"user": userID, "goal": p.Goal, "start": p.PeriodStart})
+			if err == nil {
+				report.Goals[i].Met = true
+				continue
+			}
+			if p.Value < p.Target {
+				continue
+			}
+
+			awards, err := planGoalMet(txApp, userID, p.Goal, p.PeriodStart, now)
+			if err != nil {
+				return err
+			}
+			if err := RecordAwards(txApp, userID, awards, ""); err != nil {
+				return err
+			}
+			record := core.NewRecord(col)
+			record.Set("user", userID)
+			record.Set("goal", p.Goal)
+			record.Set("period_start", p.PeriodStart)
+			record.Set("target", p.Target)
+			record.Set("value", p.Value)
+			record.Set("xp_awarded", TotalXP(awards))
+			if err := txApp.Save(record); err != nil {
+				return err
+			}
+			report.Goals[i].Met = true
+			report.NewlyMet = append(report.NewlyMet, p.Goal)
+			report.XP += TotalXP(awards)
+		}
+		if len(report.NewlyMet) == 0 {
+			return nil
+		}
+		if _, _, err := refreshStats(txApp, userID); err != nil {
+			return err
+		}
+		_, err = EvaluateAchievements(txApp, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// planGoalMet returns the goal_met awards of a goal in a period, none when already rewarded
+func planGoalMet(app core.App, userID, goal, periodStart string, at time.Time) ([]Award, error) {
+	done, err := awarded(app, userID, GoalSource(goal, periodStart))
+	if err != nil || done {
+		return nil, err
+	}
+	rules, err := LoadRules(app)
+	if err != nil {
+		return nil, err
+	}
+	metBefore, err := app.CountRecords("goal_completions", dbx.HashExp{"user": userID})
+	if err != nil {
+		return nil, err
+	}
+	return goalAwards(rules, goal, periodStart, metBefore == 0, at), nil
+}
+
+func goalAwards(rules []Rule, goal, periodStart string, firstTime bool, at time.Time) []Award {
+	ev := Event{Type: schema.XPEventGoalMet, FirstTime: firstTime, At: at}
+	var awards []Award
+	for _, a := range Evaluate(rules, ev) {
+		a.Source = GoalSource(goal, periodStart)
+		awards = append(awards, a)
+	}
+	return awards
+}
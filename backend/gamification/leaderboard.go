@@ -0,0 +1,299 @@
+package gamification
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Nickname parts: "<adjective> <animal> <1-99>", e.g. 용감한 호랑이 42
+var (
+	nicknameAdjectives = []string{"용감한", "씩씩한", "똑똑한", "귀여운", "반짝이는", "명랑한",
+		"지혜로운", "날쌘", "다정한", "신나는", "행복한", "부지런한"}
+	nicknameAnimals = []string{"호랑이", "토끼", "곰", "다람쥐", "거북이", "까치",
+		"여우", "고양이", "강아지", "판다", "펭귄", "돌고래"}
+)
+
+// Nickname is the name shown for the user on leaderboards instead of users.name. It is
+// derived from the user id, so it never changes and reveals nothing about the child.
+func Nickname(userID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	n := h.Sum32()
+	adjective := nicknameAdjectives[n%uint32(len(nicknameAdjectives))]
+	n /= uint32(len(nicknameAdjectives))
+	animal := nicknameAnimals[n%uint32(len(nicknameAnimals))]
+	n /= uint32(len(nicknameAnimals))
+	return fmt.Sprintf("%s %s %d", adjective, animal, n%99+1)
+}
+
+// NormalizeClassCode: class codes are compared trimmed and upper-cased
+func NormalizeClassCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// leaderboardLocation: weeks (from Monday) and months start in the default timezone
+func leaderboardLocation() *time.Location {
+	loc, err := time.LoadLocation(DefaultTimezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// LeaderboardPeriod returns the start (leaderboard.period_start) and end of the weekly /
+// monthly period containing t, or of the one before it (previous). all_time has no bounds.
+func LeaderboardPeriod(period string, t time.Time, previous bool) (start, end time.Time) {
+	local := t.In(leaderboardLocation())
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	switch period {
+	case schema.LeaderboardWeekly:
+		start = weekStart(day)
+		if previous {
+			start = start.AddDate(0, 0, -7)
+		}
+		return start, start.AddDate(0, 0, 7)
+	case schema.LeaderboardMonthly:
+		start = time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location())
+		if previous {
+			start = start.AddDate(0, -1, 0)
+		}
+		return start, start.AddDate(0, 1, 0)
+	}
+	return time.Time{}, time.Time{}
+}
+
+// LeaderboardPeriodStart formats leaderboard.period_start (empty for all_time)
+func LeaderboardPeriodStart(period string, t time.Time, previous bool) string {
+	start, _ := LeaderboardPeriod(period, t, previous)
+	if start.IsZero() {
+		return ""
+	}
+	return start.Format(dayLayout)
+}
+
+// leaderboardUser is a ranked user: ledger XP, user_stats, user_preferences and the avatar
+// sticker
+type leaderboardUser struct {
+	UserID     string  `db:"user"`
+	TotalXP    float64 `db:"total_xp"` // SUM(xp_transactions.amount), not user_stats.total_xp
+	Level      int     `db:"level"`
+	StreakDays int     `db:"streak_days"`
+	ClassCode  string  `db:"class_code"`
+	Avatar     string  `db:"avatar_sticker"`
+	classID    string
+}
+
+// leaderboardUsers loads the users shown on leaderboards (not opted out by a parent).
+// Without avatar_sticker, the avatar is the last published sticker the user unlocked.
+func leaderboardUsers(app core.App) ([]*leaderboardUser, error) {
+	var users []*leaderboardUser
+	err := app.DB().NewQuery(`SELECT us.user AS user,
+		COALESCE((SELECT SUM(xt.amount) FROM xp_transactions xt WHERE xt.user = us.user), 0) AS total_xp,
+		us.level AS level,
+		us.streak_days AS streak_days, COALESCE(up.class_code, '') AS class_code,
+		COALESCE(up.avatar_sticker, '') AS avatar_sticker
+	FROM user_stats us LEFT JOIN user_preferences up ON up.user = us.user
+	WHERE COALESCE(up.leaderboard_opt_out, 0) = 0`).All(&users)
+	if err != nil {
+		return nil, err
+	}
+
+	classes, err := app.FindAllRecords("class_groups")
+	if err != nil {
+		return nil, err
+	}
+	classIDs := map[string]string{}
+	for _, c := range classes {
+		classIDs[NormalizeClassCode(c.GetString("code"))] = c.Id
+	}
+
+	var unlocked []struct {
+		UserID  string `db:"user"`
+		Sticker string `db:"sticker"`
+	}
+	err = app.DB().Select("us.user AS user", "us.sticker AS sticker").From("user_stickers us").
+		InnerJoin("stickers s", dbx.NewExp("s.id = us.sticker")).
+		Where(dbx.HashExp{"s.is_published": true}).OrderBy("us.created").All(&unlocked)
+	if err != nil {
+		return nil, err
+	}
+	lastSticker := map[string]string{}
+	for _, u := range unlocked {
+		lastSticker[u.UserID] = u.Sticker
+	}
+
+	for _, u := range users {
+		if code := NormalizeClassCode(u.ClassCode); code != "" {
+			u.classID = classIDs[code]
+		}
+		if u.Avatar == "" {
+			u.Avatar = lastSticker[u.UserID]
+		}
+	}
+	return users, nil
+}
+
+// periodXP sums the xp_transactions of each user whose event happened in [start, end)
+// (occurred_at: rebuilt awards count in the period of the event, not of the rebuild)
+func periodXP(app core.App, start, end time.Time) (map[string]float64, error) {
+	var rows []struct {
+		UserID string  `db:"user"`
+		XP     float64 `db:"xp"`
+	}
+	err := app.DB().Select("user", "COALESCE(SUM(amount), 0) AS xp").From("xp_transactions").
+		Where(dbx.NewExp("occurred_at >= {:from} AND occurred_at < {:to}", dbx.Params{"from": dbTime(start), "to": dbTime(end)})).
+		GroupBy("user").All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	xp := make(map[string]float64, len(rows))
+	for _, r := range rows {
+		xp[r.UserID] = r.XP
+	}
+	return xp, nil
+}
+
+// leaderboardRow is one ranked user of a board
+type leaderboardRow struct {
+	user *leaderboardUser
+	xp   float64
+	rank int
+}
+
+// rankBoard sorts users with XP by XP (then level, then nickname) and ranks them; equal
+// XP share a rank (1, 2, 2, 4)
+func rankBoard(users []*leaderboardUser, xp func(*leaderboardUser) float64) []leaderboardRow {
+	var rows []leaderboardRow
+	for _, u := range users {
+		if v := xp(u); v > 0 {
+			rows = append(rows, leaderboardRow{user: u, xp: v})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].xp != rows[j].xp {
+			return rows[i].xp > rows[j].xp
+		}
+		if rows[i].user.Level != rows[j].user.Level {
+			return rows[i].user.Level > rows[j].user.Level
+		}
+		return Nickname(rows[i].user.UserID) < Nickname(rows[j].user.UserID)
+	})
+	for i := range rows {
+		rows[i].rank = i + 1
+		if i > 0 && rows[i].xp == rows[i-1].xp {
+			rows[i].rank = rows[i-1].rank
+		}
+	}
+	return rows
+}
+
+// RefreshLeaderboards recomputes the weekly and monthly boards (current and previous period)
+// and the all-time boards, global and per class, and removes older periods. Weekly and
+// monthly rank the XP of the events of the period, all-time the whole xp_transactions ledger.
+// Returns the number of rows written.
+func RefreshLeaderboards(app core.App, now time.Time) (int, error) {
+	written := 0
+	err := app.RunInTransaction(func(txApp core.App) error {
+		col, err := txApp.FindCollectionByNameOrId("leaderboard")
+		if err != nil {
+			return err
+		}
+		users, err := leaderboardUsers(txApp)
+		if err != nil {
+			return err
+		}
+		byClass := map[string][]*leaderboardUser{}
+		for _, u := range users {
+			if u.classID != "" {
+				byClass[u.classID] = append(byClass[u.classID], u)
+			}
+		}
+
+		type board struct {
+			period   string
+			previous bool
+		}
+		boards := []board{
+			{schema.LeaderboardWeekly, false}, {schema.LeaderboardWeekly, true},
+			{schema.LeaderboardMonthly, false}, {schema.LeaderboardMonthly, true},
+			{schema.LeaderboardAllTime, false},
+		}
+		for _, b := range boards {
+			periodStart := LeaderboardPeriodStart(b.period, now, b.previous)
+			xp := func(u *leaderboardUser) float64 { return u.TotalXP }
+			if b.period != schema.LeaderboardAllTime {
+				start, end := LeaderboardPeriod(b.period, now, b.previous)
+				earned, err := periodXP(txApp, start, end)
+				if err != nil {
+					return err
+				}
+				xp = func(u *leaderboardUser) float64 { return earned[u.UserID] }
+			}
+
+			_, err := txApp.DB().Delete("leaderboard",
+				dbx.HashExp{"period": b.period, "period_start": periodStart}).Execute()
+			if err != nil {
+				return err
+			}
+
+			save := func(scope, classID string, rows []leaderboardRow) error {
+				for _, row := range rows {
+					record := core.NewRecord(col)
+					record.Set("period", b.period)
+					record.Set("period_start", periodStart)
+					record.Set("scope", scope)
+					record.Set("class_group", classID)
+					record.Set("rank", row.rank)
+					record.Set("user", row.user.UserID)
+					record.Set("nickname", Nickname(row.user.UserID))
+					record.Set("avatar_sticker", row.user.Avatar)
+					record.Set("xp", row.xp)
+					record.Set("level", max(row.user.Level, 1))
+					record.Set("streak_days", row.user.StreakDays)
+					if err := txApp.Save(record); err != nil {
+						return err
+					}
+					written++
+				}
+				return nil
+			}
+			if err := save(schema.LeaderboardGlobal, "", rankBoard(users, xp)); err != nil {
+				return err
+			}
+			for classID, members := range byClass {
+				if err := save(schema.LeaderboardClass, classID, rankBoard(members, xp)); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Keep the current and previous weeks / months only
+		for _, period := range []string{schema.LeaderboardWeekly, schema.LeaderboardMonthly} {
+			_, err := txApp.DB().Delete("leaderboard", dbx.And(dbx.HashExp{"period": period},
+				dbx.NewExp("period_start < {:start}", dbx.Params{"start": LeaderboardPeriodStart(period, now, true)}))).Execute()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return written, err
+}
+
+// RemoveFromLeaderboards deletes the user's leaderboard rows (parent opt-out); the ranks of
+// the others are updated by the next refresh
+func RemoveFromLeaderboards(app core.App, userID string) error {
+	if _, err := app.FindCachedCollectionByNameOrId("leaderboard"); err != nil {
+		return nil
+	}
+	_, err := app.DB().Delete("leaderboard", dbx.HashExp{"user": userID}).Execute()
+	return err
+}
@@ -0,0 +1,70 @@
+package gamification_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"korean-kids-stories/gamification"
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestLeaderboardPeriodsUseEventTime(t *testing.T) {
+	app := newTestApp(t)
+	now := time.Now()
+	chapters := seedStory(t, app, 2)
+
+	// backfilled: progress from 100 days ago, rewarded by a rebuild today
+	veteran := saveTestRecord(t, app, "users", map[string]any{"email": "veteran@example.com", "password": "Passw0rd123"})
+	completeChapterAt(t, app, veteran.Id, chapters[0], now.AddDate(0, 0, -101))
+	completeChapterAt(t, app, veteran.Id, chapters[1], now.AddDate(0, 0, -100))
+	// earned live this week
+	newcomer := saveTestRecord(t, app, "users", map[string]any{"email": "newcomer@example.com", "password": "Passw0rd123"})
+	err := gamification.RecordAwards(app, newcomer.Id, []gamification.Award{
+		{Key: "chapter_read", Event: schema.XPEventChapterRead, Amount: 10, Source: gamification.ChapterSource(chapters[0]), ChapterID: chapters[0], At: now},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{veteran.Id, newcomer.Id} {
+		if _, err := gamification.RebuildUser(app, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := gamification.RefreshLeaderboards(app, now); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		period   string
+		previous bool
+		want     []string
+	}{
+		{schema.LeaderboardWeekly, false, []string{newcomer.Id}},
+		{schema.LeaderboardWeekly, true, nil},
+		{schema.LeaderboardMonthly, false, []string{newcomer.Id}},
+		{schema.LeaderboardMonthly, true, nil},
+		{schema.LeaderboardAllTime, false, []string{veteran.Id, newcomer.Id}},
+	}
+	for _, tt := range tests {
+		var rows []*core.Record
+		err := app.RecordQuery("leaderboard").Where(dbx.HashExp{
+			"period":       tt.period,
+			"period_start": gamification.LeaderboardPeriodStart(tt.period, now, tt.previous),
+			"scope":        schema.LeaderboardGlobal,
+		}).OrderBy("rank").All(&rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range rows {
+			got = append(got, r.GetString("user"))
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Fatalf("%s (previous=%v) = %v, want %v", tt.period, tt.previous, got, tt.want)
+		}
+	}
+}
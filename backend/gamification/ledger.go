@@ -0,0 +1,140 @@
+package gamification
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ChapterSource is the xp_transactions.source of a chapter completion
+func ChapterSource(chapterID string) string { return "chapter:" + chapterID }
+
+// StorySource is the xp_transactions.source of a story completion
+func StorySource(storyID string) string { return "story:" + storyID }
+
+// awarded reports whether the ledger already rewards source for the user
+func awarded(app core.App, userID, source string) (bool, error) {
+	if _, err := app.FindCollectionByNameOrId("xp_transactions"); err != nil {
+		return false, nil
+	}
+	_, err := app.FindFirstRecordByFilter("xp_transactions", "user = {:user} && source = {:source}",
+		dbx.Params{"user": userID, "source": source})
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// RecordAwards appends one xp_transactions row per award; occurred_at is the time of the
+// award's event (now when unknown), so replayed awards keep their place in time
+func RecordAwards(app core.App, userID string, awards []Award, note string) error {
+	if len(awards) == 0 {
+		return nil
+	}
+	col, err := app.FindCollectionByNameOrId("xp_transactions")
+	if err != nil {
+		return err
+	}
+	for _, a := range awards {
+		record := core.NewRecord(col)
+		record.Set("user", userID)
+		record.Set("event", a.Event)
+		record.Set("amount", a.Amount)
+		record.Set("rule_key", a.Key)
+		record.Set("source", a.Source)
+		record.Set("chapter", a.ChapterID)
+		record.Set("story", a.StoryID)
+		record.Set("note", note)
+		at := a.At
+		if at.IsZero() {
+			at = time.Now()
+		}
+		record.Set("occurred_at", at.UTC())
+		if err := app.Save(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LedgerXP sums the user's xp_transactions
+func LedgerXP(app core.App, userID string) (float64, error) {
+	var total float64
+	err := app.DB().Select("COALESCE(SUM(amount), 0)").From("xp_transactions").
+		Where(dbx.HashExp{"user": userID}).Row(&total)
+	return total, err
+}
+
+// RecomputeStats derives total_xp and level from the ledger and the counters from
+// reading_progress / listening_sessions / story_completions / quiz_attempts. Streak fields
+// are left alone; not saved.
+func RecomputeStats(app core.App, stats *core.Record, levels Levels) error {
+	userID := stats.GetString("user")
+	totalXP, err := LedgerXP(app, userID)
+	if err != nil {
+		return err
+	}
+	completed, err := app.FindRecordsByFilter("reading_progress", "user = {:user} && is_completed = true",
+		"", 0, 0, dbx.Params{"user": userID})
+	if err != nil {
+		return err
+	}
+	sessions, err := app.FindRecordsByFilter("listening_sessions", "user = {:user} && completed = true",
+		"", 0, 0, dbx.Params{"user": userID})
+	if err != nil {
+		return err
+	}
+	heard := map[string]bool{}
+	for _, s := range sessions {
+		heard[s.GetString("chapter")] = true
+	}
+	listened := 0
+	for _, p := range completed {
+		if heard[p.GetString("chapter")] {
+			listened++
+		}
+	}
+	stories, err := CompletedStoryIDs(app, userID)
+	if err != nil {
+		return err
+	}
+	quizzesPassed, err := countPassedQuizzes(app, userID)
+	if err != nil {
+		return err
+	}
+
+	stats.Set("total_xp", totalXP)
+	stats.Set("level", float64(levels.LevelFor(totalXP)))
+	stats.Set("chapters_read", float64(len(completed)))
+	stats.Set("chapters_listened", float64(listened))
+	stats.Set("stories_completed", float64(len(stories)))
+	stats.Set("quizzes_passed", quizzesPassed)
+	return nil
+}
+
+// countPassedQuizzes: stories with a passed quiz_attempts row (0 before quiz_attempts exists)
+func countPassedQuizzes(app core.App, userID string) (float64, error) {
+	if _, err := app.FindCollectionByNameOrId("quiz_attempts"); err != nil {
+		return 0, nil
+	}
+	var n float64
+	err := app.DB().Select("COUNT(DISTINCT story)").From("quiz_attempts").
+		Where(dbx.HashExp{"user": userID, "passed": true}).Row(&n)
+	return n, err
+}
+
+// allDone: every chapter of chapterIDs is in done
+func allDone(chapterIDs []string, done map[string]bool) bool {
+	if len(chapterIDs) == 0 {
+		return false
+	}
+	for _, id := range chapterIDs {
+		if !done[id] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package gamification_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"korean-kids-stories/gamification"
+	"korean-kids-stories/migrations"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	_ "github.com/pocketbase/pocketbase/migrations" // system collections, run by Bootstrap
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// newTestApp bootstraps an app in a temporary directory with every migration applied
+func newTestApp(t *testing.T) core.App {
+	t.Helper()
+	app := core.NewBaseApp(core.BaseAppConfig{DataDir: t.TempDir()})
+	if err := app.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.ResetBootstrapState() })
+	if _, err := migrations.Up(app, 0); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// saveTestRecord inserts a record without validation
+func saveTestRecord(t *testing.T, app core.App, collection string, data map[string]any) *core.Record {
+	t.Helper()
+	col, err := app.FindCollectionByNameOrId(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := core.NewRecord(col)
+	record.Load(data)
+	if err := app.SaveNoValidate(record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+// completeChapterAt stores a completed reading_progress row last updated at
+func completeChapterAt(t *testing.T, app core.App, userID, chapterID string, at time.Time) {
+	t.Helper()
+	progress := saveTestRecord(t, app, "reading_progress", map[string]any{"user": userID, "chapter": chapterID, "percent_read": 100, "is_completed": true})
+	updated, _ := types.ParseDateTime(at)
+	_, err := app.DB().Update("reading_progress", dbx.Params{"updated": updated.String()}, dbx.HashExp{"id": progress.Id}).Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// seedStory saves a story of n free chapters
+func seedStory(t *testing.T, app core.App, n int) []string {
+	t.Helper()
+	story := saveTestRecord(t, app, "stories", map[string]any{"title": "Story", "category": "folktale", "age_min": 3, "age_max": 8, "total_chapters": n})
+	var chapters []string
+	for i := 1; i <= n; i++ {
+		chapters = append(chapters, saveTestRecord(t, app, "chapters", map[string]any{"story": story.Id, "chapter_number": i, "is_free": true}).Id)
+	}
+	return chapters
+}
+
+// occurredAt returns xp_transactions.occurred_at by source for the user
+func occurredAt(t *testing.T, app core.App, userID string) map[string]string {
+	t.Helper()
+	rows, err := app.FindRecordsByFilter("xp_transactions", "user = {:user}", "", 0, 0, dbx.Params{"user": userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := map[string]string{}
+	for _, r := range rows {
+		result[r.GetString("source")] = r.GetDateTime("occurred_at").String()
+	}
+	return result
+}
+
+func TestRebuildKeepsEventTimes(t *testing.T) {
+	app := newTestApp(t)
+	user := saveTestRecord(t, app, "users", map[string]any{"email": "kid@example.com", "password": "Passw0rd123"})
+	chapters := seedStory(t, app, 2)
+	first := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	second := first.Add(48 * time.Hour)
+	completeChapterAt(t, app, user.Id, chapters[0], first)
+	completeChapterAt(t, app, user.Id, chapters[1], second)
+
+	format := func(at time.Time) string {
+		d, _ := types.ParseDateTime(at)
+		return d.String()
+	}
+	want := map[string]string{
+		gamification.ChapterSource(chapters[0]): format(first),
+		gamification.ChapterSource(chapters[1]): format(second),
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := gamification.RebuildUser(app, user.Id); err != nil {
+			t.Fatal(err)
+		}
+		got := occurredAt(t, app, user.Id)
+		for source, at := range want {
+			if got[source] != at {
+				t.Fatalf("rebuild %d: %s occurred_at = %q, want %q", i+1, source, got[source], at)
+			}
+		}
+		// the story was completed by its last chapter
+		var story string
+		for source, at := range got {
+			if strings.HasPrefix(source, "story:") {
+				story = at
+			}
+		}
+		if story != format(second) {
+			t.Fatalf("rebuild %d: story occurred_at = %q, want %q", i+1, story, format(second))
+		}
+	}
+}
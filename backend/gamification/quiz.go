@@ -0,0 +1,142 @@
+package gamification
+
+import (
+	"encoding/json"
+	"time"
+
+	"korean-kids-stories/quiz"
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// QuizSource is the xp_transactions.source of a story quiz pass
+func QuizSource(storyID string) string { return "quiz:" + storyID }
+
+// QuizOutcome is what submitting a story quiz earned
+type QuizOutcome struct {
+	AttemptID string `json:"attempt_id"`
+	*quiz.Grade
+	Awards  []Award `json:"awards"` // only the first passed attempt of a story earns XP
+	XP      float64 `json:"xp"`
+	TotalXP float64 `json:"total_xp"`
+	Level   int     `json:"level"`
+	LevelUp bool    `json:"level_up"`
+}
+
+// SubmitQuiz saves a graded attempt of the story quiz and, for the first passed attempt
+// of the story, records the quiz_passed XP and refreshes user_stats (level stickers too).
+func SubmitQuiz(app core.App, userID, storyID string, answers map[string]json.RawMessage, grade *quiz.Grade) (*QuizOutcome, error) {
+	out := &QuizOutcome{Grade: grade, Awards: []Award{}}
+	err := app.RunInTransaction(func(txApp core.App) error {
+		col, err := txApp.FindCollectionByNameOrId("quiz_attempts")
+		if err != nil {
+			return err
+		}
+
+		if grade.Passed {
+			awards, err := planQuizPassed(txApp, userID, storyID, time.Now())
+			if err != nil {
+				return err
+			}
+			if err := RecordAwards(txApp, userID, awards, ""); err != nil {
+				return err
+			}
+			out.Awards = append(out.Awards, awards...)
+			out.XP = TotalXP(awards)
+		}
+
+		attempt := core.NewRecord(col)
+		attempt.Set("user", userID)
+		attempt.Set("story", storyID)
+		attempt.Set("answers", answers)
+		attempt.Set("results", grade.Results)
+		attempt.Set("score", grade.Score)
+		attempt.Set("total", grade.Total)
+		attempt.Set("passed", grade.Passed)
+		attempt.Set("xp_awarded", out.XP)
+		if err := txApp.Save(attempt); err != nil {
+			return err
+		}
+		out.AttemptID = attempt.Id
+
+		stats, levelBefore, err := refreshStats(txApp, userID)
+		if err != nil {
+			return err
+		}
+		out.TotalXP = stats.GetFloat("total_xp")
+		out.Level = stats.GetInt("level")
+		out.LevelUp = out.Level > levelBefore
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// planQuizPassed returns the quiz_passed awards of the story, none when already rewarded
+func planQuizPassed(app core.App, userID, storyID string, at time.Time) ([]Award, error) {
+	done, err := awarded(app, userID, QuizSource(storyID))
+	if err != nil || done {
+		return nil, err
+	}
+	rules, err := LoadRules(app)
+	if err != nil {
+		return nil, err
+	}
+	category := ""
+	if story, err := app.FindRecordById("stories", storyID); err == nil {
+		category = story.GetString("category")
+	}
+	passedBefore, err := app.CountRecords("quiz_attempts", dbx.HashExp{"user": userID, "passed": true})
+	if err != nil {
+		return nil, err
+	}
+	return quizAwards(rules, storyID, category, passedBefore == 0, at), nil
+}
+
+func quizAwards(rules []Rule, storyID, category string, firstTime bool, at time.Time) []Award {
+	ev := Event{Type: schema.XPEventQuizPassed, Category: category, FirstTime: firstTime, At: at}
+	var awards []Award
+	for _, a := range Evaluate(rules, ev) {
+		a.Source, a.StoryID = QuizSource(storyID), storyID
+		awards = append(awards, a)
+	}
+	return awards
+}
+
+// refreshStats recomputes and saves the user's user_stats (created when missing) and
+// unlocks level stickers on a level up. Returns the stats and the level before.
+func refreshStats(app core.App, userID string) (*core.Record, int, error) {
+	statsCol, err := app.FindCollectionByNameOrId("user_stats")
+	if err != nil {
+		return nil, 0, err
+	}
+	stats, _ := app.FindFirstRecordByFilter(statsCol.Id, "user = {:user}", dbx.Params{"user": userID})
+	newUser := stats == nil
+	if newUser {
+		stats = core.NewRecord(statsCol)
+		stats.Set("user", userID)
+		stats.Set("streak_days", float64(0))
+	}
+	levelBefore := max(stats.GetInt("level"), 1)
+
+	levels, err := LoadLevels(app)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := RecomputeStats(app, stats, levels); err != nil {
+		return nil, 0, err
+	}
+	if err := app.Save(stats); err != nil {
+		return nil, 0, err
+	}
+	if level := stats.GetInt("level"); level > levelBefore || newUser {
+		if _, err := GrantLevelStickers(app, userID, level); err != nil {
+			return nil, 0, err
+		}
+	}
+	return stats, levelBefore, nil
+}
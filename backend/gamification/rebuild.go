@@ -0,0 +1,274 @@
+package gamification
+
+import (
+	"log"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RebuildNote marks xp_transactions rows written by a rebuild (missed by the live hook)
+const RebuildNote = "rebuild"
+
+// RebuildResult is returned for each user by RebuildUser / RebuildAll
+type RebuildResult struct {
+	UserID            string  `json:"user_id"`
+	TransactionsAdded int     `json:"transactions_added"`
+	XPAdded           float64 `json:"xp_added"`
+	TotalXP           float64 `json:"total_xp"`
+	Level             int     `json:"level"`
+	StickersAdded     int     `json:"stickers_added"`
+}
+
+// RebuildUser replays the user's completed reading_progress, passed quiz_attempts and
+// goal_completions (oldest first) against the XP rules, appends the awards missing from the ledger,
+// recomputes user_stats and unlocks missing level/story/achievement stickers. Existing
+// ledger rows and stickers are never removed.
+func RebuildUser(app core.App, userID string) (*RebuildResult, error) {
+	result := &RebuildResult{UserID: userID}
+	err := app.RunInTransaction(func(txApp core.App) error {
+		if err := RebuildStoryProgress(txApp, userID); err != nil {
+			return err
+		}
+		rules, err := LoadRules(txApp)
+		if err != nil {
+			return err
+		}
+		levels, err := LoadLevels(txApp)
+		if err != nil {
+			return err
+		}
+		completed, err := txApp.FindRecordsByFilter("reading_progress", "user = {:user} && is_completed = true",
+			"updated", 0, 0, dbx.Params{"user": userID})
+		if err != nil {
+			return err
+		}
+
+		r := replay{app: txApp, userID: userID, rules: rules, premium: isPremium(txApp, userID), done: map[string]bool{},
+			storyDone: map[string]bool{}, chapters: map[string][]string{}, categories: map[string]string{}}
+		for _, p := range completed {
+			awards, err := r.chapter(p)
+			if err != nil {
+				return err
+			}
+			if err := RecordAwards(txApp, userID, awards, RebuildNote); err != nil {
+				return err
+			}
+			result.TransactionsAdded += len(awards)
+			result.XPAdded += TotalXP(awards)
+		}
+		awards, err := r.quizzes()
+		if err != nil {
+			return err
+		}
+		if err := RecordAwards(txApp, userID, awards, RebuildNote); err != nil {
+			return err
+		}
+		result.TransactionsAdded += len(awards)
+		result.XPAdded += TotalXP(awards)
+		if awards, err = r.goals(); err != nil {
+			return err
+		}
+		if err := RecordAwards(txApp, userID, awards, RebuildNote); err != nil {
+			return err
+		}
+		result.TransactionsAdded += len(awards)
+		result.XPAdded += TotalXP(awards)
+		if _, err := CompleteStories(txApp, userID, RebuildNote); err != nil {
+			return err
+		}
+
+		statsCol, err := txApp.FindCollectionByNameOrId("user_stats")
+		if err != nil {
+			return err
+		}
+		stats, _ := txApp.FindFirstRecordByFilter(statsCol.Id, "user = {:user}", dbx.Params{"user": userID})
+		if stats == nil {
+			stats = core.NewRecord(statsCol)
+			stats.Set("user", userID)
+			stats.Set("streak_days", float64(0))
+		}
+		if err := RecomputeStats(txApp, stats, levels); err != nil {
+			return err
+		}
+		if err := txApp.Save(stats); err != nil {
+			return err
+		}
+		result.TotalXP = stats.GetFloat("total_xp")
+		result.Level = stats.GetInt("level")
+
+		added, err := GrantLevelStickers(txApp, userID, result.Level)
+		if err != nil {
+			return err
+		}
+		result.StickersAdded += added
+		stories, err := CompletedStoryIDs(txApp, userID)
+		if err != nil {
+			return err
+		}
+		for _, storyID := range stories {
+			ok, err := GrantStorySticker(txApp, userID, storyID)
+			if err != nil {
+				return err
+			}
+			if ok {
+				result.StickersAdded++
+			}
+		}
+		unlocked, err := EvaluateAchievements(txApp, userID)
+		if err != nil {
+			return err
+		}
+		result.StickersAdded += len(unlocked)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RebuildAll rebuilds every user; a failing user is logged and skipped
+func RebuildAll(app core.App) ([]*RebuildResult, error) {
+	users, err := app.FindAllRecords("users")
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*RebuildResult, 0, len(users))
+	for _, u := range users {
+		result, err := RebuildUser(app, u.Id)
+		if err != nil {
+			log.Printf("xp rebuild: user %s: %v", u.Id, err)
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// replay is the running state of RebuildUser: counters as they were at each completion
+type replay struct {
+	app         core.App
+	userID      string
+	rules       []Rule
+	premium     bool                // current entitlement (see StoryChapterIDs)
+	done        map[string]bool     // chapters completed so far
+	storyDone   map[string]bool     // stories completed so far
+	chapters    map[string][]string // story -> chapters to complete (cache)
+	categories  map[string]string   // story -> category (cache)
+	read, heard int
+}
+
+// chapter returns the awards of one completion that are not in the ledger yet
+func (r *replay) chapter(progress *core.Record) ([]Award, error) {
+	chapterID := progress.GetString("chapter")
+	chapter, err := r.app.FindRecordById("chapters", chapterID)
+	if err != nil {
+		return nil, nil // chapter deleted
+	}
+	storyID := chapter.GetString("story")
+	if storyID == "" {
+		return nil, nil
+	}
+	required, ok := r.chapters[storyID]
+	if !ok {
+		if required, err = StoryChapterIDs(r.app, storyID, r.premium); err != nil {
+			return nil, err
+		}
+		r.chapters[storyID] = required
+		if story, err := r.app.FindRecordById("stories", storyID); err == nil {
+			r.categories[storyID] = story.GetString("category")
+		}
+	}
+	r.done[chapterID] = true
+
+	c := chapterCompletion{
+		ChapterID:   chapterID,
+		StoryID:     storyID,
+		Category:    r.categories[storyID],
+		Listened:    hasListened(r.app, r.userID, chapterID),
+		FirstRead:   r.read == 0,
+		FirstListen: r.heard == 0,
+		FirstStory:  len(r.storyDone) == 0,
+		At:          progress.GetDateTime("updated").Time(),
+	}
+	c.StoryCompleted = !r.storyDone[storyID] && allDone(required, r.done)
+	if c.ChapterAwarded, err = awarded(r.app, r.userID, ChapterSource(chapterID)); err != nil {
+		return nil, err
+	}
+	if c.StoryAwarded, err = awarded(r.app, r.userID, StorySource(storyID)); err != nil {
+		return nil, err
+	}
+
+	r.read++
+	if c.Listened {
+		r.heard++
+	}
+	if c.StoryCompleted {
+		r.storyDone[storyID] = true
+	}
+	return c.awards(r.rules), nil
+}
+
+// quizzes returns the quiz_passed awards of the first passed attempt of each story that
+// are not in the ledger yet
+func (r *replay) quizzes() ([]Award, error) {
+	if _, err := r.app.FindCollectionByNameOrId("quiz_attempts"); err != nil {
+		return nil, nil
+	}
+	attempts, err := r.app.FindRecordsByFilter("quiz_attempts", "user = {:user} && passed = true",
+		"created", 0, 0, dbx.Params{"user": r.userID})
+	if err != nil {
+		return nil, err
+	}
+	var awards []Award
+	passed := map[string]bool{}
+	for _, attempt := range attempts {
+		storyID := attempt.GetString("story")
+		if passed[storyID] {
+			continue
+		}
+		first := len(passed) == 0
+		passed[storyID] = true
+		done, err := awarded(r.app, r.userID, QuizSource(storyID))
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			continue
+		}
+		category := r.categories[storyID]
+		if category == "" {
+			if story, err := r.app.FindRecordById("stories", storyID); err == nil {
+				category = story.GetString("category")
+			}
+		}
+		awards = append(awards, quizAwards(r.rules, storyID, category, first, attempt.GetDateTime("created").Time())...)
+	}
+	return awards, nil
+}
+
+// goals returns the goal_met awards of the goal_completions that are not in the ledger yet
+func (r *replay) goals() ([]Award, error) {
+	if _, err := r.app.FindCollectionByNameOrId("goal_completions"); err != nil {
+		return nil, nil
+	}
+	completions, err := r.app.FindRecordsByFilter("goal_completions", "user = {:user}",
+		"created", 0, 0, dbx.Params{"user": r.userID})
+	if err != nil {
+		return nil, err
+	}
+	var awards []Award
+	for i, c := range completions {
+		goal, periodStart := c.GetString("goal"), c.GetString("period_start")
+		done, err := awarded(r.app, r.userID, GoalSource(goal, periodStart))
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			continue
+		}
+		awards = append(awards, goalAwards(r.rules, goal, periodStart, i == 0, c.GetDateTime("created").Time())...)
+	}
+	return awards, nil
+}
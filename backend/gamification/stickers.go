@@ -0,0 +1,59 @@
+package gamification
+
+import (
+	"fmt"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// GrantLevelStickers unlocks the level stickers 1..level the user is missing.
+// Returns how many were added.
+func GrantLevelStickers(app core.App, userID string, level int) (int, error) {
+	added := 0
+	for l := 1; l <= level && l <= schema.MaxLevel; l++ {
+		ok, err := grantSticker(app, userID, `type="level" && key={:key}`, dbx.Params{"key": fmt.Sprintf("level_%d", l)}, "level_up")
+		if err != nil {
+			return added, err
+		}
+		if ok {
+			added++
+		}
+	}
+	return added, nil
+}
+
+// GrantStorySticker unlocks the sticker of a completed story (stories.has_sticker)
+func GrantStorySticker(app core.App, userID, storyID string) (bool, error) {
+	story, err := app.FindRecordById("stories", storyID)
+	if err != nil || !story.GetBool("has_sticker") {
+		return false, nil
+	}
+	return grantSticker(app, userID, `type="story" && story={:story}`, dbx.Params{"story": storyID}, "story_complete")
+}
+
+// grantSticker adds the user_stickers row of the sticker matching filter if missing
+func grantSticker(app core.App, userID, filter string, params dbx.Params, source string) (bool, error) {
+	userStickersCol, err := app.FindCollectionByNameOrId("user_stickers")
+	if err != nil {
+		return false, err
+	}
+	sticker, err := app.FindFirstRecordByFilter("stickers", filter, params)
+	if err != nil || sticker == nil {
+		return false, nil // sticker may not exist yet (seed)
+	}
+
+	existing, _ := app.FindRecordsByFilter(userStickersCol.Id, "user = {:user} && sticker = {:sticker}", "", 1, 0,
+		dbx.Params{"user": userID, "sticker": sticker.Id})
+	if len(existing) > 0 {
+		return false, nil
+	}
+
+	us := core.NewRecord(userStickersCol)
+	us.Set("user", userID)
+	us.Set("sticker", sticker.Id)
+	us.Set("unlock_source", source)
+	return true, app.Save(us)
+}
@@ -0,0 +1,224 @@
+package gamification
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// StoryCounts is a story_progress row: the chapters of a story and how many of them the
+// user completed
+type StoryCounts struct {
+	StoryID       string `db:"story" json:"story"`
+	Completed     int    `db:"completed_chapters" json:"completed_chapters"`
+	CompletedFree int    `db:"completed_free_chapters" json:"completed_free_chapters"`
+	Total         int    `db:"total_chapters" json:"total_chapters"`
+	Free          int    `db:"free_chapters" json:"free_chapters"`
+}
+
+// Complete applies the completion rule of StoryChapterIDs
+func (c StoryCounts) Complete(premium bool) bool {
+	if premium {
+		return c.Total > 0 && c.Completed >= c.Total
+	}
+	return c.Free > 0 && c.CompletedFree >= c.Free
+}
+
+// storyCountsQuery aggregates the chapters of stories with the user's completed ones
+// (reading_progress has one row per user and chapter)
+const storyCountsQuery = `SELECT c.story AS story,
+	COUNT(*) AS total_chapters,
+	COALESCE(SUM(c.is_free = 1), 0) AS free_chapters,
+	COALESCE(SUM(rp.id IS NOT NULL), 0) AS completed_chapters,
+	COALESCE(SUM(rp.id IS NOT NULL AND c.is_free = 1), 0) AS completed_free_chapters
+FROM chapters c
+LEFT JOIN reading_progress rp ON rp.chapter = c.id AND rp.user = {:user} AND rp.is_completed = 1
+WHERE `
+
+// QueryStoryCounts computes the counts of one story with a single aggregate query
+func QueryStoryCounts(app core.App, userID, storyID string) (StoryCounts, error) {
+	c := StoryCounts{StoryID: storyID}
+	err := app.DB().NewQuery(storyCountsQuery + "c.story = {:story} GROUP BY c.story").
+		Bind(dbx.Params{"user": userID, "story": storyID}).One(&c)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return c, err
+	}
+	return c, nil
+}
+
+// queryUserStoryCounts computes the counts of every story the user completed a chapter of
+func queryUserStoryCounts(app core.App, userID string) ([]StoryCounts, error) {
+	var rows []StoryCounts
+	err := app.DB().NewQuery(storyCountsQuery + `c.story IN (
+		SELECT c2.story FROM reading_progress rp2 JOIN chapters c2 ON c2.id = rp2.chapter
+		WHERE rp2.user = {:user} AND rp2.is_completed = 1)
+	GROUP BY c.story`).Bind(dbx.Params{"user": userID}).All(&rows)
+	return rows, err
+}
+
+// hasStoryProgress: story_progress exists (not yet during the older migrations)
+func hasStoryProgress(app core.App) bool {
+	_, err := app.FindCachedCollectionByNameOrId("story_progress")
+	return err == nil
+}
+
+// StoryProgressCounts returns the user's counts of a story from story_progress, from the
+// aggregate query when there is no row
+func StoryProgressCounts(app core.App, userID, storyID string) (StoryCounts, error) {
+	if hasStoryProgress(app) {
+		c := StoryCounts{}
+		err := app.DB().Select("story", "completed_chapters", "completed_free_chapters", "total_chapters", "free_chapters").
+			From("story_progress").Where(dbx.HashExp{"user": userID, "story": storyID}).One(&c)
+		if err == nil {
+			return c, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return c, err
+		}
+	}
+	return QueryStoryCounts(app, userID, storyID)
+}
+
+// saveStoryProgress upserts the story_progress row of the counts
+func saveStoryProgress(app core.App, userID string, c StoryCounts) error {
+	col, err := app.FindCollectionByNameOrId("story_progress")
+	if err != nil {
+		return err
+	}
+	record, err := app.FindFirstRecordByFilter(col.Id, "user = {:user} && story = {:story}",
+		dbx.Params{"user": userID, "story": c.StoryID})
+	if err != nil {
+		record = core.NewRecord(col)
+		record.Set("user", userID)
+		record.Set("story", c.StoryID)
+	}
+	record.Set("completed_chapters", c.Completed)
+	record.Set("completed_free_chapters", c.CompletedFree)
+	record.Set("total_chapters", c.Total)
+	record.Set("free_chapters", c.Free)
+	return app.Save(record)
+}
+
+// AdjustStoryProgress counts a chapter the user just completed (delta 1) or no longer has
+// completed (delta -1) in story_progress. The row is created from the aggregate query
+// (which already sees the change) when missing.
+func AdjustStoryProgress(app core.App, userID, chapterID string, delta int) error {
+	if !hasStoryProgress(app) {
+		return nil
+	}
+	chapter, err := app.FindRecordById("chapters", chapterID)
+	if err != nil {
+		return nil // chapter deleted: RefreshStoryProgress of the story
+	}
+	storyID := chapter.GetString("story")
+	if storyID == "" {
+		return nil
+	}
+	freeDelta := 0
+	if chapter.GetBool("is_free") {
+		freeDelta = delta
+	}
+	res, err := app.DB().NewQuery(`UPDATE story_progress SET
+		completed_chapters = MAX(completed_chapters + {:delta}, 0),
+		completed_free_chapters = MAX(completed_free_chapters + {:free}, 0),
+		updated = {:now}
+		WHERE user = {:user} AND story = {:story}`).
+		Bind(dbx.Params{"delta": delta, "free": freeDelta, "now": types.NowDateTime().String(), "user": userID, "story": storyID}).
+		Execute()
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	c, err := QueryStoryCounts(app, userID, storyID)
+	if err != nil {
+		return err
+	}
+	return saveStoryProgress(app, userID, c)
+}
+
+// RefreshStoryProgress recomputes every story_progress row of a story with one UPDATE
+// (after chapters of the story were added, removed or changed is_free). CROSS JOIN keeps
+// sqlite on chapters first, then the (user, chapter) index of reading_progress.
+func RefreshStoryProgress(app core.App, storyID string) error {
+	if !hasStoryProgress(app) || storyID == "" {
+		return nil
+	}
+	_, err := app.DB().NewQuery(`UPDATE story_progress SET
+		total_chapters = (SELECT COUNT(*) FROM chapters c WHERE c.story = story_progress.story),
+		free_chapters = (SELECT COUNT(*) FROM chapters c WHERE c.story = story_progress.story AND c.is_free = 1),
+		completed_chapters = (SELECT COUNT(*) FROM chapters c CROSS JOIN reading_progress rp
+			ON rp.user = story_progress.user AND rp.chapter = c.id
+			WHERE c.story = story_progress.story AND rp.is_completed = 1),
+		completed_free_chapters = (SELECT COUNT(*) FROM chapters c CROSS JOIN reading_progress rp
+			ON rp.user = story_progress.user AND rp.chapter = c.id
+			WHERE c.story = story_progress.story AND rp.is_completed = 1 AND c.is_free = 1),
+		updated = {:now}
+		WHERE story = {:story}`).
+		Bind(dbx.Params{"story": storyID, "now": types.NowDateTime().String()}).Execute()
+	return err
+}
+
+// RebuildStoryProgress recreates the user's story_progress rows from the aggregate query
+func RebuildStoryProgress(app core.App, userID string) error {
+	if !hasStoryProgress(app) {
+		return nil
+	}
+	rows, err := queryUserStoryCounts(app, userID)
+	if err != nil {
+		return err
+	}
+	for _, c := range rows {
+		if err := saveStoryProgress(app, userID, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// completableStories returns the stories the user has all chapters to complete of (see
+// StoryChapterIDs), completed or not in story_completions
+func completableStories(app core.App, userID string, premium bool) ([]string, error) {
+	var rows []StoryCounts
+	var err error
+	if hasStoryProgress(app) {
+		err = app.DB().Select("story", "completed_chapters", "completed_free_chapters", "total_chapters", "free_chapters").
+			From("story_progress").Where(dbx.HashExp{"user": userID}).All(&rows)
+	} else {
+		rows, err = queryUserStoryCounts(app, userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, c := range rows {
+		if c.Complete(premium) {
+			ids = append(ids, c.StoryID)
+		}
+	}
+	return ids, nil
+}
+
+// lastCompletedAt returns when the user completed the last chapter the story needs
+func lastCompletedAt(app core.App, userID, storyID string, premium bool) (time.Time, error) {
+	q := app.DB().Select("MAX(rp.updated)").From("reading_progress rp").
+		InnerJoin("chapters c", dbx.NewExp("c.id = rp.chapter")).
+		Where(dbx.HashExp{"rp.user": userID, "c.story": storyID, "rp.is_completed": true})
+	if !premium {
+		q.AndWhere(dbx.HashExp{"c.is_free": true})
+	}
+	var raw sql.NullString
+	if err := q.Row(&raw); err != nil {
+		return time.Time{}, err
+	}
+	last, err := types.ParseDateTime(raw.String)
+	if err != nil || !raw.Valid {
+		return time.Now(), nil
+	}
+	return last.Time(), nil
+}
@@ -0,0 +1,245 @@
+package gamification_test
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+
+	"korean-kids-stories/gamification"
+	"korean-kids-stories/hooks"
+	"korean-kids-stories/migrations"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Seeded benchmark database: stories x chapters (the first 2 free), users with ~70% of the
+// chapters completed. Run with: go test ./gamification -run '^$' -bench .
+const (
+	benchStories  = 50
+	benchChapters = 40
+	benchUsers    = 10
+)
+
+var (
+	benchOnce sync.Once
+	benchDB   *benchSeed
+	benchErr  error
+)
+
+type benchSeed struct {
+	app      core.App
+	dir      string
+	users    []string
+	chapters []*core.Record
+	rnd      *rand.Rand
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if benchDB != nil {
+		benchDB.app.ResetBootstrapState()
+		os.RemoveAll(benchDB.dir)
+	}
+	os.Exit(code)
+}
+
+// seeded returns the shared benchmark database, seeding it on first use
+func seeded(b *testing.B) *benchSeed {
+	b.Helper()
+	benchOnce.Do(func() {
+		benchDB, benchErr = newBenchSeed(benchStories, benchChapters, benchUsers)
+	})
+	if benchErr != nil {
+		b.Fatal(benchErr)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	return benchDB
+}
+
+// pick returns a random user and chapter
+func (s *benchSeed) pick() (string, *core.Record) {
+	return s.users[s.rnd.Intn(len(s.users))], s.chapters[s.rnd.Intn(len(s.chapters))]
+}
+
+func newBenchSeed(stories, chapters, users int) (*benchSeed, error) {
+	dir, err := os.MkdirTemp("", "kids-bench-")
+	if err != nil {
+		return nil, err
+	}
+	app := core.NewBaseApp(core.BaseAppConfig{DataDir: dir})
+	if err := app.Bootstrap(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	seed := &benchSeed{app: app, dir: dir, rnd: rand.New(rand.NewSource(1))}
+	if _, err := migrations.Up(app, 0); err != nil {
+		return seed, err
+	}
+	return seed, seed.fill(stories, chapters, users)
+}
+
+// fill inserts the records without validation (required fields are not needed here)
+func (seed *benchSeed) fill(stories, chapters, users int) error {
+	return seed.app.RunInTransaction(func(txApp core.App) error {
+		cols := map[string]*core.Collection{}
+		for _, name := range []string{"users", "stories", "chapters", "chapter_audios", "reading_progress"} {
+			col, err := txApp.FindCollectionByNameOrId(name)
+			if err != nil {
+				return err
+			}
+			cols[name] = col
+		}
+		save := func(col string, data map[string]any) (*core.Record, error) {
+			record := core.NewRecord(cols[col])
+			for k, v := range data {
+				record.Set(k, v)
+			}
+			return record, txApp.SaveNoValidate(record)
+		}
+
+		for u := 0; u < users; u++ {
+			user, err := save("users", map[string]any{"email": fmt.Sprintf("bench%d@bench.local", u), "password": "bench-password"})
+			if err != nil {
+				return err
+			}
+			seed.users = append(seed.users, user.Id)
+		}
+		rnd := rand.New(rand.NewSource(1))
+		for s := 0; s < stories; s++ {
+			story, err := save("stories", map[string]any{"title": fmt.Sprintf("Story %d", s), "is_published": true,
+				"category": "folktale", "age_min": 3, "age_max": 8, "total_chapters": chapters})
+			if err != nil {
+				return err
+			}
+			for c := 0; c < chapters; c++ {
+				chapter, err := save("chapters", map[string]any{"story": story.Id, "chapter_number": c + 1, "is_free": c < 2})
+				if err != nil {
+					return err
+				}
+				seed.chapters = append(seed.chapters, chapter)
+				if c == chapters-1 && s%2 == 0 {
+					if _, err := save("chapter_audios", map[string]any{"chapter": chapter.Id}); err != nil {
+						return err
+					}
+				}
+				for _, userID := range seed.users {
+					if rnd.Float64() < 0.7 {
+						data := map[string]any{"user": userID, "chapter": chapter.Id, "percent_read": 100, "is_completed": true}
+						if _, err := save("reading_progress", data); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+		for _, userID := range seed.users {
+			if err := gamification.RebuildStoryProgress(txApp, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// BenchmarkStoryCompletedPerChapter is the former completion check: the free chapters of
+// the story, then one reading_progress query per chapter
+func BenchmarkStoryCompletedPerChapter(b *testing.B) {
+	s := seeded(b)
+	for i := 0; i < b.N; i++ {
+		userID, chapter := s.pick()
+		chapters, err := s.app.FindRecordsByFilter("chapters", "story = {:story} && is_free = true",
+			"chapter_number", 500, 0, dbx.Params{"story": chapter.GetString("story")})
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, ch := range chapters {
+			progs, _ := s.app.FindRecordsByFilter("reading_progress",
+				"user = {:user} && chapter = {:chapter} && is_completed = true", "", 1, 0,
+				dbx.Params{"user": userID, "chapter": ch.Id})
+			if len(progs) == 0 {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkQueryStoryCounts(b *testing.B) {
+	s := seeded(b)
+	for i := 0; i < b.N; i++ {
+		userID, chapter := s.pick()
+		if _, err := gamification.QueryStoryCounts(s.app, userID, chapter.GetString("story")); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStoryProgressCounts(b *testing.B) {
+	s := seeded(b)
+	for i := 0; i < b.N; i++ {
+		userID, chapter := s.pick()
+		if _, err := gamification.StoryProgressCounts(s.app, userID, chapter.GetString("story")); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAdjustStoryProgress(b *testing.B) {
+	s := seeded(b)
+	for i := 0; i < b.N; i++ {
+		userID, chapter := s.pick()
+		if err := gamification.AdjustStoryProgress(s.app, userID, chapter.Id, 1); err != nil {
+			b.Fatal(err)
+		}
+		if err := gamification.AdjustStoryProgress(s.app, userID, chapter.Id, -1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRefreshStoryProgress(b *testing.B) {
+	s := seeded(b)
+	for i := 0; i < b.N; i++ {
+		_, chapter := s.pick()
+		if err := gamification.RefreshStoryProgress(s.app, chapter.GetString("story")); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkHasAudioOrFilter is the former has_audio check: every chapter of the story, then
+// an OR filter of their ids on chapter_audios
+func BenchmarkHasAudioOrFilter(b *testing.B) {
+	s := seeded(b)
+	for i := 0; i < b.N; i++ {
+		_, chapter := s.pick()
+		chapters, err := s.app.FindRecordsByFilter("chapters", "story = {:story}", "chapter_number", 500, 0,
+			dbx.Params{"story": chapter.GetString("story")})
+		if err != nil {
+			b.Fatal(err)
+		}
+		filter := ""
+		for j, ch := range chapters {
+			if j > 0 {
+				filter += " || "
+			}
+			filter += `chapter="` + ch.Id + `"`
+		}
+		if _, err := s.app.FindRecordsByFilter("chapter_audios", filter, "-created", 1, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSyncStoryHasAudio(b *testing.B) {
+	s := seeded(b)
+	for i := 0; i < b.N; i++ {
+		_, chapter := s.pick()
+		if err := hooks.SyncStoryHasAudio(s.app, chapter.Id); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
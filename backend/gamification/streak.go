@@ -0,0 +1,150 @@
+package gamification
+
+import (
+	"log"
+	"time"
+	_ "time/tzdata" // IANA zones without relying on the server's zoneinfo
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	// DefaultTimezone is used when user_preferences.timezone is empty or invalid
+	DefaultTimezone = "Asia/Seoul"
+	// StreakFreezeEvery: one freeze token is earned every N consecutive days
+	StreakFreezeEvery = 7
+	// MaxStreakFreezes is how many unused tokens a user can hold
+	MaxStreakFreezes = 2
+
+	dayLayout = "2006-01-02"
+)
+
+// UserLocation returns the user's timezone (user_preferences.timezone, default Asia/Seoul)
+func UserLocation(app core.App, userID string) *time.Location {
+	name := DefaultTimezone
+	if pref, err := app.FindFirstRecordByFilter("user_preferences", "user = {:user}", dbx.Params{"user": userID}); err == nil {
+		if tz := pref.GetString("timezone"); tz != "" {
+			name = tz
+		}
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc, _ = time.LoadLocation(DefaultTimezone)
+	}
+	return loc
+}
+
+// streak is the streak state of a user_stats record. lastDate (last_streak_date) is the
+// last local day covered by reading or by a freeze token.
+type streak struct {
+	days, longest, freezes int
+	lastDate               string
+}
+
+func loadStreak(stats *core.Record) streak {
+	s := streak{
+		days:     stats.GetInt("streak_days"),
+		longest:  stats.GetInt("longest_streak"),
+		freezes:  stats.GetInt("streak_freezes"),
+		lastDate: stats.GetString("last_streak_date"),
+	}
+	if s.lastDate == "" {
+		s.lastDate = stats.GetString("last_activity_date")
+	}
+	return s
+}
+
+func (s streak) save(stats *core.Record) {
+	stats.Set("streak_days", float64(s.days))
+	stats.Set("longest_streak", float64(s.longest))
+	stats.Set("streak_freezes", float64(s.freezes))
+	stats.Set("last_streak_date", s.lastDate)
+}
+
+// catchUp covers the days missed between lastDate and today (exclusive) with freeze
+// tokens, one per day; when there are not enough tokens the streak is lost and the tokens
+// are kept. Today is not missed yet.
+func (s *streak) catchUp(today time.Time) {
+	if s.days == 0 || s.lastDate == "" {
+		return
+	}
+	last, err := time.ParseInLocation(dayLayout, s.lastDate, today.Location())
+	if err != nil {
+		s.days = 0
+		return
+	}
+	todayStr := today.Format(dayLayout)
+	var missed []string
+	for day := last.AddDate(0, 0, 1); day.Format(dayLayout) < todayStr; day = day.AddDate(0, 0, 1) {
+		missed = append(missed, day.Format(dayLayout))
+		if len(missed) > s.freezes {
+			s.days = 0
+			return
+		}
+	}
+	if len(missed) > 0 {
+		s.freezes -= len(missed)
+		s.lastDate = missed[len(missed)-1]
+	}
+}
+
+// RecordActivity counts today (in the user's timezone) for the streak of stats: extends
+// it, updates longest_streak, earns freeze tokens and sets last_activity_date. Not saved.
+func RecordActivity(app core.App, stats *core.Record, now time.Time) {
+	today := now.In(UserLocation(app, stats.GetString("user")))
+	todayStr := today.Format(dayLayout)
+
+	s := loadStreak(stats)
+	s.catchUp(today)
+	// lastDate after today: the user moved to an earlier timezone, already counted
+	if s.days == 0 || s.lastDate < todayStr {
+		s.days++
+		s.lastDate = todayStr
+		if s.days%StreakFreezeEvery == 0 && s.freezes < MaxStreakFreezes {
+			s.freezes++
+		}
+	}
+	if s.days > s.longest {
+		s.longest = s.days
+	}
+	s.save(stats)
+	stats.Set("last_activity_date", todayStr)
+}
+
+// ExpireStreak applies missed days to stats (freeze tokens or reset) without new activity.
+// Returns whether stats changed. Not saved.
+func ExpireStreak(app core.App, stats *core.Record, now time.Time) bool {
+	before := loadStreak(stats)
+	if before.days == 0 {
+		return false
+	}
+	s := before
+	s.catchUp(now.In(UserLocation(app, stats.GetString("user"))))
+	if s == before {
+		return false
+	}
+	s.save(stats)
+	return true
+}
+
+// ExpireStaleStreaks runs ExpireStreak for every user with an active streak; returns how
+// many user_stats were updated
+func ExpireStaleStreaks(app core.App, now time.Time) (int, error) {
+	records, err := app.FindRecordsByFilter("user_stats", "streak_days > 0", "", 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for _, stats := range records {
+		if !ExpireStreak(app, stats, now) {
+			continue
+		}
+		if err := app.Save(stats); err != nil {
+			log.Printf("streaks: save user_stats %s: %v", stats.Id, err)
+			continue
+		}
+		updated++
+	}
+	return updated, nil
+}
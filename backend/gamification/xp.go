@@ -0,0 +1,157 @@
+package gamification
+
+import (
+	"slices"
+	"time"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Event is something a user did that can earn XP (see schema.XPEventTypes)
+type Event struct {
+	Type      string
+	Category  string // story category, empty when unknown
+	FirstTime bool   // the user's first event of this type
+	At        time.Time
+}
+
+// Rule is one xp_rules row
+type Rule struct {
+	ID            string
+	Key           string
+	Event         string
+	Amount        float64
+	Categories    []string // empty = any category
+	FirstTimeOnly bool
+	StartsAt      time.Time // zero = no start
+	EndsAt        time.Time // zero = no end
+}
+
+// Award is the XP one rule grants for an event (one xp_transactions row)
+type Award struct {
+	RuleID    string    `json:"rule_id,omitempty"` // empty for built-in defaults
+	Key       string    `json:"key"`
+	Event     string    `json:"event"`
+	Amount    float64   `json:"amount"`
+	Source    string    `json:"source"` // see ChapterSource, StorySource
+	ChapterID string    `json:"-"`
+	StoryID   string    `json:"-"`
+	At        time.Time `json:"-"` // when the event happened (xp_transactions.occurred_at)
+}
+
+// Matches reports whether the rule applies to the event
+func (r Rule) Matches(ev Event) bool {
+	if r.Event != ev.Type {
+		return false
+	}
+	if len(r.Categories) > 0 && !slices.Contains(r.Categories, ev.Category) {
+		return false
+	}
+	if r.FirstTimeOnly && !ev.FirstTime {
+		return false
+	}
+	if !r.StartsAt.IsZero() && ev.At.Before(r.StartsAt) {
+		return false
+	}
+	if !r.EndsAt.IsZero() && !ev.At.Before(r.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// LoadRules returns the active xp_rules; the built-in defaults when the collection is missing
+func LoadRules(app core.App) ([]Rule, error) {
+	col, err := app.FindCollectionByNameOrId("xp_rules")
+	if err != nil {
+		return defaultRules(), nil
+	}
+	records, err := app.FindRecordsByFilter(col.Id, "active = true", "key", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]Rule, 0, len(records))
+	for _, r := range records {
+		rules = append(rules, Rule{
+			ID:            r.Id,
+			Key:           r.GetString("key"),
+			Event:         r.GetString("event"),
+			Amount:        r.GetFloat("amount"),
+			Categories:    r.GetStringSlice("categories"),
+			FirstTimeOnly: r.GetBool("first_time_only"),
+			StartsAt:      r.GetDateTime("starts_at").Time(),
+			EndsAt:        r.GetDateTime("ends_at").Time(),
+		})
+	}
+	return rules, nil
+}
+
+func defaultRules() []Rule {
+	rules := make([]Rule, 0, len(schema.DefaultXPRules))
+	for _, d := range schema.DefaultXPRules {
+		rules = append(rules, Rule{Key: d.Key, Event: d.Event, Amount: d.Amount})
+	}
+	return rules
+}
+
+// Evaluate returns the award of every rule matching the event (amounts add up)
+func Evaluate(rules []Rule, ev Event) []Award {
+	var awards []Award
+	for _, r := range rules {
+		if r.Matches(ev) {
+			awards = append(awards, Award{RuleID: r.ID, Key: r.Key, Event: r.Event, Amount: r.Amount, At: ev.At})
+		}
+	}
+	return awards
+}
+
+// TotalXP sums the awards
+func TotalXP(awards []Award) float64 {
+	total := 0.0
+	for _, a := range awards {
+		total += a.Amount
+	}
+	return total
+}
+
+// Levels holds the min XP of each level (index 0 = level 1)
+type Levels []float64
+
+// LoadLevels reads xp_levels; levels missing there keep their default threshold
+func LoadLevels(app core.App) (Levels, error) {
+	levels := make(Levels, schema.MaxLevel)
+	copy(levels, schema.DefaultXPLevelThresholds)
+	col, err := app.FindCollectionByNameOrId("xp_levels")
+	if err != nil {
+		return levels, nil
+	}
+	records, err := app.FindRecordsByFilter(col.Id, "", "level", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if level := r.GetInt("level"); level >= 1 && level <= schema.MaxLevel {
+			levels[level-1] = r.GetFloat("min_xp")
+		}
+	}
+	return levels, nil
+}
+
+// LevelFor returns the highest level whose threshold is reached (at least 1)
+func (l Levels) LevelFor(totalXP float64) int {
+	for i := len(l) - 1; i >= 0; i-- {
+		if totalXP >= l[i] {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// Next returns the threshold of the level after level, or -1 at the max level
+func (l Levels) Next(level int) float64 {
+	if level < 1 || level >= len(l) {
+		return -1
+	}
+	return l[level]
+}
@@ -0,0 +1,37 @@
+package hooks
+
+import (
+	"log"
+
+	"korean-kids-stories/gamification"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterAchievementsHooks evaluates achievement stickers (stickers.criteria) after the
+// events that change their metrics; reading_progress is handled in processChapterCompleted.
+// Also rejects invalid criteria on sticker create/update.
+func RegisterAchievementsHooks(app *pocketbase.PocketBase) {
+	evaluate := func(e *core.RecordEvent) error {
+		if userID := e.Record.GetString("user"); userID != "" {
+			if _, err := gamification.EvaluateAchievements(e.App, userID); err != nil {
+				log.Printf("%s: EvaluateAchievements failed: %v", e.Record.Collection().Name, err)
+			}
+		}
+		return e.Next()
+	}
+	for _, collection := range []string{"listening_sessions", "reviews", "quiz_attempts"} {
+		app.OnRecordAfterCreateSuccess(collection).BindFunc(evaluate)
+	}
+	app.OnRecordAfterUpdateSuccess("listening_sessions").BindFunc(evaluate)
+
+	validate := func(e *core.RecordRequestEvent) error {
+		if _, err := gamification.ParseCriteria([]byte(e.Record.GetString("criteria"))); err != nil {
+			return e.BadRequestError(err.Error(), nil)
+		}
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("stickers").BindFunc(validate)
+	app.OnRecordUpdateRequest("stickers").BindFunc(validate)
+}
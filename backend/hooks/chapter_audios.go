@@ -3,39 +3,98 @@ package hooks
 import (
 	"log"
 
+	"korean-kids-stories/audiometa"
+	"korean-kids-stories/readalong"
+
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/core"
 )
 
-// RegisterChapterAudiosHooks cập nhật stories.has_audio khi chapter_audios thay đổi
+// RegisterChapterAudiosHooks cập nhật stories.has_audio khi chapter_audios thay đổi, đọc
+// metadata của audio_file mới tải lên (ghi đè audio_duration; từ chối file hỏng / sai định
+// dạng), kiểm tra word_timings (cấu trúc, thời gian, khớp với chapters.content) khi tạo / sửa và tính
+// segments (đoạn / câu) từ word_timings
 func RegisterChapterAudiosHooks(app *pocketbase.PocketBase) {
+	// Chạy trước validate: word_timings được kiểm tra với audio_duration thật
+	metadata := func(e *core.RecordRequestEvent) error {
+		files := e.Record.GetUnsavedFiles("audio_file")
+		if len(files) == 0 {
+			return e.Next()
+		}
+		info, err := audiometa.ReadFile(files[0])
+		if err != nil {
+			return e.BadRequestError("audio_file: "+err.Error(), nil)
+		}
+		audiometa.Apply(e.Record, info)
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("chapter_audios").BindFunc(metadata)
+	app.OnRecordUpdateRequest("chapter_audios").BindFunc(metadata)
+
+	validate := func(e *core.RecordRequestEvent) error {
+		if !e.Record.IsNew() {
+			orig := e.Record.Original()
+			changed := false
+			for _, name := range []string{"word_timings", "audio_duration", "chapter"} {
+				if orig.GetString(name) != e.Record.GetString(name) {
+					changed = true
+				}
+			}
+			if !changed {
+				return e.Next()
+			}
+		}
+		chapter, _ := e.App.FindRecordById("chapters", e.Record.GetString("chapter"))
+		timings, report, err := readalong.CheckAudio(e.Record, chapter)
+		if err != nil {
+			return e.BadRequestError(err.Error(), nil)
+		}
+		if report != nil && len(report.Issues) > 0 {
+			return e.BadRequestError(report.Error(), map[string]any{"issues": report.Issues})
+		}
+		if timings != nil {
+			e.Record.Set("word_timings", timings)
+		}
+		readalong.SetSegments(e.Record, chapter, timings)
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("chapter_audios").BindFunc(validate)
+	app.OnRecordUpdateRequest("chapter_audios").BindFunc(validate)
+
+	// Sửa nội dung chương: tính lại segments của các audio
+	app.OnRecordAfterUpdateSuccess("chapters").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.Original().GetString("content") != e.Record.GetString("content") {
+			if err := readalong.RefreshChapterSegments(e.App, e.Record); err != nil {
+				log.Printf("chapters hook: RefreshChapterSegments: %v", err)
+			}
+		}
+		return e.Next()
+	})
+
 	app.OnRecordAfterCreateSuccess("chapter_audios").BindFunc(func(e *core.RecordEvent) error {
-		if err := syncStoryHasAudio(app, e.Record.GetString("chapter")); err != nil {
+		if err := SyncStoryHasAudio(e.App, e.Record.GetString("chapter")); err != nil {
 			log.Printf("chapter_audios hook: %v", err)
 		}
 		return e.Next()
 	})
 
 	app.OnRecordAfterDeleteSuccess("chapter_audios").BindFunc(func(e *core.RecordEvent) error {
-		if err := syncStoryHasAudio(app, e.Record.GetString("chapter")); err != nil {
+		if err := SyncStoryHasAudio(e.App, e.Record.GetString("chapter")); err != nil {
 			log.Printf("chapter_audios hook: %v", err)
 		}
 		return e.Next()
 	})
 }
 
-// syncStoryHasAudio: chapterId -> chapter.story -> đếm chapter_audios của story -> set has_audio
-func syncStoryHasAudio(app core.App, chapterId string) error {
+// SyncStoryHasAudio: chapterId -> chapter.story -> story có chapter_audios không -> set has_audio
+func SyncStoryHasAudio(app core.App, chapterId string) error {
 	if chapterId == "" {
 		return nil
 	}
 
 	return app.RunInTransaction(func(txApp core.App) error {
-		chaptersCollection, err := txApp.FindCollectionByNameOrId("chapters")
-		if err != nil {
-			return err
-		}
-		chapter, err := txApp.FindRecordById(chaptersCollection, chapterId)
+		chapter, err := txApp.FindRecordById("chapters", chapterId)
 		if err != nil {
 			return err
 		}
@@ -44,52 +103,21 @@ func syncStoryHasAudio(app core.App, chapterId string) error {
 			return nil
 		}
 
-		// Lấy tất cả chapter của story
-		safeStoryId := escapeFilter(storyId)
-		chapters, err := txApp.FindRecordsByFilter(
-			chaptersCollection.Id,
-			`story="`+safeStoryId+`"`,
-			"chapter_number",
-			500,
-			0,
-		)
-		if err != nil {
-			return err
-		}
-
-		chapterAudiosCollection, err := txApp.FindCollectionByNameOrId("chapter_audios")
+		// 1 query: có ít nhất 1 audio trong các chapter của story
+		var hasAny bool
+		err = txApp.DB().NewQuery(`SELECT EXISTS(
+			SELECT 1 FROM chapter_audios a JOIN chapters c ON c.id = a.chapter WHERE c.story = {:story})`).
+			Bind(dbx.Params{"story": storyId}).Row(&hasAny)
 		if err != nil {
 			return err
 		}
 
-		hasAny := false
-		if len(chapters) > 0 {
-			// Filter: chapter in (id1, id2, ...) - chỉ cần 1 record để biết có audio
-			var orParts []string
-			for _, ch := range chapters {
-				orParts = append(orParts, `chapter="`+escapeFilter(ch.Id)+`"`)
-			}
-			filter := orParts[0]
-			for i := 1; i < len(orParts); i++ {
-				filter += " || " + orParts[i]
-			}
-			audios, err := txApp.FindRecordsByFilter(
-				chapterAudiosCollection.Id,
-				filter,
-				"-created",
-				1,
-				0,
-			)
-			hasAny = err == nil && len(audios) > 0
-		}
-
-		storiesCollection, err := txApp.FindCollectionByNameOrId("stories")
+		story, err := txApp.FindRecordById("stories", storyId)
 		if err != nil {
 			return err
 		}
-		story, err := txApp.FindRecordById(storiesCollection, storyId)
-		if err != nil {
-			return err
+		if story.GetBool("has_audio") == hasAny {
+			return nil
 		}
 		story.Set("has_audio", hasAny)
 		return txApp.Save(story)
@@ -1,110 +1,364 @@
 package hooks
 
 import (
+	"encoding/json"
+	"html"
+	"net/url"
 	"regexp"
 	"strings"
-	"time"
+	"unicode/utf8"
 
+	"korean-kids-stories/entitlements"
+
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/inflector"
 )
 
-const deviceIDHeader = "X-Device-ID"
-
-var premiumProductIDs = []string{
-	"com.hbstore.koreankids.monthly",
-	"com.hbstore.koreankids.threemonth",
-	"com.hbstore.koreankids.yearly",
-}
+// previewRunes: most text kept in chapters.content for locked chapters
+const previewRunes = 200
 
-// chapterFilterRe extracts chapter="xxx" from filter string
-var chapterFilterRe = regexp.MustCompile(`chapter\s*=\s*"([^"]+)"`)
+var (
+	paragraphRe = regexp.MustCompile(`(?is)<p\b[^>]*>.*?</p>`)
+	htmlTagRe   = regexp.MustCompile(`<[^>]*>`)
+)
 
-// RegisterChaptersPremiumHooks gates chapter_audios for locked chapters (is_free=false)
+// RegisterChaptersPremiumHooks gates locked chapters (is_free=false) and premium narrators
+// (narrators.is_premium) for callers without premium:
+//   - chapters: content is replaced by a short preview (is_locked=true)
+//   - chapter_audios: audios of locked chapters or premium narrators keep their metadata but
+//     lose audio_file, word_timings and segments (is_locked=true)
+//   - chapter_audios view / audio file download: 403
+//   - filter/sort on gated fields (also through relations, back-relations and @collection,
+//     and in realtime subscriptions): 400
+//
+// The gating runs in the enrich hooks, so list, view, expand and realtime responses all get
+// the same treatment.
 func RegisterChaptersPremiumHooks(app *pocketbase.PocketBase) {
-	// Chapter is_free=false + user not premium → no audio
-	app.OnRecordsListRequest("chapter_audios").BindFunc(func(e *core.RecordsListRequestEvent) error {
-		if err := e.Next(); err != nil {
-			return err
+	app.OnRecordEnrich("chapters").BindFunc(func(e *core.RecordEnrichEvent) error {
+		gateChapterRecord(e.Record, isInfoPremium(e.App, e.RequestInfo))
+		return e.Next()
+	})
+	app.OnRecordEnrich("chapter_audios").BindFunc(func(e *core.RecordEnrichEvent) error {
+		locked := len(lockedAudioIDs(e.App, []*core.Record{e.Record})) > 0 && !isInfoPremium(e.App, e.RequestInfo)
+		e.Record.WithCustomData(true)
+		e.Record.Set("is_locked", locked)
+		if locked {
+			e.Record.Set("audio_file", "")
+			e.Record.Set("word_timings", nil)
+			e.Record.Set("segments", nil)
 		}
-		chapterID := extractChapterIDFromFilter(e.Request.URL.Query().Get("filter"))
-		if chapterID == "" {
-			return nil // no chapter filter, leave as-is
+		return e.Next()
+	})
+
+	app.OnRecordsListRequest().BindFunc(func(e *core.RecordsListRequestEvent) error {
+		query := e.Request.URL.Query()
+		for _, expr := range []string{query.Get("filter"), query.Get("sort")} {
+			if field := gatedFieldIn(e.App, e.Collection, expr); field != "" && !isRequestPremium(e.RequestEvent) {
+				return e.BadRequestError("Premium required to filter or sort by "+field+".", nil)
+			}
 		}
-		chapter, err := app.FindRecordById("chapters", chapterID)
-		if err != nil || chapter == nil {
-			return nil
+		return e.Next()
+	})
+	app.OnRealtimeSubscribeRequest().BindFunc(func(e *core.RealtimeSubscribeRequestEvent) error {
+		for _, sub := range e.Subscriptions {
+			collection, filter := subscriptionFilter(e.App, sub)
+			if collection == nil {
+				continue
+			}
+			if field := gatedFieldIn(e.App, collection, filter); field != "" && !isRequestPremium(e.RequestEvent) {
+				return e.BadRequestError("Premium required to filter by "+field+".", nil)
+			}
 		}
-		if chapter.GetBool("is_free") {
-			return nil // free chapter, everyone gets audio
+		return e.Next()
+	})
+
+	app.OnRecordViewRequest("chapter_audios").BindFunc(func(e *core.RecordRequestEvent) error {
+		if e.Record != nil && len(lockedAudioIDs(e.App, []*core.Record{e.Record})) > 0 && !isRequestPremium(e.RequestEvent) {
+			return e.ForbiddenError("Premium required for this audio.", nil)
 		}
-		if !checkDevicePremium(e.App, e.Request.Header.Get(deviceIDHeader)) {
-			e.Records = []*core.Record{}
+		return e.Next()
+	})
+	app.OnFileDownloadRequest("chapter_audios").BindFunc(func(e *core.FileDownloadRequestEvent) error {
+		if e.Record != nil && len(lockedAudioIDs(e.App, []*core.Record{e.Record})) > 0 && !isRequestPremium(e.RequestEvent) {
+			return e.ForbiddenError("Premium required for this audio.", nil)
 		}
-		return nil
+		return e.Next()
 	})
+}
+
+// gateChapterRecord sets is_premium/is_locked and truncates content of a locked chapter
+func gateChapterRecord(r *core.Record, isPremium bool) {
+	locked := !r.GetBool("is_free") && !isPremium
+	r.WithCustomData(true)
+	r.Set("is_premium", isPremium)
+	r.Set("is_locked", locked)
+	if locked {
+		r.Set("content", chapterPreview(r.GetString("content")))
+	}
+}
+
+// gatedFields are the fields that would reveal locked text through filter/sort
+var gatedFields = map[string][]string{
+	"chapters":       {"content"},
+	"chapter_audios": {"word_timings", "segments"},
+}
+
+var (
+	filterTextRe       = regexp.MustCompile(`'(?:\\.|[^'])*'|"(?:\\.|[^"])*"|//[^\n]*`)
+	filterIdentifierRe = regexp.MustCompile(`[@A-Za-z_][\w.:@]*`)
+	viaRe              = regexp.MustCompile(`^(\w+)_via_\w+$`)
+)
+
+// gatedFieldIn returns the first gated field ("chapters.content") referenced by a filter or
+// sort expression of collection, following relations, back-relations and @collection
+func gatedFieldIn(app core.App, collection *core.Collection, expr string) string {
+	if strings.TrimSpace(expr) == "" {
+		return ""
+	}
+	expr = filterTextRe.ReplaceAllString(expr, "")
+	for _, ident := range filterIdentifierRe.FindAllString(expr, -1) {
+		if field := gatedFieldPath(app, collection, ident); field != "" {
+			return field
+		}
+	}
+	return ""
+}
 
-	app.OnRecordsListRequest("chapters").BindFunc(func(e *core.RecordsListRequestEvent) error {
-		if err := e.Next(); err != nil {
-			return err
+// gatedFieldPath resolves one identifier path (e.g. "chapters_via_story.content:lower")
+func gatedFieldPath(app core.App, collection *core.Collection, path string) string {
+	parts := strings.Split(path, ".")
+	if parts[0] == "@collection" && len(parts) > 2 {
+		c, err := app.FindCachedCollectionByNameOrId(stripModifier(parts[1]))
+		if err != nil {
+			return ""
 		}
-		addIsPremiumToRecords(e.App, e.Records, e.Request.Header.Get(deviceIDHeader))
-		return nil
-	})
-	app.OnRecordViewRequest("chapters").BindFunc(func(e *core.RecordRequestEvent) error {
-		if err := e.Next(); err != nil {
-			return err
+		collection, parts = c, parts[2:]
+	} else if strings.HasPrefix(parts[0], "@") {
+		return ""
+	}
+	for i, part := range parts {
+		name := stripModifier(part)
+		if i == len(parts)-1 {
+			for _, f := range gatedFields[collection.Name] {
+				if f == name {
+					return collection.Name + "." + name
+				}
+			}
+			return ""
 		}
-		if e.Record != nil {
-			isPremium := checkDevicePremium(e.App, e.Request.Header.Get(deviceIDHeader))
-			e.Record.Set("is_premium", isPremium)
+		collection = relatedCollection(app, collection, name)
+		if collection == nil {
+			return ""
 		}
+	}
+	return ""
+}
+
+// relatedCollection is the collection a relation field or "x_via_field" back-relation points to
+func relatedCollection(app core.App, collection *core.Collection, name string) *core.Collection {
+	collectionID := ""
+	if f, ok := collection.Fields.GetByName(name).(*core.RelationField); ok {
+		collectionID = f.CollectionId
+	} else if m := viaRe.FindStringSubmatch(name); m != nil {
+		collectionID = m[1]
+	}
+	if collectionID == "" {
 		return nil
-	})
+	}
+	c, err := app.FindCachedCollectionByNameOrId(collectionID)
+	if err != nil {
+		return nil
+	}
+	return c
 }
 
-func addIsPremiumToRecords(app core.App, records []*core.Record, deviceID string) {
-	isPremium := checkDevicePremium(app, deviceID)
-	for _, r := range records {
-		if r != nil {
-			r.Set("is_premium", isPremium)
+// stripModifier drops ":lower", ":each", ":length" and @collection aliases
+func stripModifier(part string) string {
+	if i := strings.IndexByte(part, ':'); i >= 0 {
+		return part[:i]
+	}
+	return part
+}
+
+// subscriptionFilter returns the collection and options.query.filter of a realtime topic such
+// as `chapters/*?options={"query":{"filter":"..."}}`
+func subscriptionFilter(app core.App, sub string) (*core.Collection, string) {
+	u, err := url.Parse(sub)
+	if err != nil {
+		return nil, ""
+	}
+	name, _, _ := strings.Cut(u.Path, "/")
+	collection, err := app.FindCachedCollectionByNameOrId(name)
+	if err != nil {
+		return nil, ""
+	}
+	var options struct {
+		Query map[string]any `json:"query"`
+	}
+	if raw := u.Query().Get("options"); raw != "" {
+		json.Unmarshal([]byte(raw), &options)
+	}
+	filter, _ := options.Query["filter"].(string)
+	return collection, filter
+}
+
+// lockedAudioIDs returns the chapter_audios records that need premium: audios of locked
+// chapters and audios of premium narrators
+func lockedAudioIDs(app core.App, audios []*core.Record) map[string]bool {
+	chapters := lockedChapterIDs(app, audios)
+	narrators := premiumNarratorIDs(app, audios)
+	locked := map[string]bool{}
+	for _, r := range audios {
+		if chapters[r.GetString("chapter")] || narrators[r.GetString("narrator_ref")] {
+			locked[r.Id] = true
 		}
 	}
+	return locked
 }
 
-func extractChapterIDFromFilter(filter string) string {
-	if filter == "" {
-		return ""
+// premiumNarratorIDs returns the is_premium narrators referenced by chapter_audios records
+func premiumNarratorIDs(app core.App, audios []*core.Record) map[string]bool {
+	premium := map[string]bool{}
+	var ids []any
+	seen := map[string]bool{}
+	for _, r := range audios {
+		if id := r.GetString("narrator_ref"); id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
 	}
-	m := chapterFilterRe.FindStringSubmatch(filter)
-	if len(m) < 2 {
-		return ""
+	if len(ids) == 0 {
+		return premium
+	}
+	var rows []struct {
+		Id string `db:"id"`
+	}
+	err := app.DB().Select("id").From("narrators").
+		Where(dbx.In("id", ids...)).
+		AndWhere(dbx.HashExp{"is_premium": true}).
+		All(&rows)
+	if err != nil {
+		// Fail closed: treat every referenced narrator as premium
+		for id := range seen {
+			premium[id] = true
+		}
+		return premium
+	}
+	for _, row := range rows {
+		premium[row.Id] = true
 	}
-	return m[1]
+	return premium
 }
 
-func checkDevicePremium(app core.App, deviceID string) bool {
-	if deviceID == "" {
-		return false
+// lockedChapterIDs returns the is_free=false chapters referenced by chapter_audios records
+func lockedChapterIDs(app core.App, audios []*core.Record) map[string]bool {
+	locked := map[string]bool{}
+	var ids []any
+	seen := map[string]bool{}
+	for _, r := range audios {
+		if id := r.GetString("chapter"); id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return locked
 	}
-	col, err := app.FindCollectionByNameOrId("iap_verifications")
+	var rows []struct {
+		Id string `db:"id"`
+	}
+	err := app.DB().Select("id").From("chapters").
+		Where(dbx.In("id", ids...)).
+		AndWhere(dbx.HashExp{"is_free": false}).
+		All(&rows)
 	if err != nil {
-		return false
+		// Fail closed: treat every referenced chapter as locked
+		for id := range seen {
+			locked[id] = true
+		}
+		return locked
 	}
-	now := time.Now().UTC().Format("2006-01-02 15:04:05.000Z")
-	for _, pid := range premiumProductIDs {
-		r, err := app.FindFirstRecordByFilter(col.Id,
-			`device_id="`+escapeFilter(deviceID)+`" && product_id="`+escapeFilter(pid)+`"`)
-		if err != nil || r == nil {
+	for _, row := range rows {
+		locked[row.Id] = true
+	}
+	return locked
+}
+
+// chapterPreview keeps the start of the chapter HTML: whole paragraphs, then the paragraph
+// that reaches the limit cut at it and ended with "…". The limit is previewRunes of text, or
+// a quarter of the chapter when that is shorter.
+func chapterPreview(content string) string {
+	limit := min(previewRunes, utf8.RuneCountInString(htmlText(content))/4)
+	paragraphs := paragraphRe.FindAllString(content, -1)
+	if len(paragraphs) == 0 {
+		// No <p> blocks: plain text truncation
+		return truncateText(strings.TrimSpace(htmlText(content)), limit)
+	}
+	var b strings.Builder
+	total := 0
+	for _, p := range paragraphs {
+		text := htmlText(p)
+		n := utf8.RuneCountInString(text)
+		if total+n <= limit {
+			b.WriteString(p)
+			total += n
 			continue
 		}
-		exp := strings.TrimSpace(r.GetString("expires_at"))
-		if exp == "" {
-			return true // non-consumable or legacy
-		}
-		if exp > now {
-			return true // subscription still active
+		if rest := limit - total; rest > 0 {
+			b.WriteString("<p>" + truncateText(strings.TrimSpace(text), rest) + "</p>")
 		}
+		break
+	}
+	return b.String()
+}
+
+// htmlText is the unescaped text of an HTML fragment
+func htmlText(s string) string {
+	return html.UnescapeString(htmlTagRe.ReplaceAllString(s, ""))
+}
+
+// truncateText escapes the first n runes of text, ending with "…" when text was cut
+func truncateText(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return html.EscapeString(text)
+	}
+	return html.EscapeString(string(runes[:n])) + "…"
+}
+
+// isInfoPremium is isRequestPremium for enrich events (list, view, expand and realtime)
+func isInfoPremium(app core.App, info *core.RequestInfo) bool {
+	if info == nil {
+		return false
+	}
+	if info.HasSuperuserAuth() {
+		return true
+	}
+	userID := ""
+	if info.Auth != nil && info.Auth.Collection().Name == "users" {
+		userID = info.Auth.Id
+	}
+	return entitlements.IsPremium(app, userID, info.Headers[deviceIDInfoHeader])
+}
+
+// deviceIDInfoHeader is X-Device-ID as stored in core.RequestInfo.Headers
+var deviceIDInfoHeader = inflector.Snakecase(entitlements.DeviceIDHeader)
+
+// isRequestPremium: purchases linked to the signed-in user, or guest purchases of X-Device-ID.
+// Superusers (admin UI) always see full content.
+func isRequestPremium(e *core.RequestEvent) bool {
+	if e.HasSuperuserAuth() {
+		return true
+	}
+	return entitlements.IsPremium(e.App, requestUserID(e), e.Request.Header.Get(entitlements.DeviceIDHeader))
+}
+
+// requestUserID is the signed-in user ("" for guests and superusers)
+func requestUserID(e *core.RequestEvent) string {
+	if e.Auth != nil && e.Auth.Collection().Name == "users" {
+		return e.Auth.Id
 	}
-	return false
+	return ""
 }
@@ -0,0 +1,39 @@
+package hooks
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChapterPreview(t *testing.T) {
+	long := strings.Repeat("가", 1000)
+	short := strings.Repeat("나", 30)
+	manyShort := strings.Repeat("<p>"+short+"</p>", 40) // 1200 runes
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"one long paragraph", "<p>" + long + "</p>", "<p>" + strings.Repeat("가", previewRunes) + "…</p>"},
+		{"many short paragraphs", manyShort, strings.Repeat("<p>"+short+"</p>", 6) + "<p>" + strings.Repeat("나", 20) + "…</p>"},
+		{"paragraph ends at the limit", strings.Repeat("<p>"+strings.Repeat("다", 50)+"</p>", 20), strings.Repeat("<p>"+strings.Repeat("다", 50)+"</p>", 4)},
+		{"short chapter keeps a quarter", "<p>" + strings.Repeat("라", 40) + "</p>", "<p>" + strings.Repeat("라", 10) + "…</p>"},
+		{"formatting of whole paragraphs kept", "<p><b>" + short + "</b></p>" + "<p>" + long + "</p>", "<p><b>" + short + "</b></p><p>" + strings.Repeat("가", previewRunes-30) + "…</p>"},
+		{"entities count as one rune", "<p>" + strings.Repeat("&amp;", 1000) + "</p>", "<p>" + strings.Repeat("&amp;", previewRunes) + "…</p>"},
+		{"plain text", long, strings.Repeat("가", previewRunes) + "…"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chapterPreview(tt.content)
+			if got != tt.want {
+				t.Fatalf("preview = %q\nwant %q", got, tt.want)
+			}
+			if n := utf8.RuneCountInString(htmlText(got)); n > previewRunes+1 {
+				t.Fatalf("preview has %d runes of text", n)
+			}
+		})
+	}
+}
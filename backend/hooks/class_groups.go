@@ -0,0 +1,26 @@
+package hooks
+
+import (
+	"korean-kids-stories/gamification"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// classCodeAlphabet: no 0/O or 1/I, the code is typed by children
+const classCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// RegisterClassGroupsHooks normalizes class_groups.code and generates one when empty
+func RegisterClassGroupsHooks(app *pocketbase.PocketBase) {
+	normalize := func(e *core.RecordRequestEvent) error {
+		code := gamification.NormalizeClassCode(e.Record.GetString("code"))
+		if code == "" {
+			code = security.RandomStringWithAlphabet(6, classCodeAlphabet)
+		}
+		e.Record.Set("code", code)
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("class_groups").BindFunc(normalize)
+	app.OnRecordUpdateRequest("class_groups").BindFunc(normalize)
+}
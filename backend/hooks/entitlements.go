@@ -0,0 +1,29 @@
+package hooks
+
+import (
+	"log"
+
+	"korean-kids-stories/gamification"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterEntitlementsHooks re-applies story completion rules when a user's purchases change.
+// Guest purchases are never linked on sign-in: X-Device-ID is not a secret, so they move to
+// the account only through a store-verified restore (POST /api/entitlements/claim).
+func RegisterEntitlementsHooks(app *pocketbase.PocketBase) {
+	// Verify, restore/claim and store notifications all write iap_verifications
+	recompute := func(e *core.RecordEvent) error {
+		if userID := e.Record.GetString("user"); userID != "" {
+			if added, err := gamification.RecomputeCompletions(e.App, userID); err != nil {
+				log.Printf("entitlements: recompute story completions failed: %v", err)
+			} else if len(added) > 0 {
+				log.Printf("entitlements: %d story completion(s) for user %s", len(added), userID)
+			}
+		}
+		return e.Next()
+	}
+	app.OnRecordAfterCreateSuccess("iap_verifications").BindFunc(recompute)
+	app.OnRecordAfterUpdateSuccess("iap_verifications").BindFunc(recompute)
+}
@@ -14,8 +14,19 @@ func SetupHooks(app *pocketbase.PocketBase) {
 	RegisterReadLaterHooks(app)
 	RegisterReportsHooks(app)
 	RegisterReadingProgressHooks(app)
+	RegisterStoryProgressHooks(app)
 	RegisterChapterAudiosHooks(app)
 	RegisterChaptersPremiumHooks(app)
+	RegisterNarratorsHooks(app)
+	RegisterEntitlementsHooks(app)
+	RegisterPurchaseEventsHooks(app)
+	RegisterXPTransactionsHooks(app)
+	RegisterUserPreferencesHooks(app)
+	RegisterClassGroupsHooks(app)
+	RegisterAchievementsHooks(app)
+	RegisterQuizzesHooks(app)
+	RegisterQuizAttemptsHooks(app)
+	RegisterReadingGoalsHooks(app)
 
 	log.Println("✅ Hooks configured successfully")
 }
@@ -0,0 +1,82 @@
+package hooks
+
+import (
+	"log"
+	"sort"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterNarratorsHooks keeps chapter_audios.narrator (text, older app versions) and
+// narrator_ref (narrators) in sync, and lists the audios of the user's preferred narrator
+// (user_preferences.preferred_narrator) first.
+func RegisterNarratorsHooks(app *pocketbase.PocketBase) {
+	// narrator_ref set: copy its display_name; only narrator text set (upload tools): find the
+	// narrator by name (old values like "Clova Female" are mapped), unknown names are rejected
+	syncNarrator := func(e *core.RecordRequestEvent) error {
+		orig := e.Record.Original()
+		ref, text := e.Record.GetString("narrator_ref"), e.Record.GetString("narrator")
+		switch {
+		case ref != "" && (e.Record.IsNew() || ref != orig.GetString("narrator_ref")):
+			narrator, err := e.App.FindRecordById("narrators", ref)
+			if err != nil {
+				return e.BadRequestError("narrator_ref: narrator not found", nil)
+			}
+			e.Record.Set("narrator", narrator.GetString("display_name"))
+		case text != "" && (e.Record.IsNew() || text != orig.GetString("narrator")):
+			name := schema.NarratorName(text)
+			narrator, err := e.App.FindFirstRecordByFilter("narrators", "display_name = {:name}", dbx.Params{"name": name})
+			if err != nil {
+				return e.BadRequestError("narrator: unknown narrator "+name+" (add it to narrators first)", nil)
+			}
+			e.Record.Set("narrator_ref", narrator.Id)
+			e.Record.Set("narrator", narrator.GetString("display_name"))
+		case ref == "" && text != "" && !e.Record.IsNew() && orig.GetString("narrator_ref") != "":
+			e.Record.Set("narrator", "") // narrator_ref cleared
+		}
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("chapter_audios").BindFunc(syncNarrator)
+	app.OnRecordUpdateRequest("chapter_audios").BindFunc(syncNarrator)
+
+	// Renamed narrator: update the narrator text of its audios
+	app.OnRecordAfterUpdateSuccess("narrators").BindFunc(func(e *core.RecordEvent) error {
+		name := e.Record.GetString("display_name")
+		if e.Record.Original().GetString("display_name") != name {
+			_, err := e.App.DB().Update("chapter_audios", dbx.Params{"narrator": name},
+				dbx.HashExp{"narrator_ref": e.Record.Id}).Execute()
+			if err != nil {
+				log.Printf("narrators hook: rename %s: %v", e.Record.Id, err)
+			}
+		}
+		return e.Next()
+	})
+
+	app.OnRecordsListRequest("chapter_audios").BindFunc(func(e *core.RecordsListRequestEvent) error {
+		preferred := preferredNarrator(e.App, requestUserID(e.RequestEvent))
+		if preferred == "" || len(e.Records) < 2 {
+			return e.Next()
+		}
+		sort.SliceStable(e.Records, func(i, j int) bool {
+			return e.Records[i].GetString("narrator_ref") == preferred && e.Records[j].GetString("narrator_ref") != preferred
+		})
+		e.Result.Items = e.Records
+		return e.Next()
+	})
+}
+
+// preferredNarrator returns user_preferences.preferred_narrator of the user ("" when unset)
+func preferredNarrator(app core.App, userID string) string {
+	if userID == "" {
+		return ""
+	}
+	pref, err := app.FindFirstRecordByFilter("user_preferences", "user = {:user}", dbx.Params{"user": userID})
+	if err != nil {
+		return ""
+	}
+	return pref.GetString("preferred_narrator")
+}
@@ -0,0 +1,17 @@
+package hooks
+
+import (
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterPurchaseEventsHooks keeps purchase_events append-only through the API
+// (superusers can read and filter flagged=true, but not edit or delete entries)
+func RegisterPurchaseEventsHooks(app *pocketbase.PocketBase) {
+	app.OnRecordUpdateRequest("purchase_events").BindFunc(func(e *core.RecordRequestEvent) error {
+		return e.ForbiddenError("purchase_events is append-only.", nil)
+	})
+	app.OnRecordDeleteRequest("purchase_events").BindFunc(func(e *core.RecordRequestEvent) error {
+		return e.ForbiddenError("purchase_events is append-only.", nil)
+	})
+}
@@ -0,0 +1,14 @@
+package hooks
+
+import (
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterQuizAttemptsHooks keeps quiz_attempts as graded by POST /api/quizzes/{storyId}/submit:
+// rows cannot be edited through the API (their XP is in the append-only ledger)
+func RegisterQuizAttemptsHooks(app *pocketbase.PocketBase) {
+	app.OnRecordUpdateRequest("quiz_attempts").BindFunc(func(e *core.RecordRequestEvent) error {
+		return e.ForbiddenError("quiz_attempts cannot be edited.", nil)
+	})
+}
@@ -0,0 +1,21 @@
+package hooks
+
+import (
+	"korean-kids-stories/quiz"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterQuizzesHooks validates options / option_images / correct_answer against the
+// question_type, so every quiz can be graded by POST /api/quizzes/{storyId}/submit
+func RegisterQuizzesHooks(app *pocketbase.PocketBase) {
+	validate := func(e *core.RecordRequestEvent) error {
+		if err := quiz.Validate(e.Record); err != nil {
+			return e.BadRequestError(err.Error(), nil)
+		}
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("quizzes").BindFunc(validate)
+	app.OnRecordUpdateRequest("quizzes").BindFunc(validate)
+}
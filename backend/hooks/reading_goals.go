@@ -0,0 +1,30 @@
+package hooks
+
+import (
+	"log"
+	"time"
+
+	"korean-kids-stories/gamification"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterReadingGoalsHooks checks the user's reading goals (goal_met XP and stickers) after
+// the records their progress is computed from change, and after the goals are set
+func RegisterReadingGoalsHooks(app *pocketbase.PocketBase) {
+	check := func(e *core.RecordEvent) error {
+		if userID := e.Record.GetString("user"); userID != "" {
+			if _, err := gamification.CheckGoals(e.App, userID, time.Now()); err != nil {
+				log.Printf("%s: CheckGoals failed: %v", e.Record.Collection().Name, err)
+			}
+		}
+		return e.Next()
+	}
+	for _, collection := range []string{"reading_history", "listening_sessions", "story_completions", "reading_goals"} {
+		app.OnRecordAfterCreateSuccess(collection).BindFunc(check)
+	}
+	for _, collection := range []string{"listening_sessions", "reading_goals"} {
+		app.OnRecordAfterUpdateSuccess(collection).BindFunc(check)
+	}
+}
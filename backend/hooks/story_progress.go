@@ -0,0 +1,38 @@
+package hooks
+
+import (
+	"log"
+
+	"korean-kids-stories/gamification"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterStoryProgressHooks keeps the chapter totals of story_progress in sync with the
+// chapters of each story (reading_progress changes are counted in reading_progress hooks)
+func RegisterStoryProgressHooks(app *pocketbase.PocketBase) {
+	refresh := func(app core.App, storyIDs ...string) {
+		for _, storyID := range uniqueStoryIds(storyIDs...) {
+			if err := gamification.RefreshStoryProgress(app, storyID); err != nil {
+				log.Printf("story_progress: refresh story %s failed: %v", storyID, err)
+			}
+		}
+	}
+
+	app.OnRecordAfterCreateSuccess("chapters").BindFunc(func(e *core.RecordEvent) error {
+		refresh(e.App, e.Record.GetString("story"))
+		return e.Next()
+	})
+	app.OnRecordAfterUpdateSuccess("chapters").BindFunc(func(e *core.RecordEvent) error {
+		orig := e.Record.Original()
+		if orig.GetString("story") != e.Record.GetString("story") || orig.GetBool("is_free") != e.Record.GetBool("is_free") {
+			refresh(e.App, orig.GetString("story"), e.Record.GetString("story"))
+		}
+		return e.Next()
+	})
+	app.OnRecordAfterDeleteSuccess("chapters").BindFunc(func(e *core.RecordEvent) error {
+		refresh(e.App, e.Record.GetString("story"))
+		return e.Next()
+	})
+}
@@ -0,0 +1,55 @@
+package hooks
+
+import (
+	"log"
+	"time"
+
+	"korean-kids-stories/gamification"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterUserPreferencesHooks rejects unknown timezones (streaks are computed in it),
+// unknown class codes and avatar stickers the user has not unlocked; opting out of
+// leaderboards removes the user's rows right away.
+func RegisterUserPreferencesHooks(app *pocketbase.PocketBase) {
+	validate := func(e *core.RecordRequestEvent) error {
+		changed := func(name string) bool {
+			return e.Record.Original().GetString(name) != e.Record.GetString(name)
+		}
+		if tz := e.Record.GetString("timezone"); tz != "" {
+			if _, err := time.LoadLocation(tz); err != nil {
+				return e.BadRequestError("Invalid timezone (IANA name, e.g. Asia/Seoul).", nil)
+			}
+		}
+		if code := gamification.NormalizeClassCode(e.Record.GetString("class_code")); code != "" && changed("class_code") {
+			if _, err := e.App.FindFirstRecordByFilter("class_groups", "code = {:code}", dbx.Params{"code": code}); err != nil {
+				return e.BadRequestError("Unknown class code.", nil)
+			}
+			e.Record.Set("class_code", code)
+		}
+		if sticker := e.Record.GetString("avatar_sticker"); sticker != "" && changed("avatar_sticker") {
+			_, err := e.App.FindFirstRecordByFilter("user_stickers", "user = {:user} && sticker = {:sticker}",
+				dbx.Params{"user": e.Record.GetString("user"), "sticker": sticker})
+			if err != nil {
+				return e.BadRequestError("Avatar must be a sticker the user has unlocked.", nil)
+			}
+		}
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("user_preferences").BindFunc(validate)
+	app.OnRecordUpdateRequest("user_preferences").BindFunc(validate)
+
+	optOut := func(e *core.RecordEvent) error {
+		if e.Record.GetBool("leaderboard_opt_out") {
+			if err := gamification.RemoveFromLeaderboards(e.App, e.Record.GetString("user")); err != nil {
+				log.Printf("user_preferences: RemoveFromLeaderboards failed: %v", err)
+			}
+		}
+		return e.Next()
+	}
+	app.OnRecordAfterCreateSuccess("user_preferences").BindFunc(optOut)
+	app.OnRecordAfterUpdateSuccess("user_preferences").BindFunc(optOut)
+}
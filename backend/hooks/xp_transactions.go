@@ -0,0 +1,17 @@
+package hooks
+
+import (
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterXPTransactionsHooks keeps the xp_transactions ledger append-only through the API
+// (fix a user's XP with the rebuild command/endpoint instead of editing rows)
+func RegisterXPTransactionsHooks(app *pocketbase.PocketBase) {
+	app.OnRecordUpdateRequest("xp_transactions").BindFunc(func(e *core.RecordRequestEvent) error {
+		return e.ForbiddenError("xp_transactions is append-only.", nil)
+	})
+	app.OnRecordDeleteRequest("xp_transactions").BindFunc(func(e *core.RecordRequestEvent) error {
+		return e.ForbiddenError("xp_transactions is append-only.", nil)
+	})
+}
@@ -0,0 +1,217 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Options controls how a StoryDoc is written
+type Options struct {
+	DryRun          bool
+	FreeChapters    int    // chapters 1..N get is_free=true when created
+	DefaultCategory string // dictionary.category when the word table has no Category column
+}
+
+// Change is one planned/applied record change
+type Change struct {
+	Collection string
+	Key        string
+	Action     string   // create | update | unchanged
+	Fields     []string // changed fields (update only)
+}
+
+func (c Change) String() string {
+	switch c.Action {
+	case "create":
+		return fmt.Sprintf("+ %s %s", c.Collection, c.Key)
+	case "update":
+		return fmt.Sprintf("~ %s %s (%s)", c.Collection, c.Key, strings.Join(c.Fields, ", "))
+	default:
+		return fmt.Sprintf("= %s %s", c.Collection, c.Key)
+	}
+}
+
+// errDryRun rolls back the transaction after a dry run
+var errDryRun = errors.New("dry run")
+
+// Import upserts the story, its chapters and dictionary words.
+// Stories are matched by title, chapters by (story, chapter_number), words by word.
+// With DryRun the changes are computed in a transaction that is rolled back.
+func Import(app core.App, doc *StoryDoc, opts Options) ([]Change, error) {
+	if err := schema.ValidateStoryFields(doc.Category, doc.AgeMin, doc.AgeMax); err != nil {
+		return nil, fmt.Errorf("%s: %w", doc.Title, err)
+	}
+	if opts.DefaultCategory == "" {
+		opts.DefaultCategory = "old_korean"
+	}
+	for _, w := range doc.Words {
+		if err := validateDictionaryCategory(categoryOr(w.Category, opts.DefaultCategory)); err != nil {
+			return nil, fmt.Errorf("%s: word %s: %w", doc.Title, w.Word, err)
+		}
+	}
+
+	var changes []Change
+	err := app.RunInTransaction(func(txApp core.App) error {
+		var err error
+		changes, err = upsertStory(txApp, doc, opts)
+		if err != nil {
+			return err
+		}
+		if opts.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func upsertStory(app core.App, doc *StoryDoc, opts Options) ([]Change, error) {
+	var changes []Change
+
+	storiesCol, err := app.FindCollectionByNameOrId("stories")
+	if err != nil {
+		return nil, err
+	}
+	chaptersCol, err := app.FindCollectionByNameOrId("chapters")
+	if err != nil {
+		return nil, err
+	}
+	dictCol, err := app.FindCollectionByNameOrId("dictionary")
+	if err != nil {
+		return nil, err
+	}
+
+	story, _ := app.FindFirstRecordByData(storiesCol.Id, "title", doc.Title)
+	isNew := story == nil
+	if isNew {
+		story = core.NewRecord(storiesCol)
+		story.Set("is_published", false) // admin publishes after review
+	}
+	fields := assign(story, map[string]any{
+		"title":          doc.Title,
+		"category":       doc.Category,
+		"age_min":        doc.AgeMin,
+		"age_max":        doc.AgeMax,
+		"summary":        doc.Summary,
+		"total_chapters": float64(len(doc.Chapters)),
+		"moral_lessons":  doc.MoralLessons,
+		"cultural_notes": doc.CulturalNotes,
+	})
+	changes = append(changes, change("stories", doc.Title, isNew, fields))
+	if isNew || len(fields) > 0 {
+		if err := app.Save(story); err != nil {
+			return nil, fmt.Errorf("save story %s: %w", doc.Title, err)
+		}
+	}
+
+	for _, ch := range doc.Chapters {
+		chapter, _ := app.FindFirstRecordByFilter(chaptersCol.Id,
+			"story = {:story} && chapter_number = {:num}",
+			dbx.Params{"story": story.Id, "num": ch.Number})
+		isNew := chapter == nil
+		if isNew {
+			chapter = core.NewRecord(chaptersCol)
+			chapter.Set("story", story.Id)
+			chapter.Set("is_free", ch.Number <= opts.FreeChapters)
+		}
+		fields := assign(chapter, map[string]any{
+			"chapter_number": float64(ch.Number),
+			"title":          ch.Title,
+			"content":        ch.Content,
+		})
+		changes = append(changes, change("chapters", fmt.Sprintf("%s #%d", doc.Title, ch.Number), isNew, fields))
+		if isNew || len(fields) > 0 {
+			if err := app.Save(chapter); err != nil {
+				return nil, fmt.Errorf("save chapter %d: %w", ch.Number, err)
+			}
+		}
+	}
+
+	for _, w := range doc.Words {
+		word, _ := app.FindFirstRecordByData(dictCol.Id, "word", w.Word)
+		isNew := word == nil
+		if isNew {
+			word = core.NewRecord(dictCol)
+		}
+		values := map[string]any{
+			"word":    w.Word,
+			"reading": w.Reading,
+			"meaning": w.Meaning,
+		}
+		// Keep the admin-assigned category unless the file sets one explicitly
+		if isNew || w.Category != "" {
+			values["category"] = categoryOr(w.Category, opts.DefaultCategory)
+		}
+		fields := assign(word, values)
+		changes = append(changes, change("dictionary", w.Word, isNew, fields))
+		if isNew || len(fields) > 0 {
+			if err := app.Save(word); err != nil {
+				return nil, fmt.Errorf("save word %s: %w", w.Word, err)
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// assign sets values that differ from the record and returns the changed field names
+func assign(record *core.Record, values map[string]any) []string {
+	var changed []string
+	for field, v := range values {
+		same := false
+		switch val := v.(type) {
+		case string:
+			same = record.GetString(field) == val
+		case float64:
+			same = record.GetFloat(field) == val
+		case bool:
+			same = record.GetBool(field) == val
+		}
+		if !same {
+			record.Set(field, v)
+			changed = append(changed, field)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func change(collection, key string, isNew bool, fields []string) Change {
+	c := Change{Collection: collection, Key: key, Fields: fields}
+	switch {
+	case isNew:
+		c.Action = "create"
+		c.Fields = nil
+	case len(fields) > 0:
+		c.Action = "update"
+	default:
+		c.Action = "unchanged"
+	}
+	return c
+}
+
+func categoryOr(category, fallback string) string {
+	if category != "" {
+		return category
+	}
+	return fallback
+}
+
+func validateDictionaryCategory(category string) error {
+	for _, c := range schema.DictionaryCategories {
+		if c == category {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid dictionary category %q (allowed: %v)", category, schema.DictionaryCategories)
+}
@@ -0,0 +1,294 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// StoryDoc is one parsed content/*.md file
+type StoryDoc struct {
+	File          string
+	Title         string // Title (KR)
+	TitleEN       string
+	Category      string
+	AgeMin        float64
+	AgeMax        float64
+	Summary       string
+	Chapters      []ChapterDoc
+	MoralLessons  string // HTML
+	CulturalNotes string // HTML
+	Words         []WordDoc
+}
+
+// ChapterDoc is one "## Chapter N: title" section
+type ChapterDoc struct {
+	Number  int
+	Title   string
+	Content string // HTML for the chapters.content EditorField
+}
+
+// WordDoc is one row of the "Word List for Dictionary" / "Vocabulary" table
+type WordDoc struct {
+	Word     string
+	Reading  string
+	Meaning  string
+	Category string // optional 4th column
+}
+
+var (
+	chapterHeadingRe = regexp.MustCompile(`^##\s+Chapter\s+(\d+)\s*:\s*(.+)$`)
+	ageRangeRe       = regexp.MustCompile(`^(\d+)\s*[-~–]\s*(\d+)$`)
+	boldRe           = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	orderedItemRe    = regexp.MustCompile(`^\d+\.\s+`)
+)
+
+// ParseFile reads and parses a story markdown file
+func ParseFile(path string) (*StoryDoc, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	doc.File = path
+	return doc, nil
+}
+
+// Parse parses the fixed content/*.md layout:
+// Story Metadata table, "## Chapter N" + "### Content (Korean)", Moral Lessons,
+// Cultural Notes and Word List for Dictionary (or Vocabulary).
+func Parse(src string) (*StoryDoc, error) {
+	doc := &StoryDoc{}
+
+	// Split into "## " sections (keep heading line)
+	type section struct {
+		heading string
+		lines   []string
+	}
+	var sections []section
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.HasPrefix(line, "## ") {
+			sections = append(sections, section{heading: line})
+			continue
+		}
+		if len(sections) > 0 {
+			sections[len(sections)-1].lines = append(sections[len(sections)-1].lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, sec := range sections {
+		name := strings.TrimSpace(strings.TrimPrefix(sec.heading, "## "))
+		if m := chapterHeadingRe.FindStringSubmatch(sec.heading); m != nil {
+			num, _ := strconv.Atoi(m[1])
+			doc.Chapters = append(doc.Chapters, ChapterDoc{
+				Number:  num,
+				Title:   chapterTitle(m[2]),
+				Content: blocksToHTML(chapterContentLines(sec.lines)),
+			})
+			continue
+		}
+		switch name {
+		case "Story Metadata":
+			if err := parseMetadata(doc, sec.lines); err != nil {
+				return nil, err
+			}
+		case "Moral Lessons":
+			doc.MoralLessons = blocksToHTML(trimSeparators(sec.lines))
+		case "Cultural Notes":
+			doc.CulturalNotes = blocksToHTML(trimSeparators(sec.lines))
+		case "Word List for Dictionary", "Vocabulary":
+			doc.Words = append(doc.Words, parseWordTable(sec.lines)...)
+		}
+	}
+
+	if doc.Title == "" {
+		return nil, fmt.Errorf("missing Title (KR) in Story Metadata")
+	}
+	if len(doc.Chapters) == 0 {
+		return nil, fmt.Errorf("no chapters found")
+	}
+	for i, ch := range doc.Chapters {
+		if ch.Content == "" {
+			return nil, fmt.Errorf("chapter %d has no Korean content", ch.Number)
+		}
+		if ch.Number != i+1 {
+			return nil, fmt.Errorf("chapter %d out of order (expected %d)", ch.Number, i+1)
+		}
+	}
+	return doc, nil
+}
+
+func parseMetadata(doc *StoryDoc, lines []string) error {
+	for _, cells := range tableRows(lines) {
+		if len(cells) < 2 {
+			continue
+		}
+		key := strings.Trim(cells[0], "* ")
+		val := cells[1]
+		switch key {
+		case "Title (KR)":
+			doc.Title = val
+		case "Title (EN)":
+			doc.TitleEN = val
+		case "Category":
+			doc.Category = strings.ToLower(val)
+		case "Age Range":
+			m := ageRangeRe.FindStringSubmatch(val)
+			if m == nil {
+				return fmt.Errorf("invalid Age Range %q", val)
+			}
+			doc.AgeMin, _ = strconv.ParseFloat(m[1], 64)
+			doc.AgeMax, _ = strconv.ParseFloat(m[2], 64)
+		case "Summary":
+			doc.Summary = val
+		}
+	}
+	return nil
+}
+
+func parseWordTable(lines []string) []WordDoc {
+	var words []WordDoc
+	for _, cells := range tableRows(lines) {
+		if len(cells) < 3 || cells[0] == "" {
+			continue
+		}
+		w := WordDoc{Word: cells[0], Reading: cells[1], Meaning: cells[2]}
+		if len(cells) > 3 {
+			w.Category = strings.ToLower(cells[3])
+		}
+		words = append(words, w)
+	}
+	return words
+}
+
+// tableRows returns body rows of a markdown table (header and --- row skipped)
+func tableRows(lines []string) [][]string {
+	var rows [][]string
+	header := true
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "|") {
+			continue
+		}
+		parts := strings.Split(strings.Trim(line, "|"), "|")
+		cells := make([]string, len(parts))
+		for i, p := range parts {
+			cells[i] = strings.TrimSpace(p)
+		}
+		if header {
+			header = false
+			continue
+		}
+		if strings.Trim(strings.Join(cells, ""), "-: ") == "" {
+			continue // separator row
+		}
+		rows = append(rows, cells)
+	}
+	return rows
+}
+
+// chapterTitle keeps the Korean title: "착한 나무꾼 (The Kind Woodcutter)" -> "착한 나무꾼"
+func chapterTitle(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, " ("); i > 0 && strings.HasSuffix(s, ")") {
+		return strings.TrimSpace(s[:i])
+	}
+	return s
+}
+
+// chapterContentLines returns the lines under "### Content (Korean)"
+func chapterContentLines(lines []string) []string {
+	var out []string
+	in := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "### ") {
+			in = strings.HasPrefix(line, "### Content (Korean)")
+			continue
+		}
+		if in {
+			out = append(out, line)
+		}
+	}
+	return trimSeparators(out)
+}
+
+// trimSeparators drops "---" horizontal rules
+func trimSeparators(lines []string) []string {
+	var out []string
+	for _, line := range lines {
+		t := strings.TrimSpace(line)
+		if t == "---" {
+			continue
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+// blocksToHTML converts paragraphs and -/1. lists into simple HTML
+func blocksToHTML(lines []string) string {
+	var b strings.Builder
+	listTag := ""
+	var para []string
+
+	flushPara := func() {
+		if len(para) > 0 {
+			b.WriteString("<p>" + strings.Join(para, "<br>") + "</p>\n")
+			para = nil
+		}
+	}
+	closeList := func() {
+		if listTag != "" {
+			b.WriteString("</" + listTag + ">\n")
+			listTag = ""
+		}
+	}
+	openList := func(tag string) {
+		if listTag != tag {
+			closeList()
+			b.WriteString("<" + tag + ">\n")
+			listTag = tag
+		}
+	}
+
+	for _, line := range lines {
+		t := strings.TrimSpace(line)
+		switch {
+		case t == "":
+			flushPara()
+			closeList()
+		case strings.HasPrefix(t, "- "), strings.HasPrefix(t, "* "):
+			flushPara()
+			openList("ul")
+			b.WriteString("<li>" + inlineHTML(t[2:]) + "</li>\n")
+		case orderedItemRe.MatchString(t):
+			flushPara()
+			openList("ol")
+			b.WriteString("<li>" + inlineHTML(orderedItemRe.ReplaceAllString(t, "")) + "</li>\n")
+		default:
+			closeList()
+			para = append(para, inlineHTML(t))
+		}
+	}
+	flushPara()
+	closeList()
+	return strings.TrimSpace(b.String())
+}
+
+func inlineHTML(s string) string {
+	s = html.EscapeString(strings.TrimSpace(s))
+	return boldRe.ReplaceAllString(s, "<strong>$1</strong>")
+}
@@ -0,0 +1,167 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMetadata = `# 흥부와 놀부
+
+## Story Metadata
+
+| Field | Value |
+|-------|-------|
+| **Title (KR)** | 흥부와 놀부 |
+| **Title (EN)** | Heungbu and Nolbu |
+| **Category** | Folktale |
+| **Age Range** | 5-8 |
+| **Summary** | 착한 흥부와 욕심쟁이 놀부 |
+`
+
+// chapter builds a "## Chapter N" section with the given Korean content
+func chapter(heading, content string) string {
+	return heading + "\n\n### Content (English)\n\nIgnored text.\n\n### Content (Korean)\n\n" + content + "\n\n---\n"
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		want     *StoryDoc
+		errorHas string
+	}{
+		{
+			name: "metadata and chapters",
+			src: testMetadata +
+				chapter("## Chapter 1: 착한 흥부 (Kind Heungbu)", "흥부는 **착했어요**.\n제비를 도왔어요.\n\n놀부는 욕심쟁이였어요.") +
+				chapter("## Chapter 2: 박 (The Gourd)", "- 첫째 박\n- 둘째 박\n\n1. 금\n2. 은"),
+			want: &StoryDoc{
+				Title:    "흥부와 놀부",
+				TitleEN:  "Heungbu and Nolbu",
+				Category: "folktale",
+				AgeMin:   5,
+				AgeMax:   8,
+				Summary:  "착한 흥부와 욕심쟁이 놀부",
+				Chapters: []ChapterDoc{
+					{1, "착한 흥부", "<p>흥부는 <strong>착했어요</strong>.<br>제비를 도왔어요.</p>\n<p>놀부는 욕심쟁이였어요.</p>"},
+					{2, "박", "<ul>\n<li>첫째 박</li>\n<li>둘째 박</li>\n</ul>\n<ol>\n<li>금</li>\n<li>은</li>\n</ol>"},
+				},
+			},
+		},
+		{
+			name: "moral lessons, cultural notes and word list",
+			src: testMetadata + chapter("## Chapter 1: 제비", "제비가 왔어요.") + `
+## Moral Lessons
+
+- 착하게 살아요 & 나눠요
+
+---
+
+## Cultural Notes
+
+**박** is a gourd.
+
+## Word List for Dictionary
+
+| Korean | Romanization | Meaning | Category |
+|--------|--------------|---------|----------|
+| 제비 | jebi | swallow | Animal |
+| 박 | bak | gourd |
+`,
+			want: &StoryDoc{
+				Title:         "흥부와 놀부",
+				TitleEN:       "Heungbu and Nolbu",
+				Category:      "folktale",
+				AgeMin:        5,
+				AgeMax:        8,
+				Summary:       "착한 흥부와 욕심쟁이 놀부",
+				Chapters:      []ChapterDoc{{1, "제비", "<p>제비가 왔어요.</p>"}},
+				MoralLessons:  "<ul>\n<li>착하게 살아요 &amp; 나눠요</li>\n</ul>",
+				CulturalNotes: "<p><strong>박</strong> is a gourd.</p>",
+				Words: []WordDoc{
+					{Word: "제비", Reading: "jebi", Meaning: "swallow", Category: "animal"},
+					{Word: "박", Reading: "bak", Meaning: "gourd"},
+				},
+			},
+		},
+		{
+			name: "vocabulary table",
+			src: testMetadata + chapter("## Chapter 1: 제비", "제비가 왔어요.") + `
+## Vocabulary
+
+| Word | Reading | Meaning |
+|---|---|---|
+| 흥부 | heungbu | Heungbu |
+`,
+			want: &StoryDoc{
+				Title:    "흥부와 놀부",
+				TitleEN:  "Heungbu and Nolbu",
+				Category: "folktale",
+				AgeMin:   5,
+				AgeMax:   8,
+				Summary:  "착한 흥부와 욕심쟁이 놀부",
+				Chapters: []ChapterDoc{{1, "제비", "<p>제비가 왔어요.</p>"}},
+				Words:    []WordDoc{{Word: "흥부", Reading: "heungbu", Meaning: "Heungbu"}},
+			},
+		},
+		{
+			name:     "missing metadata",
+			src:      chapter("## Chapter 1: 제비", "제비가 왔어요."),
+			errorHas: "missing Title (KR)",
+		},
+		{
+			name:     "no chapters",
+			src:      testMetadata,
+			errorHas: "no chapters found",
+		},
+		{
+			name:     "chapter without Korean content",
+			src:      testMetadata + "## Chapter 1: 제비\n\n### Content (English)\n\nA swallow came.\n",
+			errorHas: "chapter 1 has no Korean content",
+		},
+		{
+			name:     "chapter out of order",
+			src:      testMetadata + chapter("## Chapter 1: 제비", "제비가 왔어요.") + chapter("## Chapter 3: 박", "박이 열렸어요."),
+			errorHas: "chapter 3 out of order (expected 2)",
+		},
+		{
+			name:     "invalid age range",
+			src:      strings.Replace(testMetadata, "5-8", "five", 1) + chapter("## Chapter 1: 제비", "제비가 왔어요."),
+			errorHas: `invalid Age Range "five"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.src)
+			if tt.errorHas != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorHas) {
+					t.Fatalf("error = %v, want %q", err, tt.errorHas)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChapterTitle(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"착한 나무꾼 (The Kind Woodcutter)", "착한 나무꾼"},
+		{"  해와 달  ", "해와 달"},
+		{"(Prologue)", "(Prologue)"},
+		{"호랑이 (큰) 이야기", "호랑이 (큰) 이야기"},
+	}
+	for _, tt := range tests {
+		if got := chapterTitle(tt.in); got != tt.want {
+			t.Errorf("chapterTitle(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
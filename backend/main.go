@@ -6,7 +6,10 @@ import (
 	"time"
 
 	"korean-kids-stories/api"
+	"korean-kids-stories/commands"
+	"korean-kids-stories/gamification"
 	"korean-kids-stories/hooks"
+	"korean-kids-stories/migrations"
 	"korean-kids-stories/schema"
 
 	"github.com/pocketbase/pocketbase"
@@ -20,18 +23,38 @@ func main() {
 	// Setup hooks for auto-updating counts
 	hooks.SetupHooks(app)
 
-	// Ensure schema on startup
+	// Apply pending schema migrations on startup
 	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
-		schema.EnsureAllSchema(app)
+		if _, err := migrations.Up(app, 0); err != nil {
+			return err
+		}
+		// Log-only: fields changed in code but not in the DB (see `drift --apply`)
+		schema.LogDrift(app)
 		schema.SeedAppConfig(app)
 		schema.SeedContentPages(app)
 		schema.SeedLevelStickers(app)
+		schema.SeedAchievementStickers(app)
+		schema.SeedXPRules(app)
+		schema.SeedXPLevels(app)
 		api.RegisterPopularRoutes(se)
 		api.RegisterIAPRoutes(se)
+		api.RegisterEntitlementRoutes(se)
 		api.RegisterReportRoutes(se)
+		api.RegisterXPRoutes(se)
+		api.RegisterQuizRoutes(se)
+		api.RegisterLeaderboardRoutes(se)
+		api.RegisterGoalRoutes(se)
+		api.RegisterReadAlongRoutes(se)
+		api.RegisterAudioStreamRoutes(se)
 
 		// Refresh popular searches every 24h
 		go runPopularRefreshCron(app)
+		// Reset streaks of users who missed a day (hourly: midnight of every timezone)
+		go runStreakResetCron(app)
+		// Story completion rules of subscriptions that expired (hourly)
+		go runEntitlementExpiryCron(app)
+		// Weekly / monthly / all-time leaderboards (on start, then hourly)
+		go runLeaderboardCron(app)
 
 		return se.Next()
 	})
@@ -42,6 +65,9 @@ func main() {
 		return se.Next()
 	})
 
+	// Custom console commands (import-stories, migrate, ...)
+	commands.RegisterCommands(app)
+
 	// Start the application
 	if err := app.Start(); err != nil {
 		log.Fatal(err)
@@ -58,3 +84,43 @@ func runPopularRefreshCron(app core.App) {
 		schema.RefreshPopularSearchesCache(app)
 	}
 }
+
+func runStreakResetCron(app core.App) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		if n, err := gamification.ExpireStaleStreaks(app, time.Now()); err != nil {
+			log.Printf("streaks: %v", err)
+		} else if n > 0 {
+			log.Printf("streaks: updated %d user(s)", n)
+		}
+	}
+}
+
+// runEntitlementExpiryCron: expired subscriptions change story completion rules (free
+// chapters only), so stories completed with the free chapters are recorded hourly
+func runEntitlementExpiryCron(app core.App) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	from := time.Now()
+	for now := range ticker.C {
+		if n, err := gamification.RecomputeExpiredEntitlements(app, from, now); err != nil {
+			log.Printf("story completions: %v", err)
+		} else if n > 0 {
+			log.Printf("story completions: %d after expired subscriptions", n)
+		}
+		from = now
+	}
+}
+
+func runLeaderboardCron(app core.App) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for now := time.Now(); ; now = <-ticker.C {
+		if n, err := gamification.RefreshLeaderboards(app, now); err != nil {
+			log.Printf("leaderboards: %v", err)
+		} else {
+			log.Printf("leaderboards: %d row(s)", n)
+		}
+	}
+}
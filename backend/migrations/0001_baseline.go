@@ -0,0 +1,443 @@
+package migrations
+
+import (
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Baseline: the collections previously created by schema.EnsureAllSchema on every startup,
+// as declared then. Missing fields and indexes are added, so this is safe on both fresh and
+// deployed databases.
+func init() {
+	Register(Migration{
+		Version: 1,
+		Name:    "baseline",
+		Up: func(txApp core.App) error {
+			if err := extendBaselineUsers(txApp); err != nil {
+				return err
+			}
+			for _, def := range baselineCollections() {
+				if err := def.ensure(txApp); err != nil {
+					return err
+				}
+			}
+			// reports can be sent by guests
+			return setRelationRequired(txApp, "reports", "user", false)
+		},
+		// Down: nil - the baseline cannot be reverted
+	})
+}
+
+// extendBaselineUsers adds the profile fields to PocketBase's users collection
+func extendBaselineUsers(txApp core.App) error {
+	return addFields(txApp, "users",
+		&core.TextField{Name: "name"},
+		&core.NumberField{Name: "birth_year", Min: schema.Ptr(2000.0), Max: schema.Ptr(2030.0)},
+		&core.FileField{Name: "avatar", MaxSelect: 1, MaxSize: 2097152, MimeTypes: []string{"image/jpeg", "image/png", "image/webp"}},
+		&core.NumberField{Name: "streak_days"},
+		&core.NumberField{Name: "total_reading_minutes"},
+		&core.EmailField{Name: "parent_email"},
+	)
+}
+
+// setRelationRequired changes Required of an existing relation field
+func setRelationRequired(txApp core.App, collectionName, field string, required bool) error {
+	collection, err := txApp.FindCollectionByNameOrId(collectionName)
+	if err != nil {
+		return err
+	}
+	relation, ok := collection.Fields.GetByName(field).(*core.RelationField)
+	if !ok || relation.Required == required {
+		return nil
+	}
+	relation.Required = required
+	return txApp.Save(collection)
+}
+
+// baselineCollections are the collections of the baseline, targets of relations first
+func baselineCollections() []collectionDef {
+	images := func() []string { return []string{"image/jpeg", "image/png", "image/webp"} }
+	published := "is_published = true"
+	signedIn := "@request.auth.id != ''"
+	lock := schema.LockRule
+	return []collectionDef{
+		{
+			Name:  "stories",
+			Rules: []string{published, published, "", "", ""},
+			Fields: []core.Field{
+				&core.TextField{Name: "title", Required: true},
+				&core.SelectField{Name: "category", Required: true, Values: []string{"folktale", "history", "legend", "edu"}, MaxSelect: 1},
+				&core.NumberField{Name: "age_min", Required: true, Min: schema.Ptr(1.0), Max: schema.Ptr(15.0)},
+				&core.NumberField{Name: "age_max", Required: true, Min: schema.Ptr(1.0), Max: schema.Ptr(15.0)},
+				&core.FileField{Name: "thumbnail", MaxSelect: 1, MaxSize: 5242880, MimeTypes: images()},
+				&core.TextField{Name: "summary"},
+				&core.NumberField{Name: "total_chapters", Required: true, Min: schema.Ptr(1.0), Max: schema.Ptr(100.0)},
+				&core.JSONField{Name: "tags"},
+				&core.EditorField{Name: "moral_lessons"},
+				&core.EditorField{Name: "cultural_notes"},
+				&core.BoolField{Name: "is_published"},
+				&core.BoolField{Name: "is_featured"},
+				&core.BoolField{Name: "has_audio"},
+				&core.BoolField{Name: "has_quiz"},
+				&core.BoolField{Name: "has_illustrations"},
+				&core.BoolField{Name: "has_sticker"},
+				&core.BoolField{Name: "required_login"},
+				&core.NumberField{Name: "view_count", Min: schema.Ptr(0.0)},
+				&core.NumberField{Name: "average_rating", Min: schema.Ptr(0.0), Max: schema.Ptr(5.0)},
+				&core.NumberField{Name: "review_count", Min: schema.Ptr(0.0)},
+				&core.NumberField{Name: "favorite_count", Min: schema.Ptr(0.0)},
+				&core.NumberField{Name: "bookmark_count", Min: schema.Ptr(0.0)},
+			},
+			Autodate: true,
+			Indexes: []indexDef{
+				{Name: "idx_stories_category", Columns: "category"},
+				{Name: "idx_stories_published", Columns: "is_published"},
+			},
+		},
+		{
+			Name:  "chapters",
+			Rules: []string{"", "", "", "", ""},
+			Fields: []core.Field{
+				relationField("story", "stories", true, true),
+				&core.NumberField{Name: "chapter_number", Required: true, Min: schema.Ptr(1.0), Max: schema.Ptr(1000.0)},
+				&core.TextField{Name: "title", Required: true},
+				&core.EditorField{Name: "content", Required: true},
+				&core.FileField{Name: "illustrations", MaxSelect: 10, MaxSize: 5242880, MimeTypes: images()},
+				&core.BoolField{Name: "is_free"},
+			},
+			Autodate: true,
+			Indexes: []indexDef{
+				{Name: "idx_chapters_story", Columns: "story"},
+				{Name: "idx_chapters_number", Columns: "story,chapter_number"},
+			},
+		},
+		{
+			Name:  "chapter_audios",
+			Rules: []string{"", "", "", "", ""},
+			Fields: []core.Field{
+				relationField("chapter", "chapters", true, true),
+				&core.TextField{Name: "narrator"},
+				&core.FileField{Name: "audio_file", MaxSelect: 1, MaxSize: 52428800, MimeTypes: []string{"audio/mpeg", "audio/mp4", "audio/wav", "audio/webm"}},
+				&core.NumberField{Name: "audio_duration"},
+				&core.JSONField{Name: "word_timings"},
+			},
+			Autodate: true,
+			Indexes:  []indexDef{{Name: "idx_chapter_audios_chapter", Columns: "chapter"}},
+		},
+		{
+			Name:  "quizzes",
+			Rules: []string{published, published, lock, lock, lock},
+			Fields: []core.Field{
+				relationField("story", "stories", true, true),
+				relationField("chapter", "chapters", false, true),
+				&core.TextField{Name: "question", Required: true},
+				&core.JSONField{Name: "options", Required: true},
+				&core.NumberField{Name: "correct_answer", Required: true, Min: schema.Ptr(0.0), Max: schema.Ptr(3.0)},
+				&core.TextField{Name: "explanation"},
+				&core.BoolField{Name: "is_published"},
+			},
+			Autodate: true,
+			Indexes: []indexDef{
+				{Name: "idx_quizzes_story", Columns: "story"},
+				{Name: "idx_quizzes_chapter", Columns: "chapter"},
+				{Name: "idx_quizzes_story_published", Columns: "story,is_published"},
+			},
+		},
+		{
+			Name:  "reading_progress",
+			Rules: []string{ownerRule, ownerRule, ownerRule, ownerRule, ""},
+			Fields: []core.Field{
+				relationField("user", "users", true, true),
+				relationField("chapter", "chapters", true, true),
+				&core.NumberField{Name: "percent_read", Required: true, Min: schema.Ptr(0.0), Max: schema.Ptr(100.0)},
+				&core.NumberField{Name: "last_position"},
+				&core.BoolField{Name: "is_completed"},
+				&core.JSONField{Name: "bookmarks"},
+			},
+			Autodate: true,
+			Indexes:  []indexDef{{Name: "idx_progress_user_chapter", Unique: true, Columns: "user,chapter"}},
+		},
+		{
+			Name:  "dictionary",
+			Rules: []string{"", "", "", "", ""},
+			Fields: []core.Field{
+				&core.TextField{Name: "word", Required: true},
+				&core.TextField{Name: "reading"},
+				&core.EditorField{Name: "meaning", Required: true},
+				&core.EditorField{Name: "example"},
+				&core.SelectField{Name: "category", Required: true, Values: []string{"hanja", "old_korean", "name", "place"}, MaxSelect: 1},
+			},
+			Autodate: true,
+			Indexes:  []indexDef{{Name: "idx_dictionary_word", Unique: true, Columns: "word"}},
+		},
+		{
+			Name:  "reports",
+			Rules: []string{ownerRule, ownerRule, signedIn, "", ""},
+			Fields: []core.Field{
+				relationField("user", "users", false, false),
+				&core.SelectField{Name: "type", Required: true, Values: []string{"story", "chapter", "app", "question", "other"}, MaxSelect: 1},
+				&core.TextField{Name: "target_id"},
+				&core.EditorField{Name: "reason", Required: true},
+				&core.SelectField{Name: "status", Required: true, Values: []string{"pending", "reviewing", "resolved", "rejected"}, MaxSelect: 1},
+				&core.EditorField{Name: "admin_note"},
+				&core.TextField{Name: "contact_email"},
+				&core.TextField{Name: "source"},
+			},
+			Autodate: true,
+			Indexes: []indexDef{
+				{Name: "idx_reports_user", Columns: "user"},
+				{Name: "idx_reports_status", Columns: "status"},
+				{Name: "idx_reports_type", Columns: "type"},
+			},
+		},
+		{
+			Name:  "user_preferences",
+			Rules: []string{ownerRule, ownerRule, signedIn, ownerRule, ownerRule},
+			Fields: []core.Field{
+				relationField("user", "users", true, true),
+				&core.SelectField{Name: "theme", Required: true, Values: []string{"light", "dark", "system"}, MaxSelect: 1},
+				&core.BoolField{Name: "notifications_enabled"},
+				&core.JSONField{Name: "extra"},
+			},
+			Autodate: true,
+			Indexes:  []indexDef{{Name: "idx_user_preferences_user", Unique: true, Columns: "user"}},
+		},
+		{
+			Name:  "content_pages",
+			Rules: []string{"", "", lock, lock, lock},
+			Fields: []core.Field{
+				&core.TextField{Name: "slug", Required: true},
+				&core.TextField{Name: "title", Required: true},
+				&core.EditorField{Name: "content"},
+				&core.TextField{Name: "locale"},
+				&core.BoolField{Name: "active"},
+			},
+			Autodate: true,
+			Indexes:  []indexDef{{Name: "idx_content_pages_slug_locale", Unique: true, Columns: "slug,locale"}},
+		},
+		{
+			Name:  "app_config",
+			Rules: []string{"", "", lock, lock, lock},
+			Fields: []core.Field{
+				&core.TextField{Name: "key", Required: true},
+				&core.TextField{Name: "value"},
+				&core.TextField{Name: "label"},
+			},
+			Autodate: true,
+			Indexes:  []indexDef{{Name: "idx_app_config_key", Unique: true, Columns: "key"}},
+		},
+		{
+			Name:  "reading_history",
+			Rules: []string{ownerRule, ownerRule, ownerRule, "", ""},
+			Fields: []core.Field{
+				relationField("user", "users", true, true),
+				relationField("story", "stories", true, false),
+				relationField("chapter", "chapters", false, true),
+				&core.SelectField{Name: "action", Required: true, Values: []string{"view", "read", "listen", "complete"}, MaxSelect: 1},
+				&core.NumberField{Name: "duration_seconds"},
+				&core.NumberField{Name: "progress_percent", Min: schema.Ptr(0.0), Max: schema.Ptr(100.0)},
+				&core.TextField{Name: "device_info"},
+			},
+			Indexes: []indexDef{
+				{Name: "idx_history_user", Columns: "user"},
+				{Name: "idx_history_user_story", Columns: "user,story"},
+			},
+		},
+		{
+			Name:  "listening_sessions",
+			Rules: []string{ownerRule, ownerRule, ownerRule, ownerRule, ""},
+			Fields: []core.Field{
+				relationField("user", "users", true, true),
+				relationField("chapter", "chapters", true, true),
+				&core.NumberField{Name: "start_position"},
+				&core.NumberField{Name: "end_position"},
+				&core.NumberField{Name: "duration_listened", Required: true, Min: schema.Ptr(0.0)},
+				&core.BoolField{Name: "completed"},
+				&core.TextField{Name: "device_info"},
+			},
+		},
+		{
+			Name:  "search_history",
+			Rules: []string{ownerRule, ownerRule, ownerRule, "", ""},
+			Fields: []core.Field{
+				relationField("user", "users", true, true),
+				&core.TextField{Name: "query", Required: true},
+				&core.SelectField{Name: "search_type", Required: true, Values: []string{"story", "category", "general"}, MaxSelect: 1},
+				&core.NumberField{Name: "results_count"},
+				&core.BoolField{Name: "clicked_result"},
+			},
+			Indexes: []indexDef{{Name: "idx_search_user", Columns: "user"}},
+		},
+		{
+			Name:  "app_events",
+			Rules: []string{"", "", signedIn, "", ""},
+			Fields: []core.Field{
+				relationField("user", "users", false, true),
+				&core.SelectField{Name: "event_type", Required: true, Values: []string{
+					"app_open", "app_close", "screen_view", "button_click", "story_favorite",
+					"story_share", "download", "signup", "login", "logout",
+				}, MaxSelect: 1},
+				&core.TextField{Name: "screen_name"},
+				&core.TextField{Name: "button_name"},
+				&core.JSONField{Name: "event_data"},
+				&core.TextField{Name: "device_id"},
+				&core.TextField{Name: "device_info"},
+				&core.TextField{Name: "session_id"},
+			},
+			Indexes: []indexDef{
+				{Name: "idx_events_type", Columns: "event_type"},
+				{Name: "idx_events_user", Columns: "user"},
+				{Name: "idx_events_session", Columns: "session_id"},
+			},
+		},
+		{
+			Name:  "popular_searches_cache",
+			Rules: []string{"id != ''", "id != ''", "query != ''", "id != ''", "id != ''"},
+			Fields: []core.Field{
+				&core.TextField{Name: "query", Required: true},
+				&core.NumberField{Name: "hit_count", Min: schema.Ptr(0.0)},
+			},
+			Autodate: true,
+			Indexes:  []indexDef{{Name: "idx_popular_hit_count", Columns: "hit_count"}},
+		},
+		{
+			Name:  "favorites",
+			Rules: []string{ownerRule, ownerRule, ownerRule, ownerRule, ""},
+			Fields: []core.Field{
+				relationField("user", "users", true, true),
+				relationField("story", "stories", true, false),
+			},
+			Autodate: true,
+			Indexes: []indexDef{
+				{Name: "idx_favorites_user", Columns: "user"},
+				{Name: "idx_favorites_user_story", Unique: true, Columns: "user,story"},
+			},
+		},
+		{
+			Name:  "read_later",
+			Rules: []string{ownerRule, ownerRule, ownerRule, ownerRule, ""},
+			Fields: []core.Field{
+				relationField("user", "users", true, true),
+				relationField("story", "stories", true, false),
+			},
+			Autodate: true,
+			Indexes: []indexDef{
+				{Name: "idx_read_later_user", Columns: "user"},
+				{Name: "idx_read_later_user_story", Unique: true, Columns: "user,story"},
+			},
+		},
+		{
+			Name:  "notes",
+			Rules: []string{ownerRule, ownerRule, ownerRule, ownerRule, ""},
+			Fields: []core.Field{
+				relationField("user", "users", true, true),
+				relationField("story", "stories", true, false),
+				relationField("chapter", "chapters", false, true),
+				&core.EditorField{Name: "note", Required: true},
+				&core.NumberField{Name: "position"},
+			},
+			Autodate: true,
+			Indexes: []indexDef{
+				{Name: "idx_notes_user", Columns: "user"},
+				{Name: "idx_notes_story", Columns: "story"},
+			},
+		},
+		{
+			Name:  "reviews",
+			Rules: []string{"", "", signedIn, ownerRule, ownerRule},
+			Fields: []core.Field{
+				relationField("user", "users", true, true),
+				relationField("story", "stories", true, true),
+				&core.NumberField{Name: "rating", Required: true, Min: schema.Ptr(1.0), Max: schema.Ptr(5.0)},
+				&core.EditorField{Name: "comment"},
+			},
+			Autodate: true,
+			Indexes: []indexDef{
+				{Name: "idx_reviews_user_story", Unique: true, Columns: "user,story"},
+				{Name: "idx_reviews_story", Columns: "story"},
+			},
+		},
+		{
+			Name:  "views",
+			Rules: []string{"", "", "", "", ""},
+			Fields: []core.Field{
+				relationField("user", "users", false, true),
+				relationField("story", "stories", true, true),
+				relationField("chapter", "chapters", false, true),
+				&core.TextField{Name: "ip_address"},
+				&core.TextField{Name: "user_agent"},
+			},
+			Autodate: true,
+			Indexes: []indexDef{
+				{Name: "idx_views_story", Columns: "story"},
+				{Name: "idx_views_user_story", Columns: "user,story"},
+				{Name: "idx_views_ip_story", Columns: "ip_address,story"},
+			},
+		},
+		{
+			Name:  "stickers",
+			Rules: []string{published, published, "", "", ""},
+			Fields: []core.Field{
+				&core.SelectField{Name: "type", Required: true, Values: []string{"level", "story"}, MaxSelect: 1},
+				&core.TextField{Name: "key", Required: true},
+				&core.TextField{Name: "name_ko", Required: true},
+				&core.TextField{Name: "description_ko"},
+				&core.FileField{Name: "image", MaxSelect: 1, MaxSize: 2097152, MimeTypes: images()},
+				&core.NumberField{Name: "sort_order", Min: schema.Ptr(0.0)},
+				&core.BoolField{Name: "is_published"},
+				&core.NumberField{Name: "level", Min: schema.Ptr(1.0), Max: schema.Ptr(18.0)},
+				&core.TextField{Name: "rank_ko"},
+				relationField("story", "stories", false, false),
+			},
+			Autodate: true,
+			Indexes: []indexDef{
+				{Name: "idx_stickers_type", Columns: "type"},
+				{Name: "idx_stickers_key", Unique: true, Columns: "key"},
+			},
+		},
+		{
+			Name:  "user_stats",
+			Rules: []string{ownerRule, ownerRule, ownerRule, ownerRule, ownerRule},
+			Fields: []core.Field{
+				relationField("user", "users", true, true),
+				&core.NumberField{Name: "total_xp", Min: schema.Ptr(0.0)},
+				&core.NumberField{Name: "level", Min: schema.Ptr(1.0), Max: schema.Ptr(18.0)},
+				&core.NumberField{Name: "streak_days", Min: schema.Ptr(0.0)},
+				&core.TextField{Name: "last_activity_date"},
+				&core.NumberField{Name: "chapters_read", Min: schema.Ptr(0.0)},
+				&core.NumberField{Name: "chapters_listened", Min: schema.Ptr(0.0)},
+				&core.NumberField{Name: "stories_completed", Min: schema.Ptr(0.0)},
+			},
+			Autodate: true,
+			Indexes:  []indexDef{{Name: "idx_user_stats_user", Unique: true, Columns: "user"}},
+		},
+		{
+			Name:  "user_stickers",
+			Rules: []string{ownerRule, ownerRule, ownerRule, ownerRule, ownerRule},
+			Fields: []core.Field{
+				relationField("user", "users", true, true),
+				relationField("sticker", "stickers", true, false),
+				&core.SelectField{Name: "unlock_source", Required: true, Values: []string{"level_up", "story_complete"}, MaxSelect: 1},
+			},
+			Autodate: true,
+			Indexes: []indexDef{
+				{Name: "idx_user_stickers_user", Columns: "user"},
+				{Name: "idx_user_stickers_user_sticker", Unique: true, Columns: "user,sticker"},
+			},
+		},
+		{
+			Name:  "iap_verifications",
+			Rules: []string{"", "", "", "", ""},
+			Fields: []core.Field{
+				&core.TextField{Name: "device_id", Required: true},
+				&core.TextField{Name: "transaction_id", Required: true},
+				&core.TextField{Name: "product_id", Required: true},
+				&core.TextField{Name: "platform"},
+				&core.TextField{Name: "expires_at"},
+			},
+			Autodate: true,
+			Indexes:  []indexDef{{Name: "idx_iap_device_product", Unique: true, Columns: "device_id,product_id"}},
+		},
+	}
+}
@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// users.avatar comes from PocketBase's default auth collection, so the 2MB / jpeg-png-webp
+// limits declared in EnsureUsersExtendCollection were never applied (reported by `drift`).
+// File limits are only checked on upload, existing avatars stay valid.
+func init() {
+	Register(Migration{
+		Version: 2,
+		Name:    "users_avatar_limits",
+		Up: func(txApp core.App) error {
+			return setAvatarLimits(txApp, 2097152, []string{"image/jpeg", "image/png", "image/webp"})
+		},
+		Down: func(txApp core.App) error {
+			return setAvatarLimits(txApp, 0, []string{"image/jpeg", "image/png", "image/svg+xml", "image/gif", "image/webp"})
+		},
+	})
+}
+
+func setAvatarLimits(txApp core.App, maxSize int64, mimeTypes []string) error {
+	users, err := txApp.FindCollectionByNameOrId("users")
+	if err != nil {
+		return err
+	}
+	avatar, ok := users.Fields.GetByName("avatar").(*core.FileField)
+	if !ok {
+		return nil
+	}
+	avatar.MaxSize = maxSize
+	avatar.MimeTypes = mimeTypes
+	return txApp.Save(users)
+}
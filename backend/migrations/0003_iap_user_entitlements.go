@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Link iap_verifications to user accounts (entitlements) and stop exposing purchases publicly
+func init() {
+	Register(Migration{
+		Version: 3,
+		Name:    "iap_user_entitlements",
+		Up: func(txApp core.App) error {
+			signedInOwner := "@request.auth.id != '' && " + ownerRule
+			return collectionDef{
+				Name:    "iap_verifications",
+				Rules:   []string{signedInOwner, signedInOwner, schema.LockRule, schema.LockRule, schema.LockRule},
+				Fields:  []core.Field{relationField("user", "users", false, false)},
+				Indexes: []indexDef{{Name: "idx_iap_user", Columns: "user"}},
+			}.ensure(txApp)
+		},
+		Down: func(txApp core.App) error {
+			collection, err := txApp.FindCollectionByNameOrId("iap_verifications")
+			if err != nil {
+				return nil
+			}
+			collection.RemoveIndex("idx_iap_user")
+			schema.RemoveField(collection, "user")
+			schema.SetRules(collection, "", "", "", "", "")
+			return txApp.Save(collection)
+		},
+	})
+}
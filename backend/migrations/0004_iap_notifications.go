@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Audit log for App Store / Google Play server notifications
+func init() {
+	Register(Migration{
+		Version: 4,
+		Name:    "iap_notifications",
+		Up: func(txApp core.App) error {
+			lock := schema.LockRule
+			return collectionDef{
+				Name:  "iap_notifications",
+				Rules: []string{lock, lock, lock, lock, lock},
+				Fields: []core.Field{
+					&core.SelectField{Name: "platform", Required: true, Values: []string{"ios", "android"}, MaxSelect: 1},
+					&core.TextField{Name: "notification_id"},
+					&core.TextField{Name: "notification_type"},
+					&core.TextField{Name: "subtype"},
+					&core.TextField{Name: "environment"},
+					&core.TextField{Name: "transaction_id"},
+					&core.TextField{Name: "product_id"},
+					&core.SelectField{Name: "result", Required: true, Values: []string{"processed", "unmatched", "ignored", "rejected", "failed"}, MaxSelect: 1},
+					&core.TextField{Name: "error"},
+					&core.JSONField{Name: "payload"},
+				},
+				Autodate: true,
+				Indexes: []indexDef{
+					{Name: "idx_iap_notifications_id", Unique: true, Columns: "platform,notification_id", Where: "notification_id != ''"},
+					{Name: "idx_iap_notifications_tx", Columns: "transaction_id"},
+				},
+			}.ensure(txApp)
+		},
+		Down: func(txApp core.App) error {
+			return deleteCollection(txApp, "iap_notifications")
+		},
+	})
+}
@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Google Play subscription state on iap_verifications (subscriptionsv2 + RTDN)
+func init() {
+	Register(Migration{
+		Version: 5,
+		Name:    "iap_subscription_state",
+		Up: func(txApp core.App) error {
+			return collectionDef{
+				Name: "iap_verifications",
+				Fields: []core.Field{
+					&core.TextField{Name: "purchase_token"},
+					&core.BoolField{Name: "auto_renewing"},
+					&core.TextField{Name: "subscription_state"},
+				},
+				Indexes: []indexDef{{Name: "idx_iap_purchase_token", Columns: "purchase_token"}},
+			}.ensure(txApp)
+		},
+		Down: func(txApp core.App) error {
+			collection, err := txApp.FindCollectionByNameOrId("iap_verifications")
+			if err != nil {
+				return nil
+			}
+			collection.RemoveIndex("idx_iap_purchase_token")
+			if err := txApp.Save(collection); err != nil {
+				return err
+			}
+			return removeFields(txApp, "iap_verifications", "purchase_token", "auto_renewing", "subscription_state")
+		},
+	})
+}
@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Append-only purchase verification log (raw store responses, replay flags)
+func init() {
+	Register(Migration{
+		Version: 6,
+		Name:    "purchase_events",
+		Up: func(txApp core.App) error {
+			lock := schema.LockRule
+			return collectionDef{
+				Name:  "purchase_events",
+				Rules: []string{lock, lock, lock, lock, lock},
+				Fields: []core.Field{
+					&core.TextField{Name: "platform"},
+					&core.TextField{Name: "device_id"},
+					relationField("user", "users", false, false),
+					&core.TextField{Name: "product_id"},
+					&core.TextField{Name: "transaction_id"},
+					&core.TextField{Name: "environment"},
+					&core.SelectField{Name: "outcome", Required: true, Values: []string{"verified", "rejected", "blocked"}, MaxSelect: 1},
+					&core.NumberField{Name: "http_status"},
+					&core.TextField{Name: "error"},
+					&core.JSONField{Name: "store_response"},
+					&core.JSONField{Name: "request"},
+					&core.BoolField{Name: "flagged"},
+					&core.TextField{Name: "flag_reason"},
+				},
+				Autodate: true,
+				Indexes: []indexDef{
+					{Name: "idx_purchase_events_tx", Columns: "transaction_id"},
+					{Name: "idx_purchase_events_flagged", Columns: "flagged", Where: "flagged = TRUE"},
+				},
+			}.ensure(txApp)
+		},
+		Down: func(txApp core.App) error {
+			return deleteCollection(txApp, "purchase_events")
+		},
+	})
+}
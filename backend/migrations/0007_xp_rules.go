@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Data-driven XP: xp_rules (amount per event + conditions) and xp_levels (thresholds)
+func init() {
+	Register(Migration{
+		Version: 7,
+		Name:    "xp_rules",
+		Up: func(txApp core.App) error {
+			lock := schema.LockRule
+			err := collectionDef{
+				Name:  "xp_rules",
+				Rules: []string{lock, lock, lock, lock, lock},
+				Fields: []core.Field{
+					&core.TextField{Name: "key", Required: true},
+					&core.SelectField{Name: "event", Required: true, Values: []string{"chapter_read", "chapter_listened", "story_completed"}, MaxSelect: 1},
+					&core.NumberField{Name: "amount", Min: schema.Ptr(0.0)},
+					&core.SelectField{Name: "categories", Values: []string{"folktale", "history", "legend", "edu"}, MaxSelect: 4},
+					&core.BoolField{Name: "first_time_only"},
+					&core.DateField{Name: "starts_at"},
+					&core.DateField{Name: "ends_at"},
+					&core.BoolField{Name: "active"},
+					&core.TextField{Name: "description"},
+				},
+				Autodate: true,
+				Indexes: []indexDef{
+					{Name: "idx_xp_rules_key", Unique: true, Columns: "key"},
+					{Name: "idx_xp_rules_event", Columns: "event"},
+				},
+			}.ensure(txApp)
+			if err != nil {
+				return err
+			}
+			return collectionDef{
+				Name:  "xp_levels",
+				Rules: []string{"", "", lock, lock, lock},
+				Fields: []core.Field{
+					&core.NumberField{Name: "level", Required: true, Min: schema.Ptr(1.0), Max: schema.Ptr(18.0)},
+					&core.NumberField{Name: "min_xp", Min: schema.Ptr(0.0)},
+				},
+				Autodate: true,
+				Indexes:  []indexDef{{Name: "idx_xp_levels_level", Unique: true, Columns: "level"}},
+			}.ensure(txApp)
+		},
+		Down: func(txApp core.App) error {
+			if err := deleteCollection(txApp, "xp_levels"); err != nil {
+				return err
+			}
+			return deleteCollection(txApp, "xp_rules")
+		},
+	})
+}
@@ -0,0 +1,418 @@
+package migrations
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// XP ledger. Backfills it from reading_progress (with the default rules/levels seeded) so
+// user_stats.total_xp, now the ledger sum, keeps the XP earned before the ledger existed.
+func init() {
+	Register(Migration{
+		Version: 8,
+		Name:    "xp_transactions",
+		Up: func(txApp core.App) error {
+			lock := schema.LockRule
+			err := collectionDef{
+				Name:  "xp_transactions",
+				Rules: []string{ownerRule, ownerRule, lock, lock, lock},
+				Fields: []core.Field{
+					relationField("user", "users", true, true),
+					&core.SelectField{Name: "event", Required: true, Values: []string{"chapter_read", "chapter_listened", "story_completed"}, MaxSelect: 1},
+					&core.NumberField{Name: "amount"},
+					&core.TextField{Name: "rule_key"},
+					&core.TextField{Name: "source", Required: true},
+					relationField("chapter", "chapters", false, false),
+					relationField("story", "stories", false, false),
+					&core.TextField{Name: "note"},
+				},
+				Autodate: true,
+				Indexes:  []indexDef{{Name: "idx_xp_transactions_award", Unique: true, Columns: "user,source,rule_key"}},
+			}.ensure(txApp)
+			if err != nil {
+				return err
+			}
+			err = seedXPRules(txApp,
+				xpRuleSeed{"chapter_read", "chapter_read", 10, "Đọc xong 1 chương"},
+				xpRuleSeed{"chapter_listened", "chapter_listened", 15, "Nghe xong 1 chương (đã gồm đọc)"},
+				xpRuleSeed{"story_completed", "story_completed", 50, "Hoàn thành truyện (tất cả chương miễn phí)"},
+			)
+			if err != nil {
+				return err
+			}
+			if err := seedXPLevels(txApp); err != nil {
+				return err
+			}
+			return rebuildXPLedger(txApp)
+		},
+		Down: func(txApp core.App) error {
+			return deleteCollection(txApp, "xp_transactions")
+		},
+	})
+}
+
+// xpRebuildNote marks the xp_transactions rows written by a migration
+const xpRebuildNote = "rebuild"
+
+// xpLevelThresholds are the default min XP of levels 1-18
+var xpLevelThresholds = []float64{
+	0, 200, 600, 1200, 2500, 4500, 7000, 10000, 14000, 19000,
+	25000, 32000, 40000, 49000, 59000, 70000, 82000, 100000,
+}
+
+// xpRuleSeed is a default xp_rules row, created (active) when its key is missing
+type xpRuleSeed struct {
+	Key, Event  string
+	Amount      float64
+	Description string
+}
+
+func seedXPRules(txApp core.App, seeds ...xpRuleSeed) error {
+	col, err := txApp.FindCollectionByNameOrId("xp_rules")
+	if err != nil {
+		return err
+	}
+	for _, seed := range seeds {
+		if _, err := txApp.FindFirstRecordByFilter(col.Id, "key = {:key}", dbx.Params{"key": seed.Key}); err == nil {
+			continue
+		}
+		record := core.NewRecord(col)
+		record.Set("key", seed.Key)
+		record.Set("event", seed.Event)
+		record.Set("amount", seed.Amount)
+		record.Set("description", seed.Description)
+		record.Set("active", true)
+		if err := txApp.Save(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedXPLevels creates the default threshold of every missing level
+func seedXPLevels(txApp core.App) error {
+	col, err := txApp.FindCollectionByNameOrId("xp_levels")
+	if err != nil {
+		return err
+	}
+	for i, minXP := range xpLevelThresholds {
+		level := float64(i + 1)
+		if _, err := txApp.FindFirstRecordByFilter(col.Id, "level = {:level}", dbx.Params{"level": level}); err == nil {
+			continue
+		}
+		record := core.NewRecord(col)
+		record.Set("level", level)
+		record.Set("min_xp", minXP)
+		if err := txApp.Save(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadXPLevels reads the xp_levels thresholds (index 0 = level 1); missing levels keep
+// their default
+func loadXPLevels(txApp core.App) ([]float64, error) {
+	levels := slices.Clone(xpLevelThresholds)
+	records, err := txApp.FindRecordsByFilter("xp_levels", "", "level", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if level := r.GetInt("level"); level >= 1 && level <= len(levels) {
+			levels[level-1] = r.GetFloat("min_xp")
+		}
+	}
+	return levels, nil
+}
+
+// levelFor returns the highest level whose threshold is reached (at least 1)
+func levelFor(levels []float64, totalXP float64) int {
+	for i := len(levels) - 1; i >= 0; i-- {
+		if totalXP >= levels[i] {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// xpRule is an active xp_rules row
+type xpRule struct {
+	Key, Event       string
+	Amount           float64
+	Categories       []string // empty = any category
+	FirstTimeOnly    bool
+	StartsAt, EndsAt time.Time // zero = open
+}
+
+func loadXPRules(txApp core.App) ([]xpRule, error) {
+	records, err := txApp.FindRecordsByFilter("xp_rules", "active = true", "key", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]xpRule, 0, len(records))
+	for _, r := range records {
+		rules = append(rules, xpRule{
+			Key:           r.GetString("key"),
+			Event:         r.GetString("event"),
+			Amount:        r.GetFloat("amount"),
+			Categories:    r.GetStringSlice("categories"),
+			FirstTimeOnly: r.GetBool("first_time_only"),
+			StartsAt:      r.GetDateTime("starts_at").Time(),
+			EndsAt:        r.GetDateTime("ends_at").Time(),
+		})
+	}
+	return rules, nil
+}
+
+// xpEvent is an event earning the XP of every matching rule, written under source
+type xpEvent struct {
+	Type, Category     string
+	FirstTime          bool // the user's first event of this type
+	At                 time.Time
+	Source             string
+	ChapterID, StoryID string
+}
+
+func (r xpRule) matches(ev xpEvent) bool {
+	return r.Event == ev.Type &&
+		(len(r.Categories) == 0 || slices.Contains(r.Categories, ev.Category)) &&
+		(!r.FirstTimeOnly || ev.FirstTime) &&
+		(r.StartsAt.IsZero() || !ev.At.Before(r.StartsAt)) &&
+		(r.EndsAt.IsZero() || ev.At.Before(r.EndsAt))
+}
+
+// awardXP appends one xp_transactions row per rule matching the event
+func awardXP(txApp core.App, rules []xpRule, userID string, ev xpEvent) error {
+	col, err := txApp.FindCollectionByNameOrId("xp_transactions")
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if !rule.matches(ev) {
+			continue
+		}
+		record := core.NewRecord(col)
+		record.Set("user", userID)
+		record.Set("event", rule.Event)
+		record.Set("amount", rule.Amount)
+		record.Set("rule_key", rule.Key)
+		record.Set("source", ev.Source)
+		record.Set("chapter", ev.ChapterID)
+		record.Set("story", ev.StoryID)
+		record.Set("note", xpRebuildNote)
+		if err := txApp.Save(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// xpAwarded reports whether the ledger already rewards source for the user
+func xpAwarded(txApp core.App, userID, source string) bool {
+	_, err := txApp.FindFirstRecordByFilter("xp_transactions", "user = {:user} && source = {:source}",
+		dbx.Params{"user": userID, "source": source})
+	return err == nil
+}
+
+// freeChapters returns the free chapters of a story (they defined story completion)
+func freeChapters(txApp core.App, storyID string) ([]string, error) {
+	chapters, err := txApp.FindRecordsByFilter("chapters", "story = {:story} && is_free = true",
+		"chapter_number", 500, 0, dbx.Params{"story": storyID})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(chapters))
+	for _, ch := range chapters {
+		ids = append(ids, ch.Id)
+	}
+	return ids, nil
+}
+
+// grantSticker adds the user_stickers row of the first sticker matching filter if missing
+func grantSticker(txApp core.App, userID, filter string, params dbx.Params, source string) error {
+	sticker, err := txApp.FindFirstRecordByFilter("stickers", filter, params)
+	if err != nil {
+		return nil // not created yet
+	}
+	col, err := txApp.FindCollectionByNameOrId("user_stickers")
+	if err != nil {
+		return err
+	}
+	if _, err := txApp.FindFirstRecordByFilter(col.Id, "user = {:user} && sticker = {:sticker}",
+		dbx.Params{"user": userID, "sticker": sticker.Id}); err == nil {
+		return nil
+	}
+	record := core.NewRecord(col)
+	record.Set("user", userID)
+	record.Set("sticker", sticker.Id)
+	record.Set("unlock_source", source)
+	return txApp.Save(record)
+}
+
+// grantLevelStickers unlocks the level stickers 1..level
+func grantLevelStickers(txApp core.App, userID string, level int) error {
+	for l := 1; l <= level; l++ {
+		err := grantSticker(txApp, userID, `type = "level" && key = {:key}`, dbx.Params{"key": fmt.Sprintf("level_%d", l)}, "level_up")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grantStorySticker unlocks the sticker of a completed story (stories.has_sticker)
+func grantStorySticker(txApp core.App, userID, storyID string) error {
+	story, err := txApp.FindRecordById("stories", storyID)
+	if err != nil || !story.GetBool("has_sticker") {
+		return nil
+	}
+	return grantSticker(txApp, userID, `type = "story" && story = {:story}`, dbx.Params{"story": storyID}, "story_complete")
+}
+
+// rebuildXPLedger replays the completed reading_progress of every user; a failing user is
+// logged and skipped
+func rebuildXPLedger(txApp core.App) error {
+	rules, err := loadXPRules(txApp)
+	if err != nil {
+		return err
+	}
+	levels, err := loadXPLevels(txApp)
+	if err != nil {
+		return err
+	}
+	users, err := txApp.FindAllRecords("users")
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if err := rebuildUserXP(txApp, rules, levels, u.Id); err != nil {
+			log.Printf("migration xp_transactions: user %s: %v", u.Id, err)
+		}
+	}
+	return nil
+}
+
+// rebuildUserXP replays the user's completed chapters (oldest first): chapter_read, or
+// chapter_listened with a completed listening session, and story_completed once every free
+// chapter of the story is done. Then user_stats follows the ledger and the level / story
+// stickers are unlocked.
+func rebuildUserXP(txApp core.App, rules []xpRule, levels []float64, userID string) error {
+	completed, err := txApp.FindRecordsByFilter("reading_progress", "user = {:user} && is_completed = true",
+		"updated", 0, 0, dbx.Params{"user": userID})
+	if err != nil {
+		return err
+	}
+	done := map[string]bool{}
+	storyDone := map[string]bool{}
+	free := map[string][]string{}
+	categories := map[string]string{}
+	read, heard := 0, 0
+	for _, p := range completed {
+		chapterID := p.GetString("chapter")
+		chapter, err := txApp.FindRecordById("chapters", chapterID)
+		if err != nil {
+			continue // chapter deleted
+		}
+		storyID := chapter.GetString("story")
+		if storyID == "" {
+			continue
+		}
+		if _, ok := free[storyID]; !ok {
+			if free[storyID], err = freeChapters(txApp, storyID); err != nil {
+				return err
+			}
+			if story, err := txApp.FindRecordById("stories", storyID); err == nil {
+				categories[storyID] = story.GetString("category")
+			}
+		}
+		done[chapterID] = true
+
+		_, err = txApp.FindFirstRecordByFilter("listening_sessions", "user = {:user} && chapter = {:chapter} && completed = true",
+			dbx.Params{"user": userID, "chapter": chapterID})
+		listened := err == nil
+		at := p.GetDateTime("updated").Time()
+		storyCompleted := !storyDone[storyID] && len(free[storyID]) > 0 &&
+			!slices.ContainsFunc(free[storyID], func(id string) bool { return !done[id] })
+
+		chapterEvent := xpEvent{Type: "chapter_read", Category: categories[storyID], FirstTime: read == 0, At: at,
+			Source: "chapter:" + chapterID, ChapterID: chapterID, StoryID: storyID}
+		if listened {
+			chapterEvent.Type, chapterEvent.FirstTime = "chapter_listened", heard == 0
+		}
+		storyEvent := xpEvent{Type: "story_completed", Category: categories[storyID], FirstTime: len(storyDone) == 0, At: at,
+			Source: "story:" + storyID, StoryID: storyID}
+		chapterAwarded := xpAwarded(txApp, userID, chapterEvent.Source)
+		storyAwarded := xpAwarded(txApp, userID, storyEvent.Source)
+
+		read++
+		if listened {
+			heard++
+		}
+		if storyCompleted {
+			storyDone[storyID] = true
+		}
+		if !chapterAwarded {
+			if err := awardXP(txApp, rules, userID, chapterEvent); err != nil {
+				return err
+			}
+		}
+		if storyCompleted && !storyAwarded {
+			if err := awardXP(txApp, rules, userID, storyEvent); err != nil {
+				return err
+			}
+		}
+	}
+
+	statsCol, err := txApp.FindCollectionByNameOrId("user_stats")
+	if err != nil {
+		return err
+	}
+	stats, err := txApp.FindFirstRecordByFilter(statsCol.Id, "user = {:user}", dbx.Params{"user": userID})
+	if err != nil {
+		stats = core.NewRecord(statsCol)
+		stats.Set("user", userID)
+		stats.Set("streak_days", 0)
+	}
+	var counts struct {
+		TotalXP  float64 `db:"total_xp"`
+		Read     int     `db:"chapters_read"`
+		Listened int     `db:"chapters_listened"`
+	}
+	err = txApp.DB().NewQuery(`SELECT
+		(SELECT COALESCE(SUM(amount), 0) FROM xp_transactions WHERE user = {:user}) AS total_xp,
+		COUNT(*) AS chapters_read,
+		COALESCE(SUM(EXISTS (SELECT 1 FROM listening_sessions ls
+			WHERE ls.user = rp.user AND ls.chapter = rp.chapter AND ls.completed = 1)), 0) AS chapters_listened
+		FROM reading_progress rp WHERE rp.user = {:user} AND rp.is_completed = 1`).
+		Bind(dbx.Params{"user": userID}).One(&counts)
+	if err != nil {
+		return err
+	}
+	level := levelFor(levels, counts.TotalXP)
+	stats.Set("total_xp", counts.TotalXP)
+	stats.Set("level", level)
+	stats.Set("chapters_read", counts.Read)
+	stats.Set("chapters_listened", counts.Listened)
+	stats.Set("stories_completed", len(storyDone))
+	if err := txApp.Save(stats); err != nil {
+		return err
+	}
+
+	if err := grantLevelStickers(txApp, userID, level); err != nil {
+		return err
+	}
+	for storyID := range storyDone {
+		if err := grantStorySticker(txApp, userID, storyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
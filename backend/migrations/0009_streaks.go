@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Timezone-aware streaks: user_preferences.timezone, longest streak and freeze tokens.
+// longest_streak starts at the current streak.
+func init() {
+	Register(Migration{
+		Version: 9,
+		Name:    "streaks",
+		Up: func(txApp core.App) error {
+			if err := addFields(txApp, "user_preferences", &core.TextField{Name: "timezone"}); err != nil {
+				return err
+			}
+			err := addFields(txApp, "user_stats",
+				&core.NumberField{Name: "longest_streak", Min: schema.Ptr(0.0)},
+				&core.NumberField{Name: "streak_freezes", Min: schema.Ptr(0.0)},
+				&core.TextField{Name: "last_streak_date"},
+			)
+			if err != nil {
+				return err
+			}
+			_, err = txApp.DB().Update("user_stats",
+				dbx.Params{"longest_streak": dbx.NewExp("streak_days"), "last_streak_date": dbx.NewExp("last_activity_date")},
+				dbx.NewExp("longest_streak < streak_days")).Execute()
+			return err
+		},
+		Down: func(txApp core.App) error {
+			if err := removeFields(txApp, "user_stats", "longest_streak", "streak_freezes", "last_streak_date"); err != nil {
+				return err
+			}
+			return removeFields(txApp, "user_preferences", "timezone")
+		},
+	})
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Achievement stickers: new sticker types, stickers.criteria and the "achievement"
+// unlock source (select values of existing fields are not changed by addFields)
+func init() {
+	Register(Migration{
+		Version: 10,
+		Name:    "achievement_stickers",
+		Up: func(txApp core.App) error {
+			if err := addFields(txApp, "stickers", &core.JSONField{Name: "criteria"}); err != nil {
+				return err
+			}
+			err := setSelectValues(txApp, "stickers", "type", []string{"level", "story", "streak", "category", "quiz", "listening", "review"})
+			if err != nil {
+				return err
+			}
+			return setSelectValues(txApp, "user_stickers", "unlock_source", []string{"level_up", "story_complete", "achievement"})
+		},
+		Down: func(txApp core.App) error {
+			if err := setSelectValues(txApp, "user_stickers", "unlock_source", []string{"level_up", "story_complete"}); err != nil {
+				return err
+			}
+			if err := setSelectValues(txApp, "stickers", "type", []string{"level", "story"}); err != nil {
+				return err
+			}
+			return removeFields(txApp, "stickers", "criteria")
+		},
+	})
+}
@@ -0,0 +1,93 @@
+package migrations
+
+import (
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Quiz attempts graded server-side: quiz_attempts, user_stats.quizzes_passed, the
+// quiz_passed XP event (rule seeded) and quizzes.correct_answer/explanation hidden
+func init() {
+	Register(Migration{
+		Version: 11,
+		Name:    "quiz_attempts",
+		Up: func(txApp core.App) error {
+			quizzes, err := txApp.FindCollectionByNameOrId("quizzes")
+			if err != nil {
+				return err
+			}
+			schema.SetHidden(quizzes, "correct_answer", true)
+			schema.SetHidden(quizzes, "explanation", true)
+			if err := txApp.Save(quizzes); err != nil {
+				return err
+			}
+			if err := addFields(txApp, "user_stats", &core.NumberField{Name: "quizzes_passed", Min: schema.Ptr(0.0)}); err != nil {
+				return err
+			}
+			lock := schema.LockRule
+			err = collectionDef{
+				Name:  "quiz_attempts",
+				Rules: []string{ownerRule, ownerRule, lock, lock, lock},
+				Fields: []core.Field{
+					relationField("user", "users", true, true),
+					relationField("story", "stories", true, true),
+					&core.JSONField{Name: "answers"},
+					&core.JSONField{Name: "results"},
+					&core.NumberField{Name: "score", Min: schema.Ptr(0.0)},
+					&core.NumberField{Name: "total", Min: schema.Ptr(0.0)},
+					&core.BoolField{Name: "passed"},
+					&core.NumberField{Name: "xp_awarded", Min: schema.Ptr(0.0)},
+				},
+				Autodate: true,
+				Indexes:  []indexDef{{Name: "idx_quiz_attempts_user_story", Columns: "user,story"}},
+			}.ensure(txApp)
+			if err != nil {
+				return err
+			}
+			events := []string{"chapter_read", "chapter_listened", "story_completed", "quiz_passed"}
+			for _, name := range []string{"xp_rules", "xp_transactions"} {
+				if err := setSelectValues(txApp, name, "event", events); err != nil {
+					return err
+				}
+			}
+			// the defaults of this version (deleted ones are re-created)
+			return seedXPRules(txApp,
+				xpRuleSeed{"chapter_read", "chapter_read", 10, "Đọc xong 1 chương"},
+				xpRuleSeed{"chapter_listened", "chapter_listened", 15, "Nghe xong 1 chương (đã gồm đọc)"},
+				xpRuleSeed{"story_completed", "story_completed", 50, "Hoàn thành truyện (tất cả chương miễn phí)"},
+				xpRuleSeed{"quiz_passed", "quiz_passed", 20, "Qua bài quiz của truyện (lần đầu)"},
+			)
+		},
+		Down: func(txApp core.App) error {
+			if err := deleteCollection(txApp, "quiz_attempts"); err != nil {
+				return err
+			}
+			if err := removeFields(txApp, "user_stats", "quizzes_passed"); err != nil {
+				return err
+			}
+			// quiz XP goes with the attempts it rewarded
+			params := dbx.Params{"event": "quiz_passed"}
+			if _, err := txApp.DB().NewQuery("DELETE FROM xp_transactions WHERE event = {:event}").Bind(params).Execute(); err != nil {
+				return err
+			}
+			if _, err := txApp.DB().NewQuery("DELETE FROM xp_rules WHERE event = {:event}").Bind(params).Execute(); err != nil {
+				return err
+			}
+			previous := []string{"chapter_read", "chapter_listened", "story_completed"}
+			for _, name := range []string{"xp_rules", "xp_transactions"} {
+				if err := setSelectValues(txApp, name, "event", previous); err != nil {
+					return err
+				}
+			}
+			collection, err := txApp.FindCollectionByNameOrId("quizzes")
+			if err != nil {
+				return err
+			}
+			schema.SetHidden(collection, "correct_answer", false)
+			schema.SetHidden(collection, "explanation", false)
+			return txApp.Save(collection)
+		},
+	})
+}
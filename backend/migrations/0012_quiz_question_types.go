@@ -0,0 +1,79 @@
+package migrations
+
+import (
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Quiz question types: quizzes.correct_answer becomes JSON (the option index of existing
+// quizzes is kept as a number, their type is multiple_choice), options is optional and
+// question_type / option_images are added
+func init() {
+	Register(Migration{
+		Version: 12,
+		Name:    "quiz_question_types",
+		Up: func(txApp core.App) error {
+			collection, err := txApp.FindCollectionByNameOrId("quizzes")
+			if err != nil {
+				return err
+			}
+			if _, ok := collection.Fields.GetByName("correct_answer").(*core.NumberField); ok {
+				schema.RenameField(collection, "correct_answer", "correct_answer_index")
+			}
+			if options, ok := collection.Fields.GetByName("options").(*core.JSONField); ok {
+				options.Required = false
+			}
+			if err := txApp.Save(collection); err != nil {
+				return err
+			}
+
+			err = addFields(txApp, "quizzes",
+				&core.SelectField{Name: "question_type", Values: []string{"multiple_choice", "true_false", "multi_select", "ordering", "matching", "picture_choice"}, MaxSelect: 1},
+				&core.FileField{Name: "option_images", MaxSelect: 6, MaxSize: 2097152, MimeTypes: []string{"image/jpeg", "image/png", "image/webp"}},
+				&core.JSONField{Name: "correct_answer", Required: true, Hidden: true},
+			)
+			if err != nil {
+				return err
+			}
+			collection, err = txApp.FindCollectionByNameOrId("quizzes")
+			if err != nil {
+				return err
+			}
+			if collection.Fields.GetByName("correct_answer_index") == nil {
+				return nil
+			}
+			if _, err := txApp.DB().NewQuery(`UPDATE quizzes SET
+				correct_answer = CAST(CAST(correct_answer_index AS INTEGER) AS TEXT),
+				question_type = 'multiple_choice'`).Execute(); err != nil {
+				return err
+			}
+			return removeFields(txApp, "quizzes", "correct_answer_index")
+		},
+		Down: func(txApp core.App) error {
+			// Only multiple choice questions fit the old 0-3 index
+			if _, err := txApp.DB().NewQuery(`DELETE FROM quizzes WHERE
+				(question_type != '' AND question_type != 'multiple_choice')
+				OR json_type(correct_answer) != 'integer'
+				OR CAST(correct_answer AS INTEGER) NOT BETWEEN 0 AND 3`).Execute(); err != nil {
+				return err
+			}
+			collection, err := txApp.FindCollectionByNameOrId("quizzes")
+			if err != nil {
+				return err
+			}
+			schema.RenameField(collection, "correct_answer", "correct_answer_json")
+			collection.Fields.Add(&core.NumberField{Name: "correct_answer", Required: true, Min: schema.Ptr(0.0), Max: schema.Ptr(3.0), Hidden: true})
+			if options, ok := collection.Fields.GetByName("options").(*core.JSONField); ok {
+				options.Required = true
+			}
+			if err := txApp.Save(collection); err != nil {
+				return err
+			}
+			if _, err := txApp.DB().NewQuery("UPDATE quizzes SET correct_answer = CAST(correct_answer_json AS INTEGER)").Execute(); err != nil {
+				return err
+			}
+			return removeFields(txApp, "quizzes", "correct_answer_json", "question_type", "option_images")
+		},
+	})
+}
@@ -0,0 +1,270 @@
+package migrations
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Per-story completion records. Stories already rewarded keep their completion; premium
+// users now need every chapter for new completions.
+func init() {
+	Register(Migration{
+		Version: 13,
+		Name:    "story_completions",
+		Up: func(txApp core.App) error {
+			lock := schema.LockRule
+			err := collectionDef{
+				Name:  "story_completions",
+				Rules: []string{ownerRule, ownerRule, lock, lock, lock},
+				Fields: []core.Field{
+					relationField("user", "users", true, true),
+					relationField("story", "stories", true, true),
+					&core.DateField{Name: "completed_at", Required: true},
+					&core.BoolField{Name: "premium"},
+				},
+				Autodate: true,
+				Indexes:  []indexDef{{Name: "idx_story_completions_user_story", Unique: true, Columns: "user,story"}},
+			}.ensure(txApp)
+			if err != nil {
+				return err
+			}
+			return backfillStoryCompletions(txApp)
+		},
+		Down: func(txApp core.App) error {
+			return deleteCollection(txApp, "story_completions")
+		},
+	})
+}
+
+// premiumProductIDs are the subscriptions that unlocked premium chapters at this version
+var premiumProductIDs = []string{
+	"com.hbstore.koreankids.monthly",
+	"com.hbstore.koreankids.threemonth",
+	"com.hbstore.koreankids.yearly",
+}
+
+// backfillStoryCompletions: stories already rewarded in the ledger (completed under the
+// free-chapter rule) keep their completion, then every user's stories are completed under
+// their entitlement (all chapters for premium users), with the missing story_completed XP
+func backfillStoryCompletions(txApp core.App) error {
+	col, err := txApp.FindCollectionByNameOrId("story_completions")
+	if err != nil {
+		return err
+	}
+	rewards, err := txApp.FindRecordsByFilter("xp_transactions", "event = 'story_completed'", "created", 0, 0)
+	if err != nil {
+		return err
+	}
+	for _, r := range rewards {
+		userID, storyID := r.GetString("user"), r.GetString("story")
+		if storyID == "" || storyCompletedBy(txApp, userID, storyID) {
+			continue
+		}
+		if _, err := txApp.FindRecordById("stories", storyID); err != nil {
+			continue // story deleted
+		}
+		record := core.NewRecord(col)
+		record.Set("user", userID)
+		record.Set("story", storyID)
+		record.Set("completed_at", r.GetDateTime("created"))
+		if err := txApp.Save(record); err != nil {
+			return err
+		}
+	}
+
+	users, err := txApp.FindAllRecords("users")
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if err := completeUserStories(txApp, col, u.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func storyCompletedBy(txApp core.App, userID, storyID string) bool {
+	_, err := txApp.FindFirstRecordByFilter("story_completions", "user = {:user} && story = {:story}",
+		dbx.Params{"user": userID, "story": storyID})
+	return err == nil
+}
+
+// hasPremium: an unexpired premium purchase linked to the user account
+func hasPremium(txApp core.App, userID string) (bool, error) {
+	records, err := txApp.FindRecordsByFilter("iap_verifications", "user = {:user}", "", 0, 0, dbx.Params{"user": userID})
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC().Format("2006-01-02 15:04:05.000Z")
+	for _, r := range records {
+		expiresAt := strings.TrimSpace(r.GetString("expires_at"))
+		if slices.Contains(premiumProductIDs, r.GetString("product_id")) && (expiresAt == "" || expiresAt > now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// completeUserStories adds the stories the user completed (every required chapter read) to
+// story_completions, at the time of the last of them
+func completeUserStories(txApp core.App, col *core.Collection, userID string) error {
+	premium, err := hasPremium(txApp, userID)
+	if err != nil {
+		return err
+	}
+	progress, err := txApp.FindRecordsByFilter("reading_progress", "user = {:user} && is_completed = true",
+		"", 0, 0, dbx.Params{"user": userID})
+	if err != nil || len(progress) == 0 {
+		return err
+	}
+	done := make(map[string]time.Time, len(progress))
+	ids := make([]any, 0, len(progress))
+	for _, p := range progress {
+		done[p.GetString("chapter")] = p.GetDateTime("updated").Time()
+		ids = append(ids, p.GetString("chapter"))
+	}
+	completions, err := txApp.FindRecordsByFilter(col.Id, "user = {:user}", "", 0, 0, dbx.Params{"user": userID})
+	if err != nil {
+		return err
+	}
+	checked := map[string]bool{}
+	for _, c := range completions {
+		checked[c.GetString("story")] = true
+	}
+	rules, err := loadXPRules(txApp)
+	if err != nil {
+		return err
+	}
+
+	chapters, err := txApp.FindAllRecords("chapters", dbx.In("id", ids...))
+	if err != nil {
+		return err
+	}
+	added := 0
+	for _, ch := range chapters {
+		storyID := ch.GetString("story")
+		if storyID == "" || checked[storyID] {
+			continue
+		}
+		checked[storyID] = true
+		filter := "story = {:story}"
+		if !premium {
+			filter += " && is_free = true"
+		}
+		required, err := txApp.FindRecordsByFilter("chapters", filter, "chapter_number", 500, 0, dbx.Params{"story": storyID})
+		if err != nil {
+			return err
+		}
+		var completedAt time.Time
+		for _, r := range required {
+			at, ok := done[r.Id]
+			if !ok {
+				completedAt = time.Time{}
+				break
+			}
+			if at.After(completedAt) {
+				completedAt = at
+			}
+		}
+		if len(required) == 0 || completedAt.IsZero() {
+			continue
+		}
+
+		record := core.NewRecord(col)
+		record.Set("user", userID)
+		record.Set("story", storyID)
+		record.Set("completed_at", completedAt)
+		record.Set("premium", premium)
+		if err := txApp.Save(record); err != nil {
+			return err
+		}
+		if source := "story:" + storyID; !xpAwarded(txApp, userID, source) {
+			category := ""
+			if story, err := txApp.FindRecordById("stories", storyID); err == nil {
+				category = story.GetString("category")
+			}
+			err := awardXP(txApp, rules, userID, xpEvent{Type: "story_completed", Category: category,
+				FirstTime: len(completions)+added == 0, At: completedAt, Source: source, StoryID: storyID})
+			if err != nil {
+				return err
+			}
+		}
+		if err := grantStorySticker(txApp, userID, storyID); err != nil {
+			return err
+		}
+		added++
+	}
+	if added == 0 {
+		return nil
+	}
+	return refreshUserStats(txApp, userID)
+}
+
+// refreshUserStats recomputes the user's stats (created when missing) and unlocks the level
+// stickers after a level up
+func refreshUserStats(txApp core.App, userID string) error {
+	statsCol, err := txApp.FindCollectionByNameOrId("user_stats")
+	if err != nil {
+		return err
+	}
+	stats, err := txApp.FindFirstRecordByFilter(statsCol.Id, "user = {:user}", dbx.Params{"user": userID})
+	newUser := err != nil
+	if newUser {
+		stats = core.NewRecord(statsCol)
+		stats.Set("user", userID)
+		stats.Set("streak_days", 0)
+	}
+	levelBefore := max(stats.GetInt("level"), 1)
+	levels, err := loadXPLevels(txApp)
+	if err != nil {
+		return err
+	}
+	if err := recomputeUserStats(txApp, stats, levels); err != nil {
+		return err
+	}
+	if err := txApp.Save(stats); err != nil {
+		return err
+	}
+	if level := stats.GetInt("level"); level > levelBefore || newUser {
+		return grantLevelStickers(txApp, userID, level)
+	}
+	return nil
+}
+
+// recomputeUserStats derives total_xp and level from the ledger and the counters from
+// reading_progress, listening_sessions, story_completions and quiz_attempts; not saved
+func recomputeUserStats(txApp core.App, stats *core.Record, levels []float64) error {
+	var counts struct {
+		TotalXP       float64 `db:"total_xp"`
+		Read          int     `db:"chapters_read"`
+		Listened      int     `db:"chapters_listened"`
+		Stories       int     `db:"stories_completed"`
+		QuizzesPassed int     `db:"quizzes_passed"`
+	}
+	err := txApp.DB().NewQuery(`SELECT
+		(SELECT COALESCE(SUM(amount), 0) FROM xp_transactions WHERE user = {:user}) AS total_xp,
+		COUNT(*) AS chapters_read,
+		COALESCE(SUM(EXISTS (SELECT 1 FROM listening_sessions ls
+			WHERE ls.user = rp.user AND ls.chapter = rp.chapter AND ls.completed = 1)), 0) AS chapters_listened,
+		(SELECT COUNT(*) FROM story_completions WHERE user = {:user}) AS stories_completed,
+		(SELECT COUNT(DISTINCT story) FROM quiz_attempts WHERE user = {:user} AND passed = 1) AS quizzes_passed
+		FROM reading_progress rp WHERE rp.user = {:user} AND rp.is_completed = 1`).
+		Bind(dbx.Params{"user": stats.GetString("user")}).One(&counts)
+	if err != nil {
+		return err
+	}
+	stats.Set("total_xp", counts.TotalXP)
+	stats.Set("level", levelFor(levels, counts.TotalXP))
+	stats.Set("chapters_read", counts.Read)
+	stats.Set("chapters_listened", counts.Listened)
+	stats.Set("stories_completed", counts.Stories)
+	stats.Set("quizzes_passed", counts.QuizzesPassed)
+	return nil
+}
@@ -0,0 +1,92 @@
+package migrations
+
+import (
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// story_progress summary (completed / total chapters per user and story), filled from
+// reading_progress
+func init() {
+	Register(Migration{
+		Version: 14,
+		Name:    "story_progress",
+		Up: func(txApp core.App) error {
+			lock := schema.LockRule
+			err := collectionDef{
+				Name:  "story_progress",
+				Rules: []string{ownerRule, ownerRule, lock, lock, lock},
+				Fields: []core.Field{
+					relationField("user", "users", true, true),
+					relationField("story", "stories", true, true),
+					&core.NumberField{Name: "completed_chapters", Min: schema.Ptr(0.0)},
+					&core.NumberField{Name: "completed_free_chapters", Min: schema.Ptr(0.0)},
+					&core.NumberField{Name: "total_chapters", Min: schema.Ptr(0.0)},
+					&core.NumberField{Name: "free_chapters", Min: schema.Ptr(0.0)},
+				},
+				Autodate: true,
+				Indexes: []indexDef{
+					{Name: "idx_story_progress_user_story", Unique: true, Columns: "user,story"},
+					{Name: "idx_story_progress_story", Columns: "story"},
+				},
+			}.ensure(txApp)
+			if err != nil {
+				return err
+			}
+			return fillStoryProgress(txApp)
+		},
+		Down: func(txApp core.App) error {
+			return deleteCollection(txApp, "story_progress")
+		},
+	})
+}
+
+// fillStoryProgress upserts a story_progress row for every user and story the user
+// completed a chapter of
+func fillStoryProgress(txApp core.App) error {
+	col, err := txApp.FindCollectionByNameOrId("story_progress")
+	if err != nil {
+		return err
+	}
+	var rows []struct {
+		User          string `db:"user"`
+		Story         string `db:"story"`
+		Total         int    `db:"total_chapters"`
+		Free          int    `db:"free_chapters"`
+		Completed     int    `db:"completed_chapters"`
+		CompletedFree int    `db:"completed_free_chapters"`
+	}
+	err = txApp.DB().NewQuery(`SELECT u.user AS user, c.story AS story,
+		COUNT(*) AS total_chapters,
+		COALESCE(SUM(c.is_free = 1), 0) AS free_chapters,
+		COALESCE(SUM(rp.id IS NOT NULL), 0) AS completed_chapters,
+		COALESCE(SUM(rp.id IS NOT NULL AND c.is_free = 1), 0) AS completed_free_chapters
+	FROM (SELECT DISTINCT rp2.user AS user, c2.story AS story FROM reading_progress rp2
+		JOIN chapters c2 ON c2.id = rp2.chapter
+		WHERE rp2.is_completed = 1 AND rp2.user IN (SELECT id FROM users)) u
+	JOIN chapters c ON c.story = u.story
+	LEFT JOIN reading_progress rp ON rp.chapter = c.id AND rp.user = u.user AND rp.is_completed = 1
+	GROUP BY u.user, c.story`).All(&rows)
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		record, err := txApp.FindFirstRecordByFilter(col.Id, "user = {:user} && story = {:story}",
+			dbx.Params{"user": r.User, "story": r.Story})
+		if err != nil {
+			record = core.NewRecord(col)
+			record.Set("user", r.User)
+			record.Set("story", r.Story)
+		}
+		record.Set("completed_chapters", r.Completed)
+		record.Set("completed_free_chapters", r.CompletedFree)
+		record.Set("total_chapters", r.Total)
+		record.Set("free_chapters", r.Free)
+		if err := txApp.Save(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package migrations
+
+import (
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Leaderboards: class groups, leaderboard rows (filled by the hourly job) and the
+// leaderboard preferences (parent opt-out, class code, avatar sticker).
+func init() {
+	Register(Migration{
+		Version: 15,
+		Name:    "leaderboards",
+		Up: func(txApp core.App) error {
+			err := addFields(txApp, "user_preferences",
+				&core.BoolField{Name: "leaderboard_opt_out"},
+				&core.TextField{Name: "class_code"},
+				relationField("avatar_sticker", "stickers", false, false),
+			)
+			if err != nil {
+				return err
+			}
+			lock := schema.LockRule
+			err = collectionDef{
+				Name:  "class_groups",
+				Rules: []string{lock, lock, lock, lock, lock},
+				Fields: []core.Field{
+					&core.TextField{Name: "name", Required: true},
+					&core.TextField{Name: "code", Required: true},
+				},
+				Autodate: true,
+				Indexes:  []indexDef{{Name: "idx_class_groups_code", Unique: true, Columns: "code"}},
+			}.ensure(txApp)
+			if err != nil {
+				return err
+			}
+			user := relationField("user", "users", true, true)
+			user.Hidden = true
+			return collectionDef{
+				Name:  "leaderboard",
+				Rules: []string{lock, lock, lock, lock, lock},
+				Fields: []core.Field{
+					&core.SelectField{Name: "period", Required: true, Values: []string{"weekly", "monthly", "all_time"}, MaxSelect: 1},
+					&core.TextField{Name: "period_start"},
+					&core.SelectField{Name: "scope", Required: true, Values: []string{"global", "class"}, MaxSelect: 1},
+					relationField("class_group", "class_groups", false, true),
+					&core.NumberField{Name: "rank", Required: true, Min: schema.Ptr(1.0)},
+					user,
+					&core.TextField{Name: "nickname", Required: true},
+					relationField("avatar_sticker", "stickers", false, false),
+					&core.NumberField{Name: "xp", Min: schema.Ptr(0.0)},
+					&core.NumberField{Name: "level", Min: schema.Ptr(1.0), Max: schema.Ptr(18.0)},
+					&core.NumberField{Name: "streak_days", Min: schema.Ptr(0.0)},
+				},
+				Autodate: true,
+				Indexes: []indexDef{
+					{Name: "idx_leaderboard_board", Columns: "period,period_start,scope,class_group,rank"},
+					{Name: "idx_leaderboard_user", Columns: "user"},
+				},
+			}.ensure(txApp)
+		},
+		Down: func(txApp core.App) error {
+			if err := deleteCollection(txApp, "leaderboard"); err != nil {
+				return err
+			}
+			if err := deleteCollection(txApp, "class_groups"); err != nil {
+				return err
+			}
+			return removeFields(txApp, "user_preferences", "leaderboard_opt_out", "class_code", "avatar_sticker")
+		},
+	})
+}
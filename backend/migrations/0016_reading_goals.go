@@ -0,0 +1,113 @@
+package migrations
+
+import (
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Reading goals set by the parent: reading_goals, goal_completions, the goal_met XP event
+// (rule seeded), the goal sticker type and created/updated on reading_history and
+// listening_sessions (older rows stay without a date and count for no day)
+func init() {
+	Register(Migration{
+		Version: 16,
+		Name:    "reading_goals",
+		Up: func(txApp core.App) error {
+			if err := addFields(txApp, "reading_history", autodateFields()...); err != nil {
+				return err
+			}
+			err := collectionDef{
+				Name:     "listening_sessions",
+				Autodate: true,
+				Indexes:  []indexDef{{Name: "idx_listening_user", Columns: "user"}},
+			}.ensure(txApp)
+			if err != nil {
+				return err
+			}
+			err = collectionDef{
+				Name:  "reading_goals",
+				Rules: []string{ownerRule, ownerRule, ownerRule, ownerRule, ownerRule},
+				Fields: []core.Field{
+					relationField("user", "users", true, true),
+					&core.NumberField{Name: "chapters_per_day", Min: schema.Ptr(0.0), Max: schema.Ptr(1000.0)},
+					&core.NumberField{Name: "minutes_per_day", Min: schema.Ptr(0.0), Max: schema.Ptr(1000.0)},
+					&core.NumberField{Name: "stories_per_week", Min: schema.Ptr(0.0), Max: schema.Ptr(1000.0)},
+				},
+				Autodate: true,
+				Indexes:  []indexDef{{Name: "idx_reading_goals_user", Unique: true, Columns: "user"}},
+			}.ensure(txApp)
+			if err != nil {
+				return err
+			}
+			lock := schema.LockRule
+			err = collectionDef{
+				Name:  "goal_completions",
+				Rules: []string{ownerRule, ownerRule, lock, lock, lock},
+				Fields: []core.Field{
+					relationField("user", "users", true, true),
+					&core.SelectField{Name: "goal", Required: true, Values: []string{"chapters_per_day", "minutes_per_day", "stories_per_week"}, MaxSelect: 1},
+					&core.TextField{Name: "period_start", Required: true},
+					&core.NumberField{Name: "target", Min: schema.Ptr(0.0)},
+					&core.NumberField{Name: "value", Min: schema.Ptr(0.0)},
+					&core.NumberField{Name: "xp_awarded", Min: schema.Ptr(0.0)},
+				},
+				Autodate: true,
+				Indexes:  []indexDef{{Name: "idx_goal_completions_user_goal_period", Unique: true, Columns: "user,goal,period_start"}},
+			}.ensure(txApp)
+			if err != nil {
+				return err
+			}
+			events := []string{"chapter_read", "chapter_listened", "story_completed", "quiz_passed", "goal_met"}
+			for _, name := range []string{"xp_rules", "xp_transactions"} {
+				if err := setSelectValues(txApp, name, "event", events); err != nil {
+					return err
+				}
+			}
+			err = setSelectValues(txApp, "stickers", "type", []string{"level", "story", "streak", "category", "quiz", "listening", "review", "goal"})
+			if err != nil {
+				return err
+			}
+			// the defaults of this version (deleted ones are re-created)
+			return seedXPRules(txApp,
+				xpRuleSeed{"chapter_read", "chapter_read", 10, "Đọc xong 1 chương"},
+				xpRuleSeed{"chapter_listened", "chapter_listened", 15, "Nghe xong 1 chương (đã gồm đọc)"},
+				xpRuleSeed{"story_completed", "story_completed", 50, "Hoàn thành truyện (chương miễn phí, hoặc tất cả chương nếu có premium)"},
+				xpRuleSeed{"quiz_passed", "quiz_passed", 20, "Qua bài quiz của truyện (lần đầu)"},
+				xpRuleSeed{"goal_met", "goal_met", 10, "Đạt mục tiêu đọc (mỗi mục tiêu, mỗi ngày / tuần)"},
+			)
+		},
+		Down: func(txApp core.App) error {
+			if err := deleteCollection(txApp, "goal_completions"); err != nil {
+				return err
+			}
+			if err := deleteCollection(txApp, "reading_goals"); err != nil {
+				return err
+			}
+			// goal XP goes with the completions it rewarded
+			params := dbx.Params{"event": "goal_met"}
+			if _, err := txApp.DB().NewQuery("DELETE FROM xp_transactions WHERE event = {:event}").Bind(params).Execute(); err != nil {
+				return err
+			}
+			if _, err := txApp.DB().NewQuery("DELETE FROM xp_rules WHERE event = {:event}").Bind(params).Execute(); err != nil {
+				return err
+			}
+			previous := []string{"chapter_read", "chapter_listened", "story_completed", "quiz_passed"}
+			for _, name := range []string{"xp_rules", "xp_transactions"} {
+				if err := setSelectValues(txApp, name, "event", previous); err != nil {
+					return err
+				}
+			}
+			if err := setSelectValues(txApp, "stickers", "type", []string{"level", "story", "streak", "category", "quiz", "listening", "review"}); err != nil {
+				return err
+			}
+			for _, name := range []string{"reading_history", "listening_sessions"} {
+				if err := removeFields(txApp, name, "created", "updated"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
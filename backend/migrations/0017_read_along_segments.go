@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"korean-kids-stories/readalong"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Read-along segments: chapter_audios.segments, computed for the audios that have word timings
+func init() {
+	Register(Migration{
+		Version: 17,
+		Name:    "read_along_segments",
+		Up: func(txApp core.App) error {
+			if err := addFields(txApp, "chapter_audios", &core.JSONField{Name: "segments"}); err != nil {
+				return err
+			}
+			audios, err := txApp.FindRecordsByFilter("chapter_audios", "word_timings != ''", "", 0, 0)
+			if err != nil {
+				return err
+			}
+			for _, audio := range audios {
+				timings, err := readalong.Normalize([]byte(audio.GetString("word_timings")))
+				if err != nil || timings == nil {
+					continue
+				}
+				chapter, err := txApp.FindRecordById("chapters", audio.GetString("chapter"))
+				if err != nil {
+					audio.Set("segments", nil)
+				} else {
+					audio.Set("segments", readalong.Segment(chapter.GetString("content"), timings))
+				}
+				if err := txApp.Save(audio); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(txApp core.App) error {
+			return removeFields(txApp, "chapter_audios", "segments")
+		},
+	})
+}
@@ -0,0 +1,83 @@
+package migrations
+
+import (
+	"log"
+	"math"
+
+	"korean-kids-stories/audiometa"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Audio file metadata on chapter_audios (audio_format, sample_rate, channels, bitrate,
+// loudness_db), read from the stored files; audio_duration is replaced by the real length.
+// Unreadable files are logged and left as they are. audio_file also accepts audio/x-m4a.
+func init() {
+	Register(Migration{
+		Version: 18,
+		Name:    "audio_metadata",
+		Up: func(txApp core.App) error {
+			err := addFields(txApp, "chapter_audios",
+				&core.SelectField{Name: "audio_format", Values: []string{"mp3", "m4a", "wav", "webm"}, MaxSelect: 1},
+				&core.NumberField{Name: "sample_rate"},
+				&core.NumberField{Name: "channels"},
+				&core.NumberField{Name: "bitrate"},
+				&core.NumberField{Name: "loudness_db"},
+			)
+			if err != nil {
+				return err
+			}
+			if err := setAudioMimeTypes(txApp, audioMimeTypes); err != nil {
+				return err
+			}
+			audios, err := txApp.FindAllRecords("chapter_audios")
+			if err != nil {
+				return err
+			}
+			for _, audio := range audios {
+				info, err := audiometa.ReadStored(txApp, audio)
+				if err != nil {
+					log.Printf("migration audio_metadata: chapter_audios %s: %v", audio.Id, err)
+					continue
+				}
+				if info == nil {
+					continue
+				}
+				audio.Set("audio_format", info.Format)
+				audio.Set("sample_rate", info.SampleRate)
+				audio.Set("channels", info.Channels)
+				audio.Set("bitrate", info.Bitrate)
+				audio.Set("loudness_db", info.LoudnessDB)
+				if info.Duration > 0 {
+					audio.Set("audio_duration", math.Round(info.Duration*1000)/1000)
+				}
+				if err := txApp.Save(audio); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(txApp core.App) error {
+			if err := setAudioMimeTypes(txApp, []string{"audio/mpeg", "audio/mp4", "audio/wav", "audio/webm"}); err != nil {
+				return err
+			}
+			return removeFields(txApp, "chapter_audios", "audio_format", "sample_rate", "channels", "bitrate", "loudness_db")
+		},
+	})
+}
+
+// audioMimeTypes are the audio files accepted from this version
+var audioMimeTypes = []string{"audio/mpeg", "audio/mp4", "audio/x-m4a", "audio/wav", "audio/webm"}
+
+func setAudioMimeTypes(txApp core.App, mimeTypes []string) error {
+	collection, err := txApp.FindCollectionByNameOrId("chapter_audios")
+	if err != nil {
+		return err
+	}
+	field, ok := collection.Fields.GetByName("audio_file").(*core.FileField)
+	if !ok {
+		return nil
+	}
+	field.MimeTypes = mimeTypes
+	return txApp.Save(collection)
+}
@@ -0,0 +1,135 @@
+package migrations
+
+import (
+	"strings"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Narrator catalog: narrators (seeded with the TTS voices), chapter_audios.narrator_ref and
+// user_preferences.preferred_narrator. Existing narrator texts are mapped to a narrator (old
+// values like "Clova Female" to their voice); unknown names become new narrators.
+func init() {
+	Register(Migration{
+		Version: 19,
+		Name:    "narrators",
+		Up: func(txApp core.App) error {
+			lock := schema.LockRule
+			err := collectionDef{
+				Name:  "narrators",
+				Rules: []string{"", "", lock, lock, lock},
+				Fields: []core.Field{
+					&core.TextField{Name: "display_name", Required: true},
+					&core.SelectField{Name: "gender", Values: []string{"female", "male", "neutral"}, MaxSelect: 1},
+					&core.SelectField{Name: "tts_engine", Values: []string{"openai", "clova", "kss", "xtts", "mms", "human", "other"}, MaxSelect: 1},
+					&core.FileField{Name: "sample_clip", MaxSelect: 1, MaxSize: 5242880, MimeTypes: audioMimeTypes},
+					&core.NumberField{Name: "sort_order"},
+					&core.BoolField{Name: "is_premium"},
+				},
+				Autodate: true,
+				Indexes:  []indexDef{{Name: "idx_narrators_display_name", Unique: true, Columns: "display_name"}},
+			}.ensure(txApp)
+			if err != nil {
+				return err
+			}
+			if err := addFields(txApp, "chapter_audios", relationField("narrator_ref", "narrators", false, false)); err != nil {
+				return err
+			}
+			if err := addFields(txApp, "user_preferences", relationField("preferred_narrator", "narrators", false, false)); err != nil {
+				return err
+			}
+
+			col, err := txApp.FindCollectionByNameOrId("narrators")
+			if err != nil {
+				return err
+			}
+			// the voices made by the tools/ TTS scripts
+			seeds := []struct {
+				name, gender, engine string
+				sortOrder            float64
+			}{
+				{"여자", "female", "kss", 1},
+				{"남자", "male", "openai", 2},
+				{"페이블", "neutral", "openai", 3},
+			}
+			for _, s := range seeds {
+				if _, err := txApp.FindFirstRecordByData(col.Id, "display_name", s.name); err == nil {
+					continue
+				}
+				narrator := core.NewRecord(col)
+				narrator.Set("display_name", s.name)
+				narrator.Set("gender", s.gender)
+				narrator.Set("tts_engine", s.engine)
+				narrator.Set("sort_order", s.sortOrder)
+				if err := txApp.Save(narrator); err != nil {
+					return err
+				}
+			}
+			audios, err := txApp.FindRecordsByFilter("chapter_audios", "narrator != '' && narrator_ref = ''", "created", 0, 0)
+			if err != nil {
+				return err
+			}
+			for _, audio := range audios {
+				name := narratorName(audio.GetString("narrator"))
+				narrator, err := txApp.FindFirstRecordByFilter(col.Id, "display_name = {:name}", dbx.Params{"name": name})
+				if err != nil {
+					var last float64
+					if err := txApp.DB().NewQuery("SELECT COALESCE(MAX(sort_order), 0) FROM narrators").Row(&last); err != nil {
+						return err
+					}
+					narrator = core.NewRecord(col)
+					narrator.Set("display_name", name)
+					narrator.Set("tts_engine", "other")
+					narrator.Set("sort_order", last+1)
+					if err := txApp.Save(narrator); err != nil {
+						return err
+					}
+				}
+				audio.Set("narrator_ref", narrator.Id)
+				audio.Set("narrator", name)
+				if err := txApp.Save(audio); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(txApp core.App) error {
+			if err := removeFields(txApp, "user_preferences", "preferred_narrator"); err != nil {
+				return err
+			}
+			if err := removeFields(txApp, "chapter_audios", "narrator_ref"); err != nil {
+				return err
+			}
+			return deleteCollection(txApp, "narrators")
+		},
+	})
+}
+
+// narratorAliases map old free-text chapter_audios.narrator values (lower case, matched as
+// substrings in this order) to a narrator display_name
+var narratorAliases = []struct{ alias, name string }{
+	{"clova female", "여자"},
+	{"clova male", "남자"},
+	{"female", "여자"},
+	{"male", "남자"},
+	{"kss", "여자"},
+	{"mms", "남자"},
+	{"cô", "여자"},
+	{"chú", "남자"},
+}
+
+// narratorName maps a chapter_audios.narrator text to a narrator display_name: known old
+// values (Clova Female...) to their voice, anything else trimmed
+func narratorName(text string) string {
+	text = strings.TrimSpace(text)
+	lower := strings.ToLower(text)
+	for _, a := range narratorAliases {
+		if strings.Contains(lower, a.alias) {
+			return a.name
+		}
+	}
+	return text
+}
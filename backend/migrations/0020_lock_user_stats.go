@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// user_stats becomes read-only for its owner (create/update/delete: superusers only).
+// total_xp, level and the counters are recomputed from the ledger so values a client wrote
+// while the rules were open are dropped.
+func init() {
+	Register(Migration{
+		Version: 20,
+		Name:    "lock_user_stats",
+		Up: func(txApp core.App) error {
+			collection, err := txApp.FindCollectionByNameOrId("user_stats")
+			if err != nil {
+				return err
+			}
+			lock := schema.LockRule
+			schema.SetRules(collection, ownerRule, ownerRule, lock, lock, lock)
+			if err := txApp.Save(collection); err != nil {
+				return err
+			}
+			levels, err := loadXPLevels(txApp)
+			if err != nil {
+				return err
+			}
+			records, err := txApp.FindAllRecords(collection)
+			if err != nil {
+				return err
+			}
+			for _, stats := range records {
+				if err := recomputeUserStats(txApp, stats, levels); err != nil {
+					return err
+				}
+				if err := txApp.Save(stats); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(txApp core.App) error {
+			collection, err := txApp.FindCollectionByNameOrId("user_stats")
+			if err != nil {
+				return nil
+			}
+			schema.SetRules(collection, ownerRule, ownerRule, ownerRule, ownerRule, ownerRule)
+			return txApp.Save(collection)
+		},
+	})
+}
@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxStreakFreezes is the cap on saved freeze tokens at this version
+const maxStreakFreezes = 2
+
+// Streak fields a client could PATCH before user_stats was locked (migration 20): tokens
+// above maxStreakFreezes are dropped and streaks cannot be longer than the account is old.
+// The longest streak never goes below the current one.
+func init() {
+	Register(Migration{
+		Version: 21,
+		Name:    "clamp_streaks",
+		Up: func(txApp core.App) error {
+			records, err := txApp.FindAllRecords("user_stats")
+			if err != nil {
+				return err
+			}
+			now := time.Now().UTC()
+			for _, stats := range records {
+				user, err := txApp.FindRecordById("users", stats.GetString("user"))
+				if err != nil {
+					continue
+				}
+				maxDays := int(now.Sub(user.GetDateTime("created").Time()).Hours()/24) + 1
+				days := min(stats.GetInt("streak_days"), maxDays)
+				longest := max(min(stats.GetInt("longest_streak"), maxDays), days)
+				freezes := min(stats.GetInt("streak_freezes"), maxStreakFreezes)
+				if days == stats.GetInt("streak_days") && longest == stats.GetInt("longest_streak") &&
+					freezes == stats.GetInt("streak_freezes") {
+					continue
+				}
+				stats.Set("streak_days", float64(days))
+				stats.Set("longest_streak", float64(longest))
+				stats.Set("streak_freezes", float64(freezes))
+				if err := txApp.Save(stats); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(txApp core.App) error {
+			// Clamped values cannot be restored
+			return nil
+		},
+	})
+}
@@ -0,0 +1,20 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// iap_verifications.store_signed_at: signedDate of the last App Store notification applied,
+// so older notifications delivered late do not overwrite newer state
+func init() {
+	Register(Migration{
+		Version: 22,
+		Name:    "iap_store_signed_at",
+		Up: func(txApp core.App) error {
+			return addFields(txApp, "iap_verifications", &core.TextField{Name: "store_signed_at"})
+		},
+		Down: func(txApp core.App) error {
+			return removeFields(txApp, "iap_verifications", "store_signed_at")
+		},
+	})
+}
@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// iap_verifications rows are keyed by purchase and account (guest rows also by device), so a
+// second account on a device gets its own row: the unique device_id+product_id index goes
+func init() {
+	Register(Migration{
+		Version: 23,
+		Name:    "iap_purchase_rows",
+		Up: func(txApp core.App) error {
+			collection, err := txApp.FindCollectionByNameOrId("iap_verifications")
+			if err != nil {
+				return err
+			}
+			collection.RemoveIndex("idx_iap_device_product")
+			collection.AddIndex("idx_iap_device", false, "device_id", "")
+			collection.AddIndex("idx_iap_purchase", true, "platform,transaction_id,product_id,user,device_id", "")
+			return txApp.Save(collection)
+		},
+		Down: func(txApp core.App) error {
+			collection, err := txApp.FindCollectionByNameOrId("iap_verifications")
+			if err != nil {
+				return nil
+			}
+			collection.RemoveIndex("idx_iap_purchase")
+			collection.RemoveIndex("idx_iap_device")
+			// fails while a device has rows of several accounts
+			collection.AddIndex("idx_iap_device_product", true, "device_id,product_id", "")
+			return txApp.Save(collection)
+		},
+	})
+}
@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// xp_transactions.occurred_at: when the rewarded event happened. Rows written by the live
+// hooks happened when they were written; rebuilt rows (note "rebuild", e.g. the backfill of
+// version 8) take the time of their source: reading_progress.updated, story_completions
+// .completed_at, the first passed quiz_attempts row, goal_completions.created (never later
+// than the row itself).
+func init() {
+	Register(Migration{
+		Version: 24,
+		Name:    "xp_occurred_at",
+		Up: func(txApp core.App) error {
+			collection, err := txApp.FindCollectionByNameOrId("xp_transactions")
+			if err != nil {
+				return err
+			}
+			if collection.Fields.GetByName("occurred_at") == nil {
+				collection.Fields.Add(&core.DateField{Name: "occurred_at"})
+			}
+			collection.AddIndex("idx_xp_transactions_occurred", false, "occurred_at,user", "")
+			if err := txApp.Save(collection); err != nil {
+				return err
+			}
+			for _, sql := range xpOccurredAtBackfill {
+				if _, err := txApp.DB().NewQuery(sql).Execute(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(txApp core.App) error {
+			collection, err := txApp.FindCollectionByNameOrId("xp_transactions")
+			if err != nil {
+				return nil
+			}
+			collection.RemoveIndex("idx_xp_transactions_occurred")
+			collection.Fields.RemoveByName("occurred_at")
+			return txApp.Save(collection)
+		},
+	})
+}
+
+var xpOccurredAtBackfill = []string{
+	`UPDATE xp_transactions SET occurred_at = created WHERE occurred_at = '' OR occurred_at IS NULL`,
+	`UPDATE xp_transactions SET occurred_at = MIN(COALESCE((
+		SELECT MAX(rp.updated) FROM reading_progress rp
+		WHERE rp.user = xp_transactions.user AND rp.chapter = substr(xp_transactions.source, 9) AND rp.is_completed = 1
+	), created), created) WHERE note = 'rebuild' AND source LIKE 'chapter:%'`,
+	`UPDATE xp_transactions SET occurred_at = MIN(COALESCE((
+		SELECT MIN(sc.completed_at) FROM story_completions sc
+		WHERE sc.user = xp_transactions.user AND sc.story = substr(xp_transactions.source, 7)
+	), created), created) WHERE note = 'rebuild' AND source LIKE 'story:%'`,
+	`UPDATE xp_transactions SET occurred_at = MIN(COALESCE((
+		SELECT MIN(qa.created) FROM quiz_attempts qa
+		WHERE qa.user = xp_transactions.user AND qa.story = substr(xp_transactions.source, 6) AND qa.passed = 1
+	), created), created) WHERE note = 'rebuild' AND source LIKE 'quiz:%'`,
+	`UPDATE xp_transactions SET occurred_at = MIN(COALESCE((
+		SELECT MIN(gc.created) FROM goal_completions gc
+		WHERE gc.user = xp_transactions.user AND 'goal:' || gc.goal || ':' || gc.period_start = xp_transactions.source
+	), created), created) WHERE note = 'rebuild' AND source LIKE 'goal:%'`,
+}
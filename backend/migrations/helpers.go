@@ -0,0 +1,140 @@
+package migrations
+
+import (
+	"fmt"
+	"slices"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ownerRule limits a rule to the records of the signed-in user
+const ownerRule = "user = @request.auth.id"
+
+// collectionDef is a collection as declared at the version of a migration. Migrations keep
+// their own definitions instead of calling the schema Ensure* functions, which follow the
+// current schema (a migration must do the same thing on every database it ever runs on).
+type collectionDef struct {
+	Name     string
+	Rules    []string     // list, view, create, update, delete (schema.LockRule: superusers only); nil keeps them
+	Fields   []core.Field // see addMissingFields
+	Autodate bool         // created / updated
+	Indexes  []indexDef
+}
+
+// indexDef is one index of a collectionDef
+type indexDef struct {
+	Name    string
+	Unique  bool
+	Columns string
+	Where   string
+}
+
+// ensure creates the collection or adds the fields and indexes it is missing (existing
+// fields are left as they are, like the Ensure* functions did), then sets the rules
+func (d collectionDef) ensure(txApp core.App) error {
+	collection, err := txApp.FindCollectionByNameOrId(d.Name)
+	if err != nil {
+		collection = core.NewBaseCollection(d.Name)
+	}
+	if len(d.Rules) == 5 {
+		schema.SetRules(collection, d.Rules[0], d.Rules[1], d.Rules[2], d.Rules[3], d.Rules[4])
+	}
+	fields := d.Fields
+	if d.Autodate {
+		fields = append(slices.Clip(fields), autodateFields()...)
+	}
+	if err := addMissingFields(txApp, collection, fields); err != nil {
+		return err
+	}
+	for _, index := range d.Indexes {
+		if collection.GetIndex(index.Name) == "" {
+			collection.AddIndex(index.Name, index.Unique, index.Columns, index.Where)
+		}
+	}
+	return txApp.Save(collection)
+}
+
+// addFields adds the fields missing from an existing collection
+func addFields(txApp core.App, collectionName string, fields ...core.Field) error {
+	collection, err := txApp.FindCollectionByNameOrId(collectionName)
+	if err != nil {
+		return err
+	}
+	if err := addMissingFields(txApp, collection, fields); err != nil {
+		return err
+	}
+	return txApp.Save(collection)
+}
+
+// addMissingFields adds the fields the collection does not have yet. The CollectionId of a
+// relation field is the name of the target collection, replaced by its id.
+func addMissingFields(txApp core.App, collection *core.Collection, fields []core.Field) error {
+	for _, field := range fields {
+		if collection.Fields.GetByName(field.GetName()) != nil {
+			continue
+		}
+		if relation, ok := field.(*core.RelationField); ok {
+			target, err := txApp.FindCollectionByNameOrId(relation.CollectionId)
+			if err != nil {
+				return fmt.Errorf("relation %s.%s: %w", collection.Name, relation.Name, err)
+			}
+			relation.CollectionId = target.Id
+		}
+		collection.Fields.Add(field)
+	}
+	return nil
+}
+
+// relationField is a single relation to the target collection (by name)
+func relationField(name, target string, required, cascadeDelete bool) *core.RelationField {
+	return &core.RelationField{Name: name, CollectionId: target, Required: required, MaxSelect: 1, CascadeDelete: cascadeDelete}
+}
+
+// autodateFields are the created / updated fields
+func autodateFields() []core.Field {
+	return []core.Field{
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	}
+}
+
+// deleteCollection drops a collection if it exists (used by Down functions)
+func deleteCollection(txApp core.App, name string) error {
+	collection, err := txApp.FindCollectionByNameOrId(name)
+	if err != nil {
+		return nil
+	}
+	return txApp.Delete(collection)
+}
+
+// removeFields drops fields from a collection if they exist (used by Down functions)
+func removeFields(txApp core.App, collectionName string, fields ...string) error {
+	collection, err := txApp.FindCollectionByNameOrId(collectionName)
+	if err != nil {
+		return nil
+	}
+	changed := false
+	for _, name := range fields {
+		if schema.RemoveField(collection, name) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return txApp.Save(collection)
+}
+
+// setSelectValues replaces the allowed values of a select field (ensure only adds missing fields)
+func setSelectValues(txApp core.App, collectionName, field string, values []string) error {
+	collection, err := txApp.FindCollectionByNameOrId(collectionName)
+	if err != nil {
+		return err
+	}
+	if !schema.SetSelectValues(collection, field, values) {
+		return nil
+	}
+	return txApp.Save(collection)
+}
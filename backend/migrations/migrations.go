@@ -0,0 +1,178 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// versionsTable records applied schema versions (one row per migration)
+const versionsTable = "_schema_versions"
+
+// ErrIrreversible is returned by Down functions that cannot be undone
+var ErrIrreversible = errors.New("migration cannot be reverted")
+
+// Migration is one versioned schema change.
+// Up/Down run inside a transaction together with the version bookkeeping.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(txApp core.App) error
+	Down    func(txApp core.App) error
+}
+
+// Status is the applied state of one registered migration
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt string
+}
+
+var registry = map[int]Migration{}
+
+// Register adds a migration to the registry. Called from init() of each migration file.
+func Register(m Migration) {
+	if m.Version <= 0 {
+		panic(fmt.Sprintf("migrations: invalid version %d (%s)", m.Version, m.Name))
+	}
+	if existing, ok := registry[m.Version]; ok {
+		panic(fmt.Sprintf("migrations: duplicate version %d (%s, %s)", m.Version, existing.Name, m.Name))
+	}
+	if m.Up == nil {
+		panic(fmt.Sprintf("migrations: %d_%s has no Up", m.Version, m.Name))
+	}
+	registry[m.Version] = m
+}
+
+// All returns registered migrations ordered by version
+func All() []Migration {
+	list := make([]Migration, 0, len(registry))
+	for _, m := range registry {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+func ensureVersionsTable(app core.App) error {
+	_, err := app.DB().NewQuery(
+		"CREATE TABLE IF NOT EXISTS {{" + versionsTable + "}} (" +
+			"[[version]] INTEGER PRIMARY KEY NOT NULL, " +
+			"[[name]] TEXT NOT NULL, " +
+			"[[applied_at]] TEXT NOT NULL)",
+	).Execute()
+	return err
+}
+
+type appliedRow struct {
+	Version   int    `db:"version"`
+	Name      string `db:"name"`
+	AppliedAt string `db:"applied_at"`
+}
+
+func applied(app core.App) (map[int]appliedRow, error) {
+	if err := ensureVersionsTable(app); err != nil {
+		return nil, err
+	}
+	var rows []appliedRow
+	if err := app.DB().Select("version", "name", "applied_at").From(versionsTable).All(&rows); err != nil {
+		return nil, err
+	}
+	result := make(map[int]appliedRow, len(rows))
+	for _, r := range rows {
+		result[r.Version] = r
+	}
+	return result, nil
+}
+
+// StatusAll lists every registered migration with its applied state
+func StatusAll(app core.App) ([]Status, error) {
+	done, err := applied(app)
+	if err != nil {
+		return nil, err
+	}
+	var list []Status
+	for _, m := range All() {
+		s := Status{Migration: m}
+		if r, ok := done[m.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = r.AppliedAt
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// Up applies pending migrations in order, up to and including target (0 = latest).
+// Each migration runs in its own transaction; the first failure stops the run.
+func Up(app core.App, target int) ([]Migration, error) {
+	done, err := applied(app)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, m := range All() {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if _, ok := done[m.Version]; ok {
+			continue
+		}
+		err := app.RunInTransaction(func(txApp core.App) error {
+			if err := m.Up(txApp); err != nil {
+				return err
+			}
+			_, err := txApp.DB().Insert(versionsTable, dbx.Params{
+				"version":    m.Version,
+				"name":       m.Name,
+				"applied_at": time.Now().UTC().Format(time.RFC3339),
+			}).Execute()
+			return err
+		})
+		if err != nil {
+			return ran, fmt.Errorf("migration %d_%s up: %w", m.Version, m.Name, err)
+		}
+		log.Printf("migrations: applied %d_%s", m.Version, m.Name)
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
+
+// Down reverts the last `steps` applied migrations, newest first
+func Down(app core.App, steps int) ([]Migration, error) {
+	done, err := applied(app)
+	if err != nil {
+		return nil, err
+	}
+
+	all := All()
+	var ran []Migration
+	for i := len(all) - 1; i >= 0 && len(ran) < steps; i-- {
+		m := all[i]
+		if _, ok := done[m.Version]; !ok {
+			continue
+		}
+		if m.Down == nil {
+			return ran, fmt.Errorf("migration %d_%s down: %w", m.Version, m.Name, ErrIrreversible)
+		}
+		err := app.RunInTransaction(func(txApp core.App) error {
+			if err := m.Down(txApp); err != nil {
+				return err
+			}
+			_, err := txApp.DB().Delete(versionsTable, dbx.HashExp{"version": m.Version}).Execute()
+			return err
+		})
+		if err != nil {
+			return ran, fmt.Errorf("migration %d_%s down: %w", m.Version, m.Name, err)
+		}
+		log.Printf("migrations: reverted %d_%s", m.Version, m.Name)
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
@@ -0,0 +1,96 @@
+package quiz
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// PassRatio is the share of correct answers needed to pass a story quiz
+const PassRatio = 0.7
+
+// Result is the graded answer to one question (one item of quiz_attempts.results)
+type Result struct {
+	QuizID        string          `json:"quiz"`
+	Type          string          `json:"question_type"`
+	Answer        json.RawMessage `json:"answer"` // null when unanswered
+	Correct       bool            `json:"correct"`
+	CorrectAnswer json.RawMessage `json:"correct_answer"`
+	Explanation   string          `json:"explanation,omitempty"`
+}
+
+// Grade is a graded submission
+type Grade struct {
+	Results []Result `json:"results"`
+	Score   int      `json:"score"`
+	Total   int      `json:"total"`
+	Passed  bool     `json:"passed"`
+}
+
+// StoryQuizzes returns the published quizzes of a story. Quizzes of locked (premium)
+// chapters are left out unless premium is set.
+func StoryQuizzes(app core.App, storyID string, premium bool) ([]*core.Record, error) {
+	quizzes, err := app.FindRecordsByFilter("quizzes", "story = {:story} && is_published = true",
+		"created", 0, 0, dbx.Params{"story": storyID})
+	if err != nil || premium {
+		return quizzes, err
+	}
+	free, err := app.FindRecordsByFilter("chapters", "story = {:story} && is_free = true",
+		"", 0, 0, dbx.Params{"story": storyID})
+	if err != nil {
+		return nil, err
+	}
+	isFree := map[string]bool{}
+	for _, ch := range free {
+		isFree[ch.Id] = true
+	}
+	visible := quizzes[:0]
+	for _, q := range quizzes {
+		if chapterID := q.GetString("chapter"); chapterID == "" || isFree[chapterID] {
+			visible = append(visible, q)
+		}
+	}
+	return visible, nil
+}
+
+// GradeSubmission grades answers (quiz id -> answer of the quiz's question_type) against
+// quizzes. Unanswered questions count as wrong; an answer to another quiz or of the wrong
+// shape is an error.
+func GradeSubmission(quizzes []*core.Record, answers map[string]json.RawMessage) (*Grade, error) {
+	known := make(map[string]bool, len(quizzes))
+	for _, q := range quizzes {
+		known[q.Id] = true
+	}
+	for id := range answers {
+		if !known[id] {
+			return nil, fmt.Errorf("unknown quiz %q", id)
+		}
+	}
+
+	grade := &Grade{Results: make([]Result, 0, len(quizzes)), Total: len(quizzes)}
+	for _, q := range quizzes {
+		r := Result{
+			QuizID:        q.Id,
+			Type:          Type(q),
+			Answer:        json.RawMessage("null"),
+			CorrectAnswer: json.RawMessage(q.GetString("correct_answer")),
+			Explanation:   q.GetString("explanation"),
+		}
+		if raw, ok := answers[q.Id]; ok && string(raw) != "null" {
+			correct, err := Check(q, raw)
+			if err != nil {
+				return nil, fmt.Errorf("quiz %q: %w", q.Id, err)
+			}
+			r.Answer = raw
+			r.Correct = correct
+		}
+		if r.Correct {
+			grade.Score++
+		}
+		grade.Results = append(grade.Results, r)
+	}
+	grade.Passed = grade.Total > 0 && float64(grade.Score) >= PassRatio*float64(grade.Total)
+	return grade, nil
+}
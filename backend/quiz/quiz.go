@@ -0,0 +1,168 @@
+package quiz
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"korean-kids-stories/schema"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Answer shapes per question type (quizzes.correct_answer and submitted answers):
+//   - multiple_choice, picture_choice: option index, e.g. 2
+//   - true_false: true | false
+//   - multi_select: the correct option indexes in any order, e.g. [0, 2]
+//   - ordering: option indexes in the right order, e.g. [2, 0, 1]
+//   - matching: for each left item, the index of its right item, e.g. [1, 0, 2]
+//
+// options is an array of strings, except matching: {"left": [...], "right": [...]}.
+// picture_choice options are the option_images files (options may hold their captions).
+
+// Matching is the options of a matching question (character ↔ description)
+type Matching struct {
+	Left  []string `json:"left"`
+	Right []string `json:"right"`
+}
+
+// Type returns the question_type of a quiz (multiple_choice when empty)
+func Type(q *core.Record) string {
+	if t := q.GetString("question_type"); t != "" {
+		return t
+	}
+	return schema.QuizMultipleChoice
+}
+
+// Validate checks options, option_images and correct_answer of a quiz for its type
+func Validate(q *core.Record) error {
+	typ := Type(q)
+	if !slices.Contains(schema.QuizQuestionTypes, typ) {
+		return fmt.Errorf("unknown question_type %q", typ)
+	}
+	answer, err := parseAnswer(typ, []byte(q.GetString("correct_answer")))
+	if err != nil {
+		return fmt.Errorf("correct_answer: %w", err)
+	}
+
+	switch typ {
+	case schema.QuizTrueFalse:
+		return nil
+	case schema.QuizMatching:
+		var m Matching
+		if err := json.Unmarshal([]byte(q.GetString("options")), &m); err != nil || len(m.Left) == 0 || len(m.Right) == 0 {
+			return fmt.Errorf(`options: matching needs {"left": [...], "right": [...]}`)
+		}
+		pairs := answer.([]int)
+		if len(pairs) != len(m.Left) {
+			return fmt.Errorf("correct_answer: one right index per left item (%d)", len(m.Left))
+		}
+		return checkIndexes(pairs, len(m.Right), true)
+	}
+
+	n, err := optionCount(q, typ)
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case schema.QuizMultiSelect:
+		indexes := answer.([]int)
+		if len(indexes) == 0 {
+			return fmt.Errorf("correct_answer: at least one option")
+		}
+		return checkIndexes(indexes, n, true)
+	case schema.QuizOrdering:
+		order := answer.([]int)
+		if len(order) != n {
+			return fmt.Errorf("correct_answer: must order all %d options", n)
+		}
+		return checkIndexes(order, n, true)
+	default: // multiple_choice, picture_choice
+		return checkIndexes([]int{answer.(int)}, n, false)
+	}
+}
+
+// Check reports whether answer is correct for the quiz; an answer that does not have the
+// shape of the question type is an error
+func Check(q *core.Record, answer json.RawMessage) (bool, error) {
+	typ := Type(q)
+	given, err := parseAnswer(typ, answer)
+	if err != nil {
+		return false, err
+	}
+	correct, err := parseAnswer(typ, []byte(q.GetString("correct_answer")))
+	if err != nil {
+		return false, fmt.Errorf("quiz %s: correct_answer: %w", q.Id, err)
+	}
+	switch c := correct.(type) {
+	case []int:
+		g := given.([]int)
+		if typ == schema.QuizMultiSelect {
+			c, g = sorted(c), sorted(g)
+		}
+		return slices.Equal(c, g), nil
+	default:
+		return given == correct, nil
+	}
+}
+
+// parseAnswer decodes an answer of the question type: int, bool or []int
+func parseAnswer(typ string, raw []byte) (any, error) {
+	switch typ {
+	case schema.QuizTrueFalse:
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return nil, fmt.Errorf("%s answer must be true or false", typ)
+		}
+		return b, nil
+	case schema.QuizMultiSelect, schema.QuizOrdering, schema.QuizMatching:
+		var indexes []int
+		if err := json.Unmarshal(raw, &indexes); err != nil {
+			return nil, fmt.Errorf("%s answer must be an array of option indexes", typ)
+		}
+		return indexes, nil
+	default:
+		var index int
+		if err := json.Unmarshal(raw, &index); err != nil {
+			return nil, fmt.Errorf("%s answer must be an option index", typ)
+		}
+		return index, nil
+	}
+}
+
+// optionCount: picture_choice counts option_images, other types the options array
+func optionCount(q *core.Record, typ string) (int, error) {
+	if typ == schema.QuizPictureChoice {
+		// saved file names + files being uploaded (request hooks run before the upload)
+		n := len(q.GetStringSlice("option_images")) + len(q.GetUnsavedFiles("option_images"))
+		if n < 2 {
+			return 0, fmt.Errorf("option_images: picture_choice needs at least 2 images")
+		}
+		return n, nil
+	}
+	var options []string
+	if err := json.Unmarshal([]byte(q.GetString("options")), &options); err != nil || len(options) < 2 {
+		return 0, fmt.Errorf("options: %s needs an array of at least 2 strings", typ)
+	}
+	return len(options), nil
+}
+
+func checkIndexes(indexes []int, n int, distinct bool) error {
+	seen := map[int]bool{}
+	for _, i := range indexes {
+		if i < 0 || i >= n {
+			return fmt.Errorf("correct_answer: index %d out of range (0-%d)", i, n-1)
+		}
+		if distinct && seen[i] {
+			return fmt.Errorf("correct_answer: index %d repeated", i)
+		}
+		seen[i] = true
+	}
+	return nil
+}
+
+func sorted(s []int) []int {
+	s = slices.Clone(s)
+	slices.Sort(s)
+	return s
+}
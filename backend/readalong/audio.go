@@ -0,0 +1,128 @@
+package readalong
+
+import (
+	"math"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Audio check statuses
+const (
+	StatusOK      = "ok"
+	StatusBroken  = "broken"  // invalid structure or times
+	StatusDrifted = "drifted" // valid, but does not match the chapter text any more
+)
+
+// AudioCheck is the word_timings check of one chapter_audios record
+type AudioCheck struct {
+	AudioID   string `json:"audio"`
+	ChapterID string `json:"chapter"`
+	StoryID   string `json:"story"`
+	Narrator  string `json:"narrator"`
+	Status    string `json:"status"`
+	// ChapterEditedAfter: the chapter was updated after the timings (likely cause of a drift)
+	ChapterEditedAfter bool `json:"chapter_edited_after"`
+	*Report
+}
+
+// CheckAudio normalizes and checks the word_timings of a chapter_audios record against the
+// chapter content and audio_duration (seconds). Returns nil timings and report when the
+// audio has no timings.
+func CheckAudio(audio, chapter *core.Record) ([]WordTiming, *Report, error) {
+	timings, err := Normalize([]byte(audio.GetString("word_timings")))
+	if err != nil || timings == nil {
+		return nil, nil, err
+	}
+	var tokens []string
+	if chapter != nil {
+		tokens = Tokenize(chapter.GetString("content"))
+	}
+	durationMs := int(math.Round(audio.GetFloat("audio_duration") * 1000))
+	return timings, Check(timings, tokens, durationMs), nil
+}
+
+// SetSegments stores the paragraph / sentence segments of the timings in
+// chapter_audios.segments (cleared without timings or chapter); not saved
+func SetSegments(audio, chapter *core.Record, timings []WordTiming) {
+	if len(timings) == 0 || chapter == nil {
+		audio.Set("segments", nil)
+		return
+	}
+	audio.Set("segments", Segment(chapter.GetString("content"), timings))
+}
+
+// RefreshChapterSegments recomputes the segments of every audio of the chapter with word
+// timings (after the chapter text was edited)
+func RefreshChapterSegments(app core.App, chapter *core.Record) error {
+	audios, err := app.FindRecordsByFilter("chapter_audios", "chapter = {:chapter}", "", 0, 0,
+		dbx.Params{"chapter": chapter.Id})
+	if err != nil {
+		return err
+	}
+	for _, audio := range audios {
+		timings, err := Normalize([]byte(audio.GetString("word_timings")))
+		if err != nil || timings == nil {
+			continue
+		}
+		SetSegments(audio, chapter, timings)
+		if err := app.Save(audio); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckAllAudios checks every chapter_audios record with word_timings; all=false lists the
+// broken and drifted ones only
+func CheckAllAudios(app core.App, all bool) ([]AudioCheck, int, error) {
+	audios, err := app.FindRecordsByFilter("chapter_audios", "word_timings != null && word_timings != '' && word_timings != '[]'",
+		"chapter", 0, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	var ids []string
+	for _, a := range audios {
+		ids = append(ids, a.GetString("chapter"))
+	}
+	chapters := map[string]*core.Record{}
+	if len(ids) > 0 {
+		records, err := app.FindRecordsByIds("chapters", ids)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, c := range records {
+			chapters[c.Id] = c
+		}
+	}
+
+	checks := []AudioCheck{}
+	checked := 0
+	for _, audio := range audios {
+		chapter := chapters[audio.GetString("chapter")]
+		check := AudioCheck{AudioID: audio.Id, ChapterID: audio.GetString("chapter"), Narrator: audio.GetString("narrator"), Status: StatusOK}
+		if chapter != nil {
+			check.StoryID = chapter.GetString("story")
+			check.ChapterEditedAfter = chapter.GetDateTime("updated").After(audio.GetDateTime("updated"))
+		}
+		_, report, err := CheckAudio(audio, chapter)
+		switch {
+		case err != nil:
+			check.Status = StatusBroken
+			check.Report = &Report{Issues: []Issue{{Code: IssueInvalid, Index: -1, Message: err.Error()}}}
+		case report == nil:
+			continue
+		case report.Broken():
+			check.Status, check.Report = StatusBroken, report
+		case len(report.Issues) > 0:
+			check.Status, check.Report = StatusDrifted, report
+		default:
+			check.Report = report
+		}
+		checked++
+		if all || check.Status != StatusOK {
+			checks = append(checks, check)
+		}
+	}
+	return checks, checked, nil
+}
@@ -0,0 +1,150 @@
+package readalong
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// alignWindow: how many word_timings entries ahead a chapter word is looked for
+const alignWindow = 8
+
+var paragraphPattern = regexp.MustCompile(`(?is)<p\b[^>]*>(.*?)</p>`)
+
+// Sentence is a sentence of a paragraph with its time range in the audio. WordStart/WordEnd
+// are the word_timings entries it covers (-1 when none matched; times are then those of the
+// surrounding sentences).
+type Sentence struct {
+	Index     int    `json:"index"`
+	Text      string `json:"text"`
+	StartMs   int    `json:"start_ms"`
+	EndMs     int    `json:"end_ms"`
+	WordStart int    `json:"word_start"`
+	WordEnd   int    `json:"word_end"`
+}
+
+// Paragraph is a <p> block of the chapter with its sentences
+type Paragraph struct {
+	Index     int        `json:"index"`
+	StartMs   int        `json:"start_ms"`
+	EndMs     int        `json:"end_ms"`
+	Sentences []Sentence `json:"sentences"`
+}
+
+// Paragraphs splits chapters.content (HTML) into the plain text of its <p> blocks (the whole
+// text when there are none)
+func Paragraphs(content string) []string {
+	var blocks []string
+	for _, m := range paragraphPattern.FindAllStringSubmatch(content, -1) {
+		blocks = append(blocks, m[1])
+	}
+	if len(blocks) == 0 {
+		blocks = []string{content}
+	}
+	var paragraphs []string
+	for _, block := range blocks {
+		text := strings.Join(strings.Fields(html.UnescapeString(tagPattern.ReplaceAllString(block, " "))), " ")
+		if text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+	}
+	return paragraphs
+}
+
+// Sentences splits a paragraph after . ! ? … (and closing quotes / brackets following them)
+func Sentences(paragraph string) []string {
+	var sentences []string
+	runes := []rune(paragraph)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		if !strings.ContainsRune(".!?…。", runes[i]) {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && (strings.ContainsRune(".!?…。", runes[end]) || isClosing(runes[end])) {
+			end++
+		}
+		if end < len(runes) && !unicode.IsSpace(runes[end]) {
+			continue // 3.5, a.m. ...
+		}
+		if s := strings.TrimSpace(string(runes[start:end])); s != "" {
+			sentences = append(sentences, s)
+		}
+		start, i = end, end
+	}
+	if s := strings.TrimSpace(string(runes[start:])); s != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+func isClosing(r rune) bool {
+	return strings.ContainsRune(`"'”’」』)]`, r)
+}
+
+// Segment derives the paragraph and sentence time ranges from the word timings: the words of
+// each sentence are matched in order with the timings (looking up to alignWindow entries
+// ahead, so missing or extra words are skipped). Without timings every range is 0.
+func Segment(content string, timings []WordTiming) []Paragraph {
+	words := make([]string, len(timings))
+	for i, t := range timings {
+		words[i] = normalizeWord(t.Word)
+	}
+
+	paragraphs := []Paragraph{}
+	var all []*Sentence
+	next := 0 // next word_timings entry to match
+	for pi, text := range Paragraphs(content) {
+		p := Paragraph{Index: pi, Sentences: []Sentence{}}
+		for si, sentence := range Sentences(text) {
+			s := Sentence{Index: si, Text: sentence, WordStart: -1, WordEnd: -1}
+			for _, field := range strings.Fields(sentence) {
+				token := normalizeWord(field)
+				if token == "" {
+					continue
+				}
+				for j := next; j < len(words) && j < next+alignWindow; j++ {
+					if words[j] == token {
+						if s.WordStart < 0 {
+							s.WordStart = j
+						}
+						s.WordEnd, next = j, j+1
+						break
+					}
+				}
+			}
+			p.Sentences = append(p.Sentences, s)
+		}
+		paragraphs = append(paragraphs, p)
+	}
+	for pi := range paragraphs {
+		for si := range paragraphs[pi].Sentences {
+			all = append(all, &paragraphs[pi].Sentences[si])
+		}
+	}
+
+	// Times of the matched words; unmatched sentences span the gap between their neighbours
+	prevEnd := 0
+	for i, s := range all {
+		if s.WordStart >= 0 {
+			s.StartMs, s.EndMs = timings[s.WordStart].StartMs, timings[s.WordEnd].EndMs
+			prevEnd = s.EndMs
+			continue
+		}
+		s.StartMs, s.EndMs = prevEnd, prevEnd
+		for _, later := range all[i+1:] {
+			if later.WordStart >= 0 {
+				s.EndMs = max(timings[later.WordStart].StartMs, prevEnd)
+				break
+			}
+		}
+	}
+	for pi := range paragraphs {
+		p := &paragraphs[pi]
+		if len(p.Sentences) > 0 {
+			p.StartMs, p.EndMs = p.Sentences[0].StartMs, p.Sentences[len(p.Sentences)-1].EndMs
+		}
+	}
+	return paragraphs
+}
@@ -0,0 +1,229 @@
+// Package readalong validates chapter_audios.word_timings against the chapter text and
+// the audio duration.
+package readalong
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// MinCoverage is the share of chapter words the timings must match (in order)
+	MinCoverage = 0.9
+	// DurationToleranceMs: timings may end this much after audio_duration (encoder padding)
+	DurationToleranceMs = 500
+	// OverlapToleranceMs: a word may start this much before the previous one ends
+	OverlapToleranceMs = 100
+	// maxIssues listed per check
+	maxIssues = 20
+)
+
+// Issue codes
+const (
+	IssueInvalid        = "invalid"    // not a [{word, start_ms, end_ms}] list
+	IssueEmptyWord      = "empty_word" // word is blank
+	IssueEndBeforeStart = "end_before_start"
+	IssueNotMonotonic   = "not_monotonic" // starts before the previous word
+	IssueOutOfBounds    = "out_of_bounds" // ends after audio_duration
+	IssueLowCoverage    = "low_coverage"  // does not match the chapter text (edited since?)
+)
+
+// WordTiming is one word_timings entry (milliseconds from the start of the audio)
+type WordTiming struct {
+	Word    string `json:"word"`
+	StartMs int    `json:"start_ms"`
+	EndMs   int    `json:"end_ms"`
+}
+
+// Issue is one problem found by Check; Index is the word_timings entry (-1 for the whole list)
+type Issue struct {
+	Code    string `json:"code"`
+	Index   int    `json:"index"`
+	Message string `json:"message"`
+}
+
+// Report is the result of Check
+type Report struct {
+	Words    int     `json:"words"`    // word_timings entries
+	Tokens   int     `json:"tokens"`   // words of the chapter text
+	Matched  int     `json:"matched"`  // entries matching the text in order
+	Coverage float64 `json:"coverage"` // matched / tokens
+	Issues   []Issue `json:"issues"`
+}
+
+// Broken: structure or time issues (not only low coverage)
+func (r *Report) Broken() bool {
+	for _, issue := range r.Issues {
+		if issue.Code != IssueLowCoverage {
+			return true
+		}
+	}
+	return false
+}
+
+// Error summarizes the first issues (for a 400 response)
+func (r *Report) Error() string {
+	var parts []string
+	for i, issue := range r.Issues {
+		if i == 3 {
+			parts = append(parts, fmt.Sprintf("and %d more", len(r.Issues)-i))
+			break
+		}
+		parts = append(parts, issue.Message)
+	}
+	return "word_timings: " + strings.Join(parts, "; ")
+}
+
+func (r *Report) add(code string, index int, format string, args ...any) {
+	if len(r.Issues) < maxIssues {
+		r.Issues = append(r.Issues, Issue{Code: code, Index: index, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// rawTiming accepts the shapes produced by the TTS / alignment tools: start_ms/end_ms, or
+// start_time/end_time and start/end in seconds; text for word
+type rawTiming struct {
+	Word      *string  `json:"word"`
+	Text      *string  `json:"text"`
+	StartMs   *float64 `json:"start_ms"`
+	EndMs     *float64 `json:"end_ms"`
+	StartTime *float64 `json:"start_time"`
+	EndTime   *float64 `json:"end_time"`
+	Start     *float64 `json:"start"`
+	End       *float64 `json:"end"`
+}
+
+func (t rawTiming) times() (start, end *float64) {
+	seconds := func(v *float64) *float64 {
+		if v == nil {
+			return nil
+		}
+		ms := *v * 1000
+		return &ms
+	}
+	switch {
+	case t.StartMs != nil || t.EndMs != nil:
+		return t.StartMs, t.EndMs
+	case t.StartTime != nil || t.EndTime != nil:
+		return seconds(t.StartTime), seconds(t.EndTime)
+	}
+	return seconds(t.Start), seconds(t.End)
+}
+
+// Normalize parses word_timings into the canonical [{word, start_ms, end_ms}] form: words
+// trimmed, times in whole milliseconds. Empty (null, "", []) gives nil.
+func Normalize(raw []byte) ([]WordTiming, error) {
+	s := strings.TrimSpace(string(raw))
+	if s == "" || s == "null" || s == `""` || s == "[]" {
+		return nil, nil
+	}
+	var items []rawTiming
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, errors.New("word_timings: must be a list of {word, start_ms, end_ms}")
+	}
+	timings := make([]WordTiming, 0, len(items))
+	for i, item := range items {
+		word := item.Word
+		if word == nil {
+			word = item.Text
+		}
+		start, end := item.times()
+		if word == nil || start == nil || end == nil {
+			return nil, fmt.Errorf("word_timings[%d]: word, start_ms and end_ms are required", i)
+		}
+		if *start < 0 || *end < 0 {
+			return nil, fmt.Errorf("word_timings[%d]: negative time", i)
+		}
+		timings = append(timings, WordTiming{
+			Word:    strings.TrimSpace(*word),
+			StartMs: int(math.Round(*start)),
+			EndMs:   int(math.Round(*end)),
+		})
+	}
+	return timings, nil
+}
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// Tokenize splits chapters.content (HTML) into the words read aloud: tags removed, entities
+// decoded, punctuation around words dropped, lower case
+func Tokenize(content string) []string {
+	text := html.UnescapeString(tagPattern.ReplaceAllString(content, " "))
+	var tokens []string
+	for _, field := range strings.Fields(text) {
+		if token := normalizeWord(field); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+func normalizeWord(word string) string {
+	trim := func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r) }
+	return strings.ToLower(strings.TrimFunc(word, trim))
+}
+
+// Check validates the timings: non-empty words, end >= start, starts in order (small overlaps
+// allowed), within durationMs (0 = unknown, not checked) and matching at least MinCoverage
+// of tokens (the chapter text, see Tokenize) in order. No tokens: coverage is not checked.
+func Check(timings []WordTiming, tokens []string, durationMs int) *Report {
+	r := &Report{Words: len(timings), Tokens: len(tokens), Issues: []Issue{}}
+	for i, t := range timings {
+		if normalizeWord(t.Word) == "" {
+			r.add(IssueEmptyWord, i, "word %d is empty", i)
+		}
+		if t.EndMs < t.StartMs {
+			r.add(IssueEndBeforeStart, i, "word %d (%q) ends at %dms before it starts at %dms", i, t.Word, t.EndMs, t.StartMs)
+		}
+		if i > 0 {
+			prev := timings[i-1]
+			if t.StartMs < prev.StartMs || t.StartMs < prev.EndMs-OverlapToleranceMs {
+				r.add(IssueNotMonotonic, i, "word %d (%q) starts at %dms, before word %d ends at %dms", i, t.Word, t.StartMs, i-1, prev.EndMs)
+			}
+		}
+		if durationMs > 0 && t.EndMs > durationMs+DurationToleranceMs {
+			r.add(IssueOutOfBounds, i, "word %d (%q) ends at %dms, after the audio (%dms)", i, t.Word, t.EndMs, durationMs)
+		}
+	}
+
+	if len(tokens) == 0 {
+		return r
+	}
+	words := make([]string, len(timings))
+	for i, t := range timings {
+		words[i] = normalizeWord(t.Word)
+	}
+	r.Matched = matchInOrder(words, tokens)
+	r.Coverage = math.Round(float64(r.Matched)/float64(len(tokens))*1000) / 1000
+	if r.Coverage < MinCoverage {
+		r.add(IssueLowCoverage, -1, "timings match %d of %d words of the chapter text (%.0f%%, min %.0f%%)",
+			r.Matched, len(tokens), r.Coverage*100, MinCoverage*100)
+	}
+	return r
+}
+
+// matchInOrder is the length of the longest common subsequence of a and b
+func matchInOrder(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] >= cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
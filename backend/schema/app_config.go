@@ -49,7 +49,7 @@ func SeedAppConfig(app core.App) {
 
 // EnsureAppConfigCollection ensures the app_config collection exists
 // Single-record or key-value config: address, phone, email, social links, etc.
-func EnsureAppConfigCollection(app core.App) {
+func EnsureAppConfigCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("app_config")
 	if err != nil {
 		collection = core.NewBaseCollection("app_config")
@@ -83,6 +83,6 @@ func EnsureAppConfigCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
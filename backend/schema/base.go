@@ -1,22 +1,12 @@
 package schema
 
 import (
-	"log"
 	"strings"
 
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/tools/types"
 )
 
-// SaveCollection saves the collection and logs the result
-func SaveCollection(app core.App, collection *core.Collection) {
-	if err := app.Save(collection); err != nil {
-		log.Printf("Failed to save collection %s: %v", collection.Name, err)
-	} else {
-		log.Printf("Collection %s saved successfully", collection.Name)
-	}
-}
-
 // Ptr returns a pointer to the given value
 func Ptr[T any](v T) *T {
 	return &v
@@ -48,167 +38,183 @@ func hasIndex(collection *core.Collection, name string) bool {
 	return false
 }
 
+// addField declares a field: it is added when missing and replaces an existing field of the
+// same name (keeping its id), so the collection holds the declaration the drift check
+// compares with the live one. Returns true when the declaration differs.
+func addField(collection *core.Collection, field core.Field) bool {
+	existing := collection.Fields.GetByName(field.GetName())
+	if existing != nil {
+		field.SetId(existing.GetId())
+		if fieldOptions(existing) == fieldOptions(field) {
+			return false
+		}
+	}
+	collection.Fields.Add(field)
+	return true
+}
+
 // AddTextField adds a text field if missing
 func AddTextField(collection *core.Collection, name string, required bool) bool {
-	if collection.Fields.GetByName(name) != nil {
-		return false
-	}
-	collection.Fields.Add(&core.TextField{
+	return addField(collection, &core.TextField{
 		Name:     name,
 		Required: required,
 	})
-	return true
 }
 
 // AddNumberField adds a number field if missing
 func AddNumberField(collection *core.Collection, name string, required bool, min, max *float64) bool {
-	if collection.Fields.GetByName(name) != nil {
-		return false
-	}
-	collection.Fields.Add(&core.NumberField{
+	return addField(collection, &core.NumberField{
 		Name:     name,
 		Required: required,
 		Min:      min,
 		Max:      max,
 	})
-	return true
 }
 
 // AddBoolField adds a bool field if missing
 func AddBoolField(collection *core.Collection, name string) bool {
-	if collection.Fields.GetByName(name) != nil {
-		return false
-	}
-	collection.Fields.Add(&core.BoolField{
+	return addField(collection, &core.BoolField{
 		Name: name,
 	})
-	return true
 }
 
 // AddSelectField adds a select field if missing
 func AddSelectField(collection *core.Collection, name string, required bool, values []string, maxSelect int) bool {
-	if collection.Fields.GetByName(name) != nil {
-		return false
-	}
-	collection.Fields.Add(&core.SelectField{
+	return addField(collection, &core.SelectField{
 		Name:      name,
 		Required:  required,
 		Values:    values,
 		MaxSelect: maxSelect,
 	})
-	return true
 }
 
 // AddFileField adds a file field if missing
 func AddFileField(collection *core.Collection, name string, maxSelect int, maxSize int64, mimeTypes []string) bool {
-	if collection.Fields.GetByName(name) != nil {
-		return false
-	}
-	collection.Fields.Add(&core.FileField{
+	return addField(collection, &core.FileField{
 		Name:      name,
 		MaxSelect: maxSelect,
 		MaxSize:   maxSize,
 		MimeTypes: mimeTypes,
 	})
-	return true
 }
 
 // AddRelationField adds a relation field if missing.
 // targetCollectionName can be collection name (e.g. "users") or id.
 // Resolves to actual CollectionId - required because PocketBase validates relation exists.
 func AddRelationField(app core.App, collection *core.Collection, name string, targetCollectionName string, required bool, maxSelect int, cascadeDelete bool) bool {
-	if collection.Fields.GetByName(name) != nil {
-		return false
-	}
 	targetCol, err := app.FindCollectionByNameOrId(targetCollectionName)
 	if err != nil {
 		return false
 	}
-	collection.Fields.Add(&core.RelationField{
+	return addField(collection, &core.RelationField{
 		Name:          name,
 		CollectionId:  targetCol.Id,
 		Required:      required,
 		MaxSelect:     maxSelect,
 		CascadeDelete: cascadeDelete,
 	})
-	return true
 }
 
 // AddJSONField adds a JSON field if missing
 func AddJSONField(collection *core.Collection, name string, required bool) bool {
-	if collection.Fields.GetByName(name) != nil {
-		return false
-	}
-	collection.Fields.Add(&core.JSONField{
+	return addField(collection, &core.JSONField{
+		Name:     name,
+		Required: required,
+	})
+}
+
+// AddDateField adds a date field if missing
+func AddDateField(collection *core.Collection, name string, required bool) bool {
+	return addField(collection, &core.DateField{
 		Name:     name,
 		Required: required,
 	})
-	return true
 }
 
 // AddSystemFields adds created and updated timestamp fields if missing
 func AddSystemFields(collection *core.Collection) bool {
-	changes := false
-	// Add created field
-	if collection.Fields.GetByName("created") == nil {
-		collection.Fields.Add(&core.AutodateField{
-			Name:     "created",
-			OnCreate: true,
-			OnUpdate: false,
-		})
-		changes = true
-	}
-	// Add updated field
-	if collection.Fields.GetByName("updated") == nil {
-		collection.Fields.Add(&core.AutodateField{
-			Name:     "updated",
-			OnCreate: true,
-			OnUpdate: true,
-		})
-		changes = true
-	}
-	return changes
+	created := addField(collection, &core.AutodateField{
+		Name:     "created",
+		OnCreate: true,
+		OnUpdate: false,
+	})
+	updated := addField(collection, &core.AutodateField{
+		Name:     "updated",
+		OnCreate: true,
+		OnUpdate: true,
+	})
+	return created || updated
 }
 
-// LockRule is a sentinel value for SetRules. Pass for create/update/delete to restrict to admin only (nil rule).
+// LockRule is a sentinel value for SetRules. Pass for any rule to restrict it to admin only (nil rule).
 const LockRule = "__lock__"
 
 func SetRules(collection *core.Collection, list, view, create, update, delete string) bool {
 	changed := false
-	if collection.ListRule == nil || *collection.ListRule != list {
-		collection.ListRule = types.Pointer(list)
-		changed = true
-	}
-	if collection.ViewRule == nil || *collection.ViewRule != view {
-		collection.ViewRule = types.Pointer(view)
-		changed = true
-	}
-	if create == LockRule {
-		if collection.CreateRule != nil {
-			collection.CreateRule = nil
+	for _, r := range []struct {
+		rule  **string
+		value string
+	}{
+		{&collection.ListRule, list},
+		{&collection.ViewRule, view},
+		{&collection.CreateRule, create},
+		{&collection.UpdateRule, update},
+		{&collection.DeleteRule, delete},
+	} {
+		if r.value == LockRule {
+			if *r.rule != nil {
+				*r.rule = nil
+				changed = true
+			}
+		} else if *r.rule == nil || **r.rule != r.value {
+			*r.rule = types.Pointer(r.value)
 			changed = true
 		}
-	} else if collection.CreateRule == nil || *collection.CreateRule != create {
-		collection.CreateRule = types.Pointer(create)
-		changed = true
 	}
-	if update == LockRule {
-		if collection.UpdateRule != nil {
-			collection.UpdateRule = nil
-			changed = true
-		}
-	} else if collection.UpdateRule == nil || *collection.UpdateRule != update {
-		collection.UpdateRule = types.Pointer(update)
-		changed = true
+	return changed
+}
+
+// SetSelectValues replaces the allowed values of an existing select field.
+// Unlike AddSelectField it also updates fields that already exist.
+func SetSelectValues(collection *core.Collection, name string, values []string) bool {
+	f, ok := collection.Fields.GetByName(name).(*core.SelectField)
+	if !ok {
+		return false
 	}
-	if delete == LockRule {
-		if collection.DeleteRule != nil {
-			collection.DeleteRule = nil
-			changed = true
-		}
-	} else if collection.DeleteRule == nil || *collection.DeleteRule != delete {
-		collection.DeleteRule = types.Pointer(delete)
-		changed = true
+	if strings.Join(f.Values, ",") == strings.Join(values, ",") {
+		return false
 	}
-	return changed
+	f.Values = values
+	return true
+}
+
+// SetHidden hides (or shows) an existing field in API responses; hidden fields are only
+// returned to superusers and cannot be used in filters by other callers
+func SetHidden(collection *core.Collection, name string, hidden bool) bool {
+	f := collection.Fields.GetByName(name)
+	if f == nil || f.GetHidden() == hidden {
+		return false
+	}
+	f.SetHidden(hidden)
+	return true
+}
+
+// RenameField renames an existing field (data is kept, the column is renamed on save)
+func RenameField(collection *core.Collection, oldName, newName string) bool {
+	f := collection.Fields.GetByName(oldName)
+	if f == nil || collection.Fields.GetByName(newName) != nil {
+		return false
+	}
+	f.SetName(newName)
+	return true
+}
+
+// RemoveField drops a field if it exists
+func RemoveField(collection *core.Collection, name string) bool {
+	f := collection.Fields.GetByName(name)
+	if f == nil {
+		return false
+	}
+	collection.Fields.RemoveById(f.GetId())
+	return true
 }
@@ -5,7 +5,7 @@ import (
 )
 
 // EnsureReadLaterCollection ensures the read_later collection exists (save-for-later, separate from favorites)
-func EnsureReadLaterCollection(app core.App) {
+func EnsureReadLaterCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("read_later")
 	if err != nil {
 		collection = core.NewBaseCollection("read_later")
@@ -35,6 +35,6 @@ func EnsureReadLaterCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -6,9 +6,17 @@ import (
 	"github.com/pocketbase/pocketbase/core"
 )
 
+var (
+	// AudioFormats are the allowed values of chapter_audios.audio_format
+	AudioFormats = []string{"mp3", "m4a", "wav", "webm"}
+	// AudioMimeTypes are the allowed (sniffed) mime types of chapter_audios.audio_file;
+	// iTunes-style M4A files are detected as audio/x-m4a
+	AudioMimeTypes = []string{"audio/mpeg", "audio/mp4", "audio/x-m4a", "audio/wav", "audio/webm"}
+)
+
 // EnsureChapterAudiosCollection ensures the chapter_audios collection exists.
 // One chapter can have multiple audio versions (different narrators/voices).
-func EnsureChapterAudiosCollection(app core.App) {
+func EnsureChapterAudiosCollection(app core.App, r *Reconciler) {
 	chaptersCollection, err := app.FindCollectionByNameOrId("chapters")
 	if err != nil {
 		log.Printf("Chapters collection not found, skipping chapter_audios creation")
@@ -27,29 +35,56 @@ func EnsureChapterAudiosCollection(app core.App) {
 		changes = true
 	}
 
-	if collection.Fields.GetByName("chapter") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "chapter",
-			CollectionId:  chaptersCollection.Id,
-			Required:      true,
-			MaxSelect:     1,
-			CascadeDelete: true,
-		})
+	if addField(collection, &core.RelationField{
+		Name:          "chapter",
+		CollectionId:  chaptersCollection.Id,
+		Required:      true,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	}) {
 		changes = true
 	}
-	// narrator: tên giọng đọc (e.g. "Clova Female", "Clova Male")
+	// narrator: tên giọng đọc (e.g. "여자", "남자"), kept as narrators.display_name of
+	// narrator_ref for older app versions
 	if AddTextField(collection, "narrator", false) {
 		changes = true
 	}
-	if AddFileField(collection, "audio_file", 1, 52428800, []string{"audio/mpeg", "audio/mp4", "audio/wav", "audio/webm"}) {
+	if AddRelationField(app, collection, "narrator_ref", "narrators", false, 1, false) {
+		changes = true
+	}
+	if AddFileField(collection, "audio_file", 1, 52428800, AudioMimeTypes) {
 		changes = true
 	}
 	if AddNumberField(collection, "audio_duration", false, nil, nil) {
 		changes = true
 	}
+	// File metadata read from the audio_file headers on upload (server-written, see audiometa);
+	// audio_duration is overwritten with the real length
+	if AddSelectField(collection, "audio_format", false, AudioFormats, 1) {
+		changes = true
+	}
+	if AddNumberField(collection, "sample_rate", false, nil, nil) {
+		changes = true
+	}
+	if AddNumberField(collection, "channels", false, nil, nil) {
+		changes = true
+	}
+	// bitrate: average kbps
+	if AddNumberField(collection, "bitrate", false, nil, nil) {
+		changes = true
+	}
+	// loudness_db: RMS level in dBFS, measured for PCM WAV only (0 = not measured)
+	if AddNumberField(collection, "loudness_db", false, nil, nil) {
+		changes = true
+	}
 	if AddJSONField(collection, "word_timings", false) {
 		changes = true
 	}
+	// segments: paragraph / sentence time ranges derived from word_timings (server-written,
+	// see readalong.Segment)
+	if AddJSONField(collection, "segments", false) {
+		changes = true
+	}
 
 	if AddSystemFields(collection) {
 		changes = true
@@ -60,6 +95,6 @@ func EnsureChapterAudiosCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -7,7 +7,7 @@ import (
 )
 
 // EnsureChaptersCollection ensures the chapters collection exists
-func EnsureChaptersCollection(app core.App) {
+func EnsureChaptersCollection(app core.App, r *Reconciler) {
 	// Get stories collection ID first
 	storiesCollection, err := app.FindCollectionByNameOrId("stories")
 	if err != nil {
@@ -27,14 +27,13 @@ func EnsureChaptersCollection(app core.App) {
 	}
 
 	// Add relation field with correct CollectionId
-	if collection.Fields.GetByName("story") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "story",
-			CollectionId:  storiesCollection.Id,
-			Required:      true,
-			MaxSelect:     1,
-			CascadeDelete: true,
-		})
+	if addField(collection, &core.RelationField{
+		Name:          "story",
+		CollectionId:  storiesCollection.Id,
+		Required:      true,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	}) {
 		changes = true
 	}
 	if AddNumberField(collection, "chapter_number", true, Ptr(1.0), Ptr(1000.0)) {
@@ -43,11 +42,10 @@ func EnsureChaptersCollection(app core.App) {
 	if AddTextField(collection, "title", true) {
 		changes = true
 	}
-	if f := collection.Fields.GetByName("content"); f == nil {
-		collection.Fields.Add(&core.EditorField{
-			Name:     "content",
-			Required: true,
-		})
+	if addField(collection, &core.EditorField{
+		Name:     "content",
+		Required: true,
+	}) {
 		changes = true
 	}
 	// Audio moved to chapter_audios collection (multiple voices per chapter)
@@ -71,6 +69,6 @@ func EnsureChaptersCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -0,0 +1,36 @@
+package schema
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// EnsureClassGroupsCollection ensures the class_groups collection exists: a class (created by
+// an admin for a teacher) with the join code children enter in user_preferences.class_code.
+// Class leaderboards rank the members of each class.
+func EnsureClassGroupsCollection(app core.App, r *Reconciler) {
+	collection, err := app.FindCollectionByNameOrId("class_groups")
+	if err != nil {
+		collection = core.NewBaseCollection("class_groups")
+	}
+
+	changes := false
+	// Admin only: codes must not be listed by clients
+	if SetRules(collection, LockRule, LockRule, LockRule, LockRule, LockRule) {
+		changes = true
+	}
+	if AddTextField(collection, "name", true) {
+		changes = true
+	}
+	if AddTextField(collection, "code", true) {
+		changes = true
+	}
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_class_groups_code", true, "code", "") {
+		changes = true
+	}
+	if changes {
+		r.save(app, collection)
+	}
+}
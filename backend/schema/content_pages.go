@@ -117,7 +117,7 @@ func SeedContentPages(app core.App) {
 
 // EnsureContentPagesCollection ensures the content_pages collection exists
 // Used for Privacy Policy, Terms of Service, etc. - editable in admin
-func EnsureContentPagesCollection(app core.App) {
+func EnsureContentPagesCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("content_pages")
 	if err != nil {
 		collection = core.NewBaseCollection("content_pages")
@@ -136,11 +136,10 @@ func EnsureContentPagesCollection(app core.App) {
 	if AddTextField(collection, "title", true) {
 		changes = true
 	}
-	if f := collection.Fields.GetByName("content"); f == nil {
-		collection.Fields.Add(&core.EditorField{
-			Name:     "content",
-			Required: false,
-		})
+	if addField(collection, &core.EditorField{
+		Name:     "content",
+		Required: false,
+	}) {
 		changes = true
 	}
 	// locale for i18n (optional: ko, en, vi)
@@ -161,6 +160,6 @@ func EnsureContentPagesCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -4,8 +4,11 @@ import (
 	"github.com/pocketbase/pocketbase/core"
 )
 
+// DictionaryCategories are the allowed values of dictionary.category
+var DictionaryCategories = []string{"hanja", "old_korean", "name", "place"}
+
 // EnsureDictionaryCollection ensures the dictionary collection exists
-func EnsureDictionaryCollection(app core.App) {
+func EnsureDictionaryCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("dictionary")
 	if err != nil {
 		collection = core.NewBaseCollection("dictionary")
@@ -23,21 +26,19 @@ func EnsureDictionaryCollection(app core.App) {
 	if AddTextField(collection, "reading", false) {
 		changes = true
 	}
-	if f := collection.Fields.GetByName("meaning"); f == nil {
-		collection.Fields.Add(&core.EditorField{
-			Name:     "meaning",
-			Required: true,
-		})
+	if addField(collection, &core.EditorField{
+		Name:     "meaning",
+		Required: true,
+	}) {
 		changes = true
 	}
-	if f := collection.Fields.GetByName("example"); f == nil {
-		collection.Fields.Add(&core.EditorField{
-			Name:     "example",
-			Required: false,
-		})
+	if addField(collection, &core.EditorField{
+		Name:     "example",
+		Required: false,
+	}) {
 		changes = true
 	}
-	if AddSelectField(collection, "category", true, []string{"hanja", "old_korean", "name", "place"}, 1) {
+	if AddSelectField(collection, "category", true, DictionaryCategories, 1) {
 		changes = true
 	}
 
@@ -51,6 +52,6 @@ func EnsureDictionaryCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -0,0 +1,367 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Drift is one difference between the schema declared in code (Ensure*) and the live database
+type Drift struct {
+	Collection string
+	Field      string // empty for collection-level drift (missing collection, rules, indexes)
+	Property   string // collection, field, type, hidden, required, min, max, values, max_select, max_size, mime_types, collection_id, cascade_delete, on_create, on_update, options, rules, index
+	Declared   string
+	Live       string
+	Safe       bool // can be applied without invalidating existing data
+	Applied    bool
+}
+
+func (d Drift) String() string {
+	target := d.Collection
+	if d.Field != "" {
+		target += "." + d.Field
+	}
+	state := "unsafe, needs a migration"
+	switch {
+	case d.Applied:
+		state = "applied"
+	case d.Safe:
+		state = "safe"
+	}
+	return fmt.Sprintf("%s %s: declared %s, live %s (%s)", target, d.Property, d.Declared, d.Live, state)
+}
+
+// Reconciler collects the drift found while the Ensure* declarations are saved (see
+// CheckDrift); with apply, safe differences are saved on the live collections.
+type Reconciler struct {
+	apply bool
+	items []Drift
+}
+
+func (r *Reconciler) add(d Drift) {
+	d.Applied = r.apply && d.Safe
+	r.items = append(r.items, d)
+}
+
+// saveFailed clears Applied for a collection whose save was rejected
+func (r *Reconciler) saveFailed(collection string) {
+	for i := range r.items {
+		if r.items[i].Collection == collection {
+			r.items[i].Applied = false
+		}
+	}
+}
+
+// CheckDrift runs the Ensure* declarations and returns every difference with the live
+// collections. With apply, safe differences (missing fields/collections, relaxed
+// constraints, added select values...) are saved; unsafe ones are only reported.
+func CheckDrift(app core.App, apply bool) []Drift {
+	r := &Reconciler{apply: apply}
+	EnsureAllSchema(app, r)
+	return r.items
+}
+
+// LogDrift logs schema drift without changing anything (startup check)
+func LogDrift(app core.App) {
+	items := CheckDrift(app, false)
+	for _, d := range items {
+		log.Printf("schema drift: %s", d)
+	}
+	if len(items) > 0 {
+		log.Printf("schema drift: %d difference(s), run `drift --apply` or add a migration", len(items))
+	}
+}
+
+// save compares a declared collection with the live one. With apply, a missing collection
+// is created and the safe differences are saved on the live collection.
+func (r *Reconciler) save(app core.App, declared *core.Collection) {
+	live, err := app.FindCollectionByNameOrId(declared.Name)
+	if err != nil {
+		r.add(Drift{Collection: declared.Name, Property: "collection", Declared: "present", Live: "missing", Safe: true})
+		if r.apply {
+			r.persist(app, declared)
+		}
+		return
+	}
+
+	changed := false
+	for _, f := range declared.Fields {
+		existing := live.Fields.GetByName(f.GetName())
+		if existing == nil {
+			r.add(Drift{Collection: declared.Name, Field: f.GetName(), Property: "field", Declared: f.Type(), Live: "missing", Safe: true})
+			if r.apply {
+				live.Fields.Add(f)
+				changed = true
+			}
+			continue
+		}
+		if r.reconcileField(live, existing, f) {
+			changed = true
+		}
+	}
+	rules := []struct {
+		name           string
+		declared, live **string
+	}{
+		{"listRule", &declared.ListRule, &live.ListRule},
+		{"viewRule", &declared.ViewRule, &live.ViewRule},
+		{"createRule", &declared.CreateRule, &live.CreateRule},
+		{"updateRule", &declared.UpdateRule, &live.UpdateRule},
+		{"deleteRule", &declared.DeleteRule, &live.DeleteRule},
+	}
+	for _, rule := range rules {
+		if ruleString(*rule.declared) != ruleString(*rule.live) {
+			r.add(Drift{Collection: declared.Name, Property: "rules", Field: rule.name, Declared: ruleString(*rule.declared), Live: ruleString(*rule.live), Safe: true})
+			if r.apply {
+				*rule.live = *rule.declared
+				changed = true
+			}
+		}
+	}
+	for _, idx := range declared.Indexes {
+		if !slices.Contains(live.Indexes, idx) {
+			r.add(Drift{Collection: declared.Name, Property: "index", Declared: idx, Live: "missing", Safe: true})
+			if r.apply {
+				live.Indexes = append(live.Indexes, idx)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		r.persist(app, live)
+	}
+}
+
+func (r *Reconciler) persist(app core.App, collection *core.Collection) {
+	if err := app.Save(collection); err != nil {
+		r.saveFailed(collection.Name)
+		log.Printf("Failed to save collection %s: %v", collection.Name, err)
+	} else {
+		log.Printf("Collection %s saved successfully", collection.Name)
+	}
+}
+
+// reconcileField compares a live field with its declaration. Known properties are reported
+// one by one and the safe ones applied to the live field with apply; any other difference
+// (text pattern, only_int, email domains...) is reported as "options". Returns true when
+// the live field was changed.
+func (r *Reconciler) reconcileField(collection *core.Collection, existing, declared core.Field) bool {
+	base := Drift{Collection: collection.Name, Field: declared.GetName()}
+	report := func(property, declaredVal, liveVal string, safe bool) bool {
+		d := base
+		d.Property, d.Declared, d.Live, d.Safe = property, declaredVal, liveVal, safe
+		r.add(d)
+		return r.apply && safe
+	}
+
+	if existing.Type() != declared.Type() {
+		report("type", declared.Type(), existing.Type(), false)
+		return false
+	}
+
+	reported := len(r.items)
+	changed := false
+	// showing a hidden field exposes its values to every caller
+	if existing.GetHidden() != declared.GetHidden() && report("hidden", fmt.Sprint(declared.GetHidden()), fmt.Sprint(existing.GetHidden()), declared.GetHidden()) {
+		existing.SetHidden(declared.GetHidden())
+		changed = true
+	}
+	switch d := declared.(type) {
+	case *core.TextField:
+		e := existing.(*core.TextField)
+		if e.Required != d.Required && report("required", fmt.Sprint(d.Required), fmt.Sprint(e.Required), !d.Required) {
+			e.Required = d.Required
+			changed = true
+		}
+	case *core.EditorField:
+		e := existing.(*core.EditorField)
+		if e.Required != d.Required && report("required", fmt.Sprint(d.Required), fmt.Sprint(e.Required), !d.Required) {
+			e.Required = d.Required
+			changed = true
+		}
+		// 0 means PocketBase's default limit
+		if editorMaxSize(e) != editorMaxSize(d) && report("max_size", fmt.Sprint(editorMaxSize(d)), fmt.Sprint(editorMaxSize(e)), editorMaxSize(d) > editorMaxSize(e)) {
+			e.MaxSize = d.MaxSize
+			changed = true
+		}
+	case *core.EmailField:
+		e := existing.(*core.EmailField)
+		if e.Required != d.Required && report("required", fmt.Sprint(d.Required), fmt.Sprint(e.Required), !d.Required) {
+			e.Required = d.Required
+			changed = true
+		}
+	case *core.BoolField:
+		e := existing.(*core.BoolField)
+		if e.Required != d.Required && report("required", fmt.Sprint(d.Required), fmt.Sprint(e.Required), !d.Required) {
+			e.Required = d.Required
+			changed = true
+		}
+	case *core.NumberField:
+		e := existing.(*core.NumberField)
+		if e.Required != d.Required && report("required", fmt.Sprint(d.Required), fmt.Sprint(e.Required), !d.Required) {
+			e.Required = d.Required
+			changed = true
+		}
+		if !floatPtrEqual(e.Min, d.Min) && report("min", floatPtrString(d.Min), floatPtrString(e.Min), d.Min == nil || (e.Min != nil && *d.Min < *e.Min)) {
+			e.Min = d.Min
+			changed = true
+		}
+		if !floatPtrEqual(e.Max, d.Max) && report("max", floatPtrString(d.Max), floatPtrString(e.Max), d.Max == nil || (e.Max != nil && *d.Max > *e.Max)) {
+			e.Max = d.Max
+			changed = true
+		}
+	case *core.SelectField:
+		e := existing.(*core.SelectField)
+		if e.Required != d.Required && report("required", fmt.Sprint(d.Required), fmt.Sprint(e.Required), !d.Required) {
+			e.Required = d.Required
+			changed = true
+		}
+		if !slices.Equal(e.Values, d.Values) && report("values", fmt.Sprint(d.Values), fmt.Sprint(e.Values), isSuperset(d.Values, e.Values)) {
+			e.Values = d.Values
+			changed = true
+		}
+		if e.MaxSelect != d.MaxSelect && report("max_select", fmt.Sprint(d.MaxSelect), fmt.Sprint(e.MaxSelect), d.MaxSelect > e.MaxSelect) {
+			e.MaxSelect = d.MaxSelect
+			changed = true
+		}
+	case *core.FileField:
+		e := existing.(*core.FileField)
+		if e.MaxSelect != d.MaxSelect && report("max_select", fmt.Sprint(d.MaxSelect), fmt.Sprint(e.MaxSelect), d.MaxSelect > e.MaxSelect) {
+			e.MaxSelect = d.MaxSelect
+			changed = true
+		}
+		// 0 means PocketBase's default limit
+		if fileMaxSize(e) != fileMaxSize(d) && report("max_size", fmt.Sprint(fileMaxSize(d)), fmt.Sprint(fileMaxSize(e)), fileMaxSize(d) > fileMaxSize(e)) {
+			e.MaxSize = d.MaxSize
+			changed = true
+		}
+		if !slices.Equal(e.MimeTypes, d.MimeTypes) && report("mime_types", fmt.Sprint(d.MimeTypes), fmt.Sprint(e.MimeTypes), len(d.MimeTypes) == 0 || isSuperset(d.MimeTypes, e.MimeTypes)) {
+			e.MimeTypes = d.MimeTypes
+			changed = true
+		}
+	case *core.RelationField:
+		e := existing.(*core.RelationField)
+		if e.CollectionId != d.CollectionId {
+			report("collection_id", d.CollectionId, e.CollectionId, false)
+		}
+		if e.Required != d.Required && report("required", fmt.Sprint(d.Required), fmt.Sprint(e.Required), !d.Required) {
+			e.Required = d.Required
+			changed = true
+		}
+		if e.MaxSelect != d.MaxSelect && report("max_select", fmt.Sprint(d.MaxSelect), fmt.Sprint(e.MaxSelect), d.MaxSelect > e.MaxSelect) {
+			e.MaxSelect = d.MaxSelect
+			changed = true
+		}
+		// deleting the target deletes (or keeps) these records from then on
+		if e.CascadeDelete != d.CascadeDelete {
+			report("cascade_delete", fmt.Sprint(d.CascadeDelete), fmt.Sprint(e.CascadeDelete), false)
+		}
+	case *core.JSONField:
+		e := existing.(*core.JSONField)
+		if e.Required != d.Required && report("required", fmt.Sprint(d.Required), fmt.Sprint(e.Required), !d.Required) {
+			e.Required = d.Required
+			changed = true
+		}
+	case *core.DateField:
+		e := existing.(*core.DateField)
+		if e.Required != d.Required && report("required", fmt.Sprint(d.Required), fmt.Sprint(e.Required), !d.Required) {
+			e.Required = d.Required
+			changed = true
+		}
+	case *core.AutodateField:
+		e := existing.(*core.AutodateField)
+		if e.OnCreate != d.OnCreate && report("on_create", fmt.Sprint(d.OnCreate), fmt.Sprint(e.OnCreate), true) {
+			e.OnCreate = d.OnCreate
+			changed = true
+		}
+		if e.OnUpdate != d.OnUpdate && report("on_update", fmt.Sprint(d.OnUpdate), fmt.Sprint(e.OnUpdate), true) {
+			e.OnUpdate = d.OnUpdate
+			changed = true
+		}
+	}
+	if len(r.items) == reported {
+		if declaredOpts, liveOpts := fieldOptions(declared), fieldOptions(existing); declaredOpts != liveOpts {
+			report("options", declaredOpts, liveOpts, false)
+		}
+	}
+	return changed
+}
+
+// fieldOptions is the JSON of a field without its id, so a declaration and a live field of
+// the same name compare equal when every option matches
+func fieldOptions(f core.Field) string {
+	raw, err := json.Marshal(f)
+	if err != nil {
+		return ""
+	}
+	var options map[string]any
+	if err := json.Unmarshal(raw, &options); err != nil {
+		return string(raw)
+	}
+	delete(options, "id")
+	raw, _ = json.Marshal(options)
+	return string(raw)
+}
+
+func editorMaxSize(f *core.EditorField) int64 {
+	if f.MaxSize <= 0 {
+		return core.DefaultEditorFieldMaxSize
+	}
+	return f.MaxSize
+}
+
+func fileMaxSize(f *core.FileField) int64 {
+	if f.MaxSize <= 0 {
+		return core.DefaultFileFieldMaxSize
+	}
+	return f.MaxSize
+}
+
+func ruleString(rule *string) string {
+	if rule == nil {
+		return "<locked>"
+	}
+	return fmt.Sprintf("%q", *rule)
+}
+
+func floatPtrEqual(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+func floatPtrString(v *float64) string {
+	if v == nil {
+		return "<none>"
+	}
+	return fmt.Sprint(*v)
+}
+
+// isSuperset reports whether declared contains every live value (only additions)
+func isSuperset(declared, live []string) bool {
+	for _, v := range live {
+		if !slices.Contains(declared, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// PendingDrift returns the number of unapplied differences and how many of those are unsafe
+func PendingDrift(items []Drift) (pending, unsafe int) {
+	for _, d := range items {
+		if d.Applied {
+			continue
+		}
+		pending++
+		if !d.Safe {
+			unsafe++
+		}
+	}
+	return pending, unsafe
+}
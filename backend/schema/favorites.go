@@ -5,7 +5,7 @@ import (
 )
 
 // EnsureFavoritesCollection ensures the favorites collection exists
-func EnsureFavoritesCollection(app core.App) {
+func EnsureFavoritesCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("favorites")
 	if err != nil {
 		collection = core.NewBaseCollection("favorites")
@@ -35,6 +35,6 @@ func EnsureFavoritesCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
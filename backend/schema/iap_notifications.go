@@ -0,0 +1,66 @@
+package schema
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// IAPNotificationResults are the outcomes recorded for store server notifications
+var IAPNotificationResults = []string{"processed", "unmatched", "ignored", "rejected", "failed"}
+
+// EnsureIAPNotificationsCollection is the audit log of store server notifications
+// (App Store Server Notifications v2, Google Play RTDN). Admin only.
+// notification_id: store-side id (Apple notificationUUID / Pub/Sub messageId), used for idempotency
+// transaction_id: original transaction id (Apple) / purchase token order id (Google) matched in iap_verifications
+func EnsureIAPNotificationsCollection(app core.App, r *Reconciler) {
+	collection, err := app.FindCollectionByNameOrId("iap_notifications")
+	if err != nil {
+		collection = core.NewBaseCollection("iap_notifications")
+	}
+	changes := false
+	if SetRules(collection, LockRule, LockRule, LockRule, LockRule, LockRule) {
+		changes = true
+	}
+	if AddSelectField(collection, "platform", true, []string{"ios", "android"}, 1) {
+		changes = true
+	}
+	if AddTextField(collection, "notification_id", false) {
+		changes = true
+	}
+	if AddTextField(collection, "notification_type", false) {
+		changes = true
+	}
+	if AddTextField(collection, "subtype", false) {
+		changes = true
+	}
+	if AddTextField(collection, "environment", false) {
+		changes = true
+	}
+	if AddTextField(collection, "transaction_id", false) {
+		changes = true
+	}
+	if AddTextField(collection, "product_id", false) {
+		changes = true
+	}
+	if AddSelectField(collection, "result", true, IAPNotificationResults, 1) {
+		changes = true
+	}
+	if AddTextField(collection, "error", false) {
+		changes = true
+	}
+	// Decoded (verified) payload
+	if AddJSONField(collection, "payload", false) {
+		changes = true
+	}
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_iap_notifications_id", true, "platform,notification_id", "notification_id != ''") {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_iap_notifications_tx", false, "transaction_id", "") {
+		changes = true
+	}
+	if changes {
+		r.save(app, collection)
+	}
+}
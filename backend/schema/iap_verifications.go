@@ -5,15 +5,18 @@ import (
 )
 
 // EnsureIAPVerificationsCollection stores verified IAP purchases (device_id, transaction_id)
-// Used to add is_premium to chapter API responses
-func EnsureIAPVerificationsCollection(app core.App) {
+// Used to add is_premium to chapter API responses.
+// user: account the purchase is linked to (empty = guest purchase, matched by device_id)
+// purchase_token, auto_renewing, subscription_state: Google Play subscription state (RTDN / subscriptionsv2)
+func EnsureIAPVerificationsCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("iap_verifications")
 	if err != nil {
 		collection = core.NewBaseCollection("iap_verifications")
 	}
 	changes := false
-	// No auth - we write from /api/iap/verify, read from hooks
-	if SetRules(collection, "", "", "", "", "") {
+	// Written from /api/iap/verify, read from hooks (both bypass rules).
+	// Users can only see their own purchases; device IDs are never listed publicly.
+	if SetRules(collection, "@request.auth.id != '' && user = @request.auth.id", "@request.auth.id != '' && user = @request.auth.id", LockRule, LockRule, LockRule) {
 		changes = true
 	}
 	if AddTextField(collection, "device_id", true) {
@@ -31,13 +34,43 @@ func EnsureIAPVerificationsCollection(app core.App) {
 	if AddTextField(collection, "expires_at", false) {
 		changes = true
 	}
+	if AddRelationField(app, collection, "user", "users", false, 1, false) {
+		changes = true
+	}
+	if AddTextField(collection, "purchase_token", false) {
+		changes = true
+	}
+	if AddBoolField(collection, "auto_renewing") {
+		changes = true
+	}
+	if AddTextField(collection, "subscription_state", false) {
+		changes = true
+	}
+	// signedDate of the last App Store notification applied (out-of-order delivery)
+	if AddTextField(collection, "store_signed_at", false) {
+		changes = true
+	}
 	if AddSystemFields(collection) {
 		changes = true
 	}
-	if EnsureIndex(collection, "idx_iap_device_product", true, "device_id,product_id", "") {
+	// One row per purchase and account; guest rows (user = '') per purchase and device
+	if hasIndex(collection, "idx_iap_device_product") {
+		collection.RemoveIndex("idx_iap_device_product")
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_iap_device", false, "device_id", "") {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_iap_purchase", true, "platform,transaction_id,product_id,user,device_id", "") {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_iap_user", false, "user", "") {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_iap_purchase_token", false, "purchase_token", "") {
 		changes = true
 	}
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -4,29 +4,45 @@ import (
 	"github.com/pocketbase/pocketbase/core"
 )
 
-// EnsureAllSchema ensures all collections exist
-func EnsureAllSchema(app core.App) {
+// EnsureAllSchema ensures all collections exist.
+// It is the declared (target) schema; on startup schema changes are applied through
+// the versioned registry in package migrations; CheckDrift compares it with the live
+// collections.
+func EnsureAllSchema(app core.App, r *Reconciler) {
 	// Order matters: users first, then stories, chapters, then others
-	EnsureUsersExtendCollection(app)
-	EnsureStoriesCollection(app)
-	EnsureChaptersCollection(app)
-	EnsureChapterAudiosCollection(app)
-	EnsureQuizzesCollection(app)
-	EnsureReadingProgressCollection(app)
-	EnsureDictionaryCollection(app)
-	EnsureReportsCollection(app)
-	EnsureUserPreferencesCollection(app)
-	EnsureContentPagesCollection(app)
-	EnsureAppConfigCollection(app)
-	EnsureTrackingCollections(app)
-	EnsurePopularSearchesCacheCollection(app)
-	EnsureFavoritesCollection(app)
-	EnsureReadLaterCollection(app)
-	EnsureNotesCollection(app)
-	EnsureReviewsCollection(app)
-	EnsureViewsCollection(app)
-	EnsureStickersCollection(app)
-	EnsureUserStatsCollection(app)
-	EnsureUserStickersCollection(app)
-	EnsureIAPVerificationsCollection(app)
+	EnsureUsersExtendCollection(app, r)
+	EnsureStoriesCollection(app, r)
+	EnsureChaptersCollection(app, r)
+	EnsureNarratorsCollection(app, r)
+	EnsureChapterAudiosCollection(app, r)
+	EnsureQuizzesCollection(app, r)
+	EnsureReadingProgressCollection(app, r)
+	EnsureDictionaryCollection(app, r)
+	EnsureReportsCollection(app, r)
+	EnsureContentPagesCollection(app, r)
+	EnsureAppConfigCollection(app, r)
+	EnsureTrackingCollections(app, r)
+	EnsurePopularSearchesCacheCollection(app, r)
+	EnsureFavoritesCollection(app, r)
+	EnsureReadLaterCollection(app, r)
+	EnsureNotesCollection(app, r)
+	EnsureReviewsCollection(app, r)
+	EnsureViewsCollection(app, r)
+	EnsureStickersCollection(app, r)
+	EnsureUserStatsCollection(app, r)
+	EnsureUserStickersCollection(app, r)
+	EnsureXPRulesCollection(app, r)
+	EnsureXPLevelsCollection(app, r)
+	EnsureXPTransactionsCollection(app, r)
+	EnsureQuizAttemptsCollection(app, r)
+	EnsureStoryCompletionsCollection(app, r)
+	EnsureStoryProgressCollection(app, r)
+	EnsureUserPreferencesCollection(app, r)
+	EnsureClassGroupsCollection(app, r)
+	EnsureLeaderboardCollection(app, r)
+	EnsureReadingGoalsCollection(app, r)
+	EnsureGoalCompletionsCollection(app, r)
+	EnsureIAPVerificationsCollection(app, r)
+	EnsureIAPNotificationsCollection(app, r)
+	EnsurePurchaseEventsCollection(app, r)
 }
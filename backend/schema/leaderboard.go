@@ -0,0 +1,84 @@
+package schema
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Leaderboard periods and scopes (leaderboard.period / leaderboard.scope)
+const (
+	LeaderboardWeekly  = "weekly"
+	LeaderboardMonthly = "monthly"
+	LeaderboardAllTime = "all_time"
+
+	LeaderboardGlobal = "global"
+	LeaderboardClass  = "class"
+)
+
+var (
+	LeaderboardPeriods = []string{LeaderboardWeekly, LeaderboardMonthly, LeaderboardAllTime}
+	LeaderboardScopes  = []string{LeaderboardGlobal, LeaderboardClass}
+)
+
+// EnsureLeaderboardCollection ensures the leaderboard collection exists: the ranked rows of
+// every board, written by the leaderboard job. Rows show a generated nickname and an
+// avatar sticker only; user is hidden (clients read boards via GET /api/leaderboards).
+// period_start: first day of the week / month (empty for all_time).
+func EnsureLeaderboardCollection(app core.App, r *Reconciler) {
+	collection, err := app.FindCollectionByNameOrId("leaderboard")
+	if err != nil {
+		collection = core.NewBaseCollection("leaderboard")
+	}
+
+	changes := false
+	if SetRules(collection, LockRule, LockRule, LockRule, LockRule, LockRule) {
+		changes = true
+	}
+	if AddSelectField(collection, "period", true, LeaderboardPeriods, 1) {
+		changes = true
+	}
+	if AddTextField(collection, "period_start", false) {
+		changes = true
+	}
+	if AddSelectField(collection, "scope", true, LeaderboardScopes, 1) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "class_group", "class_groups", false, 1, true) {
+		changes = true
+	}
+	if AddNumberField(collection, "rank", true, Ptr(1.0), nil) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "user", "users", true, 1, true) {
+		changes = true
+	}
+	if SetHidden(collection, "user", true) {
+		changes = true
+	}
+	if AddTextField(collection, "nickname", true) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "avatar_sticker", "stickers", false, 1, false) {
+		changes = true
+	}
+	if AddNumberField(collection, "xp", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddNumberField(collection, "level", false, Ptr(1.0), Ptr(18.0)) {
+		changes = true
+	}
+	if AddNumberField(collection, "streak_days", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_leaderboard_board", false, "period,period_start,scope,class_group,rank", "") {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_leaderboard_user", false, "user", "") {
+		changes = true
+	}
+	if changes {
+		r.save(app, collection)
+	}
+}
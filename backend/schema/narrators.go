@@ -0,0 +1,130 @@
+package schema
+
+import (
+	"log"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Voice genders (narrators.gender)
+const (
+	NarratorFemale  = "female"
+	NarratorMale    = "male"
+	NarratorNeutral = "neutral"
+)
+
+// NarratorGenders are the allowed values of narrators.gender
+var NarratorGenders = []string{NarratorFemale, NarratorMale, NarratorNeutral}
+
+// TTSEngines are the allowed values of narrators.tts_engine (human: recorded by a person)
+var TTSEngines = []string{"openai", "clova", "kss", "xtts", "mms", "human", "other"}
+
+// DefaultNarrator is a seeded narrators row
+type DefaultNarrator struct {
+	DisplayName string
+	Gender      string
+	TTSEngine   string
+	SortOrder   float64
+}
+
+// DefaultNarrators are the voices made by the tools/ TTS scripts (add_yeoja_voice.py,
+// add_namja_voice.py, add_third_voice.py), seeded by the narrators migration
+var DefaultNarrators = []DefaultNarrator{
+	{"여자", NarratorFemale, "kss", 1},
+	{"남자", NarratorMale, "openai", 2},
+	{"페이블", NarratorNeutral, "openai", 3},
+}
+
+// narratorAliases map old free-text chapter_audios.narrator values (lower case, matched as
+// substrings in this order) to a narrator display_name
+var narratorAliases = []struct{ alias, name string }{
+	{"clova female", "여자"},
+	{"clova male", "남자"},
+	{"female", "여자"},
+	{"male", "남자"},
+	{"kss", "여자"},
+	{"mms", "남자"},
+	{"cô", "여자"},
+	{"chú", "남자"},
+}
+
+// NarratorName maps a chapter_audios.narrator text to a narrator display_name: known old
+// values (Clova Female...) to their voice, anything else trimmed
+func NarratorName(text string) string {
+	text = strings.TrimSpace(text)
+	lower := strings.ToLower(text)
+	for _, a := range narratorAliases {
+		if strings.Contains(lower, a.alias) {
+			return a.name
+		}
+	}
+	return text
+}
+
+// EnsureNarratorsCollection ensures the narrators collection exists: the voices of
+// chapter_audios (chapter_audios.narrator_ref), listed publicly by sort_order with a sample
+// clip. Audios of an is_premium narrator need premium. Admin-managed.
+func EnsureNarratorsCollection(app core.App, r *Reconciler) {
+	collection, err := app.FindCollectionByNameOrId("narrators")
+	if err != nil {
+		collection = core.NewBaseCollection("narrators")
+	}
+
+	changes := false
+	if SetRules(collection, "", "", LockRule, LockRule, LockRule) {
+		changes = true
+	}
+	// display_name: shown to children and copied to chapter_audios.narrator (e.g. 여자)
+	if AddTextField(collection, "display_name", true) {
+		changes = true
+	}
+	if AddSelectField(collection, "gender", false, NarratorGenders, 1) {
+		changes = true
+	}
+	if AddSelectField(collection, "tts_engine", false, TTSEngines, 1) {
+		changes = true
+	}
+	if AddFileField(collection, "sample_clip", 1, 5242880, AudioMimeTypes) {
+		changes = true
+	}
+	if AddNumberField(collection, "sort_order", false, nil, nil) {
+		changes = true
+	}
+	if AddBoolField(collection, "is_premium") {
+		changes = true
+	}
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_narrators_display_name", true, "display_name", "") {
+		changes = true
+	}
+	if changes {
+		r.save(app, collection)
+	}
+}
+
+// SeedNarrators creates the default narrators whose display_name doesn't exist
+func SeedNarrators(app core.App) {
+	col, err := app.FindCollectionByNameOrId("narrators")
+	if err != nil {
+		return
+	}
+	for _, d := range DefaultNarrators {
+		existing, _ := app.FindRecordsByFilter(col.Id, `display_name="`+escapeFilter(d.DisplayName)+`"`, "", 1, 0)
+		if len(existing) > 0 {
+			continue
+		}
+		rec := core.NewRecord(col)
+		rec.Set("display_name", d.DisplayName)
+		rec.Set("gender", d.Gender)
+		rec.Set("tts_engine", d.TTSEngine)
+		rec.Set("sort_order", d.SortOrder)
+		if err := app.Save(rec); err != nil {
+			log.Printf("narrators: seed %s failed: %v", d.DisplayName, err)
+		} else {
+			log.Printf("narrators: seeded %s", d.DisplayName)
+		}
+	}
+}
@@ -5,7 +5,7 @@ import (
 )
 
 // EnsureNotesCollection ensures the notes collection exists (user notes on stories)
-func EnsureNotesCollection(app core.App) {
+func EnsureNotesCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("notes")
 	if err != nil {
 		collection = core.NewBaseCollection("notes")
@@ -25,11 +25,10 @@ func EnsureNotesCollection(app core.App) {
 	if AddRelationField(app, collection, "chapter", "chapters", false, 1, true) {
 		changes = true
 	}
-	if f := collection.Fields.GetByName("note"); f == nil {
-		collection.Fields.Add(&core.EditorField{
-			Name:     "note",
-			Required: true,
-		})
+	if addField(collection, &core.EditorField{
+		Name:     "note",
+		Required: true,
+	}) {
 		changes = true
 	}
 	if AddNumberField(collection, "position", false, nil, nil) {
@@ -48,6 +47,6 @@ func EnsureNotesCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
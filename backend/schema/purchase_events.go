@@ -0,0 +1,73 @@
+package schema
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// PurchaseEventOutcomes: verified (store accepted), rejected (store/app error), blocked (replay policy)
+var PurchaseEventOutcomes = []string{"verified", "rejected", "blocked"}
+
+// EnsurePurchaseEventsCollection is the append-only log of every /api/iap/verify and
+// /api/iap/restore call: raw store response, request metadata and outcome. Admin only.
+// flagged: suspected abuse (e.g. one transaction unlocking too many devices/accounts)
+func EnsurePurchaseEventsCollection(app core.App, r *Reconciler) {
+	collection, err := app.FindCollectionByNameOrId("purchase_events")
+	if err != nil {
+		collection = core.NewBaseCollection("purchase_events")
+	}
+	changes := false
+	if SetRules(collection, LockRule, LockRule, LockRule, LockRule, LockRule) {
+		changes = true
+	}
+	if AddTextField(collection, "platform", false) {
+		changes = true
+	}
+	if AddTextField(collection, "device_id", false) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "user", "users", false, 1, false) {
+		changes = true
+	}
+	if AddTextField(collection, "product_id", false) {
+		changes = true
+	}
+	if AddTextField(collection, "transaction_id", false) {
+		changes = true
+	}
+	if AddTextField(collection, "environment", false) {
+		changes = true
+	}
+	if AddSelectField(collection, "outcome", true, PurchaseEventOutcomes, 1) {
+		changes = true
+	}
+	if AddNumberField(collection, "http_status", false, nil, nil) {
+		changes = true
+	}
+	if AddTextField(collection, "error", false) {
+		changes = true
+	}
+	if AddJSONField(collection, "store_response", false) {
+		changes = true
+	}
+	if AddJSONField(collection, "request", false) {
+		changes = true
+	}
+	if AddBoolField(collection, "flagged") {
+		changes = true
+	}
+	if AddTextField(collection, "flag_reason", false) {
+		changes = true
+	}
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_purchase_events_tx", false, "transaction_id", "") {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_purchase_events_flagged", false, "flagged", "flagged = TRUE") {
+		changes = true
+	}
+	if changes {
+		r.save(app, collection)
+	}
+}
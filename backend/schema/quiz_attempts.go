@@ -0,0 +1,54 @@
+package schema
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// EnsureQuizAttemptsCollection ensures the quiz_attempts collection exists: one row per
+// submission of a story quiz, graded server-side by POST /api/quizzes/{storyId}/submit
+// (the only way to create rows). results: per-question outcome, see gamification.QuizResult.
+// xp_awarded: XP earned by this attempt (only the first passed attempt of a story earns XP).
+func EnsureQuizAttemptsCollection(app core.App, r *Reconciler) {
+	collection, err := app.FindCollectionByNameOrId("quiz_attempts")
+	if err != nil {
+		collection = core.NewBaseCollection("quiz_attempts")
+	}
+
+	changes := false
+	if SetRules(collection, "user = @request.auth.id", "user = @request.auth.id", LockRule, LockRule, LockRule) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "user", "users", true, 1, true) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "story", "stories", true, 1, true) {
+		changes = true
+	}
+	if AddJSONField(collection, "answers", false) {
+		changes = true
+	}
+	if AddJSONField(collection, "results", false) {
+		changes = true
+	}
+	if AddNumberField(collection, "score", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddNumberField(collection, "total", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddBoolField(collection, "passed") {
+		changes = true
+	}
+	if AddNumberField(collection, "xp_awarded", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_quiz_attempts_user_story", false, "user,story", "") {
+		changes = true
+	}
+	if changes {
+		r.save(app, collection)
+	}
+}
@@ -4,8 +4,21 @@ import (
 	"github.com/pocketbase/pocketbase/core"
 )
 
+// Quiz question types (quizzes.question_type); answer shapes are documented in package quiz
+const (
+	QuizMultipleChoice = "multiple_choice"
+	QuizTrueFalse      = "true_false"
+	QuizMultiSelect    = "multi_select"
+	QuizOrdering       = "ordering"
+	QuizMatching       = "matching"
+	QuizPictureChoice  = "picture_choice"
+)
+
+// QuizQuestionTypes are the allowed values of quizzes.question_type
+var QuizQuestionTypes = []string{QuizMultipleChoice, QuizTrueFalse, QuizMultiSelect, QuizOrdering, QuizMatching, QuizPictureChoice}
+
 // EnsureQuizzesCollection ensures the quizzes collection exists
-func EnsureQuizzesCollection(app core.App) {
+func EnsureQuizzesCollection(app core.App, r *Reconciler) {
 	// Get related collection IDs first
 	storiesCollection, err := app.FindCollectionByNameOrId("stories")
 	if err != nil {
@@ -29,25 +42,23 @@ func EnsureQuizzesCollection(app core.App) {
 	}
 
 	// Add relation fields
-	if collection.Fields.GetByName("story") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "story",
-			CollectionId:  storiesCollection.Id,
-			Required:      true,
-			MaxSelect:     1,
-			CascadeDelete: true,
-		})
+	if addField(collection, &core.RelationField{
+		Name:          "story",
+		CollectionId:  storiesCollection.Id,
+		Required:      true,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	}) {
 		changes = true
 	}
 
-	if collection.Fields.GetByName("chapter") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "chapter",
-			CollectionId:  chaptersCollection.Id,
-			Required:      false, // Optional - quiz can be for whole story
-			MaxSelect:     1,
-			CascadeDelete: true,
-		})
+	if addField(collection, &core.RelationField{
+		Name:          "chapter",
+		CollectionId:  chaptersCollection.Id,
+		Required:      false, // Optional - quiz can be for whole story
+		MaxSelect:     1,
+		CascadeDelete: true,
+	}) {
 		changes = true
 	}
 
@@ -56,13 +67,24 @@ func EnsureQuizzesCollection(app core.App) {
 		changes = true
 	}
 
-	// Options array
-	if AddJSONField(collection, "options", true) {
+	// Question type (empty = multiple_choice)
+	if AddSelectField(collection, "question_type", false, QuizQuestionTypes, 1) {
+		changes = true
+	}
+
+	// Options: array of strings, {"left": [...], "right": [...]} for matching,
+	// optional for true_false and picture_choice (captions)
+	if AddJSONField(collection, "options", false) {
+		changes = true
+	}
+
+	// Pictures of picture_choice questions (option index = file index)
+	if AddFileField(collection, "option_images", 6, 2097152, []string{"image/jpeg", "image/png", "image/webp"}) {
 		changes = true
 	}
 
-	// Correct answer index (0-3)
-	if AddNumberField(collection, "correct_answer", true, Ptr(0.0), Ptr(3.0)) {
+	// Correct answer, shaped by question_type (validated by quiz.Validate)
+	if AddJSONField(collection, "correct_answer", true) {
 		changes = true
 	}
 
@@ -71,6 +93,15 @@ func EnsureQuizzesCollection(app core.App) {
 		changes = true
 	}
 
+	// Answers are graded server-side (POST /api/quizzes/{storyId}/submit): correct_answer
+	// and explanation are only returned to superusers and in the submit response
+	if SetHidden(collection, "correct_answer", true) {
+		changes = true
+	}
+	if SetHidden(collection, "explanation", true) {
+		changes = true
+	}
+
 	// Published flag
 	if AddBoolField(collection, "is_published") {
 		changes = true
@@ -93,6 +124,6 @@ func EnsureQuizzesCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
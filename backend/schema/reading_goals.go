@@ -0,0 +1,89 @@
+package schema
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Reading goal types (reading_goals fields, goal_completions.goal)
+const (
+	GoalChaptersPerDay = "chapters_per_day"
+	GoalMinutesPerDay  = "minutes_per_day"
+	GoalStoriesPerWeek = "stories_per_week"
+)
+
+// ReadingGoalTypes are the goals of reading_goals (0 = off)
+var ReadingGoalTypes = []string{GoalChaptersPerDay, GoalMinutesPerDay, GoalStoriesPerWeek}
+
+// EnsureReadingGoalsCollection ensures the reading_goals collection exists: 1 user = 1 record,
+// set by the parent from the Parent Zone of the child's account. Progress is computed by the
+// server (GET /api/goals/today) in the user's timezone; weeks start on Monday.
+func EnsureReadingGoalsCollection(app core.App, r *Reconciler) {
+	collection, err := app.FindCollectionByNameOrId("reading_goals")
+	if err != nil {
+		collection = core.NewBaseCollection("reading_goals")
+	}
+
+	changes := false
+	if SetRules(collection, "user = @request.auth.id", "user = @request.auth.id", "user = @request.auth.id", "user = @request.auth.id", "user = @request.auth.id") {
+		changes = true
+	}
+	if AddRelationField(app, collection, "user", "users", true, 1, true) {
+		changes = true
+	}
+	for _, goal := range ReadingGoalTypes {
+		if AddNumberField(collection, goal, false, Ptr(0.0), Ptr(1000.0)) {
+			changes = true
+		}
+	}
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_reading_goals_user", true, "user", "") {
+		changes = true
+	}
+	if changes {
+		r.save(app, collection)
+	}
+}
+
+// EnsureGoalCompletionsCollection ensures the goal_completions collection exists: one row per
+// goal met in a period (period_start: the day, or the Monday of the week), written by the
+// server with the goal_met XP. Never removed.
+func EnsureGoalCompletionsCollection(app core.App, r *Reconciler) {
+	collection, err := app.FindCollectionByNameOrId("goal_completions")
+	if err != nil {
+		collection = core.NewBaseCollection("goal_completions")
+	}
+
+	changes := false
+	if SetRules(collection, "user = @request.auth.id", "user = @request.auth.id", LockRule, LockRule, LockRule) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "user", "users", true, 1, true) {
+		changes = true
+	}
+	if AddSelectField(collection, "goal", true, ReadingGoalTypes, 1) {
+		changes = true
+	}
+	if AddTextField(collection, "period_start", true) {
+		changes = true
+	}
+	if AddNumberField(collection, "target", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddNumberField(collection, "value", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddNumberField(collection, "xp_awarded", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_goal_completions_user_goal_period", true, "user,goal,period_start", "") {
+		changes = true
+	}
+	if changes {
+		r.save(app, collection)
+	}
+}
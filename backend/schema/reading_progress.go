@@ -7,7 +7,7 @@ import (
 )
 
 // EnsureReadingProgressCollection ensures the reading_progress collection exists
-func EnsureReadingProgressCollection(app core.App) {
+func EnsureReadingProgressCollection(app core.App, r *Reconciler) {
 	// Get related collection IDs first
 	usersCollection, err := app.FindCollectionByNameOrId("users")
 	if err != nil {
@@ -37,25 +37,23 @@ func EnsureReadingProgressCollection(app core.App) {
 	}
 
 	// Add relation fields with correct CollectionIds
-	if collection.Fields.GetByName("user") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "user",
-			CollectionId:  usersCollection.Id,
-			Required:      true,
-			MaxSelect:     1,
-			CascadeDelete: true,
-		})
+	if addField(collection, &core.RelationField{
+		Name:          "user",
+		CollectionId:  usersCollection.Id,
+		Required:      true,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	}) {
 		changes = true
 	}
 
-	if collection.Fields.GetByName("chapter") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "chapter",
-			CollectionId:  chaptersCollection.Id,
-			Required:      true,
-			MaxSelect:     1,
-			CascadeDelete: true,
-		})
+	if addField(collection, &core.RelationField{
+		Name:          "chapter",
+		CollectionId:  chaptersCollection.Id,
+		Required:      true,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	}) {
 		changes = true
 	}
 	if AddNumberField(collection, "percent_read", true, Ptr(0.0), Ptr(100.0)) {
@@ -81,6 +79,6 @@ func EnsureReadingProgressCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -5,7 +5,7 @@ import (
 )
 
 // EnsureReportsCollection ensures the reports collection exists
-func EnsureReportsCollection(app core.App) {
+func EnsureReportsCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("reports")
 	if err != nil {
 		collection = core.NewBaseCollection("reports")
@@ -21,34 +21,25 @@ func EnsureReportsCollection(app core.App) {
 	if AddRelationField(app, collection, "user", "users", false, 1, false) {
 		changes = true
 	}
-	// Ensure existing user field is optional (for web reports)
-	if f := collection.Fields.GetByName("user"); f != nil {
-		if rf, ok := f.(*core.RelationField); ok && rf.Required {
-			rf.Required = false
-			changes = true
-		}
-	}
 	if AddSelectField(collection, "type", true, []string{"story", "chapter", "app", "question", "other"}, 1) {
 		changes = true
 	}
 	if AddTextField(collection, "target_id", false) {
 		changes = true
 	}
-	if f := collection.Fields.GetByName("reason"); f == nil {
-		collection.Fields.Add(&core.EditorField{
-			Name:     "reason",
-			Required: true,
-		})
+	if addField(collection, &core.EditorField{
+		Name:     "reason",
+		Required: true,
+	}) {
 		changes = true
 	}
 	if AddSelectField(collection, "status", true, []string{"pending", "reviewing", "resolved", "rejected"}, 1) {
 		changes = true
 	}
-	if f := collection.Fields.GetByName("admin_note"); f == nil {
-		collection.Fields.Add(&core.EditorField{
-			Name:     "admin_note",
-			Required: false,
-		})
+	if addField(collection, &core.EditorField{
+		Name:     "admin_note",
+		Required: false,
+	}) {
 		changes = true
 	}
 	if AddTextField(collection, "contact_email", false) {
@@ -74,6 +65,6 @@ func EnsureReportsCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
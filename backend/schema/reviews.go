@@ -5,7 +5,7 @@ import (
 )
 
 // EnsureReviewsCollection ensures the reviews collection exists
-func EnsureReviewsCollection(app core.App) {
+func EnsureReviewsCollection(app core.App, r *Reconciler) {
 	// Get related collection IDs first
 	usersCollection, err := app.FindCollectionByNameOrId("users")
 	if err != nil {
@@ -29,25 +29,23 @@ func EnsureReviewsCollection(app core.App) {
 	}
 
 	// Add relation fields
-	if collection.Fields.GetByName("user") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "user",
-			CollectionId:  usersCollection.Id,
-			Required:      true,
-			MaxSelect:     1,
-			CascadeDelete: true,
-		})
+	if addField(collection, &core.RelationField{
+		Name:          "user",
+		CollectionId:  usersCollection.Id,
+		Required:      true,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	}) {
 		changes = true
 	}
 
-	if collection.Fields.GetByName("story") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "story",
-			CollectionId:  storiesCollection.Id,
-			Required:      true,
-			MaxSelect:     1,
-			CascadeDelete: true,
-		})
+	if addField(collection, &core.RelationField{
+		Name:          "story",
+		CollectionId:  storiesCollection.Id,
+		Required:      true,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	}) {
 		changes = true
 	}
 
@@ -57,11 +55,10 @@ func EnsureReviewsCollection(app core.App) {
 	}
 
 	// Optional comment
-	if f := collection.Fields.GetByName("comment"); f == nil {
-		collection.Fields.Add(&core.EditorField{
-			Name:     "comment",
-			Required: false,
-		})
+	if addField(collection, &core.EditorField{
+		Name:     "comment",
+		Required: false,
+	}) {
 		changes = true
 	}
 
@@ -78,6 +75,6 @@ func EnsureReviewsCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -8,7 +8,7 @@ import (
 )
 
 // popular_searches_cache: aggregated from search_history, refreshed daily
-func EnsurePopularSearchesCacheCollection(app core.App) {
+func EnsurePopularSearchesCacheCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("popular_searches_cache")
 	if err != nil {
 		collection = core.NewBaseCollection("popular_searches_cache")
@@ -36,7 +36,7 @@ func EnsurePopularSearchesCacheCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
 
@@ -62,9 +62,54 @@ func SeedLevelStickers(app core.App) {
 	}
 }
 
+// StickerTypes: level (관직) | story | achievements unlocked by criteria
+// (streak, category, quiz, listening, review, goal)
+var StickerTypes = []string{"level", "story", "streak", "category", "quiz", "listening", "review", "goal"}
+
+// defaultAchievementStickers are seeded unpublished: publish after adding an image
+var defaultAchievementStickers = []struct {
+	typ, key, nameKo, descriptionKo, criteria string
+}{
+	{"streak", "streak_7", "일주일 독서왕", "7일 연속 읽기", `{"metric":"longest_streak","min":7}`},
+	{"streak", "streak_30", "한 달 독서왕", "30일 연속 읽기", `{"metric":"longest_streak","min":30}`},
+	{"category", "category_folktale", "전래동화 박사", "전래동화 모두 읽기", `{"metric":"category_completed","category":"folktale","min":1}`},
+	{"category", "category_history", "역사 박사", "역사 이야기 모두 읽기", `{"metric":"category_completed","category":"history","min":1}`},
+	{"quiz", "quiz_master_5", "퀴즈 달인", "퀴즈 5개 만점", `{"metric":"quizzes_mastered","min":5}`},
+	{"listening", "listening_60", "귀 기울이는 아이", "60분 듣기", `{"metric":"listening_minutes","min":60}`},
+	{"review", "first_review", "첫 리뷰", "첫 리뷰 쓰기", `{"metric":"reviews_written","min":1}`},
+	{"goal", "goals_7", "목표 달성왕", "읽기 목표 7번 달성", `{"metric":"goals_met","min":7}`},
+}
+
+// SeedAchievementStickers creates the default achievement stickers if their key doesn't exist
+func SeedAchievementStickers(app core.App) {
+	col, err := app.FindCollectionByNameOrId("stickers")
+	if err != nil || col.Fields.GetByName("criteria") == nil {
+		return
+	}
+	for i, d := range defaultAchievementStickers {
+		existing, _ := app.FindRecordsByFilter(col.Id, "key=\""+escapeFilter(d.key)+"\"", "", 1, 0)
+		if len(existing) > 0 {
+			continue
+		}
+		rec := core.NewRecord(col)
+		rec.Set("type", d.typ)
+		rec.Set("key", d.key)
+		rec.Set("name_ko", d.nameKo)
+		rec.Set("description_ko", d.descriptionKo)
+		rec.Set("criteria", d.criteria)
+		rec.Set("sort_order", float64(100+i))
+		rec.Set("is_published", false)
+		if err := app.Save(rec); err != nil {
+			log.Printf("stickers: seed %s failed: %v", d.key, err)
+		} else {
+			log.Printf("stickers: seeded %s %s", d.key, d.nameKo)
+		}
+	}
+}
+
 // EnsureStickersCollection ensures the stickers collection exists.
-// Type: level (관직) | story
-func EnsureStickersCollection(app core.App) {
+// Type: see StickerTypes
+func EnsureStickersCollection(app core.App, r *Reconciler) {
 	storiesCollection, _ := app.FindCollectionByNameOrId("stories")
 
 	collection, err := app.FindCollectionByNameOrId("stickers")
@@ -78,7 +123,7 @@ func EnsureStickersCollection(app core.App) {
 		changes = true
 	}
 
-	if AddSelectField(collection, "type", true, []string{"level", "story"}, 1) {
+	if AddSelectField(collection, "type", true, StickerTypes, 1) {
 		changes = true
 	}
 	if AddTextField(collection, "key", true) {
@@ -107,14 +152,19 @@ func EnsureStickersCollection(app core.App) {
 		changes = true
 	}
 	// For type=story: relation to stories
-	if storiesCollection != nil && collection.Fields.GetByName("story") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "story",
-			CollectionId:  storiesCollection.Id,
-			Required:      false,
-			MaxSelect:     1,
-			CascadeDelete: false,
-		})
+	if storiesCollection != nil && addField(collection, &core.RelationField{
+		Name:          "story",
+		CollectionId:  storiesCollection.Id,
+		Required:      false,
+		MaxSelect:     1,
+		CascadeDelete: false,
+	}) {
+		changes = true
+	}
+
+	// Achievements: declarative unlock criteria, e.g. {"metric":"longest_streak","min":7}
+	// or {"all":[...]} (see gamification.Criteria)
+	if AddJSONField(collection, "criteria", false) {
 		changes = true
 	}
 
@@ -130,6 +180,6 @@ func EnsureStickersCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -1,11 +1,46 @@
 package schema
 
 import (
+	"fmt"
+
 	"github.com/pocketbase/pocketbase/core"
 )
 
+// StoryCategories are the allowed values of stories.category
+var StoryCategories = []string{"folktale", "history", "legend", "edu"}
+
+// Age bounds for stories.age_min / stories.age_max
+const (
+	StoryAgeMin = 1.0
+	StoryAgeMax = 15.0
+)
+
+// ValidateStoryFields checks category and age range against the stories schema
+func ValidateStoryFields(category string, ageMin, ageMax float64) error {
+	valid := false
+	for _, c := range StoryCategories {
+		if c == category {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid category %q (allowed: %v)", category, StoryCategories)
+	}
+	if ageMin < StoryAgeMin || ageMin > StoryAgeMax {
+		return fmt.Errorf("age_min %v out of range %v-%v", ageMin, StoryAgeMin, StoryAgeMax)
+	}
+	if ageMax < StoryAgeMin || ageMax > StoryAgeMax {
+		return fmt.Errorf("age_max %v out of range %v-%v", ageMax, StoryAgeMin, StoryAgeMax)
+	}
+	if ageMin > ageMax {
+		return fmt.Errorf("age_min %v greater than age_max %v", ageMin, ageMax)
+	}
+	return nil
+}
+
 // EnsureStoriesCollection ensures the stories collection exists
-func EnsureStoriesCollection(app core.App) {
+func EnsureStoriesCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("stories")
 	if err != nil {
 		collection = core.NewBaseCollection("stories")
@@ -19,13 +54,13 @@ func EnsureStoriesCollection(app core.App) {
 	if AddTextField(collection, "title", true) {
 		changes = true
 	}
-	if AddSelectField(collection, "category", true, []string{"folktale", "history", "legend", "edu"}, 1) {
+	if AddSelectField(collection, "category", true, StoryCategories, 1) {
 		changes = true
 	}
-	if AddNumberField(collection, "age_min", true, Ptr(1.0), Ptr(15.0)) {
+	if AddNumberField(collection, "age_min", true, Ptr(StoryAgeMin), Ptr(StoryAgeMax)) {
 		changes = true
 	}
-	if AddNumberField(collection, "age_max", true, Ptr(1.0), Ptr(15.0)) {
+	if AddNumberField(collection, "age_max", true, Ptr(StoryAgeMin), Ptr(StoryAgeMax)) {
 		changes = true
 	}
 	if AddFileField(collection, "thumbnail", 1, 5242880, []string{"image/jpeg", "image/png", "image/webp"}) {
@@ -40,6 +75,19 @@ func EnsureStoriesCollection(app core.App) {
 	if AddJSONField(collection, "tags", false) {
 		changes = true
 	}
+	// Moral lessons / cultural notes (filled by the content importer)
+	if addField(collection, &core.EditorField{
+		Name:     "moral_lessons",
+		Required: false,
+	}) {
+		changes = true
+	}
+	if addField(collection, &core.EditorField{
+		Name:     "cultural_notes",
+		Required: false,
+	}) {
+		changes = true
+	}
 	if AddBoolField(collection, "is_published") {
 		changes = true
 	}
@@ -94,6 +142,6 @@ func EnsureStoriesCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -0,0 +1,42 @@
+package schema
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// EnsureStoryCompletionsCollection ensures the story_completions collection exists: one row
+// per story a user completed (written by the server, never removed). A story is complete
+// when every chapter is completed for premium users, every free chapter for the others;
+// premium: the user was premium when the story was completed.
+func EnsureStoryCompletionsCollection(app core.App, r *Reconciler) {
+	collection, err := app.FindCollectionByNameOrId("story_completions")
+	if err != nil {
+		collection = core.NewBaseCollection("story_completions")
+	}
+
+	changes := false
+	if SetRules(collection, "user = @request.auth.id", "user = @request.auth.id", LockRule, LockRule, LockRule) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "user", "users", true, 1, true) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "story", "stories", true, 1, true) {
+		changes = true
+	}
+	if AddDateField(collection, "completed_at", true) {
+		changes = true
+	}
+	if AddBoolField(collection, "premium") {
+		changes = true
+	}
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_story_completions_user_story", true, "user,story", "") {
+		changes = true
+	}
+	if changes {
+		r.save(app, collection)
+	}
+}
@@ -0,0 +1,51 @@
+package schema
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// EnsureStoryProgressCollection ensures the story_progress collection exists: per user and
+// story, the chapters of the story (total_chapters, free_chapters) and how many the user
+// completed (completed_chapters, completed_free_chapters). Maintained by the server from
+// reading_progress and chapters changes; used for story completion checks.
+func EnsureStoryProgressCollection(app core.App, r *Reconciler) {
+	collection, err := app.FindCollectionByNameOrId("story_progress")
+	if err != nil {
+		collection = core.NewBaseCollection("story_progress")
+	}
+
+	changes := false
+	if SetRules(collection, "user = @request.auth.id", "user = @request.auth.id", LockRule, LockRule, LockRule) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "user", "users", true, 1, true) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "story", "stories", true, 1, true) {
+		changes = true
+	}
+	if AddNumberField(collection, "completed_chapters", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddNumberField(collection, "completed_free_chapters", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddNumberField(collection, "total_chapters", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddNumberField(collection, "free_chapters", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_story_progress_user_story", true, "user,story", "") {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_story_progress_story", false, "story", "") {
+		changes = true
+	}
+	if changes {
+		r.save(app, collection)
+	}
+}
@@ -5,15 +5,15 @@ import (
 )
 
 // EnsureTrackingCollections ensures tracking collections exist
-func EnsureTrackingCollections(app core.App) {
-	EnsureReadingHistoryCollection(app)
-	EnsureListeningSessionsCollection(app)
-	EnsureSearchHistoryCollection(app)
-	EnsureAppEventsCollection(app)
+func EnsureTrackingCollections(app core.App, r *Reconciler) {
+	EnsureReadingHistoryCollection(app, r)
+	EnsureListeningSessionsCollection(app, r)
+	EnsureSearchHistoryCollection(app, r)
+	EnsureAppEventsCollection(app, r)
 }
 
 // Reading history - what user has read
-func EnsureReadingHistoryCollection(app core.App) {
+func EnsureReadingHistoryCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("reading_history")
 	if err != nil {
 		collection = core.NewBaseCollection("reading_history")
@@ -45,6 +45,10 @@ func EnsureReadingHistoryCollection(app core.App) {
 	if AddTextField(collection, "device_info", false) {
 		changes = true
 	}
+	// created: reading goals count the day's rows
+	if AddSystemFields(collection) {
+		changes = true
+	}
 
 	if EnsureIndex(collection, "idx_history_user", false, "user", "") {
 		changes = true
@@ -54,12 +58,12 @@ func EnsureReadingHistoryCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
 
 // Listening sessions - track audio listening
-func EnsureListeningSessionsCollection(app core.App) {
+func EnsureListeningSessionsCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("listening_sessions")
 	if err != nil {
 		collection = core.NewBaseCollection("listening_sessions")
@@ -91,14 +95,21 @@ func EnsureListeningSessionsCollection(app core.App) {
 	if AddTextField(collection, "device_info", false) {
 		changes = true
 	}
+	// created / updated: reading goals count the day's sessions
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_listening_user", false, "user", "") {
+		changes = true
+	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
 
 // Search history
-func EnsureSearchHistoryCollection(app core.App) {
+func EnsureSearchHistoryCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("search_history")
 	if err != nil {
 		collection = core.NewBaseCollection("search_history")
@@ -130,12 +141,12 @@ func EnsureSearchHistoryCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
 
 // App events - general analytics
-func EnsureAppEventsCollection(app core.App) {
+func EnsureAppEventsCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("app_events")
 	if err != nil {
 		collection = core.NewBaseCollection("app_events")
@@ -186,6 +197,6 @@ func EnsureAppEventsCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
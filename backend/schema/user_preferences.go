@@ -6,7 +6,7 @@ import (
 
 // EnsureUserPreferencesCollection ensures the user_preferences collection exists
 // Used to sync theme, notification settings, etc. across devices
-func EnsureUserPreferencesCollection(app core.App) {
+func EnsureUserPreferencesCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("user_preferences")
 	if err != nil {
 		collection = core.NewBaseCollection("user_preferences")
@@ -29,6 +29,24 @@ func EnsureUserPreferencesCollection(app core.App) {
 	if AddBoolField(collection, "notifications_enabled") {
 		changes = true
 	}
+	// IANA timezone for streaks (e.g. Asia/Seoul, the default when empty)
+	if AddTextField(collection, "timezone", false) {
+		changes = true
+	}
+	// Leaderboards: parent opt-out, join code of a class_groups row, avatar (an unlocked sticker)
+	if AddBoolField(collection, "leaderboard_opt_out") {
+		changes = true
+	}
+	if AddTextField(collection, "class_code", false) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "avatar_sticker", "stickers", false, 1, false) {
+		changes = true
+	}
+	// Audios of this narrator come first in chapter_audios lists
+	if AddRelationField(app, collection, "preferred_narrator", "narrators", false, 1, false) {
+		changes = true
+	}
 	// Optional: other preference keys as JSON for future extensibility
 	if AddJSONField(collection, "extra", false) {
 		changes = true
@@ -43,6 +61,6 @@ func EnsureUserPreferencesCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -8,7 +8,7 @@ import (
 
 // EnsureUserStatsCollection ensures the user_stats collection exists.
 // 1 user = 1 record (upsert on create)
-func EnsureUserStatsCollection(app core.App) {
+func EnsureUserStatsCollection(app core.App, r *Reconciler) {
 	usersCollection, err := app.FindCollectionByNameOrId("users")
 	if err != nil {
 		log.Printf("Users collection not found, skipping user_stats creation")
@@ -20,24 +20,24 @@ func EnsureUserStatsCollection(app core.App) {
 		collection = core.NewBaseCollection("user_stats")
 	}
 
+	// Read-only for the owner: XP, levels, counters and streaks are written by the server only
 	changes := false
 	if SetRules(collection,
 		"user = @request.auth.id",
 		"user = @request.auth.id",
-		"user = @request.auth.id",
-		"user = @request.auth.id",
-		"user = @request.auth.id") {
+		LockRule,
+		LockRule,
+		LockRule) {
 		changes = true
 	}
 
-	if collection.Fields.GetByName("user") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "user",
-			CollectionId:  usersCollection.Id,
-			Required:      true,
-			MaxSelect:     1,
-			CascadeDelete: true,
-		})
+	if addField(collection, &core.RelationField{
+		Name:          "user",
+		CollectionId:  usersCollection.Id,
+		Required:      true,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	}) {
 		changes = true
 	}
 	if AddNumberField(collection, "total_xp", false, Ptr(0.0), nil) {
@@ -52,6 +52,17 @@ func EnsureUserStatsCollection(app core.App) {
 	if AddTextField(collection, "last_activity_date", false) {
 		changes = true
 	}
+	// Streak days are local dates in user_preferences.timezone.
+	// last_streak_date: last day covered by reading or a freeze token
+	if AddNumberField(collection, "longest_streak", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddNumberField(collection, "streak_freezes", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddTextField(collection, "last_streak_date", false) {
+		changes = true
+	}
 	if AddNumberField(collection, "chapters_read", false, Ptr(0.0), nil) {
 		changes = true
 	}
@@ -61,6 +72,10 @@ func EnsureUserStatsCollection(app core.App) {
 	if AddNumberField(collection, "stories_completed", false, Ptr(0.0), nil) {
 		changes = true
 	}
+	// Stories whose quiz was passed at least once (quiz_attempts.passed)
+	if AddNumberField(collection, "quizzes_passed", false, Ptr(0.0), nil) {
+		changes = true
+	}
 
 	if AddSystemFields(collection) {
 		changes = true
@@ -71,6 +86,6 @@ func EnsureUserStatsCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -6,8 +6,11 @@ import (
 	"github.com/pocketbase/pocketbase/core"
 )
 
+// StickerUnlockSources: how a user_stickers row was unlocked
+var StickerUnlockSources = []string{"level_up", "story_complete", "achievement"}
+
 // EnsureUserStickersCollection ensures the user_stickers collection exists.
-func EnsureUserStickersCollection(app core.App) {
+func EnsureUserStickersCollection(app core.App, r *Reconciler) {
 	usersCollection, err := app.FindCollectionByNameOrId("users")
 	if err != nil {
 		log.Printf("Users collection not found, skipping user_stickers creation")
@@ -35,27 +38,25 @@ func EnsureUserStickersCollection(app core.App) {
 		changes = true
 	}
 
-	if collection.Fields.GetByName("user") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "user",
-			CollectionId:  usersCollection.Id,
-			Required:      true,
-			MaxSelect:     1,
-			CascadeDelete: true,
-		})
+	if addField(collection, &core.RelationField{
+		Name:          "user",
+		CollectionId:  usersCollection.Id,
+		Required:      true,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	}) {
 		changes = true
 	}
-	if collection.Fields.GetByName("sticker") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "sticker",
-			CollectionId:  stickersCollection.Id,
-			Required:      true,
-			MaxSelect:     1,
-			CascadeDelete: false,
-		})
+	if addField(collection, &core.RelationField{
+		Name:          "sticker",
+		CollectionId:  stickersCollection.Id,
+		Required:      true,
+		MaxSelect:     1,
+		CascadeDelete: false,
+	}) {
 		changes = true
 	}
-	if AddSelectField(collection, "unlock_source", true, []string{"level_up", "story_complete"}, 1) {
+	if AddSelectField(collection, "unlock_source", true, StickerUnlockSources, 1) {
 		changes = true
 	}
 
@@ -71,6 +72,6 @@ func EnsureUserStickersCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -5,7 +5,7 @@ import (
 )
 
 // EnsureUsersExtendCollection extends the default users collection
-func EnsureUsersExtendCollection(app core.App) {
+func EnsureUsersExtendCollection(app core.App, r *Reconciler) {
 	collection, err := app.FindCollectionByNameOrId("users")
 	if err != nil {
 		// If users doesn't exist, let PocketBase create it first
@@ -14,7 +14,8 @@ func EnsureUsersExtendCollection(app core.App) {
 
 	changes := false
 
-	if AddTextField(collection, "name", false) {
+	// name: PocketBase's default users field (max 255)
+	if addField(collection, &core.TextField{Name: "name", Max: 255}) {
 		changes = true
 	}
 	if AddNumberField(collection, "birth_year", false, Ptr(2000.0), Ptr(2030.0)) {
@@ -29,15 +30,14 @@ func EnsureUsersExtendCollection(app core.App) {
 	if AddNumberField(collection, "total_reading_minutes", false, nil, nil) {
 		changes = true
 	}
-	if f := collection.Fields.GetByName("parent_email"); f == nil {
-		collection.Fields.Add(&core.EmailField{
-			Name:     "parent_email",
-			Required: false,
-		})
+	if addField(collection, &core.EmailField{
+		Name:     "parent_email",
+		Required: false,
+	}) {
 		changes = true
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -5,7 +5,7 @@ import (
 )
 
 // EnsureViewsCollection ensures the views collection exists
-func EnsureViewsCollection(app core.App) {
+func EnsureViewsCollection(app core.App, r *Reconciler) {
 	// Get related collection IDs first
 	usersCollection, err := app.FindCollectionByNameOrId("users")
 	if err != nil {
@@ -31,37 +31,34 @@ func EnsureViewsCollection(app core.App) {
 	}
 
 	// Add relation fields
-	if collection.Fields.GetByName("user") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "user",
-			CollectionId:  usersCollection.Id,
-			Required:      false, // Allow anonymous views
-			MaxSelect:     1,
-			CascadeDelete: true,
-		})
+	if addField(collection, &core.RelationField{
+		Name:          "user",
+		CollectionId:  usersCollection.Id,
+		Required:      false, // Allow anonymous views
+		MaxSelect:     1,
+		CascadeDelete: true,
+	}) {
 		changes = true
 	}
 
-	if collection.Fields.GetByName("story") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "story",
-			CollectionId:  storiesCollection.Id,
-			Required:      true,
-			MaxSelect:     1,
-			CascadeDelete: true,
-		})
+	if addField(collection, &core.RelationField{
+		Name:          "story",
+		CollectionId:  storiesCollection.Id,
+		Required:      true,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	}) {
 		changes = true
 	}
 
 	// Optional chapter relation
-	if chaptersCollection != nil && collection.Fields.GetByName("chapter") == nil {
-		collection.Fields.Add(&core.RelationField{
-			Name:          "chapter",
-			CollectionId:  chaptersCollection.Id,
-			Required:      false,
-			MaxSelect:     1,
-			CascadeDelete: true,
-		})
+	if chaptersCollection != nil && addField(collection, &core.RelationField{
+		Name:          "chapter",
+		CollectionId:  chaptersCollection.Id,
+		Required:      false,
+		MaxSelect:     1,
+		CascadeDelete: true,
+	}) {
 		changes = true
 	}
 
@@ -91,6 +88,6 @@ func EnsureViewsCollection(app core.App) {
 	}
 
 	if changes {
-		SaveCollection(app, collection)
+		r.save(app, collection)
 	}
 }
@@ -0,0 +1,183 @@
+package schema
+
+import (
+	"log"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// XP event types evaluated against xp_rules.
+// chapter_read and chapter_listened are exclusive: a chapter completed with a finished
+// listening session fires chapter_listened only (its amount includes reading).
+const (
+	XPEventChapterRead     = "chapter_read"
+	XPEventChapterListened = "chapter_listened"
+	XPEventStoryCompleted  = "story_completed"
+	XPEventQuizPassed      = "quiz_passed"
+	XPEventGoalMet         = "goal_met"
+)
+
+// XPEventTypes are the allowed values of xp_rules.event
+var XPEventTypes = []string{XPEventChapterRead, XPEventChapterListened, XPEventStoryCompleted, XPEventQuizPassed, XPEventGoalMet}
+
+// MaxLevel is the highest level (user_stats.level, level stickers level_1..level_18)
+const MaxLevel = 18
+
+// DefaultXPRule is a seeded xp_rules row (from docs/STICKER_SYSTEM.md)
+type DefaultXPRule struct {
+	Key         string
+	Event       string
+	Amount      float64
+	Description string
+}
+
+// DefaultXPRules are seeded on startup and used when xp_rules does not exist
+var DefaultXPRules = []DefaultXPRule{
+	{"chapter_read", XPEventChapterRead, 10, "Đọc xong 1 chương"},
+	{"chapter_listened", XPEventChapterListened, 15, "Nghe xong 1 chương (đã gồm đọc)"},
+	{"story_completed", XPEventStoryCompleted, 50, "Hoàn thành truyện (chương miễn phí, hoặc tất cả chương nếu có premium)"},
+	{"quiz_passed", XPEventQuizPassed, 20, "Qua bài quiz của truyện (lần đầu)"},
+	{"goal_met", XPEventGoalMet, 10, "Đạt mục tiêu đọc (mỗi mục tiêu, mỗi ngày / tuần)"},
+}
+
+// DefaultXPLevelThresholds (min XP to reach level N): Tăng gấp đôi để khó lên cấp hơn
+// L1: 0, L2: 200, L3: 600, L4: 1200, L5: 2500, L6: 4500, L7: 7000, L8: 10000, L9: 14000, L10: 19000
+// L11: 25000, L12: 32000, L13: 40000, L14: 49000, L15: 59000, L16: 70000, L17: 82000, L18: 100000
+var DefaultXPLevelThresholds = []float64{
+	0, 200, 600, 1200, 2500, 4500, 7000, 10000, 14000, 19000,
+	25000, 32000, 40000, 49000, 59000, 70000, 82000, 100000,
+}
+
+// SeedXPRules creates the default XP rules if their key doesn't exist.
+// Disable a default with active=false (deleted defaults are re-created).
+func SeedXPRules(app core.App) {
+	col, err := app.FindCollectionByNameOrId("xp_rules")
+	if err != nil {
+		return
+	}
+	for _, d := range DefaultXPRules {
+		existing, _ := app.FindRecordsByFilter(col.Id, `key="`+escapeFilter(d.Key)+`"`, "", 1, 0)
+		if len(existing) > 0 {
+			continue
+		}
+		rec := core.NewRecord(col)
+		rec.Set("key", d.Key)
+		rec.Set("event", d.Event)
+		rec.Set("amount", d.Amount)
+		rec.Set("description", d.Description)
+		rec.Set("active", true)
+		if err := app.Save(rec); err != nil {
+			log.Printf("xp_rules: seed %s failed: %v", d.Key, err)
+		} else {
+			log.Printf("xp_rules: seeded %s", d.Key)
+		}
+	}
+}
+
+// SeedXPLevels creates the default threshold of every missing level
+func SeedXPLevels(app core.App) {
+	col, err := app.FindCollectionByNameOrId("xp_levels")
+	if err != nil {
+		return
+	}
+	for i, minXP := range DefaultXPLevelThresholds {
+		level := float64(i + 1)
+		existing, _ := app.FindRecordsByFilter(col.Id, "level={:level}", "", 1, 0, dbx.Params{"level": level})
+		if len(existing) > 0 {
+			continue
+		}
+		rec := core.NewRecord(col)
+		rec.Set("level", level)
+		rec.Set("min_xp", minXP)
+		if err := app.Save(rec); err != nil {
+			log.Printf("xp_levels: seed level %d failed: %v", i+1, err)
+		} else {
+			log.Printf("xp_levels: seeded level %d", i+1)
+		}
+	}
+}
+
+// EnsureXPRulesCollection ensures the xp_rules collection exists.
+// Every active rule matching an event is awarded (amounts add up). Conditions:
+// categories (story category, empty = any), first_time_only (the user's first event of
+// this type), starts_at/ends_at (active date range, empty = open). Admin only.
+func EnsureXPRulesCollection(app core.App, r *Reconciler) {
+	collection, err := app.FindCollectionByNameOrId("xp_rules")
+	if err != nil {
+		collection = core.NewBaseCollection("xp_rules")
+	}
+
+	changes := false
+	if SetRules(collection, LockRule, LockRule, LockRule, LockRule, LockRule) {
+		changes = true
+	}
+	if AddTextField(collection, "key", true) {
+		changes = true
+	}
+	if AddSelectField(collection, "event", true, XPEventTypes, 1) {
+		changes = true
+	}
+	if AddNumberField(collection, "amount", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddSelectField(collection, "categories", false, StoryCategories, len(StoryCategories)) {
+		changes = true
+	}
+	if AddBoolField(collection, "first_time_only") {
+		changes = true
+	}
+	if AddDateField(collection, "starts_at", false) {
+		changes = true
+	}
+	if AddDateField(collection, "ends_at", false) {
+		changes = true
+	}
+	if AddBoolField(collection, "active") {
+		changes = true
+	}
+	if AddTextField(collection, "description", false) {
+		changes = true
+	}
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_xp_rules_key", true, "key", "") {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_xp_rules_event", false, "event", "") {
+		changes = true
+	}
+	if changes {
+		r.save(app, collection)
+	}
+}
+
+// EnsureXPLevelsCollection ensures the xp_levels collection exists (min XP of each level).
+// Public read so the app can show progress to the next level.
+func EnsureXPLevelsCollection(app core.App, r *Reconciler) {
+	collection, err := app.FindCollectionByNameOrId("xp_levels")
+	if err != nil {
+		collection = core.NewBaseCollection("xp_levels")
+	}
+
+	changes := false
+	if SetRules(collection, "", "", LockRule, LockRule, LockRule) {
+		changes = true
+	}
+	if AddNumberField(collection, "level", true, Ptr(1.0), Ptr(float64(MaxLevel))) {
+		changes = true
+	}
+	if AddNumberField(collection, "min_xp", false, Ptr(0.0), nil) {
+		changes = true
+	}
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_xp_levels_level", true, "level", "") {
+		changes = true
+	}
+	if changes {
+		r.save(app, collection)
+	}
+}
@@ -0,0 +1,62 @@
+package schema
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// EnsureXPTransactionsCollection ensures the xp_transactions ledger exists: one row per XP
+// award (append-only); user_stats.total_xp is the sum of the user's rows.
+// source identifies what was rewarded ("chapter:<id>", "story:<id>", "quiz:<story id>"): a source is rewarded
+// once per rule. note: why the row was written outside the live hook (e.g. "rebuild").
+// occurred_at: when the rewarded event happened (created is when the row was written, which
+// for rebuilt rows is the rebuild time).
+func EnsureXPTransactionsCollection(app core.App, r *Reconciler) {
+	collection, err := app.FindCollectionByNameOrId("xp_transactions")
+	if err != nil {
+		collection = core.NewBaseCollection("xp_transactions")
+	}
+
+	changes := false
+	if SetRules(collection, "user = @request.auth.id", "user = @request.auth.id", LockRule, LockRule, LockRule) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "user", "users", true, 1, true) {
+		changes = true
+	}
+	if AddSelectField(collection, "event", true, XPEventTypes, 1) {
+		changes = true
+	}
+	if AddNumberField(collection, "amount", false, nil, nil) {
+		changes = true
+	}
+	if AddTextField(collection, "rule_key", false) {
+		changes = true
+	}
+	if AddTextField(collection, "source", true) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "chapter", "chapters", false, 1, false) {
+		changes = true
+	}
+	if AddRelationField(app, collection, "story", "stories", false, 1, false) {
+		changes = true
+	}
+	if AddTextField(collection, "note", false) {
+		changes = true
+	}
+	if AddDateField(collection, "occurred_at", false) {
+		changes = true
+	}
+	if AddSystemFields(collection) {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_xp_transactions_award", true, "user,source,rule_key", "") {
+		changes = true
+	}
+	if EnsureIndex(collection, "idx_xp_transactions_occurred", false, "occurred_at,user", "") {
+		changes = true
+	}
+	if changes {
+		r.save(app, collection)
+	}
+}
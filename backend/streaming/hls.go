@@ -0,0 +1,51 @@
+package streaming
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"korean-kids-stories/audiometa"
+)
+
+// SegmentSeconds is the target length of HLS segments
+const SegmentSeconds = 6.0
+
+// Playlist builds an HLS VOD media playlist; segmentURI returns the URI of segment i
+func Playlist(segments []audiometa.Segment, segmentURI func(i int, s audiometa.Segment) string) string {
+	target := 1.0
+	for _, s := range segments {
+		target = math.Max(target, math.Ceil(s.Duration))
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(target))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for i, s := range segments {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", s.Duration, segmentURI(i, s))
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+// TimestampTag is the ID3 tag starting each packed audio segment: a PRIV frame
+// com.apple.streaming.transportStreamTimestamp with the start time in 90 kHz units
+func TimestampTag(start float64) []byte {
+	owner := "com.apple.streaming.transportStreamTimestamp"
+	ts := uint64(math.Round(start*90000)) & (1<<33 - 1)
+	body := append([]byte(owner), 0)
+	for shift := 56; shift >= 0; shift -= 8 {
+		body = append(body, byte(ts>>shift))
+	}
+	frame := append([]byte("PRIV"), synchsafe(len(body))...)
+	frame = append(frame, 0, 0) // flags
+	frame = append(frame, body...)
+	tag := append([]byte("ID3"), 4, 0, 0) // v2.4, no flags
+	tag = append(tag, synchsafe(len(frame))...)
+	return append(tag, frame...)
+}
+
+// synchsafe encodes an ID3v2.4 size (7 bits per byte)
+func synchsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
@@ -0,0 +1,112 @@
+package streaming_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"korean-kids-stories/audiometa"
+	"korean-kids-stories/streaming"
+)
+
+func TestVerifyToken(t *testing.T) {
+	secret := []byte("s3cret")
+	now := time.Now()
+	exp := now.Add(streaming.TokenTTL)
+	token := streaming.SignToken(secret, "audio1", "user1", exp)
+	encoded, mac, _ := strings.Cut(token, ".")
+	other := streaming.SignToken(secret, "audio2", "user1", exp)
+	otherEncoded, _, _ := strings.Cut(other, ".")
+
+	tests := []struct {
+		name    string
+		secret  []byte
+		token   string
+		audioID string
+		now     time.Time
+		subject string
+		valid   bool
+	}{
+		{"valid", secret, token, "audio1", now, "user1", true},
+		{"device subject", secret, streaming.SignToken(secret, "audio1", streaming.DeviceSubject+"d1", exp), "audio1", now, streaming.DeviceSubject + "d1", true},
+		{"guest", secret, streaming.SignToken(secret, "audio1", "", exp), "audio1", now, "", true},
+		{"other audio", secret, token, "audio2", now, "", false},
+		{"expired", secret, token, "audio1", exp, "", false},
+		{"other secret", []byte("other"), token, "audio1", now, "", false},
+		{"payload of another token", secret, otherEncoded + "." + mac, "audio2", now, "", false},
+		{"tampered signature", secret, encoded + "." + mac[:len(mac)-2] + "AA", "audio1", now, "", false},
+		{"no signature", secret, encoded, "audio1", now, "", false},
+		{"not base64", secret, "!!!." + mac, "audio1", now, "", false},
+		{"empty", secret, "", "audio1", now, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, err := streaming.VerifyToken(tt.secret, tt.token, tt.audioID, tt.now)
+			if !tt.valid {
+				if !errors.Is(err, streaming.ErrInvalidToken) {
+					t.Fatalf("error = %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil || subject != tt.subject {
+				t.Fatalf("subject = %q, err = %v, want %q", subject, err, tt.subject)
+			}
+		})
+	}
+}
+
+func TestPlaylist(t *testing.T) {
+	segments := []audiometa.Segment{
+		{Offset: 10, Length: 100, Start: 0, Duration: 6.008},
+		{Offset: 110, Length: 50, Start: 6.008, Duration: 2.5},
+	}
+	got := streaming.Playlist(segments, func(i int, s audiometa.Segment) string {
+		return "segment.mp3?offset=" + string(rune('0'+i))
+	})
+	want := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:7\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXTINF:6.008,\nsegment.mp3?offset=0\n" +
+		"#EXTINF:2.500,\nsegment.mp3?offset=1\n" +
+		"#EXT-X-ENDLIST\n"
+	if got != want {
+		t.Fatalf("playlist =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTimestampTag(t *testing.T) {
+	owner := "com.apple.streaming.transportStreamTimestamp\x00"
+	tests := []struct {
+		name  string
+		start float64
+		ts    uint64
+	}{
+		{"start", 0, 0},
+		{"1.5 s", 1.5, 135000},
+		{"wraps at 33 bits", float64(1<<33)/90000 + 1, 90000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag := streaming.TimestampTag(tt.start)
+			// ID3v2.4 header, PRIV frame header, owner, 8-byte timestamp
+			frameSize := len(owner) + 8
+			if len(tag) != 10+10+frameSize || !bytes.Equal(tag[:6], []byte("ID3\x04\x00\x00")) || string(tag[10:14]) != "PRIV" {
+				t.Fatalf("tag = %q", tag)
+			}
+			if got := synchsafe(tag[6:10]); got != 10+frameSize {
+				t.Fatalf("tag size = %d, want %d", got, 10+frameSize)
+			}
+			if got := synchsafe(tag[14:18]); got != frameSize {
+				t.Fatalf("frame size = %d, want %d", got, frameSize)
+			}
+			if body := tag[20:]; string(body[:len(owner)]) != owner || binary.BigEndian.Uint64(body[len(owner):]) != tt.ts {
+				t.Fatalf("frame body = %q, want timestamp %d", body, tt.ts)
+			}
+		})
+	}
+}
+
+func synchsafe(b []byte) int {
+	return int(b[0])<<21 | int(b[1])<<14 | int(b[2])<<7 | int(b[3])
+}
@@ -0,0 +1,65 @@
+// Package streaming signs the short-lived tokens of the chapter audio streaming routes and
+// builds HLS playlists of MP3 files.
+package streaming
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TokenTTL: how long a stream token is valid (the app asks for a new one when it expires)
+const TokenTTL = 15 * time.Minute
+
+// DeviceSubject prefixes the subject of tokens issued to guests with X-Device-ID purchases
+const DeviceSubject = "device:"
+
+// SuperuserSubject prefixes the subject of tokens issued to superusers (admin UI preview),
+// which play every audio without an entitlement
+const SuperuserSubject = "superuser:"
+
+// ErrInvalidToken: bad signature, other audio or expired
+var ErrInvalidToken = errors.New("invalid or expired stream token")
+
+// SignToken returns a token for the audio valid until exp. subject is the user id,
+// DeviceSubject+device id, SuperuserSubject+superuser id or "" (guest).
+func SignToken(secret []byte, audioID, subject string, exp time.Time) string {
+	payload := audioID + "|" + subject + "|" + strconv.FormatInt(exp.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(sign(secret, payload))
+}
+
+// VerifyToken checks the signature, the audio and the expiry of a token and returns its subject
+func VerifyToken(secret []byte, token, audioID string, now time.Time) (string, error) {
+	encoded, mac, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(mac)
+	if err != nil || !hmac.Equal(sig, sign(secret, string(payload))) {
+		return "", ErrInvalidToken
+	}
+	parts := strings.Split(string(payload), "|")
+	if len(parts) != 3 || parts[0] != audioID {
+		return "", ErrInvalidToken
+	}
+	exp, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || now.Unix() >= exp {
+		return "", ErrInvalidToken
+	}
+	return parts[1], nil
+}
+
+func sign(secret []byte, payload string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}